@@ -0,0 +1,105 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mdblp/hydrophone/models"
+)
+
+const (
+	// auditTimeout bounds the storage of an audit event, done before answering the request
+	auditTimeout = 5 * time.Second
+	// auditDefaultLimit is the number of events returned when the query does not tell
+	auditDefaultLimit = 100
+	// auditMaxLimit is the maximum number of events returned at once
+	auditMaxLimit = 1000
+
+	STATUS_ERR_AUDIT          = "Error reading the audit events"
+	STATUS_ERR_DECODING_AUDIT = "Error decoding the audit query"
+)
+
+// errAuditLimit rejects a limit of the audit query which is not positive
+var errAuditLimit = errors.New("the limit must be positive")
+
+// auditFilter reads the audit query, the target email is hashed as the events only store its hash
+func (a *Api) auditFilter(req *http.Request) (*models.AuditFilter, error) {
+	query := req.URL.Query()
+	filter := &models.AuditFilter{
+		Actor:           query.Get("actor"),
+		Action:          models.AuditAction(query.Get("action")),
+		TargetUser:      query.Get("targetUser"),
+		TargetTeam:      query.Get("targetTeam"),
+		TargetEmailHash: models.HashEmail(a.Config.AuditEmailKey, query.Get("targetEmail")),
+		Limit:           auditDefaultLimit,
+	}
+	var err error
+	if from := query.Get("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return nil, err
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return nil, err
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil {
+			return nil, err
+		}
+		if filter.Limit <= 0 {
+			return nil, errAuditLimit
+		}
+		if filter.Limit > auditMaxLimit {
+			filter.Limit = auditMaxLimit
+		}
+	}
+	return filter, nil
+}
+
+// @Summary Query the audit log
+// @Description Server token only, returns the audit events matching all the given filters, the latest first
+// @ID hydrophone-api-getAuditEvents
+// @Produce  json
+// @Param actor query string false "user ID of the actor, server for the servers"
+// @Param action query string false "action, e.g. invite_sent"
+// @Param targetUser query string false "user ID of the target"
+// @Param targetTeam query string false "team ID of the target"
+// @Param targetEmail query string false "email of the target, matched with its hash"
+// @Param from query string false "RFC 3339 time, inclusive"
+// @Param to query string false "RFC 3339 time, exclusive"
+// @Param limit query int false "maximum number of events, positive, 100 by default and 1000 at most"
+// @Success 200 {array} models.AuditEvent "audit events"
+// @Failure 400 {object} status.Status "The query is malformed"
+// @Failure 401 {object} status.Status "Authorization token is missing or is not a server token"
+// @Failure 403 {object} status.Status "Authorization token is invalid"
+// @Failure 500 {object} status.Status "Error (internal) while reading the events"
+// @Router /audit [get]
+// @security TidepoolAuth
+func (a *Api) GetAuditEvents(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	token := a.token(res, req)
+	if token == nil {
+		return
+	}
+	if !token.IsServer {
+		a.sendError(res, http.StatusUnauthorized, STATUS_UNAUTHORIZED)
+		return
+	}
+	filter, err := a.auditFilter(req)
+	if err != nil {
+		a.sendError(res, http.StatusBadRequest, STATUS_ERR_DECODING_AUDIT, err)
+		return
+	}
+	events, err := a.Store.FindAuditEvents(req.Context(), filter)
+	if err != nil {
+		a.sendStoreError(res, err, STATUS_ERR_AUDIT)
+		return
+	}
+	if events == nil {
+		events = []*models.AuditEvent{}
+	}
+	a.sendModelAsResWithStatus(res, events, http.StatusOK)
+}
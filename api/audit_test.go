@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/mdblp/hydrophone/clients"
+	"github.com/mdblp/hydrophone/models"
+)
+
+func TestAuditEventsResponds(t *testing.T) {
+	store := clients.NewMockStoreClient(false, false)
+	hydrophone := InitApi(FAKE_CONFIG, store, mockNotifier, mockShoreline, mockPerms, mockSeagull, mockPortal, mockTemplates)
+	testRtr := mux.NewRouter()
+	hydrophone.SetHandlers("", testRtr)
+
+	// events recorded by the handlers
+	request, _ := http.NewRequest(http.MethodPut, "/branding/team1", strings.NewReader(`{"signature": "Dr Who"}`))
+	request.Header.Set(TP_SESSION_TOKEN, testing_token)
+	request.Header.Set(TP_TRACE_SESSION, "trace-123")
+	testRtr.ServeHTTP(httptest.NewRecorder(), request)
+	invite := &models.Confirmation{Key: "secret.key", Type: models.TypeMedicalTeamInvite, Email: "Invitee@Example.com", Team: &models.Team{ID: "team2"}}
+	hydrophone.logAudit(request, models.ConfirmationEvent(models.AuditInviteSent, models.AuditFailure, invite))
+
+	tests := []struct {
+		desc     string
+		url      string
+		api      *Api
+		respCode int
+		events   []models.AuditEvent
+	}{
+		{
+			desc:     "the audit log requires a server token",
+			url:      "/audit",
+			api:      InitApi(FAKE_CONFIG, store, mockNotifier, mock_uid1Shoreline, mockPerms, mockSeagull, mockPortal, mockTemplates),
+			respCode: http.StatusUnauthorized,
+		},
+		{
+			desc:     "a malformed period is rejected",
+			url:      "/audit?from=yesterday",
+			respCode: http.StatusBadRequest,
+		},
+		{
+			desc:     "a zero limit is rejected",
+			url:      "/audit?limit=0",
+			respCode: http.StatusBadRequest,
+		},
+		{
+			desc:     "a negative limit is rejected",
+			url:      "/audit?limit=-1",
+			respCode: http.StatusBadRequest,
+		},
+		{
+			desc:     "the events are filtered by team",
+			url:      "/audit?targetTeam=team1",
+			respCode: http.StatusOK,
+			events: []models.AuditEvent{
+				{Action: models.AuditTeamBrandingUpdated, Outcome: models.AuditSuccess, Actor: models.ActorServer, TargetTeam: "team1", TraceSession: "trace-123"},
+			},
+		},
+		{
+			desc:     "the events are filtered by email, whatever its case",
+			url:      "/audit?targetEmail=invitee@example.com&action=invite_sent",
+			respCode: http.StatusOK,
+			events: []models.AuditEvent{
+				{Action: models.AuditInviteSent, Outcome: models.AuditFailure, Actor: models.ActorServer, TargetTeam: "team2", TargetEmailHash: models.HashEmail(FAKE_CONFIG.AuditEmailKey, invite.Email), TraceSession: "trace-123"},
+			},
+		},
+		{
+			desc:     "the events are limited, the latest first",
+			url:      "/audit?limit=1",
+			respCode: http.StatusOK,
+			events: []models.AuditEvent{
+				{Action: models.AuditInviteSent, Outcome: models.AuditFailure, Actor: models.ActorServer, TargetTeam: "team2", TargetEmailHash: models.HashEmail(FAKE_CONFIG.AuditEmailKey, invite.Email), TraceSession: "trace-123"},
+			},
+		},
+		{
+			desc:     "no event is found out of the period",
+			url:      "/audit?to=2020-01-01T00:00:00Z",
+			respCode: http.StatusOK,
+			events:   []models.AuditEvent{},
+		},
+	}
+
+	for idx, test := range tests {
+		rtr := testRtr
+		if test.api != nil {
+			rtr = mux.NewRouter()
+			test.api.SetHandlers("", rtr)
+		}
+		request, _ := http.NewRequest(http.MethodGet, test.url, nil)
+		request.Header.Set(TP_SESSION_TOKEN, testing_token)
+		response := httptest.NewRecorder()
+		rtr.ServeHTTP(response, request)
+
+		if response.Code != test.respCode {
+			t.Fatalf("TestId `%d` `%s` expected `%d` actual `%d` body `%s`", idx, test.desc, test.respCode, response.Code, response.Body)
+		}
+		if test.events == nil {
+			continue
+		}
+		var events []models.AuditEvent
+		if err := json.NewDecoder(response.Body).Decode(&events); err != nil {
+			t.Fatalf("TestId `%d` `%s` errored `%s`", idx, test.desc, err)
+		}
+		if len(events) != len(test.events) {
+			t.Fatalf("TestId `%d` `%s` expected the events %v actual %v", idx, test.desc, test.events, events)
+		}
+		for i, expected := range test.events {
+			actual := events[i]
+			if actual.Action != expected.Action || actual.Outcome != expected.Outcome || actual.Actor != expected.Actor ||
+				actual.TargetTeam != expected.TargetTeam || actual.TargetEmailHash != expected.TargetEmailHash || actual.TraceSession != expected.TraceSession || actual.Time.IsZero() {
+				t.Fatalf("TestId `%d` `%s` expected the event %v actual %v", idx, test.desc, expected, actual)
+			}
+			if strings.Contains(actual.Details, invite.Key) || strings.Contains(actual.TargetEmailHash, "@") {
+				t.Fatalf("TestId `%d` `%s` the event should not hold the key nor the email %v", idx, test.desc, actual)
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	// TP_BRAND selects the brand of the emails explicitly, it takes precedence over the request host
+	TP_BRAND = "x-tidepool-brand"
+	// DefaultBrand is the name of the brand built from the top level configuration
+	DefaultBrand = "default"
+)
+
+// Brand is a white-label profile of the emails, the empty fields default to the top level configuration
+type Brand struct {
+	Name                    string   `json:"name"`
+	Hosts                   []string `json:"hosts"`                   // request hosts selecting the brand
+	WebURL                  string   `json:"webUrl"`                  // used for link to the front-end of the brand
+	SupportURL              string   `json:"supportUrl"`              // used for link to support
+	AssetURL                string   `json:"assetUrl"`                // used for location of the images
+	AssetPath               string   `json:"assetPath"`               // local copy of the assets, used to embed the images
+	PatientPasswordResetURL string   `json:"patientPasswordResetUrl"` // URL of the help web site that is used to give instructions to reset password for patients
+	FromAddress             string   `json:"fromAddress"`             // sender address, the notifier one by default
+	FromName                string   `json:"fromName"`                // sender display name
+	I18nTemplatesPath       string   `json:"i18nTemplatesPath"`       // template set of the brand, the default one when empty
+}
+
+// CheckBrands validates the brands configuration: names and hosts are given once
+func (c *Config) CheckBrands() error {
+	names := map[string]bool{DefaultBrand: true}
+	hosts := map[string]string{}
+	for _, brand := range c.Brands {
+		if brand.Name == "" {
+			return errors.New("api: brand name is missing")
+		}
+		if names[brand.Name] {
+			return fmt.Errorf("api: brand %s is declared twice", brand.Name)
+		}
+		names[brand.Name] = true
+		for _, host := range brand.Hosts {
+			host = strings.ToLower(host)
+			if other, found := hosts[host]; found {
+				return fmt.Errorf("api: host %s is used by brands %s and %s", host, other, brand.Name)
+			}
+			hosts[host] = brand.Name
+		}
+	}
+	return nil
+}
+
+// defaultBrand returns the brand built from the top level configuration
+func (c *Config) defaultBrand() Brand {
+	return Brand{
+		Name:                    DefaultBrand,
+		WebURL:                  c.WebURL,
+		SupportURL:              c.SupportURL,
+		AssetURL:                c.AssetURL,
+		AssetPath:               c.AssetPath,
+		PatientPasswordResetURL: c.PatientPasswordResetURL,
+	}
+}
+
+// withDefaults returns the brand with its empty fields taken from the default brand
+func (b Brand) withDefaults(defaults Brand) Brand {
+	fill := func(value *string, defaultValue string) {
+		if *value == "" {
+			*value = defaultValue
+		}
+	}
+	fill(&b.WebURL, defaults.WebURL)
+	fill(&b.SupportURL, defaults.SupportURL)
+	fill(&b.AssetURL, defaults.AssetURL)
+	fill(&b.AssetPath, defaults.AssetPath)
+	fill(&b.PatientPasswordResetURL, defaults.PatientPasswordResetURL)
+	return b
+}
+
+// requestHost returns the host the request was made to, without its port
+func requestHost(req *http.Request) string {
+	host := req.Header.Get("X-Forwarded-Host")
+	if host == "" {
+		host = req.Host
+	}
+	// the first host is the one requested by the client when the request went through several proxies
+	host = strings.TrimSpace(strings.Split(host, ",")[0])
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	return strings.ToLower(host)
+}
+
+// brand returns the brand of the request, selected by the brand header then by the request host
+// The default brand is returned when none matches
+func (a *Api) brand(req *http.Request) Brand {
+	defaults := a.Config.defaultBrand()
+	if name := req.Header.Get(TP_BRAND); name == DefaultBrand {
+		return defaults
+	} else if name != "" {
+		for _, brand := range a.Config.Brands {
+			if brand.Name == name {
+				return brand.withDefaults(defaults)
+			}
+		}
+		log.Printf("brand: unknown brand %s requested, using the request host", name)
+	}
+	host := requestHost(req)
+	for _, brand := range a.Config.Brands {
+		for _, brandHost := range brand.Hosts {
+			if strings.ToLower(brandHost) == host {
+				return brand.withDefaults(defaults)
+			}
+		}
+	}
+	return defaults
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mdblp/hydrophone/clients"
+	"github.com/mdblp/hydrophone/models"
+	"github.com/mdblp/hydrophone/templates"
+)
+
+var testBrands = []Brand{
+	{
+		Name:        "partner",
+		Hosts:       []string{"api.partner.example.com"},
+		WebURL:      "https://app.partner.example.com",
+		AssetURL:    "https://assets.partner.example.com",
+		FromAddress: "noreply@partner.example.com",
+		FromName:    "Partner Santé",
+	},
+	{
+		Name:  "other",
+		Hosts: []string{"api.other.example.com"},
+	},
+}
+
+func TestBrandResolution(t *testing.T) {
+	config := FAKE_CONFIG
+	config.Brands = testBrands
+	hydrophone := InitApi(config, mockStore, mockNotifier, mockShoreline, mockPerms, mockSeagull, mockPortal, mockTemplates)
+
+	tests := []struct {
+		desc     string
+		host     string
+		headers  map[string]string
+		expected string
+	}{
+		{desc: "an unknown host uses the default brand", host: "api.example.com", expected: DefaultBrand},
+		{desc: "the host selects the brand", host: "API.partner.example.com:8009", expected: "partner"},
+		{desc: "the forwarded host selects the brand", host: "hydrophone:9157", headers: map[string]string{"X-Forwarded-Host": "api.other.example.com, proxy"}, expected: "other"},
+		{desc: "the header selects the brand", host: "api.other.example.com", headers: map[string]string{TP_BRAND: "partner"}, expected: "partner"},
+		{desc: "the header selects the default brand", host: "api.other.example.com", headers: map[string]string{TP_BRAND: DefaultBrand}, expected: DefaultBrand},
+		{desc: "an unknown brand header uses the host", host: "api.other.example.com", headers: map[string]string{TP_BRAND: "unknown"}, expected: "other"},
+	}
+	for _, test := range tests {
+		request, _ := http.NewRequest("POST", "/send/inform/"+testing_uid1, nil)
+		request.Host = test.host
+		for name, value := range test.headers {
+			request.Header.Set(name, value)
+		}
+		if brand := hydrophone.brand(request); brand.Name != test.expected {
+			t.Fatalf("%s: expecting brand %s but got %s", test.desc, test.expected, brand.Name)
+		}
+	}
+
+	request, _ := http.NewRequest("POST", "/send/inform/"+testing_uid1, nil)
+	request.Host = "api.partner.example.com"
+	brand := hydrophone.brand(request)
+	if brand.WebURL != "https://app.partner.example.com" || brand.SupportURL != FAKE_CONFIG.SupportURL {
+		t.Fatalf("The brand should keep its urls and default the others, got %+v", brand)
+	}
+	if defaults := hydrophone.Config.defaultBrand(); defaults.FromAddress != "" || defaults.FromName != "" {
+		t.Fatalf("The default brand should use the notifier sender, got %+v", defaults)
+	}
+}
+
+func TestCheckBrands(t *testing.T) {
+	tests := map[string][]Brand{
+		"a brand has no name":        {{Hosts: []string{"api.example.com"}}},
+		"a brand is declared twice":  {{Name: "partner"}, {Name: "partner"}},
+		"a brand is the default one": {{Name: DefaultBrand}},
+		"a host is used twice":       {{Name: "partner", Hosts: []string{"api.example.com"}}, {Name: "other", Hosts: []string{"API.example.com"}}},
+	}
+	for desc, brands := range tests {
+		config := Config{Brands: brands}
+		if err := config.CheckBrands(); err == nil {
+			t.Fatalf("The brands should be rejected when %s", desc)
+		}
+	}
+	config := Config{Brands: testBrands}
+	if err := config.CheckBrands(); err != nil {
+		t.Fatalf("The brands should be valid, got %s", err)
+	}
+}
+
+func TestBrandSent(t *testing.T) {
+	config := FAKE_CONFIG
+	config.Brands = testBrands
+	emailTemplates, err := templates.New(FAKE_CONFIG.I18nTemplatesPath, mockLocalizer)
+	if err != nil {
+		t.Fatalf("Failed to load the templates: %s", err)
+	}
+	notifier := clients.NewMockNotifier()
+	hydrophone := InitApi(config, clients.NewMockStoreClient(false, false), notifier, mockShoreline, mockPerms, mockSeagull, mockPortal, emailTemplates)
+
+	send := func(host string) *clients.EmailArgs {
+		conf, _ := models.NewConfirmation(models.TypeInformation, models.TemplateNamePatientInformation, "")
+		conf.Email = "patient@example.com"
+		request, _ := http.NewRequest("POST", "/send/inform/"+testing_uid1, nil)
+		request.Host = host
+		if !hydrophone.createAndSendNotification(request, conf, informationContent{Email: conf.Email}, "en") {
+			t.Fatalf("The notification should have been sent")
+		}
+		return notifier.GetLastEmail()
+	}
+
+	email := send("api.partner.example.com")
+	if email.From != "=?utf-8?q?Partner_Sant=C3=A9?= <noreply@partner.example.com>" {
+		t.Fatalf("The email should be sent from the brand address, got %s", email.From)
+	}
+	if !strings.Contains(email.Msg, "https://app.partner.example.com") || !strings.Contains(email.Msg, "https://assets.partner.example.com/img/") {
+		t.Fatalf("The email should link to the brand front-end and assets")
+	}
+	if strings.Contains(email.Msg, FAKE_CONFIG.WebURL) {
+		t.Fatalf("The email should not link to the default front-end")
+	}
+
+	email = send("api.example.com")
+	if email.From != "" || !strings.Contains(email.Msg, FAKE_CONFIG.WebURL) {
+		t.Fatalf("The email should be sent with the default brand, got sender %q", email.From)
+	}
+
+	// a brand with its own template set sends its templates
+	hydrophone.BrandTemplates = map[string]models.Templates{"other": {}}
+	conf, _ := models.NewConfirmation(models.TypeInformation, models.TemplateNamePatientInformation, "")
+	conf.Email = "patient@example.com"
+	request, _ := http.NewRequest("POST", "/send/inform/"+testing_uid1, nil)
+	request.Host = "api.other.example.com"
+	if hydrophone.createAndSendNotification(request, conf, informationContent{Email: conf.Email}, "en") {
+		t.Fatalf("The notification should not be sent when the brand template set lacks the template")
+	}
+}
+
+func TestForwardedHostNotLinked(t *testing.T) {
+	emailTemplates, err := templates.New(FAKE_CONFIG.I18nTemplatesPath, mockLocalizer)
+	if err != nil {
+		t.Fatalf("Failed to load the templates: %s", err)
+	}
+	noWebURL := FAKE_CONFIG
+	noWebURL.WebURL = ""
+	noWebURL.Protocol = "https"
+	noWebURL.Brands = testBrands
+
+	tests := []struct {
+		desc     string
+		config   Config
+		headers  map[string]string
+		sent     bool
+		expected string
+	}{
+		{desc: "the default web URL is linked", config: FAKE_CONFIG, sent: true, expected: FAKE_CONFIG.WebURL},
+		{desc: "the brand host is linked without web URL", config: noWebURL, headers: map[string]string{TP_BRAND: "other"}, sent: true, expected: "https://api.other.example.com"},
+		{desc: "nothing is sent without web URL nor brand host", config: noWebURL, sent: false},
+	}
+	for idx, test := range tests {
+		notifier := clients.NewMockNotifier()
+		hydrophone := InitApi(test.config, clients.NewMockStoreClient(false, false), notifier, mockShoreline, mockPerms, mockSeagull, mockPortal, emailTemplates)
+		conf, _ := models.NewConfirmation(models.TypeInformation, models.TemplateNamePatientInformation, "")
+		conf.Email = "patient@example.com"
+		request, _ := http.NewRequest("POST", "/send/inform/"+testing_uid1, nil)
+		request.Host = "hydrophone:9157"
+		request.Header.Set("X-Forwarded-Host", "evil.example.com")
+		for name, value := range test.headers {
+			request.Header.Set(name, value)
+		}
+		if sent := hydrophone.createAndSendNotification(request, conf, informationContent{Email: conf.Email}, "en"); sent != test.sent {
+			t.Fatalf("TestId `%d` `%s` expected sent %t actual %t", idx, test.desc, test.sent, sent)
+		}
+		if !test.sent {
+			continue
+		}
+		email := notifier.GetLastEmail()
+		if strings.Contains(email.Msg, "evil.example.com") || strings.Contains(email.Msg, "hydrophone:9157") || !strings.Contains(email.Msg, test.expected) {
+			t.Fatalf("TestId `%d` `%s` the email should only link to %s", idx, test.desc, test.expected)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mdblp/hydrophone/models"
+)
+
+const (
+	// maxBrandingSize is the maximum size of the branding texts payload
+	maxBrandingSize = 64 << 10
+
+	STATUS_ERR_BRANDING          = "Error accessing the team branding"
+	STATUS_ERR_DECODING_BRANDING = "Error decoding the team branding"
+	STATUS_BRANDING_NOT_FOUND    = "No branding for this team"
+	STATUS_LOGO_NOT_FOUND        = "No logo for this team"
+)
+
+type (
+	// brandingBody holds the branding texts, the logo is uploaded on its own
+	brandingBody struct {
+		Signature string            `json:"signature"`
+		Contact   string            `json:"contact"`
+		Welcome   map[string]string `json:"welcome"`
+	}
+	brandingValidation struct {
+		Errors []string `json:"errors"`
+	}
+)
+
+// canEditBranding checks the request is made by a server or an admin of the team, writes the error otherwise
+func (a *Api) canEditBranding(res http.ResponseWriter, req *http.Request, teamID string) (string, bool) {
+	token := a.token(res, req)
+	if token == nil {
+		return "", false
+	}
+	if token.IsServer {
+		return token.UserId, true
+	}
+	isAdmin, _, err := a.getTeamForUser(req.Header.Get(TP_SESSION_TOKEN), teamID, token.UserId, res)
+	if err != nil {
+		return "", false
+	}
+	if !isAdmin {
+		a.sendError(res, http.StatusUnauthorized, STATUS_NOT_ADMIN)
+		return "", false
+	}
+	return token.UserId, true
+}
+
+// brandingLogoURL returns the public link to the team logo, versioned by the last modification so the mail clients don't show an old one
+// It is empty when the team has no logo or the public url of the service is not configured
+func (a *Api) brandingLogoURL(branding *models.TeamBranding) string {
+	if a.Config.PublicURL == "" || len(branding.Logo) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s/branding/%s/logo?v=%d", strings.TrimSuffix(a.Config.PublicURL, "/"), url.PathEscape(branding.TeamID), branding.Modified.Unix())
+}
+
+// teamBranding returns the branding of the team for the content of a medical team invite, nil when there is none
+// The invite is still sent without it when the branding cannot be read
+func (a *Api) teamBranding(ctx context.Context, teamID string, lang string) *teamBrandingContent {
+	branding, err := a.Store.FindTeamBranding(ctx, teamID)
+	if err != nil {
+		log.Printf("teamBranding: error finding the branding of team %s [%v]", teamID, err)
+		return nil
+	}
+	if branding == nil {
+		return nil
+	}
+	values := branding.Content(lang, a.brandingLogoURL(branding))
+	content := &teamBrandingContent{}
+	content.TeamLogoURL, _ = values["TeamLogoURL"].(string)
+	content.TeamWelcome, _ = values["TeamWelcome"].(string)
+	content.TeamSignature, _ = values["TeamSignature"].(string)
+	content.TeamContact, _ = values["TeamContact"].(string)
+	return content
+}
+
+// findTeamBranding returns the branding of the team, writes the error otherwise
+func (a *Api) findTeamBranding(res http.ResponseWriter, req *http.Request, teamID string) (*models.TeamBranding, bool) {
+	branding, err := a.Store.FindTeamBranding(req.Context(), teamID)
+	if err != nil {
+		a.sendStoreError(res, err, STATUS_ERR_BRANDING)
+		return nil, false
+	}
+	return branding, true
+}
+
+// @Summary Get the branding of a medical team
+// @Description Team admins or server token, returns the texts and the link to the logo of the team invites
+// @ID hydrophone-api-getTeamBranding
+// @Produce  json
+// @Param teamid path string true "team id"
+// @Success 200 {object} models.TeamBranding "team branding"
+// @Failure 401 {object} status.Status "Authorization token is missing or the user is not a team admin"
+// @Failure 403 {object} status.Status "Authorization token is invalid"
+// @Failure 404 {object} status.Status "The team has no branding"
+// @Failure 500 {object} status.Status "Error (internal) while reading the branding"
+// @Router /branding/{teamid} [get]
+// @security TidepoolAuth
+func (a *Api) GetTeamBranding(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	teamID := vars["teamid"]
+	if _, ok := a.canEditBranding(res, req, teamID); !ok {
+		return
+	}
+	branding, ok := a.findTeamBranding(res, req, teamID)
+	if !ok {
+		return
+	}
+	if branding == nil {
+		a.sendError(res, http.StatusNotFound, STATUS_BRANDING_NOT_FOUND)
+		return
+	}
+	branding.LogoURL = a.brandingLogoURL(branding)
+	a.sendModelAsResWithStatus(res, branding, http.StatusOK)
+}
+
+// @Summary Update the branding of a medical team
+// @Description Team admins or server token, sets the signature, contact block and welcome paragraph of the team invites
+// @Description The texts are plain text, the welcome paragraph is given by language, the logo is kept
+// @ID hydrophone-api-updateTeamBranding
+// @Accept  json
+// @Produce  json
+// @Param teamid path string true "team id"
+// @Param payload body api.brandingBody true "branding texts"
+// @Success 200 {object} models.TeamBranding "updated team branding"
+// @Failure 400 {object} api.brandingValidation "The payload is malformed or the texts are too long"
+// @Failure 401 {object} status.Status "Authorization token is missing or the user is not a team admin"
+// @Failure 403 {object} status.Status "Authorization token is invalid"
+// @Failure 500 {object} status.Status "Error (internal) while storing the branding"
+// @Router /branding/{teamid} [put]
+// @security TidepoolAuth
+func (a *Api) UpdateTeamBranding(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	teamID := vars["teamid"]
+	userID, ok := a.canEditBranding(res, req, teamID)
+	if !ok {
+		return
+	}
+	defer req.Body.Close()
+	var body brandingBody
+	if err := json.NewDecoder(http.MaxBytesReader(res, req.Body, maxBrandingSize)).Decode(&body); err != nil {
+		log.Printf("UpdateTeamBranding: error decoding the branding of team %s [%v]", teamID, err)
+		a.sendError(res, http.StatusBadRequest, STATUS_ERR_DECODING_BRANDING, err)
+		return
+	}
+	branding, ok := a.findTeamBranding(res, req, teamID)
+	if !ok {
+		return
+	}
+	if branding == nil {
+		branding = &models.TeamBranding{TeamID: teamID}
+	}
+	branding.Signature = body.Signature
+	branding.Contact = body.Contact
+	branding.Welcome = body.Welcome
+	if problems := branding.Validate(); len(problems) > 0 {
+		a.sendModelAsResWithStatus(res, brandingValidation{Errors: problems}, http.StatusBadRequest)
+		return
+	}
+	branding.Modified = time.Now()
+	branding.ModifiedBy = userID
+	if err := a.Store.UpsertTeamBranding(req.Context(), branding); err != nil {
+		a.sendStoreError(res, err, STATUS_ERR_BRANDING)
+		return
+	}
+	a.logAudit(req, models.AuditEvent{Action: models.AuditTeamBrandingUpdated, TargetTeam: teamID})
+	branding.LogoURL = a.brandingLogoURL(branding)
+	a.sendModelAsResWithStatus(res, branding, http.StatusOK)
+}
+
+// @Summary Delete the branding of a medical team
+// @Description Team admins or server token, the team invites are sent without branding afterwards
+// @ID hydrophone-api-deleteTeamBranding
+// @Param teamid path string true "team id"
+// @Success 200 "branding deleted"
+// @Failure 401 {object} status.Status "Authorization token is missing or the user is not a team admin"
+// @Failure 403 {object} status.Status "Authorization token is invalid"
+// @Failure 500 {object} status.Status "Error (internal) while deleting the branding"
+// @Router /branding/{teamid} [delete]
+// @security TidepoolAuth
+func (a *Api) DeleteTeamBranding(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	teamID := vars["teamid"]
+	if _, ok := a.canEditBranding(res, req, teamID); !ok {
+		return
+	}
+	if err := a.Store.RemoveTeamBranding(req.Context(), teamID); err != nil {
+		a.sendStoreError(res, err, STATUS_ERR_BRANDING)
+		return
+	}
+	a.logAudit(req, models.AuditEvent{Action: models.AuditTeamBrandingDeleted, TargetTeam: teamID})
+	res.WriteHeader(http.StatusOK)
+}
+
+// @Summary Upload the logo of a medical team
+// @Description Team admins or server token, the body is the png, jpeg or gif image, 100KB and 1000x1000 pixels at most
+// @ID hydrophone-api-uploadTeamLogo
+// @Accept  image/png,image/jpeg,image/gif
+// @Produce  json
+// @Param teamid path string true "team id"
+// @Success 200 {object} models.TeamBranding "updated team branding"
+// @Failure 400 {object} api.brandingValidation "The image is too large or not a supported logo"
+// @Failure 401 {object} status.Status "Authorization token is missing or the user is not a team admin"
+// @Failure 403 {object} status.Status "Authorization token is invalid"
+// @Failure 500 {object} status.Status "Error (internal) while storing the logo"
+// @Router /branding/{teamid}/logo [put]
+// @security TidepoolAuth
+func (a *Api) UploadTeamLogo(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	teamID := vars["teamid"]
+	userID, ok := a.canEditBranding(res, req, teamID)
+	if !ok {
+		return
+	}
+	defer req.Body.Close()
+	logo, err := ioutil.ReadAll(http.MaxBytesReader(res, req.Body, models.MaxLogoSize))
+	if err != nil {
+		log.Printf("UploadTeamLogo: error reading the logo of team %s [%v]", teamID, err)
+		problem := fmt.Sprintf("the logo is larger than %d bytes", models.MaxLogoSize)
+		a.sendModelAsResWithStatus(res, brandingValidation{Errors: []string{problem}}, http.StatusBadRequest)
+		return
+	}
+	branding, ok := a.findTeamBranding(res, req, teamID)
+	if !ok {
+		return
+	}
+	if branding == nil {
+		branding = &models.TeamBranding{TeamID: teamID}
+	}
+	if err := branding.SetLogo(logo); err != nil {
+		log.Printf("UploadTeamLogo: invalid logo for team %s [%v]", teamID, err)
+		problem := strings.TrimPrefix(err.Error(), "models: ")
+		a.sendModelAsResWithStatus(res, brandingValidation{Errors: []string{problem}}, http.StatusBadRequest)
+		return
+	}
+	branding.Modified = time.Now()
+	branding.ModifiedBy = userID
+	if err := a.Store.UpsertTeamBranding(req.Context(), branding); err != nil {
+		a.sendStoreError(res, err, STATUS_ERR_BRANDING)
+		return
+	}
+	a.logAudit(req, models.AuditEvent{Action: models.AuditTeamLogoUploaded, TargetTeam: teamID})
+	branding.LogoURL = a.brandingLogoURL(branding)
+	a.sendModelAsResWithStatus(res, branding, http.StatusOK)
+}
+
+// @Summary Get the logo of a medical team
+// @Description Public route, the logo is linked from the team invites
+// @ID hydrophone-api-getTeamLogo
+// @Produce  image/png,image/jpeg,image/gif
+// @Param teamid path string true "team id"
+// @Success 200 "team logo"
+// @Failure 404 {object} status.Status "The team has no logo"
+// @Failure 500 {object} status.Status "Error (internal) while reading the logo"
+// @Router /branding/{teamid}/logo [get]
+func (a *Api) GetTeamLogo(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	branding, ok := a.findTeamBranding(res, req, vars["teamid"])
+	if !ok {
+		return
+	}
+	if branding == nil || len(branding.Logo) == 0 {
+		a.sendError(res, http.StatusNotFound, STATUS_LOGO_NOT_FOUND)
+		return
+	}
+	res.Header().Set("Content-Type", branding.LogoType)
+	res.Header().Set("Cache-Control", "public, max-age=86400")
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(http.StatusOK)
+	res.Write(branding.Logo)
+}
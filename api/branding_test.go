@@ -0,0 +1,184 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/mdblp/hydrophone/clients"
+)
+
+func testTeamLogo(t *testing.T) []byte {
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, image.NewRGBA(image.Rect(0, 0, 20, 10))); err != nil {
+		t.Fatalf("Failed to encode the logo: %s", err)
+	}
+	return buffer.Bytes()
+}
+
+func TestTeamBrandingResponds(t *testing.T) {
+	logo := testTeamLogo(t)
+	tests := []struct {
+		desc     string
+		method   string
+		url      string
+		token    string
+		body     []byte
+		respCode int
+		response testJSONObject
+	}{
+		{
+			desc:     "a team without branding has none",
+			method:   "GET",
+			url:      "/branding/team1",
+			token:    testing_token,
+			respCode: http.StatusNotFound,
+		},
+		{
+			desc:     "a branding requires a token",
+			method:   "PUT",
+			url:      "/branding/team1",
+			body:     []byte(`{"signature": "Dr Who"}`),
+			respCode: http.StatusUnauthorized,
+		},
+		{
+			desc:     "a too long signature is rejected",
+			method:   "PUT",
+			url:      "/branding/team1",
+			token:    testing_token,
+			body:     []byte(`{"signature": "` + strings.Repeat("a", 501) + `"}`),
+			respCode: http.StatusBadRequest,
+			response: testJSONObject{"errors": []interface{}{"signature is 501 characters long, the maximum is 500"}},
+		},
+		{
+			desc:     "the branding texts are stored",
+			method:   "PUT",
+			url:      "/branding/team1",
+			token:    testing_token,
+			body:     []byte(`{"signature": "Dr Who", "contact": "+33 1 23 45 67 89", "welcome": {"en": "Welcome", "fr": "Bienvenue"}}`),
+			respCode: http.StatusOK,
+			response: testJSONObject{"teamId": "team1", "signature": "Dr Who"},
+		},
+		{
+			desc:     "a svg logo is rejected",
+			method:   "PUT",
+			url:      "/branding/team1/logo",
+			token:    testing_token,
+			body:     []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`),
+			respCode: http.StatusBadRequest,
+		},
+		{
+			desc:     "a too large logo is rejected",
+			method:   "PUT",
+			url:      "/branding/team1/logo",
+			token:    testing_token,
+			body:     append(logo, make([]byte, 100*1024)...),
+			respCode: http.StatusBadRequest,
+		},
+		{
+			desc:     "a png logo is stored with the texts",
+			method:   "PUT",
+			url:      "/branding/team1/logo",
+			token:    testing_token,
+			body:     logo,
+			respCode: http.StatusOK,
+			response: testJSONObject{"signature": "Dr Who"},
+		},
+		{
+			desc:     "the logo is public",
+			method:   "GET",
+			url:      "/branding/team1/logo",
+			respCode: http.StatusOK,
+		},
+		{
+			desc:     "the branding is deleted",
+			method:   "DELETE",
+			url:      "/branding/team1",
+			token:    testing_token,
+			respCode: http.StatusOK,
+		},
+		{
+			desc:     "the logo is deleted with the branding",
+			method:   "GET",
+			url:      "/branding/team1/logo",
+			respCode: http.StatusNotFound,
+		},
+	}
+
+	store := clients.NewMockStoreClient(false, false)
+	config := FAKE_CONFIG
+	config.PublicURL = "https://api.example.com/confirm"
+	hydrophone := InitApi(config, store, mockNotifier, mockShoreline, mockPerms, mockSeagull, mockPortal, mockTemplates)
+	testRtr := mux.NewRouter()
+	hydrophone.SetHandlers("", testRtr)
+
+	for idx, test := range tests {
+		request, _ := http.NewRequest(test.method, test.url, bytes.NewReader(test.body))
+		if test.token != "" {
+			request.Header.Set(TP_SESSION_TOKEN, test.token)
+		}
+		response := httptest.NewRecorder()
+		testRtr.ServeHTTP(response, request)
+
+		if response.Code != test.respCode {
+			t.Fatalf("TestId `%d` `%s` expected `%d` actual `%d` body `%s`", idx, test.desc, test.respCode, response.Code, response.Body)
+		}
+		if len(test.response) != 0 {
+			var result = &testJSONObject{}
+			if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+				t.Fatalf("TestId `%d` `%s` errored `%s`", idx, test.desc, err)
+			}
+			for k, expected := range test.response {
+				if !reflect.DeepEqual((*result)[k], expected) {
+					t.Fatalf("TestId `%d` `%s` URL `%s` `%s` expected `%v` actual `%v`", idx, test.desc, test.url, k, expected, (*result)[k])
+				}
+			}
+		}
+		if test.method == "GET" && strings.HasSuffix(test.url, "/logo") && response.Code == http.StatusOK {
+			if response.Header().Get("Content-Type") != "image/png" || !bytes.Equal(response.Body.Bytes(), logo) {
+				t.Fatalf("TestId `%d` `%s` the png logo should be served, got `%s`", idx, test.desc, response.Header().Get("Content-Type"))
+			}
+		}
+	}
+}
+
+func TestTeamBrandingSent(t *testing.T) {
+	store := clients.NewMockStoreClient(false, false)
+	config := FAKE_CONFIG
+	config.PublicURL = "https://api.example.com/confirm"
+	hydrophone := InitApi(config, store, mockNotifier, mockShoreline, mockPerms, mockSeagull, mockPortal, mockTemplates)
+
+	if content := hydrophone.teamBranding(context.Background(), "team1", "fr"); content != nil {
+		t.Fatalf("A team without branding should not change the content, got %v", content)
+	}
+
+	branding := map[string]interface{}{"TeamWelcome": "Bienvenue", "TeamSignature": "Dr &lt;Who&gt;"}
+	request, _ := http.NewRequest("PUT", "/branding/team1", strings.NewReader(`{"signature": "Dr <Who>", "welcome": {"en": "Welcome", "fr": "Bienvenue"}}`))
+	request.Header.Set(TP_SESSION_TOKEN, testing_token)
+	hydrophone.UpdateTeamBranding(httptest.NewRecorder(), request, map[string]string{"teamid": "team1"})
+	content := contentValues(medicalteamInviteContent{teamBrandingContent: hydrophone.teamBranding(context.Background(), "team1", "fr")})
+	for name, expected := range branding {
+		if content[name] != expected {
+			t.Fatalf("The branding %s should be added, expecting %q but got %v", name, expected, content[name])
+		}
+	}
+	if _, found := content["TeamLogoURL"]; found {
+		t.Fatalf("A team without logo should not have a logo url")
+	}
+
+	request, _ = http.NewRequest("PUT", "/branding/team1/logo", bytes.NewReader(testTeamLogo(t)))
+	request.Header.Set(TP_SESSION_TOKEN, testing_token)
+	hydrophone.UploadTeamLogo(httptest.NewRecorder(), request, map[string]string{"teamid": "team1"})
+	if url := hydrophone.teamBranding(context.Background(), "team1", "fr").TeamLogoURL; !strings.HasPrefix(url, "https://api.example.com/confirm/branding/team1/logo?v=") {
+		t.Fatalf("The logo url should be added, got %q", url)
+	}
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/mdblp/hydrophone/clients"
+	"github.com/mdblp/hydrophone/models"
+)
+
+const (
+	STATUS_ERR_ERASING = "Error erasing the user data"
+
+	reasonUserErased = "the user was erased"
+)
+
+type (
+	// erasure tells what the erasure of a user changed, all are 0 once the user is erased
+	erasure struct {
+		Canceled      int   `json:"canceled"`      // pending confirmations canceled
+		Pseudonymized int64 `json:"pseudonymized"` // confirmations whose identifiers of the user were replaced
+		AuditEvents   int64 `json:"auditEvents"`   // audit events whose identifiers of the user were replaced
+	}
+)
+
+// @Summary Erase the data held about a deleted user
+// @Description Server token only, cancels the pending confirmations the user created, received by user ID or by any of their emails,
+// @Description or is the subject of, then replaces the user ID and the emails of the user in all of them by a random pseudonym
+// @Description and removes their context. The type, status, team and dates are kept for the statistics,
+// @Description the email of another user invited by the user too. The user ID and the hashes of the emails of the user
+// @Description are replaced by the same pseudonym in the audit events, the erasure is recorded with the pseudonym.
+// @Description Erasing a user again changes nothing.
+// @ID hydrophone-api-eraseUserData
+// @Produce  json
+// @Param userid path string true "user ID"
+// @Param email query []string false "other emails of the user, e.g. the former ones or the ones of a user removed from shoreline"
+// @Success 200 {object} api.erasure "number of confirmations canceled and pseudonymized and of audit events pseudonymized"
+// @Failure 401 {object} status.Status "Authorization token is missing or is not a server token"
+// @Failure 403 {object} status.Status "Authorization token is invalid"
+// @Failure 500 {object} status.Status "Error (internal) while reading the user or changing the confirmations"
+// @Failure 503 {object} status.Status "The store is unavailable, retry later"
+// @Router /user/{userid} [delete]
+// @security TidepoolAuth
+func (a *Api) EraseUserData(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	token := a.token(res, req)
+	if token == nil {
+		return
+	}
+	if !token.IsServer {
+		a.sendError(res, http.StatusUnauthorized, STATUS_UNAUTHORIZED)
+		return
+	}
+	userID := vars["userid"]
+	emails, err := a.userEmails(req, userID)
+	if err != nil {
+		a.sendError(res, http.StatusInternalServerError, STATUS_ERR_FINDING_USER, err)
+		return
+	}
+
+	// the events of the erasure only hold the pseudonym, they are not pseudonymized themselves
+	pseudonym, err := models.NewPseudonym(a.Config.AuditEmailKey)
+	if err != nil {
+		a.sendError(res, http.StatusInternalServerError, STATUS_ERR_ERASING, err)
+		return
+	}
+	var result erasure
+	failed := func(err error) {
+		a.logAudit(req, models.AuditEvent{
+			Action:     models.AuditUserDataErased,
+			Outcome:    models.AuditFailure,
+			TargetUser: pseudonym.UserID,
+			Details:    fmt.Sprintf("%d canceled, %d pseudonymized", result.Canceled, result.Pseudonymized),
+		})
+		a.sendStoreError(res, err, STATUS_ERR_ERASING)
+	}
+	confirmations, err := a.Store.FindUserConfirmations(req.Context(), userID, emails)
+	if err != nil {
+		failed(err)
+		return
+	}
+	for _, conf := range confirmations {
+		if conf.Status != models.StatusPending {
+			continue
+		}
+		_, err := a.Store.TransitionStatus(req.Context(), conf.Key, conf.Revision, a.statusChange(req, conf.Status, models.StatusCanceled, reasonUserErased), nil)
+		var conflict *clients.StatusConflictError
+		if errors.As(err, &conflict) {
+			// changed meanwhile, it is pseudonymized in its new status
+			log.Printf("EraseUserData: confirmation changed meanwhile [%v]", err)
+			continue
+		}
+		if err != nil {
+			failed(err)
+			return
+		}
+		result.Canceled++
+	}
+
+	if result.Pseudonymized, err = a.Store.PseudonymizeUserConfirmations(req.Context(), userID, emails, pseudonym); err != nil {
+		failed(err)
+		return
+	}
+	emailHashes := make([]string, 0, len(emails))
+	for _, email := range emails {
+		emailHashes = append(emailHashes, models.HashEmail(a.Config.AuditEmailKey, email))
+	}
+	if result.AuditEvents, err = a.Store.PseudonymizeUserAuditEvents(req.Context(), userID, emailHashes, pseudonym); err != nil {
+		failed(err)
+		return
+	}
+	a.logAudit(req, models.AuditEvent{
+		Action:     models.AuditUserDataErased,
+		TargetUser: pseudonym.UserID,
+		Details:    fmt.Sprintf("%d canceled, %d pseudonymized, %d audit events pseudonymized", result.Canceled, result.Pseudonymized, result.AuditEvents),
+	})
+	a.sendModelAsResWithStatus(res, result, http.StatusOK)
+}
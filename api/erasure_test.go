@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/mdblp/hydrophone/clients"
+	"github.com/mdblp/hydrophone/models"
+)
+
+func TestEraseUserDataResponds(t *testing.T) {
+	store := clients.NewMockStoreClient(false, false)
+	formerHash := models.HashEmail(FAKE_CONFIG.AuditEmailKey, "former@example.com")
+	// events recorded before the erasure
+	for _, event := range []*models.AuditEvent{
+		{Actor: "123", Action: models.AuditInviteSent, Outcome: models.AuditSuccess, TargetTeam: "team1"},
+		{Actor: models.ActorServer, Action: models.AuditSignupSent, Outcome: models.AuditSuccess, TargetEmailHash: formerHash},
+	} {
+		store.InsertAuditEvent(context.Background(), event)
+	}
+
+	tests := []struct {
+		desc     string
+		store    *clients.MockStoreClient
+		api      *Api
+		respCode int
+		outcome  models.AuditOutcome
+		erasure  erasure
+	}{
+		{
+			desc:     "the erasure requires a server token",
+			api:      InitApi(FAKE_CONFIG, store, mockNotifier, mock_uid1Shoreline, mockPerms, mockSeagull, mockPortal, mockTemplates),
+			respCode: http.StatusUnauthorized,
+		},
+		{
+			desc:     "the store failure is reported",
+			api:      InitApi(FAKE_CONFIG, clients.NewMockStoreClient(false, true), mockNotifier, mockShoreline, mockPerms, mockSeagull, mockPortal, mockTemplates),
+			respCode: http.StatusInternalServerError,
+		},
+		{
+			desc:     "the pending confirmations are canceled and all of them pseudonymized",
+			store:    store,
+			api:      InitApi(FAKE_CONFIG, store, mockNotifier, mockShoreline, mockPerms, mockSeagull, mockPortal, mockTemplates),
+			respCode: http.StatusOK,
+			outcome:  models.AuditSuccess,
+			erasure:  erasure{Canceled: 3, Pseudonymized: 3, AuditEvents: 2},
+		},
+	}
+
+	for idx, test := range tests {
+		rtr := mux.NewRouter()
+		test.api.SetHandlers("", rtr)
+		request, _ := http.NewRequest(http.MethodDelete, "/user/123?email=former@example.com", nil)
+		request.Header.Set(TP_SESSION_TOKEN, testing_token)
+		response := httptest.NewRecorder()
+		rtr.ServeHTTP(response, request)
+
+		if response.Code != test.respCode {
+			t.Fatalf("TestId `%d` `%s` expected `%d` actual `%d` body `%s`", idx, test.desc, test.respCode, response.Code, response.Body)
+		}
+		if test.respCode != http.StatusOK {
+			continue
+		}
+		var result erasure
+		if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+			t.Fatalf("TestId `%d` `%s` errored `%s`", idx, test.desc, err)
+		}
+		if result != test.erasure {
+			t.Fatalf("TestId `%d` `%s` expected %v actual %v", idx, test.desc, test.erasure, result)
+		}
+		for _, filter := range []*models.AuditFilter{{Actor: "123"}, {TargetUser: "123"}, {TargetEmailHash: formerHash}} {
+			if events, _ := test.store.FindAuditEvents(request.Context(), filter); len(events) != 0 {
+				t.Fatalf("TestId `%d` `%s` the audit events should not hold the user identifiers %v", idx, test.desc, events)
+			}
+		}
+		events, _ := test.store.FindAuditEvents(request.Context(), &models.AuditFilter{Action: models.AuditUserDataErased})
+		if len(events) != 1 || events[0].Outcome != test.outcome || !strings.HasPrefix(events[0].TargetUser, "erased-") ||
+			events[0].Details != "3 canceled, 3 pseudonymized, 2 audit events pseudonymized" {
+			t.Fatalf("TestId `%d` `%s` the erasure should be audited with the pseudonym %v", idx, test.desc, events)
+		}
+		if pseudonymized, _ := test.store.FindAuditEvents(request.Context(), &models.AuditFilter{Actor: events[0].TargetUser}); len(pseudonymized) != 1 {
+			t.Fatalf("TestId `%d` `%s` the events of the user should hold the same pseudonym %v", idx, test.desc, pseudonymized)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/mdblp/hydrophone/models"
+)
+
+const STATUS_ERR_EXPORTING = "Error exporting the user data"
+
+// userEmails returns the emails of the user known by shoreline followed by the ones of the query, each once
+// A user removed from shoreline is only known by the emails of the query
+func (a *Api) userEmails(req *http.Request, userID string) ([]string, error) {
+	usr, err := a.sl.GetUser(userID, a.sl.TokenProvide())
+	if err != nil {
+		return nil, err
+	}
+	var candidates []string
+	if usr != nil {
+		candidates = append(candidates, usr.Emails...)
+	}
+	candidates = append(candidates, req.URL.Query()["email"]...)
+	emails := []string{}
+	seen := map[string]bool{}
+	for _, email := range candidates {
+		if canonical := models.CanonicalEmail(email); canonical != "" && !seen[canonical] {
+			seen[canonical] = true
+			emails = append(emails, email)
+		}
+	}
+	return emails, nil
+}
+
+// @Summary Export the data held about a user
+// @Description Server token only, returns every confirmation the user created, received by user ID or by any of their emails,
+// @Description or is the subject of (resets, sign up, pin reset), the latest first, with its context decoded.
+// @Description The confirmation keys are left out.
+// @ID hydrophone-api-exportUserData
+// @Produce  json
+// @Param userid path string true "user ID"
+// @Param email query []string false "other emails of the user, e.g. the former ones or the ones of a user removed from shoreline"
+// @Success 200 {object} models.UserExport "user data"
+// @Failure 401 {object} status.Status "Authorization token is missing or is not a server token"
+// @Failure 403 {object} status.Status "Authorization token is invalid"
+// @Failure 500 {object} status.Status "Error (internal) while reading the user or the confirmations"
+// @Failure 503 {object} status.Status "The store is unavailable, retry later"
+// @Router /export/{userid} [get]
+// @security TidepoolAuth
+func (a *Api) ExportUserData(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	token := a.token(res, req)
+	if token == nil {
+		return
+	}
+	if !token.IsServer {
+		a.sendError(res, http.StatusUnauthorized, STATUS_UNAUTHORIZED)
+		return
+	}
+	userID := vars["userid"]
+	emails, err := a.userEmails(req, userID)
+	if err != nil {
+		a.sendError(res, http.StatusInternalServerError, STATUS_ERR_FINDING_USER, err)
+		return
+	}
+	confirmations, err := a.Store.FindUserConfirmations(req.Context(), userID, emails)
+	if err != nil {
+		a.sendStoreError(res, err, STATUS_ERR_EXPORTING)
+		return
+	}
+	export := models.NewUserExport(userID, emails, confirmations)
+	a.logAudit(req, models.AuditEvent{
+		Action:     models.AuditUserDataExported,
+		TargetUser: userID,
+		Details:    fmt.Sprintf("%d confirmations", len(export.Confirmations)),
+	})
+	a.sendModelAsResWithStatus(res, export, http.StatusOK)
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/mdblp/hydrophone/clients"
+	"github.com/mdblp/hydrophone/models"
+)
+
+func TestExportUserDataResponds(t *testing.T) {
+	store := clients.NewMockStoreClient(false, false)
+
+	tests := []struct {
+		desc          string
+		url           string
+		api           *Api
+		respCode      int
+		confirmations int
+	}{
+		{
+			desc:     "the export requires a server token",
+			url:      "/export/123",
+			api:      InitApi(FAKE_CONFIG, store, mockNotifier, mock_uid1Shoreline, mockPerms, mockSeagull, mockPortal, mockTemplates),
+			respCode: http.StatusUnauthorized,
+		},
+		{
+			desc:     "the store failure is reported",
+			url:      "/export/123",
+			api:      InitApi(FAKE_CONFIG, clients.NewMockStoreClient(false, true), mockNotifier, mockShoreline, mockPerms, mockSeagull, mockPortal, mockTemplates),
+			respCode: http.StatusInternalServerError,
+		},
+		{
+			desc:          "the confirmations sent, received and about the user are exported",
+			url:           "/export/123?email=former@example.com",
+			api:           InitApi(FAKE_CONFIG, store, mockNotifier, mockShoreline, mockPerms, mockSeagull, mockPortal, mockTemplates),
+			respCode:      http.StatusOK,
+			confirmations: 3,
+		},
+	}
+
+	for idx, test := range tests {
+		rtr := mux.NewRouter()
+		test.api.SetHandlers("", rtr)
+		request, _ := http.NewRequest(http.MethodGet, test.url, nil)
+		request.Header.Set(TP_SESSION_TOKEN, testing_token)
+		response := httptest.NewRecorder()
+		rtr.ServeHTTP(response, request)
+
+		if response.Code != test.respCode {
+			t.Fatalf("TestId `%d` `%s` expected `%d` actual `%d` body `%s`", idx, test.desc, test.respCode, response.Code, response.Body)
+		}
+		if test.respCode != http.StatusOK {
+			continue
+		}
+		var export models.UserExport
+		if err := json.NewDecoder(response.Body).Decode(&export); err != nil {
+			t.Fatalf("TestId `%d` `%s` errored `%s`", idx, test.desc, err)
+		}
+		if export.UserID != "123" || len(export.Confirmations) != test.confirmations || export.Emails[len(export.Emails)-1] != "former@example.com" {
+			t.Fatalf("TestId `%d` `%s` wrong export %v", idx, test.desc, export)
+		}
+		if otp, ok := export.Confirmations[2].Context.(map[string]interface{}); !ok || otp["OTP"] != "123456" {
+			t.Fatalf("TestId `%d` `%s` the context should be decoded %v", idx, test.desc, export.Confirmations[2])
+		}
+		events, _ := store.FindAuditEvents(request.Context(), &models.AuditFilter{Action: models.AuditUserDataExported, TargetUser: "123"})
+		if len(events) != 1 {
+			t.Fatalf("TestId `%d` `%s` the export should be audited %v", idx, test.desc, events)
+		}
+	}
+}
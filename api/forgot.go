@@ -115,17 +115,21 @@ func (a *Api) passwordReset(res http.ResponseWriter, req *http.Request, vars map
 	}
 
 	if resetCnf != nil && (info != nil || a.addOrUpdateConfirmation(req.Context(), resetCnf, res)) {
-		a.logAudit(req, "reset confirmation created")
-		emailContent := map[string]interface{}{
-			"Key":      resetCnf.Key,
-			"Email":    resetCnf.Email,
-			"ShortKey": resetCnf.ShortKey,
+		a.logAudit(req, models.ConfirmationEvent(models.AuditPasswordResetCreated, models.AuditSuccess, resetCnf))
+		reset := resetContent{
+			Key:      resetCnf.Key,
+			Email:    resetCnf.Email,
+			ShortKey: resetCnf.ShortKey,
+		}
+		var emailContent notificationContent = reset
+		if resetCnf.Type == models.TypePasswordReset {
+			emailContent = passwordResetContent{resetContent: reset, expiryContent: newExpiryContent(resetCnf)}
 		}
 
 		if a.createAndSendNotification(req, resetCnf, emailContent, resetterLanguage) {
-			a.logAudit(req, "reset confirmation sent")
+			a.logAudit(req, models.ConfirmationEvent(models.AuditPasswordResetSent, models.AuditSuccess, resetCnf))
 		} else {
-			a.logAudit(req, "reset confirmation failed to be sent")
+			a.logAudit(req, models.ConfirmationEvent(models.AuditPasswordResetSent, models.AuditFailure, resetCnf))
 			log.Print("Something happened generating a passwordReset email")
 			res.WriteHeader(http.StatusUnprocessableEntity)
 			return
@@ -143,7 +147,7 @@ func (a *Api) findResetConfirmation(ctx context.Context, conf *models.Confirmati
 	found, err := a.findExistingConfirmation(ctx, conf, res)
 	if err != nil {
 		log.Printf("findResetConfirmation: error [%s]\n", err.Error())
-		a.sendModelAsResWithStatus(res, err, http.StatusInternalServerError)
+		a.sendModelAsResWithStatus(res, err, err.Code)
 		return nil
 	}
 	if found == nil {
@@ -218,7 +222,7 @@ func (a *Api) acceptPassword(res http.ResponseWriter, req *http.Request, vars ma
 			}
 			conf.UpdateStatus(models.StatusCompleted)
 			if a.addOrUpdateConfirmation(req.Context(), conf, res) {
-				a.logAudit(req, "password reset")
+				a.logAudit(req, models.ConfirmationEvent(models.AuditPasswordReset, models.AuditSuccess, conf))
 				a.sendModelAsResWithStatus(
 					res,
 					status.StatusError{Status: status.NewStatus(http.StatusOK, statusResetAccepted)},
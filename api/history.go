@@ -0,0 +1,91 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/mdblp/hydrophone/models"
+)
+
+type (
+	// inviteHistory is the status history of an invite, read-only
+	inviteHistory struct {
+		Key     string                `json:"key"`
+		Type    models.Type           `json:"type"`
+		Status  models.Status         `json:"status"`
+		History []models.StatusChange `json:"history"`
+	}
+)
+
+// isInvite tells if the confirmation is an invite, the only ones having a history exposed
+func isInvite(conf *models.Confirmation) bool {
+	switch conf.Type {
+	case models.TypeCareteamInvite, models.TypeMedicalTeamInvite, models.TypeMedicalTeamPatientInvite:
+		return true
+	}
+	return false
+}
+
+// canReadHistory checks the request is made by a server, the invitee, the sender or an admin of the team of the invite,
+// writes the error otherwise
+func (a *Api) canReadHistory(res http.ResponseWriter, req *http.Request, conf *models.Confirmation) bool {
+	token := a.token(res, req)
+	if token == nil {
+		return false
+	}
+	if token.IsServer || token.UserId == conf.UserId || token.UserId == conf.CreatorId {
+		return true
+	}
+	tokenValue := req.Header.Get(TP_SESSION_TOKEN)
+	// the invitee may not have had an account when invited
+	if usr := a.findExistingUser(token.UserId, tokenValue); usr != nil {
+		for _, email := range usr.Emails {
+			if models.CanonicalEmail(email) == models.CanonicalEmail(conf.Email) {
+				return true
+			}
+		}
+	}
+	if conf.Team != nil && conf.Team.ID != "" {
+		isAdmin, _, err := a.getTeamForUser(tokenValue, conf.Team.ID, token.UserId, res)
+		if err != nil {
+			return false
+		}
+		if isAdmin {
+			return true
+		}
+	}
+	a.sendError(res, http.StatusUnauthorized, STATUS_UNAUTHORIZED)
+	return false
+}
+
+// @Summary Get the status history of an invite
+// @Description The invitee, the sender, the team admins or a server token, returns each change of the invite status
+// @Description with its time, the user who made it ("server" for the servers), the trace session and the reason
+// @ID hydrophone-api-getInviteHistory
+// @Produce  json
+// @Param key path string true "invite key"
+// @Success 200 {object} api.inviteHistory "invite status history"
+// @Failure 401 {object} status.Status "Authorization token is missing or does not provide sufficient privileges"
+// @Failure 403 {object} status.Status "Authorization token is invalid"
+// @Failure 404 {object} status.Status "invitation not found"
+// @Failure 500 {object} status.Status "Error (internal) while processing the data"
+// @Router /invite/{key}/history [get]
+// @security TidepoolAuth
+func (a *Api) GetInviteHistory(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	conf, err := a.findExistingConfirmation(req.Context(), &models.Confirmation{Key: vars["key"]}, res)
+	if err != nil {
+		a.sendModelAsResWithStatus(res, err, err.Code)
+		return
+	}
+	if conf == nil || !isInvite(conf) {
+		a.sendError(res, http.StatusNotFound, statusInviteNotFoundMessage)
+		return
+	}
+	if !a.canReadHistory(res, req, conf) {
+		return
+	}
+	history := inviteHistory{Key: conf.Key, Type: conf.Type, Status: conf.Status, History: conf.History}
+	if history.History == nil {
+		history.History = []models.StatusChange{}
+	}
+	a.sendModelAsResWithStatus(res, history, http.StatusOK)
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/mdblp/hydrophone/clients"
+	"github.com/mdblp/hydrophone/models"
+)
+
+func TestInviteHistoryResponds(t *testing.T) {
+	tests := []struct {
+		desc      string
+		url       string
+		token     string
+		shoreline *testingShorelingMock
+		respCode  int
+		history   []models.StatusChange
+	}{
+		{
+			desc:      "the history requires a token",
+			url:       "/invite/careteam.invite.history/history",
+			shoreline: mock_uid2Shoreline,
+			respCode:  http.StatusUnauthorized,
+		},
+		{
+			desc:      "an unknown invite has no history",
+			url:       "/invite/key.does.not.exist/history",
+			token:     testing_token_uid2,
+			shoreline: mock_uid2Shoreline,
+			respCode:  http.StatusNotFound,
+		},
+		{
+			desc:      "the sender reads the history",
+			url:       "/invite/careteam.invite.history/history",
+			token:     testing_token_uid2,
+			shoreline: mock_uid2Shoreline,
+			respCode:  http.StatusOK,
+			history: []models.StatusChange{
+				{From: models.StatusPending, To: models.StatusCanceled, Actor: testing_uid2, Reason: "sent by mistake"},
+			},
+		},
+		{
+			desc:      "another user can't read the history",
+			url:       "/invite/careteam.invite.history/history",
+			token:     testing_token_uid1,
+			shoreline: mock_uid1Shoreline,
+			respCode:  http.StatusUnauthorized,
+		},
+		{
+			desc:      "the invitee reads the history, empty until the status changes",
+			url:       "/invite/medicalteam.invite.member/history",
+			token:     testing_token_uid1,
+			shoreline: mock_uid1Shoreline,
+			respCode:  http.StatusOK,
+			history:   []models.StatusChange{},
+		},
+	}
+
+	for idx, test := range tests {
+		hydrophone := InitApi(FAKE_CONFIG, clients.NewMockStoreClient(false, false), mockNotifier, test.shoreline, mockPerms, mockSeagull, mockPortal, mockTemplates)
+		testRtr := mux.NewRouter()
+		hydrophone.SetHandlers("", testRtr)
+
+		request, _ := http.NewRequest(http.MethodGet, test.url, nil)
+		if test.token != "" {
+			request.Header.Set(TP_SESSION_TOKEN, test.token)
+		}
+		response := httptest.NewRecorder()
+		testRtr.ServeHTTP(response, request)
+
+		if response.Code != test.respCode {
+			t.Fatalf("TestId `%d` `%s` expected `%d` actual `%d` body `%s`", idx, test.desc, test.respCode, response.Code, response.Body)
+		}
+		if test.history == nil {
+			continue
+		}
+		var result inviteHistory
+		if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+			t.Fatalf("TestId `%d` `%s` errored `%s`", idx, test.desc, err)
+		}
+		if len(result.History) != len(test.history) {
+			t.Fatalf("TestId `%d` `%s` expected the history %v actual %v", idx, test.desc, test.history, result.History)
+		}
+		for i, expected := range test.history {
+			actual := result.History[i]
+			if actual.From != expected.From || actual.To != expected.To || actual.Actor != expected.Actor || actual.Reason != expected.Reason {
+				t.Fatalf("TestId `%d` `%s` expected the change %v actual %v", idx, test.desc, expected, actual)
+			}
+		}
+	}
+}
+
+func TestTransitionRecordsActor(t *testing.T) {
+	hydrophone := InitApi(FAKE_CONFIG, clients.NewMockStoreClient(false, false), mockNotifier, mock_uid1Shoreline, mockPerms, mockSeagull, mockPortal, mockTemplates)
+	request, _ := http.NewRequest(http.MethodPut, "/dismiss/team/invite/123456", nil)
+	request.Header.Set(TP_SESSION_TOKEN, testing_token_uid1)
+	request.Header.Set(TP_TRACE_SESSION, "trace-123")
+
+	conf := &models.Confirmation{Key: "medicalteam.invite.member", Status: models.StatusPending}
+	if !hydrophone.transitionConfirmation(request, conf, models.StatusDeclined, httptest.NewRecorder()) {
+		t.Fatalf("The invite should have been declined")
+	}
+	expected := models.StatusChange{From: models.StatusPending, To: models.StatusDeclined, Actor: testing_uid1, TraceSession: "trace-123"}
+	if len(conf.History) != 1 || conf.History[0].Actor != expected.Actor || conf.History[0].TraceSession != expected.TraceSession ||
+		conf.History[0].From != expected.From || conf.History[0].To != expected.To {
+		t.Fatalf("The change should be recorded as %v, got %v", expected, conf.History)
+	}
+
+	hydrophone.revertConfirmation(request, conf, models.StatusPending)
+	if len(conf.History) != 1 || conf.History[0].Actor != models.ActorServer || conf.History[0].Reason != reasonTeamUpdateFailed {
+		t.Fatalf("The revert should be recorded as made by the server, got %v", conf.History)
+	}
+}
@@ -3,20 +3,24 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
+	"reflect"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 
 	crewClient "github.com/mdblp/crew/client"
 	"github.com/mdblp/hydrophone/clients"
+	"github.com/mdblp/hydrophone/localize"
 	"github.com/mdblp/hydrophone/models"
+	"github.com/mdblp/hydrophone/utils/cid"
 	"github.com/mdblp/shoreline/clients/shoreline"
 	"github.com/mdblp/shoreline/schema"
 	"github.com/mdblp/shoreline/token"
@@ -36,18 +40,32 @@ type (
 		portal         portal.Client
 		Config         Config
 		LanguageBundle *i18n.Bundle
-		logger         *log.Logger
+		// Localizer used to build the template versions, they are disabled when it is not set
+		Localizer *localize.I18nLocalizer
+		versions  *templateVersionCache
+		images    *cid.Inliner
+		logger    *log.Logger
+
+		// BrandTemplates are the template sets of the brands having their own, keyed by brand name
+		BrandTemplates map[string]models.Templates
 	}
 	Config struct {
 		ServerSecret              string `json:"serverSecret"`              //used for services
+		AuditEmailKey             string `json:"auditEmailKey"`             // secret key of the hash of the emails stored in the audit events
 		WebURL                    string `json:"webUrl"`                    // used for link to blip
 		SupportURL                string `json:"supportUrl"`                // used for link to support
 		AssetURL                  string `json:"assetUrl"`                  // used for location of the images
+		AssetPath                 string `json:"assetPath"`                 // local copy of the assets, used to embed the images
+		InlineImages              bool   `json:"inlineImages"`              // true means that the images found in the asset path are embedded in the emails instead of linked
 		I18nTemplatesPath         string `json:"i18nTemplatesPath"`         // where are the templates located?
 		AllowPatientResetPassword bool   `json:"allowPatientResetPassword"` // true means that patients can reset their password, false means that only clinicianc can reset their password
 		PatientPasswordResetURL   string `json:"patientPasswordResetUrl"`   // URL of the help web site that is used to give instructions to reset password for patients
+		PublicURL                 string `json:"publicUrl"`                 // public url of this service, used for the links to the team logos
 		Protocol                  string `json:"protocol"`
 		EnableTestRoutes          bool   `json:"test"`
+
+		// Brands are white-label profiles selected per request, the fields above being the default brand
+		Brands []Brand `json:"brands"`
 	}
 
 	group struct {
@@ -64,6 +82,8 @@ const (
 	TP_SESSION_TOKEN = "x-tidepool-session-token"
 	// TP_TRACE_SESSION Session trace: uuid v4
 	TP_TRACE_SESSION = "x-tidepool-trace-session"
+	// storeRetryAfter is the Retry-After, in seconds, answered when the store is unavailable
+	storeRetryAfter = "5"
 
 	//returned error messages
 	STATUS_ERR_SENDING_EMAIL         = "Error sending email"
@@ -71,6 +91,7 @@ const (
 	STATUS_ERR_CREATING_CONFIRMATION = "Error creating a confirmation"
 	STATUS_ERR_CLINICAL_USR          = "Cannot send an information to clinical"
 	STATUS_ERR_FINDING_CONFIRMATION  = "Error finding the confirmation"
+	STATUS_ERR_STORE_UNAVAILABLE     = "The store is unavailable, retry later"
 	STATUS_ERR_FINDING_USER          = "Error finding the user"
 	STATUS_ERR_FINDING_TEAM          = "Error finding the team"
 	STATUS_ERR_DECODING_CONFIRMATION = "Error decoding the confirmation"
@@ -78,6 +99,7 @@ const (
 	STATUS_ERR_FINDING_VALIDATION    = "Error finding the account validation"
 	STATUS_ERR_DECODING_INVITE       = "Error decoding the invitation"
 	STATUS_ERR_MISSING_DATA_INVITE   = "Error missing data in the invitation"
+	STATUS_ERR_STATUS_CONFLICT       = "The confirmation has been updated meanwhile"
 
 	//returned status messages
 	STATUS_NOT_FOUND           = "Nothing found"
@@ -111,16 +133,23 @@ func InitApi(
 		portal:         portal,
 		templates:      templates,
 		LanguageBundle: nil,
+		versions:       newTemplateVersionCache(),
+		images:         cid.NewInliner(),
 		logger:         logger,
 	}
 }
 
-func (a *Api) getWebURL(req *http.Request) string {
-	if a.Config.WebURL == "" {
-		host := req.Header.Get("Host")
-		return a.Config.Protocol + "://" + host
+// getWebURL returns the front-end the links of the emails of the brand point to, only taken from the configuration:
+// the request headers can be forged to send a link holding a valid key to another host
+// It is empty when neither the brand nor the default one has a web URL nor the brand a host
+func (a *Api) getWebURL(brand Brand) string {
+	if brand.WebURL != "" {
+		return brand.WebURL
+	}
+	if len(brand.Hosts) > 0 {
+		return a.Config.Protocol + "://" + strings.ToLower(brand.Hosts[0])
 	}
-	return a.Config.WebURL
+	return ""
 }
 
 func (a *Api) SetHandlers(prefix string, rtr *mux.Router) {
@@ -164,6 +193,8 @@ func (a *Api) SetHandlers(prefix string, rtr *mux.Router) {
 	// GET /confirm/invite/:userid
 	rtr.Handle("/signup/{userid}", varsHandler(a.getSignUp)).Methods("GET")
 	rtr.Handle("/invite/{userid}", varsHandler(a.GetSentInvitations)).Methods("GET")
+	// GET /confirm/invite/:key/history
+	rtr.Handle("/invite/{key}/history", varsHandler(a.GetInviteHistory)).Methods("GET")
 
 	// GET /confirm/invitations/:userid
 	rtr.Handle("/invitations/{userid}", varsHandler(a.GetReceivedInvitations)).Methods("GET")
@@ -186,6 +217,43 @@ func (a *Api) SetHandlers(prefix string, rtr *mux.Router) {
 	// PUT /confirm/signup/:userid
 	rtr.Handle("/{userid}/invited/{invited_address}", varsHandler(a.CancelInvite)).Methods("PUT")
 	rtr.Handle("/signup/{userid}", varsHandler(a.cancelSignUp)).Methods("PUT")
+
+	// GET /confirm/audit
+	rtr.Handle("/audit", varsHandler(a.GetAuditEvents)).Methods("GET")
+
+	// GET /confirm/export/:userid
+	rtr.Handle("/export/{userid}", varsHandler(a.ExportUserData)).Methods("GET")
+
+	// DELETE /confirm/user/:userid
+	rtr.Handle("/user/{userid}", varsHandler(a.EraseUserData)).Methods("DELETE")
+
+	// GET /confirm/branding/:teamid
+	// PUT /confirm/branding/:teamid
+	// DELETE /confirm/branding/:teamid
+	// GET /confirm/branding/:teamid/logo
+	// PUT /confirm/branding/:teamid/logo
+	branding := rtr.PathPrefix("/branding/{teamid}").Subrouter()
+	branding.Handle("", varsHandler(a.GetTeamBranding)).Methods("GET")
+	branding.Handle("", varsHandler(a.UpdateTeamBranding)).Methods("PUT")
+	branding.Handle("", varsHandler(a.DeleteTeamBranding)).Methods("DELETE")
+	branding.Handle("/logo", varsHandler(a.GetTeamLogo)).Methods("GET")
+	branding.Handle("/logo", varsHandler(a.UploadTeamLogo)).Methods("PUT")
+
+	// GET /confirm/admin/templates/:template/versions
+	// POST /confirm/admin/templates/:template/versions
+	// POST /confirm/admin/templates/:template/validate
+	// POST /confirm/admin/templates/:template/versions/:version/preview
+	// PUT /confirm/admin/templates/:template/versions/:version/activate
+	// PUT /confirm/admin/templates/:template/rollback
+	if a.Localizer != nil {
+		admin := rtr.PathPrefix("/admin/templates/{template}").Subrouter()
+		admin.Handle("/versions", varsHandler(a.GetTemplateVersions)).Methods("GET")
+		admin.Handle("/versions", varsHandler(a.UploadTemplateVersion)).Methods("POST")
+		admin.Handle("/validate", varsHandler(a.ValidateTemplateVersion)).Methods("POST")
+		admin.Handle("/versions/{version}/preview", varsHandler(a.PreviewTemplateVersion)).Methods("POST")
+		admin.Handle("/versions/{version}/activate", varsHandler(a.ActivateTemplateVersion)).Methods("PUT")
+		admin.Handle("/rollback", varsHandler(a.RollbackTemplateVersion)).Methods("PUT")
+	}
 }
 
 func (h varsHandler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
@@ -214,24 +282,68 @@ func (a *Api) GetStatus(res http.ResponseWriter, req *http.Request) {
 }
 
 //Save this confirmation or
+//write a conflict if the same invite is already pending or
 //write an error if it all goes wrong
 func (a *Api) addOrUpdateConfirmation(ctx context.Context, conf *models.Confirmation, res http.ResponseWriter) bool {
-	if err := a.Store.UpsertConfirmation(ctx, conf); err != nil {
+	err := a.Store.UpsertConfirmation(ctx, conf)
+	if errors.Is(err, clients.ErrDuplicateInvite) {
+		// another request created the same invite since the duplicates were checked
+		log.Printf("Duplicate invite for %s [%v]", conf.Email, err)
+		statusErr := &status.StatusError{Status: status.NewStatus(http.StatusConflict, statusExistingInviteMessage)}
+		a.sendModelAsResWithStatus(res, statusErr, http.StatusConflict)
+		return false
+	}
+	if err != nil {
 		log.Printf("Error saving the confirmation [%v]", err)
-		statusErr := &status.StatusError{status.NewStatus(http.StatusInternalServerError, STATUS_ERR_SAVING_CONFIRMATION)}
-		a.sendModelAsResWithStatus(res, statusErr, http.StatusInternalServerError)
+		statusErr := storeErrorStatus(res, err, STATUS_ERR_SAVING_CONFIRMATION)
+		a.sendModelAsResWithStatus(res, statusErr, statusErr.Code)
 		return false
 	}
 	return true
 }
 
+// transitionConfirmation changes the status of the confirmation only if it is unchanged since it was found, status and revision
+// The change is recorded in its history with the user of the request
+// It writes a conflict when another request changed it meanwhile or an error if it all goes wrong
+func (a *Api) transitionConfirmation(req *http.Request, conf *models.Confirmation, to models.Status, res http.ResponseWriter) bool {
+	updated, err := a.Store.TransitionStatus(req.Context(), conf.Key, conf.Revision, a.statusChange(req, conf.Status, to, ""), nil)
+	var conflict *clients.StatusConflictError
+	if errors.As(err, &conflict) {
+		log.Printf("Conflict changing the confirmation status [%v]", err)
+		a.sendError(res, http.StatusConflict, STATUS_ERR_STATUS_CONFLICT)
+		return false
+	} else if err != nil {
+		a.sendStoreError(res, err, STATUS_ERR_SAVING_CONFIRMATION)
+		return false
+	}
+	conf.Status, conf.Modified, conf.Revision, conf.History = updated.Status, updated.Modified, updated.Revision, updated.History
+	return true
+}
+
+// revertConfirmation restores the status the confirmation had before a transition, when what it triggered failed
+func (a *Api) revertConfirmation(req *http.Request, conf *models.Confirmation, previous models.Status) {
+	change := a.statusChange(req, conf.Status, previous, reasonTeamUpdateFailed)
+	change.Actor = models.ActorServer
+	if updated, err := a.Store.TransitionStatus(req.Context(), conf.Key, conf.Revision, change, nil); err != nil {
+		log.Printf("Error reverting the confirmation %s to %s [%v]", conf.Key, previous, err)
+	} else {
+		conf.Status, conf.Modified, conf.Revision, conf.History = updated.Status, updated.Modified, updated.Revision, updated.History
+	}
+}
+
+// statusChange describes a change of the confirmation status made by the request
+// The actor is the user of the request token, ActorServer for a server token
+func (a *Api) statusChange(req *http.Request, from, to models.Status, reason string) models.StatusChange {
+	actor, _ := a.requestActor(req)
+	return models.StatusChange{From: from, To: to, Actor: actor, TraceSession: req.Header.Get(TP_TRACE_SESSION), Reason: reason}
+}
+
 //Find this confirmation
-//write error if it fails
-func (a *Api) findExistingConfirmation(ctx context.Context, conf *models.Confirmation, res http.ResponseWriter) (*models.Confirmation, error) {
+//return the status to write if it fails
+func (a *Api) findExistingConfirmation(ctx context.Context, conf *models.Confirmation, res http.ResponseWriter) (*models.Confirmation, *status.StatusError) {
 	if found, err := a.Store.FindConfirmation(ctx, conf); err != nil {
 		log.Printf("findExistingConfirmation: [%v]", err)
-		statusErr := &status.StatusError{status.NewStatus(http.StatusInternalServerError, STATUS_ERR_FINDING_CONFIRMATION)}
-		return nil, statusErr
+		return nil, storeErrorStatus(res, err, STATUS_ERR_FINDING_CONFIRMATION)
 	} else {
 		return found, nil
 	}
@@ -256,8 +368,8 @@ func (a *Api) addProfile(conf *models.Confirmation) error {
 func (a *Api) checkFoundConfirmations(token string, res http.ResponseWriter, results []*models.Confirmation, err error) []*models.Confirmation {
 	if err != nil {
 		log.Println("Error finding confirmations ", err)
-		statusErr := &status.StatusError{status.NewStatus(http.StatusInternalServerError, STATUS_ERR_FINDING_CONFIRMATION)}
-		a.sendModelAsResWithStatus(res, statusErr, http.StatusInternalServerError)
+		statusErr := storeErrorStatus(res, err, STATUS_ERR_FINDING_CONFIRMATION)
+		a.sendModelAsResWithStatus(res, statusErr, statusErr.Code)
 		return nil
 	} else if results == nil || len(results) == 0 {
 		statusErr := &status.StatusError{status.NewStatus(http.StatusNotFound, STATUS_NOT_FOUND)}
@@ -284,63 +396,55 @@ func (a *Api) checkFoundConfirmations(token string, res http.ResponseWriter, res
 }
 
 //Generate a notification from the given confirmation,write the error if it fails
-func (a *Api) createAndSendNotification(req *http.Request, conf *models.Confirmation, content map[string]interface{}, lang string) bool {
+func (a *Api) createAndSendNotification(req *http.Request, conf *models.Confirmation, emailContent notificationContent, lang string) bool {
 	log.Printf("trying notification with template '%s' to %s with language '%s'", conf.TemplateName, conf.Email, lang)
 
 	// Get the template name based on the requested communication type
 	templateName := conf.TemplateName
 	if templateName == models.TemplateNameUndefined {
-		switch conf.Type {
-		case models.TypePasswordReset:
-			templateName = models.TemplateNamePasswordReset
-		case models.TypePatientPasswordReset:
-			templateName = models.TemplateNamePatientPasswordReset
-		case models.TypePatientPasswordInfo:
-			templateName = models.TemplateNamePatientPasswordInfo
-		case models.TypeCareteamInvite:
-			templateName = models.TemplateNameCareteamInvite
-		case models.TypeMedicalTeamInvite:
-			templateName = models.TemplateNameMedicalteamInvite
-		case models.TypeMedicalTeamPatientInvite:
-			templateName = models.TemplateNameMedicalteamPatientInvite
-		case models.TypeMedicalTeamDoAdmin:
-			templateName = models.TemplateNameMedicalteamDoAdmin
-		case models.TypeMedicalTeamRemove:
-			templateName = models.TemplateNameMedicalteamRemove
-		case models.TypeSignUp:
-			templateName = models.TemplateNameSignup
-		case models.TypeNoAccount:
-			templateName = models.TemplateNameNoAccount
-		case models.TypeInformation:
-			templateName = models.TemplateNamePatientInformation
-		default:
+		var found bool
+		if templateName, found = models.DefaultTemplateNames[conf.Type]; !found {
 			log.Printf("Unknown confirmation type %s", conf.Type)
 			return false
 		}
 	}
 
-	// Support address configuration contains the mailto we want to strip out
-	supportEmail := fmt.Sprintf("<a href=%s>%s</a>", a.Config.SupportURL, strings.Replace(a.Config.SupportURL, "mailto:", "", 1))
-
-	// Content collection is here to replace placeholders in template body/content
-	content["WebURL"] = a.getWebURL(req)
-	content["SupportURL"] = a.Config.SupportURL
-	content["AssetURL"] = a.Config.AssetURL
-	content["PatientPasswordResetURL"] = a.Config.PatientPasswordResetURL
-	content["SupportEmail"] = supportEmail
-
-	mail, ok := content["Email"]
-	if ok {
-		content["EncodedEmail"] = url.QueryEscape(mail.(string))
+	// Retrieve the template of the brand when it has its own set
+	// otherwise the active version of the template, the preloaded one by default
+	brand := a.brand(req)
+	var template models.Template
+	var version int
+	var ok bool
+	if brandTemplates, found := a.BrandTemplates[brand.Name]; found {
+		template, ok = brandTemplates[templateName]
+	} else {
+		template, version, ok = a.resolveTemplate(req.Context(), templateName)
 	}
-
-	// Retrieve the template from all the preloaded templates
-	template, ok := a.templates[templateName]
 	if !ok {
 		log.Printf("Unknown template type %s", templateName)
 		return false
 	}
 
+	// The content must be the one checked against the variables of the template
+	if contentType := templateContents[templateName]; contentType != reflect.TypeOf(emailContent) {
+		log.Printf("Content %T is not the one of template %s", emailContent, templateName)
+		return false
+	}
+
+	// Content collection is here to replace placeholders in template body/content
+	// Service variables are only added when the template declares them
+	content := contentValues(emailContent)
+	serviceContent := a.serviceContent(brand, content)
+	for _, v := range template.Variables() {
+		if value, ok := serviceContent[v.Name]; ok {
+			content[v.Name] = value
+		}
+		if v.Name == "WebURL" && serviceContent["WebURL"] == "" {
+			log.Printf("No web URL configured for the brand %s, template %s is not sent", brand.Name, templateName)
+			return false
+		}
+	}
+
 	// Email information (subject and body) are retrieved from the "executed" email template
 	// "Execution" adds dynamic content using text/template lib
 	subject, body, err := template.Execute(content, lang)
@@ -350,11 +454,36 @@ func (a *Api) createAndSendNotification(req *http.Request, conf *models.Confirma
 		return false
 	}
 
+	// The template sender identity takes precedence over the brand one
+	senderName, replyTo, err := template.ExecuteSender(content, lang)
+	if err != nil {
+		log.Printf("Error executing email template sender '%s'", err)
+		return false
+	}
+	message := &clients.Message{FromName: brand.FromName, FromAddress: brand.FromAddress, ReplyTo: replyTo, To: []string{conf.Email}, Subject: subject, Body: body}
+	if senderName != "" {
+		message.FromName = senderName
+	}
+	if address := template.Sender().Address; address != "" {
+		message.FromAddress = address
+	}
+
 	// Finally send the email
-	if status, details := a.notifier.Send([]string{conf.Email}, subject, body); status != http.StatusOK {
+	if a.Config.InlineImages {
+		message.Body, message.Images = a.images.Inline(body, brand.AssetURL, brand.AssetPath)
+	}
+	if status, details := a.notifier.Send(message); status != http.StatusOK {
 		log.Printf("Issue sending email: Status [%d] Message [%s]", status, details)
 		return false
 	}
+
+	// Record which version of the template was sent, only updating the confirmations already stored
+	if conf.TemplateVersion != version {
+		conf.TemplateVersion = version
+		if err := a.Store.SetConfirmationTemplateVersion(req.Context(), conf.Key, version); err != nil {
+			log.Printf("Error recording the template version of the confirmation [%v]", err)
+		}
+	}
 	return true
 }
 
@@ -378,30 +507,53 @@ func (a *Api) token(res http.ResponseWriter, req *http.Request) *token.TokenData
 	return nil
 }
 
-// logAudit Variatic log for audit trails
-func (a *Api) logAudit(req *http.Request, format string, args ...interface{}) {
+// logAudit records what the request did in the audit log, with the actor, trace session and remote address of the request
+// The event is logged too, a failure to store it does not fail the request
+func (a *Api) logAudit(req *http.Request, event models.AuditEvent) {
 	var prefix string
-	var isServer bool = false
-
-	// Get token from request
-	if token := req.Header.Get(TP_SESSION_TOKEN); token != "" {
-		td := a.sl.CheckToken(token)
-		isServer = td != nil && td.IsServer
+	var isServer bool
+	event.Actor, isServer = a.requestActor(req)
+	event.TraceSession = req.Header.Get(TP_TRACE_SESSION)
+	event.RemoteAddr = req.RemoteAddr
+	if event.Time.IsZero() {
+		event.Time = time.Now()
 	}
-
-	if req.RemoteAddr != "" {
-		prefix = fmt.Sprintf("remoteAddr{%s}, ", req.RemoteAddr)
+	if event.Outcome == "" {
+		event.Outcome = models.AuditSuccess
 	}
-
-	traceSession := req.Header.Get(TP_TRACE_SESSION)
-	if traceSession != "" {
-		prefix += fmt.Sprintf("trace{%s}, ", traceSession)
+	if event.TargetEmail != "" {
+		event.TargetEmailHash = models.HashEmail(a.Config.AuditEmailKey, event.TargetEmail)
+		event.TargetEmail = ""
 	}
 
+	if event.RemoteAddr != "" {
+		prefix = fmt.Sprintf("remoteAddr{%s}, ", event.RemoteAddr)
+	}
+	if event.TraceSession != "" {
+		prefix += fmt.Sprintf("trace{%s}, ", event.TraceSession)
+	}
 	prefix += fmt.Sprintf("isServer{%t}, ", isServer)
+	a.logger.Printf("%s%s %s user{%s} team{%s} %s", prefix, event.Action, event.Outcome, event.TargetUser, event.TargetTeam, event.Details)
+
+	// the event is stored even when the client is gone
+	ctx, cancel := context.WithTimeout(context.Background(), auditTimeout)
+	defer cancel()
+	if err := a.Store.InsertAuditEvent(ctx, &event); err != nil {
+		log.Printf("logAudit: error storing the %s event [%v]", event.Action, err)
+	}
+}
 
-	s := fmt.Sprintf(format, args...)
-	a.logger.Printf("%s%s", prefix, s)
+// requestActor returns the user of the request token, ActorServer for a server token
+func (a *Api) requestActor(req *http.Request) (string, bool) {
+	if token := req.Header.Get(TP_SESSION_TOKEN); token != "" {
+		if td := a.sl.CheckToken(token); td != nil {
+			if td.IsServer {
+				return models.ActorServer, true
+			}
+			return td.UserId, false
+		}
+	}
+	return models.ActorServer, false
 }
 
 //Find existing user based on the given identifier
@@ -464,6 +616,38 @@ func (a *Api) sendError(res http.ResponseWriter, statusCode int, reason string,
 	a.sendModelAsResWithStatus(res, status.NewStatus(statusCode, reason), statusCode)
 }
 
+// storeErrorStatus returns the status answering a failed store operation, by the category of its error:
+// 503 when the store is unavailable, telling the client when to retry, 404 when not found, 409 on a conflict
+// and 500 with the reason otherwise
+func storeErrorStatus(res http.ResponseWriter, err error, reason string) *status.StatusError {
+	switch {
+	case errors.Is(err, clients.ErrUnavailable):
+		res.Header().Set("Retry-After", storeRetryAfter)
+		return &status.StatusError{Status: status.NewStatus(http.StatusServiceUnavailable, STATUS_ERR_STORE_UNAVAILABLE)}
+	case errors.Is(err, clients.ErrNotFound):
+		return &status.StatusError{Status: status.NewStatus(http.StatusNotFound, reason)}
+	case errors.Is(err, clients.ErrConflict):
+		return &status.StatusError{Status: status.NewStatus(http.StatusConflict, reason)}
+	}
+	return &status.StatusError{Status: status.NewStatus(http.StatusInternalServerError, reason)}
+}
+
+// sendStoreError writes the failure of a store operation with the status of its category
+func (a *Api) sendStoreError(res http.ResponseWriter, err error, reason string) {
+	_, file, line, ok := runtime.Caller(1)
+	if ok {
+		segments := strings.Split(file, "/")
+		file = segments[len(segments)-1]
+	} else {
+		file = "???"
+		line = 0
+	}
+
+	statusErr := storeErrorStatus(res, err, reason)
+	log.Printf("%s:%d RESPONSE ERROR: [%d %s] %v", file, line, statusErr.Code, statusErr.Reason, err)
+	a.sendModelAsResWithStatus(res, statusErr.Status, statusErr.Code)
+}
+
 func (a *Api) sendErrorWithCode(res http.ResponseWriter, statusCode int, errorCode int, reason string, extras ...interface{}) {
 	_, file, line, ok := runtime.Caller(1)
 	if ok {
@@ -1,11 +1,16 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/gorilla/mux"
@@ -14,6 +19,7 @@ import (
 	"github.com/mdblp/hydrophone/clients"
 	"github.com/mdblp/hydrophone/localize"
 	"github.com/mdblp/hydrophone/models"
+	"github.com/mdblp/hydrophone/templates"
 	"github.com/mdblp/shoreline/clients/shoreline"
 	"github.com/mdblp/shoreline/schema"
 	"github.com/mdblp/shoreline/token"
@@ -46,7 +52,11 @@ var (
 
 	FAKE_CONFIG = Config{
 		ServerSecret:      "shhh! don't tell",
+		AuditEmailKey:     "audit key",
 		I18nTemplatesPath: "../templates",
+		WebURL:            "https://yourloops.example.com",
+		SupportURL:        "mailto:support@example.com",
+		AssetURL:          "https://assets.example.com",
 	}
 	/*
 	 * basics setup
@@ -237,3 +247,118 @@ func Test_isAuthorizedUser_UnAuthorized(t *testing.T) {
 		t.Fatalf("Test_isAuthorizedUser_UnAuthorized should have returned false")
 	}
 }
+
+func TestInlineImagesSent(t *testing.T) {
+	assets, err := ioutil.TempDir("", "assets")
+	if err != nil {
+		t.Fatalf("Failed to create the asset directory: %s", err)
+	}
+	defer os.RemoveAll(assets)
+	os.MkdirAll(filepath.Join(assets, "img"), 0755)
+	ioutil.WriteFile(filepath.Join(assets, "img", "logo.png"), []byte("\x89PNG\r\n\x1a\nlogo"), 0644)
+
+	emailTemplates, err := templates.New(FAKE_CONFIG.I18nTemplatesPath, mockLocalizer)
+	if err != nil {
+		t.Fatalf("Failed to load the templates: %s", err)
+	}
+	config := FAKE_CONFIG
+	config.AssetPath = assets
+	config.InlineImages = true
+	notifier := clients.NewMockNotifier()
+	hydrophone := InitApi(config, clients.NewMockStoreClient(false, false), notifier, mockShoreline, mockPerms, mockSeagull, mockPortal, emailTemplates)
+
+	conf, _ := models.NewConfirmation(models.TypeInformation, models.TemplateNamePatientInformation, "")
+	conf.Email = "patient@example.com"
+	request, _ := http.NewRequest("POST", "/send/inform/"+testing_uid1, nil)
+	if !hydrophone.createAndSendNotification(request, conf, informationContent{Email: conf.Email}, "en") {
+		t.Fatalf("The notification should have been sent")
+	}
+	email := notifier.GetLastEmail()
+	if len(email.Images) != 1 || email.Images[0].ContentID != "img.logo.png@hydrophone" {
+		t.Fatalf("The logo should be embedded, got %v", email.Images)
+	}
+	if !strings.Contains(email.Msg, `src="cid:img.logo.png@hydrophone"`) || strings.Contains(email.Msg, FAKE_CONFIG.AssetURL+"/img/logo.png") {
+		t.Fatalf("The logo should be referenced by its Content-ID")
+	}
+	// the other images are not found locally so they stay remote
+	if !strings.Contains(email.Msg, FAKE_CONFIG.AssetURL+"/img/facebook.png") {
+		t.Fatalf("The missing images should stay remote")
+	}
+}
+
+func TestTemplateSenderSent(t *testing.T) {
+	emailTemplates, err := templates.New(FAKE_CONFIG.I18nTemplatesPath, mockLocalizer)
+	if err != nil {
+		t.Fatalf("Failed to load the templates: %s", err)
+	}
+	config := FAKE_CONFIG
+	config.Brands = testBrands
+	notifier := clients.NewMockNotifier()
+	hydrophone := InitApi(config, clients.NewMockStoreClient(false, false), notifier, mockShoreline, mockPerms, mockSeagull, mockPortal, emailTemplates)
+
+	send := func(conf *models.Confirmation, content notificationContent, lang string) *clients.EmailArgs {
+		request, _ := http.NewRequest("POST", "/send/inform/"+testing_uid1, nil)
+		request.Host = "api.partner.example.com"
+		if !hydrophone.createAndSendNotification(request, conf, content, lang) {
+			t.Fatalf("The notification should have been sent")
+		}
+		return notifier.GetLastEmail()
+	}
+
+	conf, _ := models.NewConfirmation(models.TypePatientPinReset, models.TemplateNamePatientPinReset, "")
+	conf.Email = "patient@example.com"
+	email := send(conf, pinResetContent{Email: conf.Email, OTP: "123456"}, "fr")
+	if email.FromName != "Assistance YourLoops" || email.ReplyTo != "support@example.com" {
+		t.Fatalf("The reset should be sent by the localized support with replies to the support, got %q %q", email.FromName, email.ReplyTo)
+	}
+	if !strings.HasSuffix(email.From, "<noreply@partner.example.com>") {
+		t.Fatalf("The template sender should keep the brand address, got %s", email.From)
+	}
+
+	// the replies to the invitations are sent to the inviter when the address is known
+	conf, _ = models.NewConfirmation(models.TypeCareteamInvite, models.TemplateNameCareteamInvite, testing_uid1)
+	conf.Email = "invitee@example.com"
+	content := careteamInviteContent{expiryContent: newExpiryContent(conf), PatientName: "Jane Doe", Email: conf.Email, WebPath: "signup"}
+	if email = send(conf, content, "en"); email.FromName != "YourLoops invitations" || email.ReplyTo != "" {
+		t.Fatalf("The invite without inviter address should not have a reply-to, got %q %q", email.FromName, email.ReplyTo)
+	}
+	content.CreatorEmail = "jane.doe@example.com"
+	if email = send(conf, content, "en"); email.ReplyTo != "jane.doe@example.com" {
+		t.Fatalf("The replies to the invite should be sent to the inviter, got %q", email.ReplyTo)
+	}
+
+	// the brand name is kept for the templates without sender
+	conf, _ = models.NewConfirmation(models.TypeInformation, models.TemplateNamePatientInformation, "")
+	conf.Email = "patient@example.com"
+	if email = send(conf, informationContent{Email: conf.Email}, "en"); email.FromName != "Partner Santé" || email.ReplyTo != "" {
+		t.Fatalf("The brand sender should be used, got %q %q", email.FromName, email.ReplyTo)
+	}
+}
+
+// unavailableStore fails the lookups as a store which can't be reached
+type unavailableStore struct {
+	*clients.MockStoreClient
+}
+
+func (s *unavailableStore) FindConfirmation(ctx context.Context, confirmation *models.Confirmation) (*models.Confirmation, error) {
+	return nil, &clients.StoreError{Op: "FindConfirmation", Kind: clients.ErrUnavailable, Err: errors.New("no primary")}
+}
+
+func TestStoreUnavailableResponds(t *testing.T) {
+	store := &unavailableStore{MockStoreClient: clients.NewMockStoreClient(false, false)}
+	hydrophone := InitApi(FAKE_CONFIG, store, mockNotifier, mock_uid2Shoreline, mockPerms, mockSeagull, mockPortal, mockTemplates)
+	testRtr := mux.NewRouter()
+	hydrophone.SetHandlers("", testRtr)
+
+	request, _ := http.NewRequest(http.MethodGet, "/invite/careteam.invite.history/history", nil)
+	request.Header.Set(TP_SESSION_TOKEN, testing_token_uid2)
+	response := httptest.NewRecorder()
+	testRtr.ServeHTTP(response, request)
+
+	if response.Code != http.StatusServiceUnavailable || response.Header().Get("Retry-After") == "" {
+		t.Fatalf("An unavailable store should answer `%d` with a Retry-After, got `%d` %v", http.StatusServiceUnavailable, response.Code, response.Header())
+	}
+	if !strings.Contains(response.Body.String(), STATUS_ERR_STORE_UNAVAILABLE) {
+		t.Fatalf("Message given [%s] expected [%s]", response.Body.String(), STATUS_ERR_STORE_UNAVAILABLE)
+	}
+}
@@ -3,11 +3,13 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
 
 	"github.com/mdblp/crew/store"
+	"github.com/mdblp/hydrophone/clients"
 	"github.com/mdblp/hydrophone/models"
 	"github.com/mdblp/shoreline/schema"
 	"github.com/tidepool-org/go-common/clients/status"
@@ -21,6 +23,11 @@ const (
 	statusInviteCanceledMessage  = "Invite has been canceled"
 	statusInviteNotActiveMessage = "Invite already canceled"
 	statusForbiddenMessage       = "Forbidden to perform requested operation"
+
+	//Reasons of the status changes recorded in the invites history
+	reasonInviteExpired      = "expired, replaced by a new invite"
+	reasonTeamUpdateFailed   = "restored, the team could not be updated"
+	reasonAllInvitesCanceled = "all the invites of the user canceled"
 )
 
 type (
@@ -55,6 +62,7 @@ func (a *Api) checkForDuplicateInvite(ctx context.Context, inviteeEmail, invitor
 			a.sendModelAsResWithStatus(res, statusErr, http.StatusConflict)
 			return true, nil
 		}
+		a.cancelExpiredInvites(ctx, invites)
 	}
 
 	invitedUsr := a.findExistingUser(inviteeEmail, a.sl.TokenProvide())
@@ -105,6 +113,7 @@ func (a *Api) checkForDuplicateTeamInvite(ctx context.Context, inviteeEmail, inv
 			a.sendModelAsResWithStatus(res, statusErr, http.StatusConflict)
 			return true, nil
 		}
+		a.cancelExpiredInvites(ctx, invites)
 	}
 
 	invitedUsr := a.findExistingUser(inviteeEmail, a.sl.TokenProvide())
@@ -137,6 +146,19 @@ func (a *Api) checkForDuplicateTeamInvite(ctx context.Context, inviteeEmail, inv
 	return false, nil
 }
 
+// cancelExpiredInvites cancels the expired pending invites replaced by a new one
+// Only a single invite can be pending for the same email and inviter
+func (a *Api) cancelExpiredInvites(ctx context.Context, invites []*models.Confirmation) {
+	for _, invite := range invites {
+		change := models.StatusChange{From: models.StatusPending, To: models.StatusCanceled, Actor: models.ActorServer, Reason: reasonInviteExpired}
+		_, err := a.Store.TransitionStatus(ctx, invite.Key, invite.Revision, change, nil)
+		var conflict *clients.StatusConflictError
+		if err != nil && !errors.As(err, &conflict) {
+			log.Printf("cancelExpiredInvites: error canceling the invite %s [%v]", invite.Key, err)
+		}
+	}
+}
+
 func (a *Api) getUserPreferences(userid string, res http.ResponseWriter) *models.Preferences {
 	// let's get the invitee user preferences
 	inviteePreferences := &models.Preferences{}
@@ -244,7 +266,7 @@ func (a *Api) GetReceivedInvitations(res http.ResponseWriter, req *http.Request,
 		if invites := a.checkFoundConfirmations(tokenValue, res, found, err); invites != nil {
 			a.ensureIdSet(req.Context(), inviteeID, invites)
 			log.Printf("GetReceivedInvitations: found and have checked [%d] invites ", len(invites))
-			a.logAudit(req, "get received invites")
+			a.logAudit(req, models.AuditEvent{Action: models.AuditInvitesRead, TargetUser: inviteeID, Details: "received"})
 			a.sendModelAsResWithStatus(res, invites, http.StatusOK)
 		}
 	}
@@ -292,7 +314,7 @@ func (a *Api) GetSentInvitations(res http.ResponseWriter, req *http.Request, var
 		[]models.Type{models.TypeCareteamInvite, models.TypeMedicalTeamInvite, models.TypeMedicalTeamPatientInvite},
 	)
 	if invitations := a.checkFoundConfirmations(tokenValue, res, found, err); invitations != nil {
-		a.logAudit(req, "get sent invites")
+		a.logAudit(req, models.AuditEvent{Action: models.AuditInvitesRead, TargetUser: invitorID, Details: "sent"})
 		a.sendModelAsResWithStatus(res, invitations, http.StatusOK)
 		return
 	}
@@ -362,7 +384,7 @@ func (a *Api) AcceptInvite(res http.ResponseWriter, req *http.Request, vars map[
 		conf, err := a.findExistingConfirmation(req.Context(), accept, res)
 		if err != nil {
 			log.Printf("AcceptInvite error while finding confirmation [%s]\n", err.Error())
-			a.sendModelAsResWithStatus(res, err, http.StatusInternalServerError)
+			a.sendModelAsResWithStatus(res, err, err.Code)
 			return
 		}
 		if conf == nil {
@@ -391,8 +413,13 @@ func (a *Api) AcceptInvite(res http.ResponseWriter, req *http.Request, vars map[
 			return
 		}
 
+		// the invite is completed first so a concurrent accept gets a conflict instead of setting the permissions twice
+		if !a.transitionConfirmation(req, conf, models.StatusCompleted, res) {
+			return
+		}
 		if err := a.perms.SetPermissions(a.sl.TokenProvide(), invitorID, inviteeID); err != nil {
 			log.Printf("AcceptInvite error setting permissions [%v]\n", err)
+			a.revertConfirmation(req, conf, models.StatusPending)
 			a.sendModelAsResWithStatus(
 				res,
 				&status.StatusError{Status: status.NewStatus(http.StatusInternalServerError, STATUS_ERR_DECODING_CONFIRMATION)},
@@ -401,14 +428,7 @@ func (a *Api) AcceptInvite(res http.ResponseWriter, req *http.Request, vars map[
 			return
 		}
 		log.Printf("AcceptInvite: permissions were set for [%v -> %v] after an invite was accepted", invitorID, inviteeID)
-		conf.UpdateStatus(models.StatusCompleted)
-		if !a.addOrUpdateConfirmation(req.Context(), conf, res) {
-			statusErr := &status.StatusError{Status: status.NewStatus(http.StatusInternalServerError, STATUS_ERR_SAVING_CONFIRMATION)}
-			log.Println("AcceptInvite ", statusErr.Error())
-			a.sendModelAsResWithStatus(res, statusErr, http.StatusInternalServerError)
-			return
-		}
-		a.logAudit(req, "acceptinvite")
+		a.logAudit(req, models.ConfirmationEvent(models.AuditInviteAccepted, models.AuditSuccess, conf))
 		res.WriteHeader(http.StatusOK)
 		res.Write([]byte(STATUS_OK))
 		return
@@ -470,7 +490,7 @@ func (a *Api) AcceptTeamNotifs(res http.ResponseWriter, req *http.Request, vars
 	conf, err := a.findExistingConfirmation(req.Context(), accept, res)
 	if err != nil {
 		log.Printf("AcceptInvite error while finding confirmation [%s]\n", err.Error())
-		a.sendModelAsResWithStatus(res, err, http.StatusInternalServerError)
+		a.sendModelAsResWithStatus(res, err, err.Code)
 		return
 	}
 	if conf == nil {
@@ -515,19 +535,20 @@ func (a *Api) AcceptTeamNotifs(res http.ResponseWriter, req *http.Request, vars
 }
 
 func (a *Api) acceptAnyInvite(res http.ResponseWriter, req *http.Request, conf *models.Confirmation) {
-	conf.UpdateStatus(models.StatusCompleted)
-	if !a.addOrUpdateConfirmation(req.Context(), conf, res) {
-		statusErr := &status.StatusError{Status: status.NewStatus(http.StatusInternalServerError, STATUS_ERR_SAVING_CONFIRMATION)}
-		log.Println("AcceptAnyInvite ", statusErr.Error())
-		a.sendModelAsResWithStatus(res, statusErr, http.StatusInternalServerError)
+	if !a.transitionConfirmation(req, conf, models.StatusCompleted, res) {
 		return
 	}
-	a.logAudit(req, "acceptanyinvite")
+	a.logAudit(req, models.ConfirmationEvent(models.AuditInviteAccepted, models.AuditSuccess, conf))
 	res.WriteHeader(http.StatusOK)
 	res.Write([]byte(STATUS_OK))
 }
 
 func (a *Api) acceptTeamInvite(res http.ResponseWriter, req *http.Request, conf *models.Confirmation) {
+	// the invite is completed first so a concurrent accept gets a conflict instead of adding the member twice
+	previous := conf.Status
+	if !a.transitionConfirmation(req, conf, models.StatusCompleted, res) {
+		return
+	}
 
 	var member = store.Member{
 		UserID:           conf.UserId,
@@ -544,6 +565,7 @@ func (a *Api) acceptTeamInvite(res http.ResponseWriter, req *http.Request, conf
 	}
 	if err != nil {
 		log.Printf("AcceptInvite error setting permissions [%v]\n", err)
+		a.revertConfirmation(req, conf, previous)
 		a.sendModelAsResWithStatus(
 			res,
 			&status.StatusError{Status: status.NewStatus(http.StatusInternalServerError, STATUS_ERR_DECODING_CONFIRMATION)},
@@ -553,14 +575,7 @@ func (a *Api) acceptTeamInvite(res http.ResponseWriter, req *http.Request, conf
 	}
 
 	log.Printf("AcceptInvite: permissions were set for [%v -> %v] after an invite was accepted", conf.Team.ID, conf.UserId)
-	conf.UpdateStatus(models.StatusCompleted)
-	if !a.addOrUpdateConfirmation(req.Context(), conf, res) {
-		statusErr := &status.StatusError{Status: status.NewStatus(http.StatusInternalServerError, STATUS_ERR_SAVING_CONFIRMATION)}
-		log.Println("AcceptInvite ", statusErr.Error())
-		a.sendModelAsResWithStatus(res, statusErr, http.StatusInternalServerError)
-		return
-	}
-	a.logAudit(req, "acceptinvite")
+	a.logAudit(req, models.ConfirmationEvent(models.AuditInviteAccepted, models.AuditSuccess, conf))
 	res.WriteHeader(http.StatusOK)
 	res.Write([]byte(STATUS_OK))
 }
@@ -605,16 +620,14 @@ func (a *Api) CancelInvite(res http.ResponseWriter, req *http.Request, vars map[
 
 		if conf, err := a.findExistingConfirmation(req.Context(), invite, res); err != nil {
 			log.Printf("CancelInvite: finding [%s]", err.Error())
-			a.sendModelAsResWithStatus(res, err, http.StatusInternalServerError)
+			a.sendModelAsResWithStatus(res, err, err.Code)
 		} else if conf != nil {
 			//cancel the invite
-			conf.UpdateStatus(models.StatusCanceled)
-
-			if a.addOrUpdateConfirmation(req.Context(), conf, res) {
-				a.logAudit(req, "cancelled invite")
+			if a.transitionConfirmation(req, conf, models.StatusCanceled, res) {
+				a.logAudit(req, models.ConfirmationEvent(models.AuditInviteCanceled, models.AuditSuccess, conf))
 				res.WriteHeader(http.StatusOK)
-				return
 			}
+			return
 		}
 		statusErr := &status.StatusError{Status: status.NewStatus(http.StatusNotFound, statusInviteNotFoundMessage)}
 		log.Printf("CancelInvite: [%s]", statusErr.Error())
@@ -674,17 +687,15 @@ func (a *Api) DismissInvite(res http.ResponseWriter, req *http.Request, vars map
 
 		if conf, err := a.findExistingConfirmation(req.Context(), dismiss, res); err != nil {
 			log.Printf("DismissInvite: finding [%s]", err.Error())
-			a.sendModelAsResWithStatus(res, err, http.StatusInternalServerError)
+			a.sendModelAsResWithStatus(res, err, err.Code)
 			return
 		} else if conf != nil {
 
-			conf.UpdateStatus(models.StatusDeclined)
-
-			if a.addOrUpdateConfirmation(req.Context(), conf, res) {
-				a.logAudit(req, "dismissinvite")
+			if a.transitionConfirmation(req, conf, models.StatusDeclined, res) {
+				a.logAudit(req, models.ConfirmationEvent(models.AuditInviteDeclined, models.AuditSuccess, conf))
 				res.WriteHeader(http.StatusOK)
-				return
 			}
+			return
 		}
 		statusErr := &status.StatusError{Status: status.NewStatus(http.StatusNotFound, statusInviteNotFoundMessage)}
 		log.Printf("DismissInvite: [%s]", statusErr.Error())
@@ -752,12 +763,18 @@ func (a *Api) DismissTeamInvite(res http.ResponseWriter, req *http.Request, vars
 
 	if conf, err := a.findExistingConfirmation(req.Context(), dismiss, res); err != nil {
 		log.Printf("DismissInvite: finding [%s]", err.Error())
-		a.sendModelAsResWithStatus(res, err, http.StatusInternalServerError)
+		a.sendModelAsResWithStatus(res, err, err.Code)
 		return
 	} else if conf != nil {
 
 		if conf.Status != models.StatusDeclined && conf.Status != models.StatusCanceled {
 
+			// the invite is declined first so a concurrent dismiss gets a conflict instead of updating the team twice
+			previous := conf.Status
+			if !a.transitionConfirmation(req, conf, models.StatusDeclined, res) {
+				return
+			}
+
 			var member = store.Member{
 				UserID:           conf.UserId,
 				TeamID:           teamID,
@@ -772,19 +789,16 @@ func (a *Api) DismissTeamInvite(res http.ResponseWriter, req *http.Request, vars
 				_, err = a.perms.UpdateTeamMember(tokenValue, member)
 			}
 			if err != nil {
+				a.revertConfirmation(req, conf, previous)
 				statusErr := &status.StatusError{Status: status.NewStatus(http.StatusInternalServerError, STATUS_ERR_UPDATING_TEAM)}
 				a.sendModelAsResWithStatus(res, statusErr, statusErr.Code)
 				return
 			}
 
-			conf.UpdateStatus(models.StatusDeclined)
-
-			if a.addOrUpdateConfirmation(req.Context(), conf, res) {
-				log.Printf("dismiss invite [%s] for [%s]", dismiss.Key, dismiss.Team.ID)
-				a.logAudit(req, "dismissinvite ")
-				res.WriteHeader(http.StatusOK)
-				return
-			}
+			log.Printf("dismiss invite [%s] for [%s]", dismiss.Key, dismiss.Team.ID)
+			a.logAudit(req, models.ConfirmationEvent(models.AuditInviteDeclined, models.AuditSuccess, conf))
+			res.WriteHeader(http.StatusOK)
+			return
 		}
 		statusErr := &status.StatusError{Status: status.NewStatus(http.StatusNotModified, statusInviteNotActiveMessage)}
 		log.Printf("DismissInvite: [%s]", statusErr.Error())
@@ -836,14 +850,13 @@ func (a *Api) CancelAnyInvite(res http.ResponseWriter, req *http.Request, vars m
 	cancel.Status = models.StatusPending
 	if conf, err := a.findExistingConfirmation(req.Context(), cancel, res); err != nil {
 		log.Printf("CancelInvite: finding [%s]", err.Error())
-		a.sendModelAsResWithStatus(res, err, http.StatusInternalServerError)
+		a.sendModelAsResWithStatus(res, err, err.Code)
 		return
 	} else if conf != nil {
 
-		var err error
+		// the requester is checked before the invite is declined
 		switch conf.Type {
 		case models.TypeMedicalTeamPatientInvite:
-			err = a.perms.RemovePatient(tokenValue, conf.Team.ID, conf.UserId)
 		case models.TypeMedicalTeamInvite:
 			if requestorIsAdmin, _, err := a.getTeamForUser(tokenValue, cancel.Team.ID, token.UserId, res); err != nil {
 				statusErr := &status.StatusError{Status: status.NewStatus(http.StatusInternalServerError, STATUS_ERR_UPDATING_TEAM)}
@@ -853,9 +866,6 @@ func (a *Api) CancelAnyInvite(res http.ResponseWriter, req *http.Request, vars m
 				res.WriteHeader(http.StatusUnauthorized)
 				return
 			}
-			if conf.UserId != "" {
-				err = a.perms.RemoveTeamMember(tokenValue, conf.Team.ID, conf.UserId)
-			}
 		case models.TypeCareteamInvite:
 			//verify the request comes from the creator
 			if !a.isAuthorizedUser(token, conf.CreatorId) {
@@ -867,20 +877,33 @@ func (a *Api) CancelAnyInvite(res http.ResponseWriter, req *http.Request, vars m
 			return
 		}
 
-		if err != nil {
-			statusErr := &status.StatusError{Status: status.NewStatus(http.StatusInternalServerError, STATUS_ERR_UPDATING_TEAM)}
-			a.sendModelAsResWithStatus(res, statusErr, statusErr.Code)
+		// the invite is declined first so a concurrent cancel gets a conflict instead of updating the team twice
+		previous := conf.Status
+		if !a.transitionConfirmation(req, conf, models.StatusDeclined, res) {
 			return
 		}
 
-		conf.UpdateStatus(models.StatusDeclined)
+		var err error
+		switch conf.Type {
+		case models.TypeMedicalTeamPatientInvite:
+			err = a.perms.RemovePatient(tokenValue, conf.Team.ID, conf.UserId)
+		case models.TypeMedicalTeamInvite:
+			if conf.UserId != "" {
+				err = a.perms.RemoveTeamMember(tokenValue, conf.Team.ID, conf.UserId)
+			}
+		}
 
-		if a.addOrUpdateConfirmation(req.Context(), conf, res) {
-			log.Printf("cancel invite [%s]", cancel.Key)
-			a.logAudit(req, "cancelInvite ")
-			res.WriteHeader(http.StatusOK)
+		if err != nil {
+			a.revertConfirmation(req, conf, previous)
+			statusErr := &status.StatusError{Status: status.NewStatus(http.StatusInternalServerError, STATUS_ERR_UPDATING_TEAM)}
+			a.sendModelAsResWithStatus(res, statusErr, statusErr.Code)
 			return
 		}
+
+		log.Printf("cancel invite [%s]", cancel.Key)
+		a.logAudit(req, models.ConfirmationEvent(models.AuditInviteCanceled, models.AuditSuccess, conf))
+		res.WriteHeader(http.StatusOK)
+		return
 	}
 	statusErr := &status.StatusError{Status: status.NewStatus(http.StatusNotFound, statusInviteNotFoundMessage)}
 	log.Printf("CancelInvite: [%s]", statusErr.Error())
@@ -918,13 +941,21 @@ func (a *Api) CancelAllInvites(res http.ResponseWriter, req *http.Request, vars
 	}
 
 	for _, inv := range invites {
-		inv.UpdateStatus(models.StatusCanceled)
-		if !a.addOrUpdateConfirmation(req.Context(), inv, res) {
+		// an invite changed meanwhile is not pending anymore, it does not need to be canceled
+		change := a.statusChange(req, inv.Status, models.StatusCanceled, reasonAllInvitesCanceled)
+		_, err := a.Store.TransitionStatus(req.Context(), inv.Key, inv.Revision, change, nil)
+		var conflict *clients.StatusConflictError
+		if errors.As(err, &conflict) {
+			log.Printf("CancelAllInvite skipped: [%v]", err)
+		} else if err != nil {
 			statusErr := &status.StatusError{Status: status.NewStatus(http.StatusInternalServerError, STATUS_ERR_SAVING_CONFIRMATION)}
 			log.Printf("CancelAllInvite failed: [%s]", statusErr.Error())
 			a.sendModelAsResWithStatus(res, statusErr, http.StatusNotFound)
 			return
 		}
+		if err == nil {
+			a.logAudit(req, models.ConfirmationEvent(models.AuditInviteCanceled, models.AuditSuccess, inv))
+		}
 	}
 	log.Printf("cancel invites for [%s]", inviteeEmail)
 	res.WriteHeader(http.StatusOK)
@@ -1009,7 +1040,7 @@ func (a *Api) SendInvite(res http.ResponseWriter, req *http.Request, vars map[st
 			}
 
 			if a.addOrUpdateConfirmation(req.Context(), invite, res) {
-				a.logAudit(req, "invite created")
+				a.logAudit(req, models.ConfirmationEvent(models.AuditInviteCreated, models.AuditSuccess, invite))
 
 				if err := a.addProfile(invite); err != nil {
 					log.Println("SendInvite: ", err.Error())
@@ -1028,16 +1059,18 @@ func (a *Api) SendInvite(res http.ResponseWriter, req *http.Request, vars map[st
 						webPath = "login"
 					}
 
-					emailContent := map[string]interface{}{
-						"PatientName": fullName,
-						"Email":       invite.Email,
-						"WebPath":     webPath,
+					emailContent := careteamInviteContent{
+						expiryContent: newExpiryContent(invite),
+						PatientName:   fullName,
+						Email:         invite.Email,
+						WebPath:       webPath,
+						CreatorEmail:  a.creatorEmail(invite),
 					}
 
 					if a.createAndSendNotification(req, invite, emailContent, inviteeLanguage) {
-						a.logAudit(req, "invite sent")
+						a.logAudit(req, models.ConfirmationEvent(models.AuditInviteSent, models.AuditSuccess, invite))
 					} else {
-						a.logAudit(req, "invite failed to be sent")
+						a.logAudit(req, models.ConfirmationEvent(models.AuditInviteSent, models.AuditFailure, invite))
 						log.Print("Something happened generating an invite email")
 						res.WriteHeader(http.StatusUnprocessableEntity)
 						return
@@ -1159,7 +1192,7 @@ func (a *Api) SendTeamInvite(res http.ResponseWriter, req *http.Request, vars ma
 			inviteeLanguage = a.getUserLanguage(invite.UserId, res)
 		}
 		if a.addOrUpdateConfirmation(req.Context(), invite, res) {
-			a.logAudit(req, "invite created")
+			a.logAudit(req, models.ConfirmationEvent(models.AuditInviteCreated, models.AuditSuccess, invite))
 
 			if err := a.addProfile(invite); err != nil {
 				log.Println("SendInvite: ", err.Error())
@@ -1169,20 +1202,24 @@ func (a *Api) SendTeamInvite(res http.ResponseWriter, req *http.Request, vars ma
 					webPath = "signup"
 				}
 
-				emailContent := map[string]interface{}{
-					"MedicalteamName":          team.Name,
-					"MedicalteamAddress":       team.Address,
-					"MedicalteamPhone":         team.Phone,
-					"MedicalteamIentification": team.Code,
-					"CreatorName":              invite.Creator.Profile.FullName,
-					"Email":                    invite.Email,
-					"WebPath":                  webPath,
+				emailContent := medicalteamInviteContent{
+					expiryContent:            newExpiryContent(invite),
+					teamBrandingContent:      a.teamBranding(req.Context(), ib.TeamID, inviteeLanguage),
+					MedicalteamName:          team.Name,
+					MedicalteamAddress:       team.Address,
+					MedicalteamPhone:         team.Phone,
+					MedicalteamIentification: team.Code,
+					CreatorName:              invite.Creator.Profile.FullName,
+					CreatorEmail:             a.creatorEmail(invite),
+					Email:                    invite.Email,
+					WebPath:                  webPath,
+					Language:                 inviteeLanguage,
 				}
 
 				if a.createAndSendNotification(req, invite, emailContent, inviteeLanguage) {
-					a.logAudit(req, "invite sent")
+					a.logAudit(req, models.ConfirmationEvent(models.AuditInviteSent, models.AuditSuccess, invite))
 				} else {
-					a.logAudit(req, "invite failed to be sent")
+					a.logAudit(req, models.ConfirmationEvent(models.AuditInviteSent, models.AuditFailure, invite))
 					log.Print("Something happened generating an invite email")
 					res.WriteHeader(http.StatusUnprocessableEntity)
 					return
@@ -1328,22 +1365,22 @@ func (a *Api) UpdateTeamRole(res http.ResponseWriter, req *http.Request, vars ma
 		// does the invitee have a preferred language?
 		inviteeLanguage = a.getUserLanguage(invite.UserId, res)
 		if a.addOrUpdateConfirmation(req.Context(), invite, res) {
-			a.logAudit(req, "invite created")
+			a.logAudit(req, models.ConfirmationEvent(models.AuditInviteCreated, models.AuditSuccess, invite))
 
 			if err := a.addProfile(invite); err != nil {
 				log.Println("SendInvite: ", err.Error())
 			} else {
 
-				emailContent := map[string]interface{}{
-					"MedicalteamName": team.Name,
-					"Email":           invite.Email,
-					"Language":        inviteeLanguage,
+				emailContent := teamMemberContent{
+					MedicalteamName: team.Name,
+					Email:           invite.Email,
+					Language:        inviteeLanguage,
 				}
 
 				if a.createAndSendNotification(req, invite, emailContent, inviteeLanguage) {
-					a.logAudit(req, "invite sent")
+					a.logAudit(req, models.ConfirmationEvent(models.AuditInviteSent, models.AuditSuccess, invite))
 				} else {
-					a.logAudit(req, "invite failed to be sent")
+					a.logAudit(req, models.ConfirmationEvent(models.AuditInviteSent, models.AuditFailure, invite))
 					log.Print("Something happened generating an invite email")
 					res.WriteHeader(http.StatusUnprocessableEntity)
 					return
@@ -1446,22 +1483,22 @@ func (a *Api) DeleteTeamMember(res http.ResponseWriter, req *http.Request, vars
 	}
 
 	if a.addOrUpdateConfirmation(req.Context(), invite, res) {
-		a.logAudit(req, "invite created")
+		a.logAudit(req, models.ConfirmationEvent(models.AuditInviteCreated, models.AuditSuccess, invite))
 
 		if err := a.addProfile(invite); err != nil {
 			log.Println("SendInvite: ", err.Error())
 		} else {
 
-			emailContent := map[string]interface{}{
-				"MedicalteamName": team.Name,
-				"Email":           invite.Email,
-				"Language":        inviteeLanguage,
+			emailContent := teamMemberContent{
+				MedicalteamName: team.Name,
+				Email:           invite.Email,
+				Language:        inviteeLanguage,
 			}
 
 			if a.createAndSendNotification(req, invite, emailContent, inviteeLanguage) {
-				a.logAudit(req, "invite sent")
+				a.logAudit(req, models.ConfirmationEvent(models.AuditInviteSent, models.AuditSuccess, invite))
 			} else {
-				a.logAudit(req, "invite failed to be sent")
+				a.logAudit(req, models.ConfirmationEvent(models.AuditInviteSent, models.AuditFailure, invite))
 				log.Print("Something happened generating an invite email")
 				res.WriteHeader(http.StatusUnprocessableEntity)
 				return
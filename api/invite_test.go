@@ -859,6 +859,18 @@ func TestCaregiverInvite(t *testing.T) {
 				"permissions": testJSONObject{"view": testJSONObject{}},
 			},
 		},
+		{
+			desc:       "can't have a duplicate invite created meanwhile",
+			returnNone: true,
+			method:     http.MethodPost,
+			url:        fmt.Sprintf("/send/invite/%s", testing_uid2),
+			token:      testing_token_uid1,
+			respCode:   http.StatusConflict,
+			body: testJSONObject{
+				"email":       "duplicate.invite@email.org",
+				"permissions": testJSONObject{"view": testJSONObject{}},
+			},
+		},
 		{
 			desc:     "invitations gives list of our outstanding invitations",
 			method:   http.MethodGet,
@@ -1061,6 +1073,16 @@ func TestAcceptTeamInvite(t *testing.T) {
 				"key": "medicalteam.invite.member",
 			},
 		},
+		{
+			desc:     "team invite accepted meanwhile by another request",
+			method:   http.MethodPut,
+			url:      "/accept/team/invite",
+			token:    testing_token_hcp,
+			respCode: http.StatusConflict,
+			body: testJSONObject{
+				"key": "medicalteam.invite.conflict",
+			},
+		},
 		{
 			desc:     "valid request to accept a team invite for a patient",
 			method:   http.MethodPut,
@@ -123,9 +123,9 @@ func (a *Api) SendPinReset(res http.ResponseWriter, req *http.Request, vars map[
 
 	var templateName = models.TemplateNamePatientPinReset
 
-	emailContent := map[string]interface{}{
-		"Email": usrDetails.Emails[0],
-		"OTP":   re.ReplaceAllString(totp.OTP, `$1-$2-$3`),
+	emailContent := pinResetContent{
+		Email: usrDetails.Emails[0],
+		OTP:   re.ReplaceAllString(totp.OTP, `$1-$2-$3`),
 	}
 
 	// Create new confirmation with context data = totp
@@ -137,7 +137,7 @@ func (a *Api) SendPinReset(res http.ResponseWriter, req *http.Request, vars map[
 
 		if a.createAndSendNotification(req, newOTP, emailContent, userLanguage) {
 			log.Printf("sendPinReset - OTP sent for %s", userID)
-			a.logAudit(req, "pin reset OTP sent")
+			a.logAudit(req, models.ConfirmationEvent(models.AuditPinResetSent, models.AuditSuccess, newOTP))
 			res.WriteHeader(http.StatusOK)
 			res.Write([]byte("OK"))
 		} else {
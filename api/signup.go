@@ -49,7 +49,7 @@ func (a *Api) findSignUp(ctx context.Context, conf *models.Confirmation, res htt
 	found, err := a.findExistingConfirmation(ctx, conf, res)
 	if err != nil {
 		log.Printf("findSignUp: error [%s]\n", err.Error())
-		a.sendModelAsResWithStatus(res, err, http.StatusInternalServerError)
+		a.sendModelAsResWithStatus(res, err, err.Code)
 		return nil
 	}
 	if found == nil {
@@ -85,7 +85,11 @@ func (a *Api) updateSignupConfirmation(newStatus models.Status, res http.Respons
 		found.UpdateStatus(newStatus)
 
 		if a.addOrUpdateConfirmation(req.Context(), found, res) {
-			a.logAudit(req, updatedStatus)
+			action := models.AuditSignupDeclined
+			if newStatus == models.StatusCanceled {
+				action = models.AuditSignupCanceled
+			}
+			a.logAudit(req, models.ConfirmationEvent(action, models.AuditSuccess, found))
 			res.WriteHeader(http.StatusOK)
 			return
 		}
@@ -136,8 +140,8 @@ func (a *Api) sendSignUpInformation(res http.ResponseWriter, req *http.Request,
 			// send information message to patient
 			var templateName = models.TemplateNamePatientInformation
 
-			emailContent := map[string]interface{}{
-				"Email": usrDetails.Emails[0],
+			emailContent := informationContent{
+				Email: usrDetails.Emails[0],
 			}
 
 			newSignUp, _ = models.NewConfirmation(models.TypeInformation, templateName, usrDetails.UserID)
@@ -150,11 +154,11 @@ func (a *Api) sendSignUpInformation(res http.ResponseWriter, req *http.Request,
 
 			if a.createAndSendNotification(req, newSignUp, emailContent, signerLanguage) {
 				log.Printf("signup information sent for %s", userID)
-				a.logAudit(req, "signup information sent")
+				a.logAudit(req, models.ConfirmationEvent(models.AuditSignupInformationSent, models.AuditSuccess, newSignUp))
 				res.WriteHeader(http.StatusOK)
 				return
 			} else {
-				a.logAudit(req, "signup confirmation failed to be sent")
+				a.logAudit(req, models.ConfirmationEvent(models.AuditSignupInformationSent, models.AuditFailure, newSignUp))
 				log.Print("Something happened generating a signup email")
 				res.WriteHeader(http.StatusUnprocessableEntity)
 			}
@@ -244,7 +248,7 @@ func (a *Api) sendSignUp(res http.ResponseWriter, req *http.Request, vars map[st
 			}
 
 			if a.addOrUpdateConfirmation(req.Context(), newSignUp, res) {
-				a.logAudit(req, "signup confirmation created")
+				a.logAudit(req, models.ConfirmationEvent(models.AuditSignupCreated, models.AuditSuccess, newSignUp))
 
 				if err := a.addProfile(newSignUp); err != nil {
 					log.Printf("sendSignUp: error when adding profile [%s]", err.Error())
@@ -259,15 +263,7 @@ func (a *Api) sendSignUp(res http.ResponseWriter, req *http.Request, vars map[st
 
 					log.Printf("Sending email confirmation to %s with key %s", newSignUp.Email, newSignUp.Key)
 
-					emailContent := map[string]interface{}{
-						"Key":      newSignUp.Key,
-						"Email":    newSignUp.Email,
-						"FullName": profile.FullName,
-					}
-
-					if newSignUp.Creator.Profile != nil {
-						emailContent["CreatorName"] = newSignUp.Creator.Profile.FullName
-					}
+					emailContent := newSignupContent(newSignUp, profile.FullName)
 
 					// on the "signup" page in Blip, the preferred language is now selected by listbox
 					// even if not selected there is one by default so we should normally always end up with
@@ -279,11 +275,11 @@ func (a *Api) sendSignUp(res http.ResponseWriter, req *http.Request, vars map[st
 					}
 
 					if a.createAndSendNotification(req, newSignUp, emailContent, signerLanguage) {
-						a.logAudit(req, "signup confirmation sent")
+						a.logAudit(req, models.ConfirmationEvent(models.AuditSignupSent, models.AuditSuccess, newSignUp))
 						res.WriteHeader(http.StatusOK)
 						return
 					} else {
-						a.logAudit(req, "signup confirmation failed to be sent")
+						a.logAudit(req, models.ConfirmationEvent(models.AuditSignupSent, models.AuditFailure, newSignUp))
 						log.Print("Something happened generating a signup email")
 						res.WriteHeader(http.StatusUnprocessableEntity)
 					}
@@ -326,7 +322,7 @@ func (a *Api) resendSignUp(res http.ResponseWriter, req *http.Request, vars map[
 		}
 
 		if a.addOrUpdateConfirmation(req.Context(), found, res) {
-			a.logAudit(req, "signup confirmation recreated")
+			a.logAudit(req, models.ConfirmationEvent(models.AuditSignupCreated, models.AuditSuccess, found))
 
 			if err := a.addProfile(found); err != nil {
 				log.Printf("resendSignUp: error when adding profile [%s]", err.Error())
@@ -341,15 +337,7 @@ func (a *Api) resendSignUp(res http.ResponseWriter, req *http.Request, vars map[
 
 				log.Printf("Resending email confirmation to %s with key %s", found.Email, found.Key)
 
-				emailContent := map[string]interface{}{
-					"Key":      found.Key,
-					"Email":    found.Email,
-					"FullName": profile.FullName,
-				}
-
-				if found.Creator.Profile != nil {
-					emailContent["CreatorName"] = found.Creator.Profile.FullName
-				}
+				emailContent := newSignupContent(found, profile.FullName)
 
 				// although technically there exists a profile at the signup stage, the preferred language would always be empty here
 				// as it is set in the app and once the signup procedure is complete (after signup email has been confirmed)
@@ -360,9 +348,9 @@ func (a *Api) resendSignUp(res http.ResponseWriter, req *http.Request, vars map[
 				}
 
 				if a.createAndSendNotification(req, found, emailContent, signerLanguage) {
-					a.logAudit(req, "signup confirmation re-sent")
+					a.logAudit(req, models.ConfirmationEvent(models.AuditSignupSent, models.AuditSuccess, found))
 				} else {
-					a.logAudit(req, "signup confirmation failed to be sent")
+					a.logAudit(req, models.ConfirmationEvent(models.AuditSignupSent, models.AuditFailure, found))
 					log.Print("resendSignUp: Something happened trying to resend a signup email")
 					res.WriteHeader(http.StatusUnprocessableEntity)
 					return
@@ -424,7 +412,7 @@ func (a *Api) acceptSignUp(res http.ResponseWriter, req *http.Request, vars map[
 
 		found.UpdateStatus(models.StatusCompleted)
 		if a.addOrUpdateConfirmation(req.Context(), found, res) {
-			a.logAudit(req, "accept signup")
+			a.logAudit(req, models.ConfirmationEvent(models.AuditSignupAccepted, models.AuditSuccess, found))
 		}
 
 		res.WriteHeader(http.StatusOK)
@@ -493,7 +481,7 @@ func (a *Api) getSignUp(res http.ResponseWriter, req *http.Request, vars map[str
 			a.sendModelAsResWithStatus(res, status.NewStatus(http.StatusNotFound, STATUS_SIGNUP_NOT_FOUND), http.StatusNotFound)
 			return
 		} else {
-			a.logAudit(req, "get signups")
+			a.logAudit(req, models.AuditEvent{Action: models.AuditSignupsRead, TargetUser: userId})
 			log.Printf("getSignUp found %d for user %s", len(signups), userId)
 			a.sendModelAsResWithStatus(res, signups, http.StatusOK)
 			return
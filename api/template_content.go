@@ -0,0 +1,318 @@
+package api
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mdblp/crew/store"
+	"github.com/mdblp/hydrophone/models"
+)
+
+// notificationContent is the content a handler sends with a template
+// Each exported field is a variable of the template named after the field, the embedded structs add their fields,
+// the fields tagged `content:"omitempty"` and the nil embedded structs are left out of the content
+// Templates returns the templates the content is sent with
+type notificationContent interface {
+	Templates() []models.TemplateName
+}
+
+type (
+	// expiryContent is the expiration of an invite, nil when it never expires
+	expiryContent struct {
+		ExpiryDate time.Time
+		ExpiryDays int
+	}
+
+	// teamBrandingContent is the branding of the team sending an invite, nil when the team has none
+	teamBrandingContent struct {
+		TeamLogoURL   string `content:"omitempty"`
+		TeamWelcome   string `content:"omitempty"`
+		TeamSignature string `content:"omitempty"`
+		TeamContact   string `content:"omitempty"`
+	}
+
+	careteamInviteContent struct {
+		*expiryContent
+		PatientName  string
+		Email        string
+		WebPath      string
+		CreatorEmail string `content:"omitempty"`
+	}
+
+	medicalteamInviteContent struct {
+		*expiryContent
+		*teamBrandingContent
+		MedicalteamName          string
+		MedicalteamAddress       *store.Address
+		MedicalteamPhone         string
+		MedicalteamIentification string
+		CreatorName              string
+		CreatorEmail             string `content:"omitempty"`
+		Email                    string
+		WebPath                  string
+		Language                 string
+	}
+
+	// teamMemberContent notifies a member of a change of their membership
+	teamMemberContent struct {
+		MedicalteamName string
+		Email           string
+		Language        string
+	}
+
+	resetContent struct {
+		Key      string
+		Email    string
+		ShortKey string
+	}
+
+	passwordResetContent struct {
+		resetContent
+		*expiryContent
+	}
+
+	informationContent struct {
+		Email string
+	}
+
+	pinResetContent struct {
+		Email string
+		OTP   string
+	}
+
+	signupContent struct {
+		Key      string
+		Email    string
+		FullName string
+	}
+
+	// custodialClinicSignupContent is the signup of a patient by their clinic
+	custodialClinicSignupContent struct {
+		signupContent
+		CreatorName string `content:"omitempty"`
+	}
+)
+
+func (careteamInviteContent) Templates() []models.TemplateName {
+	return []models.TemplateName{models.TemplateNameCareteamInvite}
+}
+
+func (medicalteamInviteContent) Templates() []models.TemplateName {
+	return []models.TemplateName{models.TemplateNameMedicalteamInvite, models.TemplateNameMedicalteamPatientInvite}
+}
+
+func (teamMemberContent) Templates() []models.TemplateName {
+	return []models.TemplateName{models.TemplateNameMedicalteamDoAdmin, models.TemplateNameMedicalteamRemove}
+}
+
+func (resetContent) Templates() []models.TemplateName {
+	return []models.TemplateName{models.TemplateNameNoAccount, models.TemplateNamePatientPasswordReset, models.TemplateNamePatientPasswordInfo}
+}
+
+func (passwordResetContent) Templates() []models.TemplateName {
+	return []models.TemplateName{models.TemplateNamePasswordReset}
+}
+
+func (informationContent) Templates() []models.TemplateName {
+	return []models.TemplateName{models.TemplateNamePatientInformation}
+}
+
+func (pinResetContent) Templates() []models.TemplateName {
+	return []models.TemplateName{models.TemplateNamePatientPinReset}
+}
+
+func (signupContent) Templates() []models.TemplateName {
+	return []models.TemplateName{models.TemplateNameSignup, models.TemplateNameSignupClinic, models.TemplateNameSignupCustodial}
+}
+
+func (custodialClinicSignupContent) Templates() []models.TemplateName {
+	return []models.TemplateName{models.TemplateNameSignupCustodialClinic}
+}
+
+// notificationContents are the contents sent by the handlers, createAndSendNotification only sends the one of its template
+var notificationContents = []notificationContent{
+	careteamInviteContent{},
+	medicalteamInviteContent{},
+	teamMemberContent{},
+	resetContent{},
+	passwordResetContent{},
+	informationContent{},
+	pinResetContent{},
+	signupContent{},
+	custodialClinicSignupContent{},
+}
+
+// templateContents is the type of the content sent with each template
+var templateContents = func() map[models.TemplateName]reflect.Type {
+	contents := make(map[models.TemplateName]reflect.Type)
+	for _, content := range notificationContents {
+		for _, name := range content.Templates() {
+			contents[name] = reflect.TypeOf(content)
+		}
+	}
+	return contents
+}()
+
+// newExpiryContent returns the expiration of the confirmation, nil when it never expires
+func newExpiryContent(conf *models.Confirmation) *expiryContent {
+	expiresAt, ok := conf.ExpiresAt()
+	if !ok {
+		return nil
+	}
+	return &expiryContent{ExpiryDate: expiresAt, ExpiryDays: int(expiresAt.Sub(conf.Created).Hours() / 24)}
+}
+
+// newSignupContent returns the content of the signup confirmation, the custodial clinic one names the clinician who created the account
+func newSignupContent(conf *models.Confirmation, fullName string) notificationContent {
+	content := signupContent{Key: conf.Key, Email: conf.Email, FullName: fullName}
+	if conf.TemplateName != models.TemplateNameSignupCustodialClinic {
+		return content
+	}
+	custodial := custodialClinicSignupContent{signupContent: content}
+	if conf.Creator.Profile != nil {
+		custodial.CreatorName = conf.Creator.Profile.FullName
+	}
+	return custodial
+}
+
+// contentValues returns the variables of the content with their values
+func contentValues(content notificationContent) map[string]interface{} {
+	values := make(map[string]interface{})
+	addContentValues(values, reflect.ValueOf(content))
+	return values
+}
+
+func addContentValues(values map[string]interface{}, value reflect.Value) {
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		switch {
+		case field.Anonymous:
+			addContentValues(values, value.Field(i))
+		case field.PkgPath != "":
+			// unexported
+		case field.Tag.Get("content") == "omitempty" && value.Field(i).IsZero():
+		default:
+			values[field.Name] = value.Field(i).Interface()
+		}
+	}
+}
+
+// contentVariables returns the names of the variables of a content type
+func contentVariables(contentType reflect.Type) []string {
+	if contentType.Kind() == reflect.Ptr {
+		contentType = contentType.Elem()
+	}
+	var names []string
+	for i := 0; i < contentType.NumField(); i++ {
+		field := contentType.Field(i)
+		switch {
+		case field.Anonymous:
+			names = append(names, contentVariables(field.Type)...)
+		case field.PkgPath == "":
+			names = append(names, field.Name)
+		}
+	}
+	return names
+}
+
+// serviceVariables are the variables filled by createAndSendNotification from the configuration
+var serviceVariables = []string{"WebURL", "SupportURL", "AssetURL", "PatientPasswordResetURL", "SupportEmail", "SupportAddress", "EncodedEmail"}
+
+// serviceContent returns the values of the service variables for this request, taken from its brand
+func (a *Api) serviceContent(brand Brand, content map[string]interface{}) map[string]interface{} {
+	// Support address configuration contains the mailto we want to strip out
+	supportEmail := fmt.Sprintf("<a href=%s>%s</a>", brand.SupportURL, strings.Replace(brand.SupportURL, "mailto:", "", 1))
+
+	values := map[string]interface{}{
+		"WebURL":                  a.getWebURL(brand),
+		"SupportURL":              brand.SupportURL,
+		"AssetURL":                brand.AssetURL,
+		"PatientPasswordResetURL": brand.PatientPasswordResetURL,
+		"SupportEmail":            supportEmail,
+	}
+	if email, ok := content["Email"].(string); ok {
+		values["EncodedEmail"] = url.QueryEscape(email)
+	}
+	// The bare support address is given only when the support is reached by email, it is used as reply-to address
+	if strings.HasPrefix(brand.SupportURL, "mailto:") {
+		if address, err := mail.ParseAddress(strings.TrimPrefix(brand.SupportURL, "mailto:")); err == nil {
+			values["SupportAddress"] = address.Address
+		}
+	}
+	return values
+}
+
+// creatorEmail returns the email address of the creator of the confirmation, the replies to the invitations being sent to it
+func (a *Api) creatorEmail(conf *models.Confirmation) string {
+	if conf.CreatorId == "" {
+		return ""
+	}
+	if usr := a.findExistingUser(conf.CreatorId, a.sl.TokenProvide()); usr != nil && len(usr.Emails) > 0 {
+		if address, err := mail.ParseAddress(usr.Emails[0]); err == nil {
+			return address.Address
+		}
+	}
+	return ""
+}
+
+// CheckTemplatesContent cross-validates the content provided by the handlers with the variables declared by the templates
+// It is run at startup so a template declaring a variable nobody sends, or a handler sending an undeclared one, is caught before any email
+func CheckTemplatesContent(templates models.Templates) error {
+	var problems []string
+	for name := range templateContents {
+		template, ok := templates[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("template %s is not loaded", name))
+			continue
+		}
+		problems = append(problems, checkTemplateContent(name, template)...)
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("api: templates content mismatch: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// checkTemplateContent returns the mismatches between the content provided by the handlers and the variables declared by one template
+func checkTemplateContent(name models.TemplateName, template models.Template) []string {
+	var problems []string
+	provided := contentVariables(templateContents[name])
+	available := make(map[string]bool, len(provided)+len(serviceVariables))
+	for _, v := range serviceVariables {
+		available[v] = true
+	}
+	declared := make(map[string]bool)
+	for _, v := range template.Variables() {
+		declared[v.Name] = true
+		if v.Required && !available[v.Name] && !contains(provided, v.Name) {
+			problems = append(problems, fmt.Sprintf("template %s requires %s which is never provided", name, v.Name))
+		}
+	}
+	for _, v := range provided {
+		if !declared[v] {
+			problems = append(problems, fmt.Sprintf("template %s does not declare %s", name, v))
+		}
+	}
+	return problems
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
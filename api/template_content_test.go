@@ -0,0 +1,112 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdblp/hydrophone/clients"
+	"github.com/mdblp/hydrophone/localize"
+	"github.com/mdblp/hydrophone/models"
+	"github.com/mdblp/hydrophone/templates"
+)
+
+func TestCheckTemplatesContent(t *testing.T) {
+	emailTemplates, err := templates.New("../templates", localize.NewMockLocalizer(map[string]string{}))
+	if err != nil {
+		t.Fatalf("Failed to load the templates: %s", err)
+	}
+	if err := CheckTemplatesContent(emailTemplates); err != nil {
+		t.Fatalf("Templates content should match the handlers: %s", err)
+	}
+}
+
+func TestCheckTemplatesContent_Mismatch(t *testing.T) {
+	variables := []models.TemplateVariable{
+		{Name: "Email", Type: models.VariableTypeString, Required: true},
+		{Name: "Birthday", Type: models.VariableTypeString, Required: true},
+	}
+	tmpl, err := models.NewPrecompiledTemplate(models.TemplateNamePatientPinReset, "subject", "{{ .Email }}", []string{}, []string{}, variables, localize.NewMockLocalizer(map[string]string{}))
+	if err != nil {
+		t.Fatalf("Failed to create the template: %s", err)
+	}
+	err = CheckTemplatesContent(models.Templates{models.TemplateNamePatientPinReset: tmpl})
+	if err == nil {
+		t.Fatal("Templates content check should have failed")
+	}
+	for _, expected := range []string{
+		"template patient_pin_reset requires Birthday which is never provided",
+		"template patient_pin_reset does not declare OTP",
+		"template password_reset is not loaded",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Error %q should contain %q", err, expected)
+		}
+	}
+}
+
+func TestNotificationContents(t *testing.T) {
+	sent := map[models.TemplateName]int{}
+	for _, content := range notificationContents {
+		for _, name := range content.Templates() {
+			sent[name]++
+		}
+	}
+	for name, count := range sent {
+		if count != 1 {
+			t.Errorf("Template %s should be sent with a single content, got %d", name, count)
+		}
+	}
+
+	variables := contentVariables(templateContents[models.TemplateNamePasswordReset])
+	if strings.Join(variables, ",") != "Key,Email,ShortKey,ExpiryDate,ExpiryDays" {
+		t.Fatalf("The variables of the embedded contents should be listed, got %v", variables)
+	}
+	values := contentValues(passwordResetContent{resetContent: resetContent{Key: "key", Email: "jane@example.com"}})
+	if len(values) != 3 || values["Key"] != "key" || values["ShortKey"] != "" {
+		t.Fatalf("The nil embedded contents should be left out, got %v", values)
+	}
+	values = contentValues(careteamInviteContent{expiryContent: &expiryContent{ExpiryDate: time.Now(), ExpiryDays: 7}, PatientName: "Jane"})
+	if _, found := values["CreatorEmail"]; found || values["ExpiryDays"] != 7 || len(values) != 5 {
+		t.Fatalf("The empty optional variables should be left out, got %v", values)
+	}
+}
+
+func TestSignupContentSent(t *testing.T) {
+	emailTemplates, err := templates.New(FAKE_CONFIG.I18nTemplatesPath, mockLocalizer)
+	if err != nil {
+		t.Fatalf("Failed to load the templates: %s", err)
+	}
+	notifier := clients.NewMockNotifier()
+	hydrophone := InitApi(FAKE_CONFIG, clients.NewMockStoreClient(false, false), notifier, mockShoreline, mockPerms, mockSeagull, mockPortal, emailTemplates)
+
+	for idx, test := range []struct {
+		template models.TemplateName
+		creator  bool
+	}{
+		{template: models.TemplateNameSignup, creator: true},
+		{template: models.TemplateNameSignupClinic, creator: true},
+		{template: models.TemplateNameSignupCustodial, creator: true},
+		{template: models.TemplateNameSignupCustodialClinic, creator: true},
+		{template: models.TemplateNameSignup},
+	} {
+		conf, _ := models.NewConfirmation(models.TypeSignUp, test.template, "")
+		conf.Email = "jane@example.com"
+		if test.creator {
+			conf.Creator.Profile = &models.Profile{FullName: "Dr Who"}
+		}
+		request, _ := http.NewRequest("POST", "/send/signup/"+testing_uid1, nil)
+		if !hydrophone.createAndSendNotification(request, conf, newSignupContent(conf, "Jane Doe"), "en") {
+			t.Fatalf("TestId `%d` `%s` the signup should have been sent", idx, test.template)
+		}
+	}
+
+	// a content is only sent with its templates
+	conf, _ := models.NewConfirmation(models.TypeSignUp, models.TemplateNameSignupCustodialClinic, "")
+	conf.Email = "jane@example.com"
+	request, _ := http.NewRequest("POST", "/send/signup/"+testing_uid1, nil)
+	if hydrophone.createAndSendNotification(request, conf, signupContent{Key: conf.Key, Email: conf.Email}, "en") {
+		t.Fatalf("The content of another template should not be sent")
+	}
+}
@@ -0,0 +1,524 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdblp/hydrophone/clients"
+	"github.com/mdblp/hydrophone/models"
+	"github.com/mdblp/hydrophone/templates"
+)
+
+const (
+	// templateVersionCacheTTL is how long the active version of a template is used before asking the store again
+	// An activation or a rollback made on another instance is taken into account after this delay
+	templateVersionCacheTTL = time.Minute
+	// maxTemplateVersionSize is the maximum size of an uploaded template version, html and locale files included
+	maxTemplateVersionSize = 2 << 20
+
+	STATUS_ERR_TEMPLATE_NOT_FOUND         = "Unknown template"
+	STATUS_ERR_TEMPLATE_VERSION_NOT_FOUND = "Unknown template version"
+	STATUS_ERR_DECODING_TEMPLATE_VERSION  = "Error decoding the template version"
+	STATUS_ERR_TEMPLATE_VERSIONS          = "Error accessing the template versions"
+	STATUS_ERR_RENDERING_TEMPLATE         = "Error rendering the template"
+	STATUS_NO_ACTIVE_TEMPLATE_VERSION     = "No active template version to roll back"
+)
+
+type (
+	// templateVersionBody is the template version uploaded or validated
+	// It holds the same fields as the meta files, the html body and the locale files keyed by locale
+	templateVersionBody struct {
+		Description        string                    `json:"description"`
+		Subject            string                    `json:"subject"`
+		HTML               string                    `json:"html"`
+		ContentParts       []string                  `json:"contentParts"`
+		EscapeContentParts []string                  `json:"escapeContentParts"`
+		Variables          []models.TemplateVariable `json:"variables"`
+		Sender             models.TemplateSender     `json:"sender"`
+		Locales            map[string]string         `json:"locales"`
+	}
+	templateValidation struct {
+		Valid  bool     `json:"valid"`
+		Errors []string `json:"errors"`
+	}
+	templatePreviewBody struct {
+		Lang    string                 `json:"lang"`
+		Content map[string]interface{} `json:"content"`
+	}
+	templatePreview struct {
+		Subject string `json:"subject"`
+		HTML    string `json:"html"`
+		Text    string `json:"text"`
+	}
+	templateRollback struct {
+		Template models.TemplateName `json:"template"`
+		Version  int                 `json:"version"`
+	}
+
+	// templateVersionCache keeps the template sent for each template name with its version, 0 being the disk baseline
+	templateVersionCache struct {
+		mutex   sync.Mutex
+		entries map[models.TemplateName]cachedTemplate
+	}
+	cachedTemplate struct {
+		template models.Template
+		version  int
+		expires  time.Time
+	}
+)
+
+func newTemplateVersionCache() *templateVersionCache {
+	return &templateVersionCache{entries: map[models.TemplateName]cachedTemplate{}}
+}
+
+func (c *templateVersionCache) get(name models.TemplateName) (cachedTemplate, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.entries[name]
+	if !ok || time.Now().After(entry.expires) {
+		return cachedTemplate{}, false
+	}
+	return entry, true
+}
+
+func (c *templateVersionCache) set(name models.TemplateName, template models.Template, version int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[name] = cachedTemplate{template: template, version: version, expires: time.Now().Add(templateVersionCacheTTL)}
+}
+
+func (c *templateVersionCache) forget(name models.TemplateName) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.entries, name)
+}
+
+// resolveTemplate returns the template to send with its version: the active version when there is one, the disk baseline otherwise
+// The baseline is used as well when the store cannot be reached or the active version cannot be built anymore
+func (a *Api) resolveTemplate(ctx context.Context, name models.TemplateName) (models.Template, int, bool) {
+	baseline, ok := a.templates[name]
+	if !ok || a.Localizer == nil {
+		return baseline, 0, ok
+	}
+	if entry, found := a.versions.get(name); found {
+		return entry.template, entry.version, true
+	}
+
+	active, err := a.Store.FindActiveTemplateVersion(ctx, name)
+	if err != nil {
+		log.Printf("resolveTemplate: error finding the active version of %s, using the baseline [%v]", name, err)
+		return baseline, 0, true
+	}
+	if active == nil {
+		a.versions.set(name, baseline, 0)
+		return baseline, 0, true
+	}
+	template, err := templates.NewFromVersion(active, a.Localizer)
+	if err != nil {
+		log.Printf("resolveTemplate: error building %s version %d, using the baseline [%v]", name, active.Version, err)
+		return baseline, 0, true
+	}
+	a.versions.set(name, template, active.Version)
+	return template, active.Version, true
+}
+
+// isServerRequest checks the request is made with a server token, writes the error otherwise
+func (a *Api) isServerRequest(res http.ResponseWriter, req *http.Request) bool {
+	token := a.token(res, req)
+	if token == nil {
+		return false
+	}
+	if !token.IsServer {
+		a.sendError(res, http.StatusUnauthorized, STATUS_UNAUTHORIZED)
+		return false
+	}
+	return true
+}
+
+// templateFromVars returns the name of a template loaded from disk, writes a not found error otherwise
+func (a *Api) templateFromVars(res http.ResponseWriter, vars map[string]string) (models.TemplateName, bool) {
+	name := models.TemplateName(vars["template"])
+	if _, ok := a.templates[name]; !ok {
+		a.sendError(res, http.StatusNotFound, STATUS_ERR_TEMPLATE_NOT_FOUND, "template: "+name.String())
+		return name, false
+	}
+	return name, true
+}
+
+// versionFromVars returns a stored template version, writes the error otherwise
+func (a *Api) versionFromVars(res http.ResponseWriter, req *http.Request, name models.TemplateName, vars map[string]string) (*models.TemplateVersion, bool) {
+	number, err := strconv.Atoi(vars["version"])
+	if err != nil || number < 1 {
+		a.sendError(res, http.StatusNotFound, STATUS_ERR_TEMPLATE_VERSION_NOT_FOUND, "version: "+vars["version"])
+		return nil, false
+	}
+	version, err := a.Store.FindTemplateVersion(req.Context(), name, number)
+	if err != nil {
+		a.sendStoreError(res, err, STATUS_ERR_TEMPLATE_VERSIONS)
+		return nil, false
+	}
+	if version == nil {
+		a.sendError(res, http.StatusNotFound, STATUS_ERR_TEMPLATE_VERSION_NOT_FOUND, "version: "+vars["version"])
+		return nil, false
+	}
+	return version, true
+}
+
+// decodeTemplateVersion reads the template version of the request body, writes the error when it is malformed
+func (a *Api) decodeTemplateVersion(res http.ResponseWriter, req *http.Request, name models.TemplateName) (*models.TemplateVersion, bool) {
+	body := &templateVersionBody{}
+	if err := json.NewDecoder(http.MaxBytesReader(res, req.Body, maxTemplateVersionSize)).Decode(body); err != nil {
+		a.sendError(res, http.StatusBadRequest, STATUS_ERR_DECODING_TEMPLATE_VERSION, err)
+		return nil, false
+	}
+	return &models.TemplateVersion{
+		Template:           name,
+		Description:        body.Description,
+		Subject:            body.Subject,
+		HTML:               body.HTML,
+		ContentParts:       body.ContentParts,
+		EscapeContentParts: body.EscapeContentParts,
+		Variables:          body.Variables,
+		Sender:             body.Sender,
+		Locales:            body.Locales,
+		Created:            time.Now(),
+	}, true
+}
+
+// validateTemplateVersion builds the template of a version and renders it with sample content in every language
+// It returns the problems found: template or locale errors, content not matching the handlers, rendering failures and lint issues
+func (a *Api) validateTemplateVersion(version *models.TemplateVersion) (models.Template, []string) {
+	template, err := templates.NewFromVersion(version, a.Localizer)
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+	var problems []string
+	if _, sent := templateContents[version.Template]; sent {
+		problems = append(problems, checkTemplateContent(version.Template, template)...)
+	}
+
+	content := map[string]interface{}{}
+	for _, v := range template.Variables() {
+		content[v.Name] = sampleValue(v)
+	}
+	// The lint issues are mostly the same in every language, each one is reported once with its languages
+	var issues []string
+	issueLanguages := map[string][]string{}
+	for _, lang := range versionLanguages(a.Localizer.Languages(), version) {
+		_, body, err := template.Execute(content, lang)
+		if err != nil {
+			problems = append(problems, lang+": "+err.Error())
+			continue
+		}
+		if _, _, err := template.ExecuteSender(content, lang); err != nil {
+			problems = append(problems, lang+": "+err.Error())
+		}
+		for _, issue := range templates.LintEmail(body) {
+			if _, ok := issueLanguages[issue.String()]; !ok {
+				issues = append(issues, issue.String())
+			}
+			issueLanguages[issue.String()] = append(issueLanguages[issue.String()], lang)
+		}
+	}
+	for _, issue := range issues {
+		problems = append(problems, issue+" ("+strings.Join(issueLanguages[issue], ", ")+")")
+	}
+	return template, problems
+}
+
+// versionLanguages returns the loaded languages and the ones added by the locale files of the version
+func versionLanguages(loaded []string, version *models.TemplateVersion) []string {
+	languages := append([]string{}, loaded...)
+	var added []string
+	for locale := range version.Locales {
+		if !contains(languages, locale) {
+			added = append(added, locale)
+		}
+	}
+	sort.Strings(added)
+	return append(languages, added...)
+}
+
+// sampleValue returns a value of the variable type, used to render a template version when validating it
+func sampleValue(v models.TemplateVariable) interface{} {
+	switch v.Type {
+	case models.VariableTypeURL:
+		return "https://www.example.com/" + v.Name
+	case models.VariableTypeNumber:
+		return 2
+	case models.VariableTypeDate:
+		return time.Now()
+	case models.VariableTypeGender:
+		return "other"
+	}
+	return v.Name
+}
+
+// @Summary List the versions of a template
+// @Description Server token only, the latest version first
+// @ID hydrophone-api-getTemplateVersions
+// @Produce  json
+// @Param template path string true "template name"
+// @Success 200 {array} models.TemplateVersion "template versions"
+// @Failure 401 {object} status.Status "Authorization token is missing or is not a server token"
+// @Failure 403 {object} status.Status "Authorization token is invalid"
+// @Failure 404 {object} status.Status "Unknown template"
+// @Failure 500 {object} status.Status "Error (internal) while reading the versions"
+// @Router /admin/templates/{template}/versions [get]
+// @security TidepoolAuth
+func (a *Api) GetTemplateVersions(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	if !a.isServerRequest(res, req) {
+		return
+	}
+	name, ok := a.templateFromVars(res, vars)
+	if !ok {
+		return
+	}
+	versions, err := a.Store.FindTemplateVersions(req.Context(), name)
+	if err != nil {
+		a.sendStoreError(res, err, STATUS_ERR_TEMPLATE_VERSIONS)
+		return
+	}
+	if versions == nil {
+		versions = []*models.TemplateVersion{}
+	}
+	a.sendModelAsResWithStatus(res, versions, http.StatusOK)
+}
+
+// @Summary Validate a template version
+// @Description Server token only, builds the template and renders it with sample content in every language without storing it
+// @ID hydrophone-api-validateTemplateVersion
+// @Accept  json
+// @Produce  json
+// @Param template path string true "template name"
+// @Param payload body api.templateVersionBody true "template version"
+// @Success 200 {object} api.templateValidation "validation result, with the problems found"
+// @Failure 400 {object} status.Status "The payload is malformed"
+// @Failure 401 {object} status.Status "Authorization token is missing or is not a server token"
+// @Failure 403 {object} status.Status "Authorization token is invalid"
+// @Failure 404 {object} status.Status "Unknown template"
+// @Router /admin/templates/{template}/validate [post]
+// @security TidepoolAuth
+func (a *Api) ValidateTemplateVersion(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	if !a.isServerRequest(res, req) {
+		return
+	}
+	name, ok := a.templateFromVars(res, vars)
+	if !ok {
+		return
+	}
+	version, ok := a.decodeTemplateVersion(res, req, name)
+	if !ok {
+		return
+	}
+	_, problems := a.validateTemplateVersion(version)
+	a.sendModelAsResWithStatus(res, templateValidation{Valid: len(problems) == 0, Errors: problems}, http.StatusOK)
+}
+
+// @Summary Upload a template version
+// @Description Server token only, the version is validated then stored inactive with the next version number
+// @ID hydrophone-api-uploadTemplateVersion
+// @Accept  json
+// @Produce  json
+// @Param template path string true "template name"
+// @Param payload body api.templateVersionBody true "template version"
+// @Success 201 {object} models.TemplateVersion "stored template version"
+// @Failure 400 {object} api.templateValidation "The payload is malformed or the version is invalid"
+// @Failure 401 {object} status.Status "Authorization token is missing or is not a server token"
+// @Failure 403 {object} status.Status "Authorization token is invalid"
+// @Failure 404 {object} status.Status "Unknown template"
+// @Failure 500 {object} status.Status "Error (internal) while storing the version"
+// @Router /admin/templates/{template}/versions [post]
+// @security TidepoolAuth
+func (a *Api) UploadTemplateVersion(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	if !a.isServerRequest(res, req) {
+		return
+	}
+	name, ok := a.templateFromVars(res, vars)
+	if !ok {
+		return
+	}
+	version, ok := a.decodeTemplateVersion(res, req, name)
+	if !ok {
+		return
+	}
+	if _, problems := a.validateTemplateVersion(version); len(problems) > 0 {
+		log.Printf("UploadTemplateVersion: invalid version of %s %v", name, problems)
+		a.sendModelAsResWithStatus(res, templateValidation{Valid: false, Errors: problems}, http.StatusBadRequest)
+		return
+	}
+	if err := a.Store.InsertTemplateVersion(req.Context(), version); err != nil {
+		a.sendStoreError(res, err, STATUS_ERR_TEMPLATE_VERSIONS)
+		return
+	}
+	a.logAudit(req, models.AuditEvent{Action: models.AuditTemplateVersionUploaded, Details: fmt.Sprintf("template %s version %d", name, version.Version)})
+	a.sendModelAsResWithStatus(res, version, http.StatusCreated)
+}
+
+// @Summary Preview a template version
+// @Description Server token only, renders a version (0 for the disk template) in a language
+// @Description The variables missing from the content are given sample values, the dates may be given as RFC 3339 or YYYY-MM-DD strings
+// @ID hydrophone-api-previewTemplateVersion
+// @Accept  json
+// @Produce  json
+// @Param template path string true "template name"
+// @Param version path int true "template version"
+// @Param payload body api.templatePreviewBody true "language and content"
+// @Success 200 {object} api.templatePreview "rendered subject, html and text"
+// @Failure 400 {object} status.Status "The payload is malformed or the content does not match the template variables"
+// @Failure 401 {object} status.Status "Authorization token is missing or is not a server token"
+// @Failure 403 {object} status.Status "Authorization token is invalid"
+// @Failure 404 {object} status.Status "Unknown template or version"
+// @Failure 500 {object} status.Status "Error (internal) while rendering the version"
+// @Router /admin/templates/{template}/versions/{version}/preview [post]
+// @security TidepoolAuth
+func (a *Api) PreviewTemplateVersion(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	if !a.isServerRequest(res, req) {
+		return
+	}
+	name, ok := a.templateFromVars(res, vars)
+	if !ok {
+		return
+	}
+	template := a.templates[name]
+	if vars["version"] != "0" {
+		version, ok := a.versionFromVars(res, req, name, vars)
+		if !ok {
+			return
+		}
+		var err error
+		if template, err = templates.NewFromVersion(version, a.Localizer); err != nil {
+			a.sendError(res, http.StatusInternalServerError, STATUS_ERR_RENDERING_TEMPLATE, err)
+			return
+		}
+	}
+
+	body := &templatePreviewBody{}
+	if err := json.NewDecoder(http.MaxBytesReader(res, req.Body, maxTemplateVersionSize)).Decode(body); err != nil {
+		a.sendError(res, http.StatusBadRequest, STATUS_ERR_DECODING_TEMPLATE_VERSION, err)
+		return
+	}
+	if body.Lang == "" {
+		body.Lang = "en"
+	}
+
+	// Sample values, then the service variables, then the posted content
+	content := map[string]interface{}{}
+	serviceContent := a.serviceContent(a.brand(req), body.Content)
+	types := map[string]models.VariableType{}
+	for _, v := range template.Variables() {
+		types[v.Name] = v.Type
+		content[v.Name] = sampleValue(v)
+		if value, ok := serviceContent[v.Name].(string); ok && value != "" {
+			content[v.Name] = value
+		}
+	}
+	for k, value := range body.Content {
+		content[k] = types[k].JSONValue(value)
+	}
+
+	subject, html, err := template.Execute(content, body.Lang)
+	if err != nil {
+		if _, invalid := err.(*models.ContentError); invalid {
+			a.sendError(res, http.StatusBadRequest, err.Error())
+			return
+		}
+		a.sendError(res, http.StatusInternalServerError, STATUS_ERR_RENDERING_TEMPLATE, err)
+		return
+	}
+	a.sendModelAsResWithStatus(res, templatePreview{Subject: subject, HTML: html, Text: templates.HTMLToText(html)}, http.StatusOK)
+}
+
+// @Summary Activate a template version
+// @Description Server token only, the version is validated again then sent instead of the current one
+// @ID hydrophone-api-activateTemplateVersion
+// @Produce  json
+// @Param template path string true "template name"
+// @Param version path int true "template version"
+// @Success 200 {object} models.TemplateVersion "activated template version"
+// @Failure 400 {object} api.templateValidation "The version is not valid anymore"
+// @Failure 401 {object} status.Status "Authorization token is missing or is not a server token"
+// @Failure 403 {object} status.Status "Authorization token is invalid"
+// @Failure 404 {object} status.Status "Unknown template or version"
+// @Failure 500 {object} status.Status "Error (internal) while activating the version"
+// @Router /admin/templates/{template}/versions/{version}/activate [put]
+// @security TidepoolAuth
+func (a *Api) ActivateTemplateVersion(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	if !a.isServerRequest(res, req) {
+		return
+	}
+	name, ok := a.templateFromVars(res, vars)
+	if !ok {
+		return
+	}
+	version, ok := a.versionFromVars(res, req, name, vars)
+	if !ok {
+		return
+	}
+	// The disk templates and locales may have changed since the upload
+	if _, problems := a.validateTemplateVersion(version); len(problems) > 0 {
+		log.Printf("ActivateTemplateVersion: invalid version %d of %s %v", version.Version, name, problems)
+		a.sendModelAsResWithStatus(res, templateValidation{Valid: false, Errors: problems}, http.StatusBadRequest)
+		return
+	}
+	if err := a.Store.ActivateTemplateVersion(req.Context(), name, version.Version); err != nil {
+		a.sendStoreError(res, err, STATUS_ERR_TEMPLATE_VERSIONS)
+		return
+	}
+	a.versions.forget(name)
+	a.logAudit(req, models.AuditEvent{Action: models.AuditTemplateVersionActivated, Details: fmt.Sprintf("template %s version %d", name, version.Version)})
+
+	version.Active = true
+	version.RolledBack = false
+	version.Activated = time.Now()
+	a.sendModelAsResWithStatus(res, version, http.StatusOK)
+}
+
+// @Summary Roll back a template version
+// @Description Server token only, deactivates the active version and activates the previously active one, or the disk template when there is none
+// @ID hydrophone-api-rollbackTemplateVersion
+// @Produce  json
+// @Param template path string true "template name"
+// @Success 200 {object} api.templateRollback "template version now sent, 0 for the disk template"
+// @Failure 401 {object} status.Status "Authorization token is missing or is not a server token"
+// @Failure 403 {object} status.Status "Authorization token is invalid"
+// @Failure 404 {object} status.Status "Unknown template or no active version"
+// @Failure 409 {object} status.Status "The active version changed meanwhile"
+// @Failure 500 {object} status.Status "Error (internal) while rolling back the version"
+// @Router /admin/templates/{template}/rollback [put]
+// @security TidepoolAuth
+func (a *Api) RollbackTemplateVersion(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	if !a.isServerRequest(res, req) {
+		return
+	}
+	name, ok := a.templateFromVars(res, vars)
+	if !ok {
+		return
+	}
+	target, err := a.Store.RollbackTemplateVersion(req.Context(), name)
+	if errors.Is(err, clients.ErrNoActiveTemplateVersion) {
+		a.sendError(res, http.StatusNotFound, STATUS_NO_ACTIVE_TEMPLATE_VERSION, "template: "+name.String())
+		return
+	}
+	if err != nil {
+		a.sendStoreError(res, err, STATUS_ERR_TEMPLATE_VERSIONS)
+		return
+	}
+	a.versions.forget(name)
+
+	rollback := templateRollback{Template: name}
+	if target != nil {
+		rollback.Version = target.Version
+	}
+	a.logAudit(req, models.AuditEvent{Action: models.AuditTemplateRolledBack, Details: fmt.Sprintf("template %s version %d", name, rollback.Version)})
+	a.sendModelAsResWithStatus(res, rollback, http.StatusOK)
+}
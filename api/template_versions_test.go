@@ -0,0 +1,239 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/mdblp/hydrophone/clients"
+	"github.com/mdblp/hydrophone/models"
+	"github.com/mdblp/hydrophone/templates"
+)
+
+func TestTemplateVersionsResponds(t *testing.T) {
+	html, err := ioutil.ReadFile("../templates/html/patient_information.html")
+	if err != nil {
+		t.Fatalf("Failed to read the template html: %s", err)
+	}
+	version := testJSONObject{
+		"description":        "new subject",
+		"subject":            "PatientInfoSubject",
+		"html":               string(html),
+		"contentParts":       []string{"PatientInfoHeadline", "PatientInfoBody", "PatientInfoBody2", "PatientInfoBody3", "PatientInfoLogin", "FooterGetSupport"},
+		"escapeContentParts": []string{},
+		"variables": []testJSONObject{
+			{"name": "AssetURL", "type": "url", "required": true},
+			{"name": "SupportURL", "type": "url", "required": true},
+			{"name": "WebURL", "type": "url", "required": true},
+			{"name": "Email", "type": "string", "required": true},
+			{"name": "EncodedEmail", "type": "string", "required": true},
+		},
+		"locales": map[string]string{"en": `PatientInfoSubject: "Your new YourLoops account"`},
+	}
+	invalid := testJSONObject{
+		"subject":            "PatientInfoSubject",
+		"html":               "<p>{{ .PatientInfoHeadline }}</p>",
+		"contentParts":       []string{"PatientInfoHeadline"},
+		"escapeContentParts": []string{},
+		"variables":          []testJSONObject{},
+	}
+	preview := testJSONObject{"lang": "en", "content": testJSONObject{"Email": "patient@example.com"}}
+
+	tests := []toTest{
+		{
+			desc:     "a user token cannot list the versions",
+			method:   "GET",
+			url:      "/admin/templates/patient_information/versions",
+			token:    testing_token_uid1,
+			respCode: http.StatusUnauthorized,
+		},
+		{
+			desc:     "an unknown template has no versions",
+			method:   "GET",
+			url:      "/admin/templates/unknown/versions",
+			token:    testing_token,
+			respCode: http.StatusNotFound,
+		},
+		{
+			desc:     "an invalid version is reported",
+			method:   "POST",
+			url:      "/admin/templates/patient_information/validate",
+			body:     invalid,
+			token:    testing_token,
+			respCode: http.StatusOK,
+			response: testJSONObject{"valid": false},
+		},
+		{
+			desc:     "a valid version is validated",
+			method:   "POST",
+			url:      "/admin/templates/patient_information/validate",
+			body:     version,
+			token:    testing_token,
+			respCode: http.StatusOK,
+			response: testJSONObject{"valid": true},
+		},
+		{
+			desc:     "an invalid version is not stored",
+			method:   "POST",
+			url:      "/admin/templates/patient_information/versions",
+			body:     invalid,
+			token:    testing_token,
+			respCode: http.StatusBadRequest,
+		},
+		{
+			desc:     "a valid version is stored inactive",
+			method:   "POST",
+			url:      "/admin/templates/patient_information/versions",
+			body:     version,
+			token:    testing_token,
+			respCode: http.StatusCreated,
+			response: testJSONObject{"id": "patient_information:1", "version": float64(1), "active": false},
+		},
+		{
+			desc:     "a stored version is previewed with its locale overrides",
+			method:   "POST",
+			url:      "/admin/templates/patient_information/versions/1/preview",
+			body:     preview,
+			token:    testing_token,
+			respCode: http.StatusOK,
+			response: testJSONObject{"subject": "Your new YourLoops account"},
+		},
+		{
+			desc:     "an unknown version cannot be previewed",
+			method:   "POST",
+			url:      "/admin/templates/patient_information/versions/2/preview",
+			body:     preview,
+			token:    testing_token,
+			respCode: http.StatusNotFound,
+		},
+		{
+			desc:     "the disk template preview, as version 0, rejects undeclared variables",
+			method:   "POST",
+			url:      "/admin/templates/patient_information/versions/0/preview",
+			body:     testJSONObject{"lang": "en", "content": testJSONObject{"Unknown": "value"}},
+			token:    testing_token,
+			respCode: http.StatusBadRequest,
+		},
+		{
+			desc:     "there is nothing to roll back before an activation",
+			method:   "PUT",
+			url:      "/admin/templates/patient_information/rollback",
+			token:    testing_token,
+			respCode: http.StatusNotFound,
+		},
+		{
+			desc:     "a stored version is activated",
+			method:   "PUT",
+			url:      "/admin/templates/patient_information/versions/1/activate",
+			token:    testing_token,
+			respCode: http.StatusOK,
+			response: testJSONObject{"version": float64(1), "active": true},
+		},
+		{
+			desc:     "the versions are listed",
+			method:   "GET",
+			url:      "/admin/templates/patient_information/versions",
+			token:    testing_token,
+			respCode: http.StatusOK,
+		},
+	}
+
+	emailTemplates, err := templates.New(FAKE_CONFIG.I18nTemplatesPath, mockLocalizer)
+	if err != nil {
+		t.Fatalf("Failed to load the templates: %s", err)
+	}
+	store := clients.NewMockStoreClient(false, false)
+	hydrophone := InitApi(FAKE_CONFIG, store, mockNotifier, mockShoreline, mockPerms, mockSeagull, mockPortal, emailTemplates)
+	hydrophone.Localizer = mockLocalizer
+	testRtr := mux.NewRouter()
+	hydrophone.SetHandlers("", testRtr)
+	// the user token is checked by the users shoreline mock
+	userHydrophone := InitApi(FAKE_CONFIG, store, mockNotifier, mock_uid1Shoreline, mockPerms, mockSeagull, mockPortal, emailTemplates)
+	userHydrophone.Localizer = mockLocalizer
+	userRtr := mux.NewRouter()
+	userHydrophone.SetHandlers("", userRtr)
+
+	for idx, test := range tests {
+		var body = &bytes.Buffer{}
+		if len(test.body) != 0 {
+			json.NewEncoder(body).Encode(test.body)
+		}
+		request, _ := http.NewRequest(test.method, test.url, body)
+		request.Header.Set(TP_SESSION_TOKEN, test.token)
+		response := httptest.NewRecorder()
+		if test.token == testing_token {
+			testRtr.ServeHTTP(response, request)
+		} else {
+			userRtr.ServeHTTP(response, request)
+		}
+
+		if response.Code != test.respCode {
+			t.Fatalf("TestId `%d` `%s` expected `%d` actual `%d` body `%s`", idx, test.desc, test.respCode, response.Code, response.Body)
+		}
+		if len(test.response) != 0 {
+			var result = &testJSONObject{}
+			if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+				t.Fatalf("TestId `%d` `%s` errored `%s`", idx, test.desc, err)
+			}
+			for k, expected := range test.response {
+				if !reflect.DeepEqual((*result)[k], expected) {
+					t.Fatalf("TestId `%d` `%s` URL `%s` `%s` expected `%v` actual `%v`", idx, test.desc, test.url, k, expected, (*result)[k])
+				}
+			}
+		}
+	}
+}
+
+func TestTemplateVersionsSent(t *testing.T) {
+	emailTemplates, err := templates.New(FAKE_CONFIG.I18nTemplatesPath, mockLocalizer)
+	if err != nil {
+		t.Fatalf("Failed to load the templates: %s", err)
+	}
+	store := clients.NewMockStoreClient(false, false)
+	hydrophone := InitApi(FAKE_CONFIG, store, mockNotifier, mockShoreline, mockPerms, mockSeagull, mockPortal, emailTemplates)
+	hydrophone.Localizer = mockLocalizer
+
+	baseline := emailTemplates[models.TemplateNamePatientInformation]
+	html, _ := ioutil.ReadFile("../templates/html/patient_information.html")
+	version := &models.TemplateVersion{
+		Template:           models.TemplateNamePatientInformation,
+		Subject:            baseline.Subject(),
+		HTML:               string(html),
+		ContentParts:       baseline.ContentParts(),
+		EscapeContentParts: baseline.EscapeParts(),
+		Variables:          baseline.Variables(),
+		Locales:            map[string]string{"en": `PatientInfoSubject: "Your new YourLoops account"`},
+	}
+	store.InsertTemplateVersion(context.Background(), version)
+
+	send := func() (string, int) {
+		conf, _ := models.NewConfirmation(models.TypeInformation, models.TemplateNamePatientInformation, "")
+		conf.Email = "patient@example.com"
+		request, _ := http.NewRequest("POST", "/send/inform/"+testing_uid1, nil)
+		if !hydrophone.createAndSendNotification(request, conf, informationContent{Email: conf.Email}, "en") {
+			t.Fatalf("The notification should have been sent")
+		}
+		return mockNotifier.GetLastEmailSubject(), conf.TemplateVersion
+	}
+
+	baselineSubject, sentVersion := send()
+	if sentVersion != 0 {
+		t.Fatalf("The disk template should be sent before any activation, got version %d", sentVersion)
+	}
+
+	store.ActivateTemplateVersion(context.Background(), models.TemplateNamePatientInformation, version.Version)
+	if subject, _ := send(); subject != baselineSubject {
+		t.Fatalf("The active version should be cached, got subject %q", subject)
+	}
+	hydrophone.versions.forget(models.TemplateNamePatientInformation)
+	if subject, sentVersion := send(); subject != "Your new YourLoops account" || sentVersion != 1 {
+		t.Fatalf("The active version should be sent and recorded, got subject %q and version %d", subject, sentVersion)
+	}
+}
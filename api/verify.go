@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/mdblp/hydrophone/clients"
 	"github.com/tidepool-org/go-common/clients/status"
 )
 
@@ -60,7 +61,9 @@ func (a *Api) sendSanityCheckEmail(res http.ResponseWriter, req *http.Request, v
 			// Here, we assume the email address found for the user is valid
 
 			// Try sending
-			if status, details := a.notifier.Send([]string{recipient}, subject, body); status != http.StatusOK {
+			brand := a.brand(req)
+			message := &clients.Message{FromName: brand.FromName, FromAddress: brand.FromAddress, To: []string{recipient}, Subject: subject, Body: body}
+			if status, details := a.notifier.Send(message); status != http.StatusOK {
 				log.Printf("Issue sending sanity check email: Status [%d] Message [%s]", status, details)
 				res.WriteHeader(http.StatusInternalServerError)
 				return
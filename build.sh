@@ -26,4 +26,4 @@ cp env.sh dist/
 cp start.sh dist/
 
 echo "Push email templates"
-rsync -av --progress templates dist/ --exclude '*.go' --exclude 'preview'
+rsync -av --progress templates dist/ --exclude '*.go' --exclude 'preview' --exclude 'i18ncheck' --exclude 'emaillint' --exclude 'inliner' --exclude 'testdata'
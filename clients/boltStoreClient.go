@@ -0,0 +1,669 @@
+package clients
+
+import (
+	"context"
+	"encoding/binary"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mdblp/hydrophone/models"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// boltOpenTimeout bounds the wait for the lock of the store file, held by another process
+	boltOpenTimeout = 5 * time.Second
+	// boltPurgeInterval is how often the expired audit events are removed, as the mongo TTL monitor does
+	boltPurgeInterval = time.Hour
+)
+
+// BoltClient is a StoreClient keeping its documents in an embedded bbolt file, for the development and the tests
+// The documents are encoded in BSON as in mongo and are looked up with the same semantics as Client,
+// one bucket per mongo collection
+type BoltClient struct {
+	db        *bolt.DB
+	done      chan struct{}
+	closeOnce sync.Once
+	// AuditRetention is how long the audit events are kept, set before Start
+	AuditRetention time.Duration
+}
+
+// NewBoltStore opens the store file, it is created with its buckets when it does not exist
+func NewBoltStore(path string) (*BoltClient, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range []string{confirmationsCollection, templateVersionsCollection, teamBrandingsCollection, auditEventsCollection} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltClient{db: db, done: make(chan struct{}), AuditRetention: DefaultAuditRetention}, nil
+}
+
+// Start removes the expired audit events, then keeps removing them in the background until the store is closed
+func (c *BoltClient) Start() {
+	c.purgeAuditEvents(time.Now())
+	go func() {
+		ticker := time.NewTicker(boltPurgeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.done:
+				return
+			case now := <-ticker.C:
+				c.purgeAuditEvents(now)
+			}
+		}
+	}()
+}
+
+// WaitUntilStarted returns at once, the store file is opened by NewBoltStore
+func (c *BoltClient) WaitUntilStarted() {}
+
+// Close stops the background purge and closes the store file
+func (c *BoltClient) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		err = c.db.Close()
+	})
+	return err
+}
+
+// Ping fails once the store file is closed
+func (c *BoltClient) Ping() error {
+	return c.db.View(func(tx *bolt.Tx) error { return nil })
+}
+
+func (c *BoltClient) PingOK() bool {
+	return c.Ping() == nil
+}
+
+// Collection returns nil, the bolt store has no mongo collection
+func (c *BoltClient) Collection(collectionName string, databaseName ...string) *mongo.Collection {
+	return nil
+}
+
+// decodeDocument decodes a stored document, the value read is only valid during its transaction so it is copied first
+func decodeDocument(raw []byte, document interface{}) error {
+	return bson.Unmarshal(append([]byte(nil), raw...), document)
+}
+
+// getDocument decodes the document of the key, it returns false when there is none
+func getDocument(tx *bolt.Tx, bucket, key string, document interface{}) (bool, error) {
+	raw := tx.Bucket([]byte(bucket)).Get([]byte(key))
+	if raw == nil {
+		return false, nil
+	}
+	return true, decodeDocument(raw, document)
+}
+
+func putDocument(tx *bolt.Tx, bucket, key string, document interface{}) error {
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(bucket)).Put([]byte(key), raw)
+}
+
+// setFields sets the fields on the stored document as the mongo $set operator, the other fields are kept
+// The fields are a struct or a map, they are encoded in BSON to be merged, the result is decoded in document
+func setFields(raw []byte, fields interface{}, document interface{}) error {
+	merged := bson.M{}
+	if raw != nil {
+		if err := decodeDocument(raw, &merged); err != nil {
+			return err
+		}
+	}
+	encoded, err := bson.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	var set bson.M
+	if err := bson.Unmarshal(encoded, &set); err != nil {
+		return err
+	}
+	for field, value := range set {
+		merged[field] = value
+	}
+	if encoded, err = bson.Marshal(merged); err != nil {
+		return err
+	}
+	return bson.Unmarshal(encoded, document)
+}
+
+// lookupString returns the string field of an encoded document, empty when it is missing
+func lookupString(raw bson.Raw, field string) string {
+	value, _ := raw.Lookup(field).StringValueOK()
+	return value
+}
+
+// putConfirmation saves a confirmation, refusing a second pending invite for the same email and inviter
+// as the partial unique indexes of mongo do
+func putConfirmation(tx *bolt.Tx, confirmation *models.Confirmation) error {
+	raw, err := bson.Marshal(confirmation)
+	if err != nil {
+		return err
+	}
+	bucket := tx.Bucket([]byte(confirmationsCollection))
+	for _, invite := range pendingInvites {
+		if confirmation.Status != models.StatusPending || confirmation.Type != invite.Type {
+			continue
+		}
+		unique := []string{"status", "type", "emailLower", invite.Inviter}
+		err := bucket.ForEach(func(key, other []byte) error {
+			if string(key) == confirmation.Key {
+				return nil
+			}
+			for _, field := range unique {
+				if lookupString(other, field) != lookupString(raw, field) {
+					return nil
+				}
+			}
+			return ErrDuplicateInvite
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return bucket.Put([]byte(confirmation.Key), raw)
+}
+
+// UpsertConfirmation creates or updates a confirmation, with the canonical form of its email
+// ErrDuplicateInvite is returned when another invite is pending for the same email and inviter
+func (c *BoltClient) UpsertConfirmation(ctx context.Context, confirmation *models.Confirmation) error {
+	confirmation.EmailLower = models.CanonicalEmail(confirmation.Email)
+	// the revision and the history are only changed by the store, as in mongo
+	saved := *confirmation
+	saved.Revision = 0
+	saved.History = nil
+	return c.db.Update(func(tx *bolt.Tx) error {
+		raw := tx.Bucket([]byte(confirmationsCollection)).Get([]byte(confirmation.Key))
+		var updated models.Confirmation
+		if err := setFields(raw, &saved, &updated); err != nil {
+			return err
+		}
+		updated.Revision++
+		return putConfirmation(tx, &updated)
+	})
+}
+
+// findConfirmations returns the confirmations matching the filter as the mongo queries do, the latest created first
+func (c *BoltClient) findConfirmations(filter *models.Confirmation, statuses []models.Status, types []models.Type) (results []*models.Confirmation, err error) {
+	emailLower := models.CanonicalEmail(filter.Email)
+	if len(types) == 0 && filter.Type != "" {
+		types = []models.Type{filter.Type}
+	}
+	hasStatus := func(confirmation *models.Confirmation) bool {
+		for _, status := range statuses {
+			if confirmation.Status == status {
+				return true
+			}
+		}
+		return len(statuses) == 0
+	}
+	hasType := func(confirmation *models.Confirmation) bool {
+		for _, t := range types {
+			if confirmation.Type == t {
+				return true
+			}
+		}
+		return len(types) == 0
+	}
+	err = c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(confirmationsCollection)).ForEach(func(key, raw []byte) error {
+			var confirmation models.Confirmation
+			if err := decodeDocument(raw, &confirmation); err != nil {
+				return err
+			}
+			switch {
+			case filter.Email != "" && confirmation.EmailLower != emailLower,
+				filter.Key != "" && confirmation.Key != filter.Key,
+				filter.CreatorId != "" && confirmation.CreatorId != filter.CreatorId,
+				filter.UserId != "" && confirmation.UserId != filter.UserId,
+				filter.ShortKey != "" && confirmation.ShortKey != filter.ShortKey,
+				filter.Team != nil && filter.Team.ID != "" && (confirmation.Team == nil || confirmation.Team.ID != filter.Team.ID),
+				!hasStatus(&confirmation),
+				!hasType(&confirmation):
+				return nil
+			}
+			results = append(results, &confirmation)
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("FindConfirmations: something bad happened [%v]", err)
+		return nil, err
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Created.After(results[j].Created) })
+	return results, nil
+}
+
+// FindConfirmation returns latest created confirmation matching filter passed as parameter
+func (c *BoltClient) FindConfirmation(ctx context.Context, confirmation *models.Confirmation) (*models.Confirmation, error) {
+	var statuses []models.Status
+	if confirmation.Status != "" {
+		statuses = []models.Status{confirmation.Status}
+	}
+	results, err := c.findConfirmations(confirmation, statuses, nil)
+	if err != nil || len(results) == 0 {
+		return nil, err
+	}
+	return results[0], nil
+}
+
+// FindConfirmations returns all created confirmations matching filter passed as parameter
+// As in mongo the status of the filter is ignored, only the statuses given are
+func (c *BoltClient) FindConfirmations(ctx context.Context, confirmation *models.Confirmation, statuses []models.Status, types []models.Type) ([]*models.Confirmation, error) {
+	return c.findConfirmations(confirmation, statuses, types)
+}
+
+// FindUserConfirmations returns the confirmations created by the user, sent to the user or to any of the emails, the latest first
+func (c *BoltClient) FindUserConfirmations(ctx context.Context, userID string, emails []string) (results []*models.Confirmation, err error) {
+	canonical := make(map[string]bool, len(emails))
+	for _, email := range emails {
+		canonical[models.CanonicalEmail(email)] = true
+	}
+	err = c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(confirmationsCollection)).ForEach(func(key, raw []byte) error {
+			var confirmation models.Confirmation
+			if err := decodeDocument(raw, &confirmation); err != nil {
+				return err
+			}
+			if confirmation.CreatorId == userID || confirmation.UserId == userID || canonical[confirmation.EmailLower] {
+				results = append(results, &confirmation)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("FindUserConfirmations: something bad happened [%v]", err)
+		return nil, err
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Created.After(results[j].Created) })
+	return results, nil
+}
+
+// PseudonymizeUserConfirmations replaces the identifiers of the user in the confirmations involving the user or any of the emails
+// It returns the number of confirmations changed, none once done
+func (c *BoltClient) PseudonymizeUserConfirmations(ctx context.Context, userID string, emails []string, pseudonym *models.Pseudonym) (int64, error) {
+	var changed []*models.Confirmation
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(confirmationsCollection))
+		// the bucket can't be changed while iterating it
+		err := bucket.ForEach(func(key, raw []byte) error {
+			var confirmation models.Confirmation
+			if err := decodeDocument(raw, &confirmation); err != nil {
+				return err
+			}
+			if confirmation.Pseudonymize(userID, emails, pseudonym) {
+				confirmation.Revision++
+				changed = append(changed, &confirmation)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, confirmation := range changed {
+			if err := putDocument(tx, confirmationsCollection, confirmation.Key, confirmation); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(changed)), nil
+}
+
+// RemoveConfirmation deletes confirmation based on key
+func (c *BoltClient) RemoveConfirmation(ctx context.Context, confirmation *models.Confirmation) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(confirmationsCollection)).Delete([]byte(confirmation.Key))
+	})
+}
+
+// TransitionStatus changes the status of a confirmation only if it still has the expected status and revision, with the fields of the patch
+// The check and the update are done in the same write transaction, the second of two concurrent transitions gets a StatusConflictError
+// The change is appended to the history of the confirmation, it returns the confirmation updated
+func (c *BoltClient) TransitionStatus(ctx context.Context, key string, revision int, change models.StatusChange, patch map[string]interface{}) (*models.Confirmation, error) {
+	if change.Time.IsZero() {
+		change.Time = time.Now()
+	}
+	var result models.Confirmation
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		raw := tx.Bucket([]byte(confirmationsCollection)).Get([]byte(key))
+		var current models.Confirmation
+		if raw != nil {
+			if err := decodeDocument(raw, &current); err != nil {
+				return err
+			}
+		}
+		if raw == nil || current.Status != change.From || current.Revision != revision {
+			return &StatusConflictError{Key: key, Expected: change.From, Actual: current.Status, ExpectedRevision: revision, ActualRevision: current.Revision}
+		}
+		set := bson.M{}
+		for field, value := range patch {
+			set[field] = value
+		}
+		set["status"] = change.To
+		set["modified"] = change.Time
+		if err := setFields(raw, set, &result); err != nil {
+			return err
+		}
+		result.Revision++
+		result.History = append(result.History, change)
+		return putConfirmation(tx, &result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SetConfirmationTemplateVersion records the template version sent for an existing confirmation
+func (c *BoltClient) SetConfirmationTemplateVersion(ctx context.Context, key string, version int) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		raw := tx.Bucket([]byte(confirmationsCollection)).Get([]byte(key))
+		if raw == nil {
+			return nil
+		}
+		var updated models.Confirmation
+		if err := setFields(raw, bson.M{"templateVersion": version}, &updated); err != nil {
+			return err
+		}
+		return putDocument(tx, confirmationsCollection, key, &updated)
+	})
+}
+
+// templateVersions returns the versions of a template, the latest first
+func templateVersions(tx *bolt.Tx, name models.TemplateName) (results []*models.TemplateVersion, err error) {
+	err = tx.Bucket([]byte(templateVersionsCollection)).ForEach(func(key, raw []byte) error {
+		var version models.TemplateVersion
+		if err := decodeDocument(raw, &version); err != nil {
+			return err
+		}
+		if version.Template == name {
+			results = append(results, &version)
+		}
+		return nil
+	})
+	sort.Slice(results, func(i, j int) bool { return results[i].Version > results[j].Version })
+	return results, err
+}
+
+// activateTemplateVersion makes a version the active one of its template and deactivates the others
+func activateTemplateVersion(tx *bolt.Tx, name models.TemplateName, version int) error {
+	versions, err := templateVersions(tx, name)
+	if err != nil {
+		return err
+	}
+	var activated *models.TemplateVersion
+	for _, v := range versions {
+		if v.Version == version {
+			activated = v
+		}
+	}
+	if activated == nil {
+		return ErrTemplateVersionNotFound
+	}
+	for _, v := range versions {
+		switch {
+		case v == activated:
+			v.Active, v.RolledBack, v.Activated = true, false, time.Now()
+		case v.Active:
+			v.Active = false
+		default:
+			continue
+		}
+		if err := putDocument(tx, templateVersionsCollection, v.ID, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertTemplateVersion stores a new version of a template, numbered after the last one
+func (c *BoltClient) InsertTemplateVersion(ctx context.Context, version *models.TemplateVersion) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		versions, err := templateVersions(tx, version.Template)
+		if err != nil {
+			return err
+		}
+		version.Version = 1
+		if len(versions) > 0 {
+			version.Version = versions[0].Version + 1
+		}
+		version.ID = models.TemplateVersionID(version.Template, version.Version)
+		return putDocument(tx, templateVersionsCollection, version.ID, version)
+	})
+}
+
+// FindTemplateVersions returns the versions of a template, the latest first
+func (c *BoltClient) FindTemplateVersions(ctx context.Context, name models.TemplateName) (results []*models.TemplateVersion, err error) {
+	err = c.db.View(func(tx *bolt.Tx) error {
+		results, err = templateVersions(tx, name)
+		return err
+	})
+	return results, err
+}
+
+// FindTemplateVersion returns a version of a template, nil when it does not exist
+func (c *BoltClient) FindTemplateVersion(ctx context.Context, name models.TemplateName, version int) (*models.TemplateVersion, error) {
+	var result models.TemplateVersion
+	var found bool
+	err := c.db.View(func(tx *bolt.Tx) (err error) {
+		found, err = getDocument(tx, templateVersionsCollection, models.TemplateVersionID(name, version), &result)
+		return err
+	})
+	if err != nil || !found {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// FindActiveTemplateVersion returns the active version of a template, nil when the disk baseline is used
+func (c *BoltClient) FindActiveTemplateVersion(ctx context.Context, name models.TemplateName) (result *models.TemplateVersion, err error) {
+	versions, err := c.FindTemplateVersions(ctx, name)
+	for _, v := range versions {
+		if v.Active && (result == nil || v.Activated.After(result.Activated)) {
+			result = v
+		}
+	}
+	return result, err
+}
+
+// ActivateTemplateVersion makes a version the one sent for its template and deactivates the others
+func (c *BoltClient) ActivateTemplateVersion(ctx context.Context, name models.TemplateName, version int) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return activateTemplateVersion(tx, name, version)
+	})
+}
+
+// RollbackTemplateVersion deactivates the active version of a template and activates the previous one
+// It returns the version activated, nil when the template goes back to its disk baseline
+func (c *BoltClient) RollbackTemplateVersion(ctx context.Context, name models.TemplateName) (target *models.TemplateVersion, err error) {
+	err = c.db.Update(func(tx *bolt.Tx) error {
+		versions, err := templateVersions(tx, name)
+		if err != nil {
+			return err
+		}
+		var current *models.TemplateVersion
+		if current, target = models.RollbackTarget(versions); current == nil {
+			return ErrNoActiveTemplateVersion
+		}
+		if target != nil {
+			if err := activateTemplateVersion(tx, name, target.Version); err != nil {
+				return err
+			}
+			// the target is read again once activated
+			if _, err := getDocument(tx, templateVersionsCollection, target.ID, target); err != nil {
+				return err
+			}
+		}
+		current.Active, current.RolledBack = false, true
+		return putDocument(tx, templateVersionsCollection, current.ID, current)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// FindTeamBranding returns the branding of a medical team, nil when it has none
+func (c *BoltClient) FindTeamBranding(ctx context.Context, teamID string) (*models.TeamBranding, error) {
+	var result models.TeamBranding
+	var found bool
+	err := c.db.View(func(tx *bolt.Tx) (err error) {
+		found, err = getDocument(tx, teamBrandingsCollection, teamID, &result)
+		return err
+	})
+	if err != nil || !found {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// UpsertTeamBranding creates or replaces the branding of a medical team
+func (c *BoltClient) UpsertTeamBranding(ctx context.Context, branding *models.TeamBranding) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return putDocument(tx, teamBrandingsCollection, branding.TeamID, branding)
+	})
+}
+
+// RemoveTeamBranding deletes the branding of a medical team
+func (c *BoltClient) RemoveTeamBranding(ctx context.Context, teamID string) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(teamBrandingsCollection)).Delete([]byte(teamID))
+	})
+}
+
+// InsertAuditEvent records an event in the audit log, keyed by its insertion order
+func (c *BoltClient) InsertAuditEvent(ctx context.Context, event *models.AuditEvent) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(auditEventsCollection))
+		sequence, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, sequence)
+		raw, err := bson.Marshal(event)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, raw)
+	})
+}
+
+// FindAuditEvents returns the audit events selected by the filter, the latest first
+func (c *BoltClient) FindAuditEvents(ctx context.Context, filter *models.AuditFilter) (results []*models.AuditEvent, err error) {
+	err = c.db.View(func(tx *bolt.Tx) error {
+		// read from the last inserted so the events of the same time are the latest first too
+		cursor := tx.Bucket([]byte(auditEventsCollection)).Cursor()
+		for key, raw := cursor.Last(); key != nil; key, raw = cursor.Prev() {
+			var event models.AuditEvent
+			if err := decodeDocument(raw, &event); err != nil {
+				return err
+			}
+			if filter.Match(&event) {
+				results = append(results, &event)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("FindAuditEvents: something bad happened [%v]", err)
+		return nil, err
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Time.After(results[j].Time) })
+	if filter.Limit > 0 && len(results) > filter.Limit {
+		results = results[:filter.Limit]
+	}
+	return results, nil
+}
+
+// PseudonymizeUserAuditEvents replaces the user ID and the hashes of the emails of the user in the audit events
+// It returns the number of events changed, none once done
+func (c *BoltClient) PseudonymizeUserAuditEvents(ctx context.Context, userID string, emailHashes []string, pseudonym *models.Pseudonym) (int64, error) {
+	var changed int64
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(auditEventsCollection))
+		// the bucket can't be changed while iterating it
+		updates := make(map[string][]byte)
+		err := bucket.ForEach(func(key, raw []byte) error {
+			var event models.AuditEvent
+			if err := decodeDocument(raw, &event); err != nil {
+				return err
+			}
+			if !event.Pseudonymize(userID, emailHashes, pseudonym) {
+				return nil
+			}
+			updated, err := bson.Marshal(&event)
+			if err != nil {
+				return err
+			}
+			updates[string(key)] = updated
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for key, raw := range updates {
+			if err := bucket.Put([]byte(key), raw); err != nil {
+				return err
+			}
+		}
+		changed = int64(len(updates))
+		return nil
+	})
+	if err != nil {
+		log.Printf("PseudonymizeUserAuditEvents: something bad happened [%v]", err)
+		return 0, err
+	}
+	return changed, nil
+}
+
+// purgeAuditEvents removes the audit events older than the retention
+func (c *BoltClient) purgeAuditEvents(now time.Time) {
+	expired := now.Add(-c.AuditRetention)
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(auditEventsCollection))
+		var keys [][]byte
+		err := bucket.ForEach(func(key, raw []byte) error {
+			var event models.AuditEvent
+			if err := decodeDocument(raw, &event); err != nil {
+				return err
+			}
+			if event.Time.Before(expired) {
+				keys = append(keys, append([]byte(nil), key...))
+			}
+			return nil
+		})
+		// the bucket can't be changed while iterated
+		for _, key := range keys {
+			if err == nil {
+				err = bucket.Delete(key)
+			}
+		}
+		return err
+	})
+	if err != nil {
+		log.Printf("purgeAuditEvents: something bad happened [%v]", err)
+	}
+}
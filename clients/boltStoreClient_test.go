@@ -0,0 +1,47 @@
+package clients
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mdblp/hydrophone/models"
+)
+
+func newTestBoltStore(t *testing.T) *BoltClient {
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "hydrophone.db"))
+	if err != nil {
+		t.Fatalf("we could not open the bolt store - err [%v]", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestBoltStoreConformance(t *testing.T) {
+	testStoreConformance(t, func(t *testing.T) StoreClient {
+		return newTestBoltStore(t)
+	})
+}
+
+func TestBoltStorePurgeAuditEvents(t *testing.T) {
+	store := newTestBoltStore(t)
+	store.AuditRetention = 24 * time.Hour
+	ctx := context.Background()
+
+	expired := &models.AuditEvent{Time: time.Now().Add(-48 * time.Hour), Action: models.AuditInviteSent}
+	kept := &models.AuditEvent{Time: time.Now(), Action: models.AuditInviteAccepted}
+	for _, event := range []*models.AuditEvent{expired, kept} {
+		if err := store.InsertAuditEvent(ctx, event); err != nil {
+			t.Fatalf("we could not save the audit event - err [%v]", err)
+		}
+	}
+	store.Start()
+	found, err := store.FindAuditEvents(ctx, &models.AuditFilter{})
+	if err != nil || len(found) != 1 || found[0].Action != models.AuditInviteAccepted {
+		t.Fatalf("only the event within the retention should be kept [%v] - err [%v]", found, err)
+	}
+	if err := store.Close(); err != nil || store.PingOK() {
+		t.Fatalf("the store should be closed - err [%v]", err)
+	}
+}
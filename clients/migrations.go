@@ -0,0 +1,278 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/mdblp/hydrophone/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	migrationsCollection = "migrations"
+	// migrationsLockID is the document of the migrations collection held by the instance running the migrations
+	migrationsLockID = "lock"
+	// migrationsTimeout bounds the migrations done at once, the lock expires after it
+	// so the migrations are run again by another instance when the one holding it stopped meanwhile
+	migrationsTimeout = 30 * time.Minute
+)
+
+// ErrMigrationsLocked is returned when another instance is running the migrations
+var ErrMigrationsLocked = errors.New("clients: the migrations are run by another instance")
+
+type (
+	// Migration is a change of the documents stored in a former shape
+	// Up must be idempotent: it only changes the documents not migrated yet,
+	// it returns the number of documents changed
+	Migration struct {
+		ID          string
+		Description string
+		Up          func(c *Client, ctx context.Context) (int64, error)
+	}
+
+	// AppliedMigration is the record of a migration done
+	AppliedMigration struct {
+		ID      string    `json:"id" bson:"_id"`
+		Applied time.Time `json:"applied" bson:"applied"`
+		Changed int64     `json:"changed" bson:"changed"`
+	}
+)
+
+// migrations are applied in order, once, an applied migration must never be changed nor removed
+var migrations = []Migration{
+	{
+		ID:          "0001_email_lower",
+		Description: "set the canonical email of the confirmations",
+		Up:          (*Client).BackfillEmailLower,
+	},
+	{
+		ID:          "0002_inline_team",
+		Description: "move the team of the confirmations at the root of the document",
+		Up:          (*Client).InlineTeam,
+	},
+	{
+		ID:          "0003_cancel_duplicate_invites",
+		Description: "cancel the pending invites sent before the latest one for the same email and inviter",
+		Up:          (*Client).CancelDuplicateInvites,
+	},
+	{
+		ID:          "0004_unset_creator",
+		Description: "remove the creator profiles stored in the confirmations",
+		Up:          (*Client).UnsetCreator,
+	},
+	{
+		ID:          "0005_template_name",
+		Description: "set the template name of the confirmations from their type",
+		Up:          (*Client).BackfillTemplateName,
+	},
+}
+
+func mgoMigrationsCollection(c *Client) *mongo.Collection {
+	return c.Collection(migrationsCollection)
+}
+
+// AppliedMigrations returns the migrations done, in the order they were applied
+func (c *Client) AppliedMigrations(ctx context.Context) (results []*AppliedMigration, err error) {
+	opts := options.Find().SetSort(bson.D{{"applied", 1}, {"_id", 1}})
+	cursor, err := mgoMigrationsCollection(c).Find(ctx, bson.M{"applied": bson.M{"$exists": true}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	err = cursor.All(ctx, &results)
+	return results, err
+}
+
+// MigrationsDone tells if all the migrations are applied and none is running, the lock of the migrations being released or expired
+func (c *Client) MigrationsDone(ctx context.Context) (bool, error) {
+	done, err := c.AppliedMigrations(ctx)
+	if err != nil {
+		return false, err
+	}
+	applied := make(map[string]bool, len(done))
+	for _, migration := range done {
+		applied[migration.ID] = true
+	}
+	for _, migration := range migrations {
+		if !applied[migration.ID] {
+			return false, nil
+		}
+	}
+	locked, err := mgoMigrationsCollection(c).CountDocuments(ctx, bson.M{"_id": migrationsLockID, "expires": bson.M{"$gte": time.Now()}})
+	return locked == 0, err
+}
+
+// Migrate applies the migrations not done yet, in order, holding the lock of the migrations
+// It returns the migrations applied, ErrMigrationsLocked when another instance is running them
+func (c *Client) Migrate(ctx context.Context) ([]*AppliedMigration, error) {
+	owner, err := c.lockMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.unlockMigrations(owner)
+
+	done, err := c.AppliedMigrations(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("clients: failure to list the migrations applied: %s", err)
+	}
+	applied := make(map[string]bool, len(done))
+	for _, migration := range done {
+		applied[migration.ID] = true
+	}
+	var results []*AppliedMigration
+	for _, migration := range migrations {
+		if applied[migration.ID] {
+			continue
+		}
+		changed, err := migration.Up(c, ctx)
+		if err != nil {
+			return results, fmt.Errorf("clients: migration %s failed after %d changes: %s", migration.ID, changed, err)
+		}
+		result := &AppliedMigration{ID: migration.ID, Applied: time.Now(), Changed: changed}
+		if _, err := mgoMigrationsCollection(c).InsertOne(ctx, result); err != nil {
+			return results, fmt.Errorf("clients: failure to record the migration %s: %s", migration.ID, err)
+		}
+		log.Printf("Migrate: %s applied, %s on %d confirmations", migration.ID, migration.Description, changed)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// lockMigrations takes the lock of the migrations when it is free or expired, it returns the owner releasing it
+func (c *Client) lockMigrations(ctx context.Context) (string, error) {
+	hostname, _ := os.Hostname()
+	owner := fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano())
+	now := time.Now()
+	query := bson.M{"_id": migrationsLockID, "expires": bson.M{"$lt": now}}
+	update := bson.M{"$set": bson.M{"owner": owner, "locked": now, "expires": now.Add(migrationsTimeout)}}
+	// the lock held by another instance does not match, its upsert is refused by the unique _id
+	_, err := mgoMigrationsCollection(c).UpdateOne(ctx, query, update, options.Update().SetUpsert(true))
+	if isDuplicateKeyError(err) {
+		return "", ErrMigrationsLocked
+	}
+	if err != nil {
+		return "", fmt.Errorf("clients: failure to lock the migrations: %s", err)
+	}
+	return owner, nil
+}
+
+// unlockMigrations releases the lock, unless it expired and was taken by another instance
+func (c *Client) unlockMigrations(owner string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := mgoMigrationsCollection(c).DeleteOne(ctx, bson.M{"_id": migrationsLockID, "owner": owner}); err != nil {
+		log.Printf("Migrate: failure to release the lock [%v]", err)
+	}
+}
+
+// updateConfirmations applies the update built for each confirmation matching the query, by batches, a nil update skips it
+// It returns the number of confirmations updated
+func (c *Client) updateConfirmations(ctx context.Context, query bson.M, projection bson.M, build func(raw bson.Raw) (bson.M, error)) (int64, error) {
+	return updateDocuments(ctx, mgoConfirmationsCollection(c), query, projection, build)
+}
+
+// updateDocuments applies the update built for each document of the collection matching the query, by batches, a nil update skips it
+// It returns the number of documents updated
+func updateDocuments(ctx context.Context, collection *mongo.Collection, query bson.M, projection bson.M, build func(raw bson.Raw) (bson.M, error)) (int64, error) {
+	cursor, err := collection.Find(ctx, query, options.Find().SetProjection(projection))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var updated int64
+	updates := make([]mongo.WriteModel, 0, backfillBatchSize)
+	flush := func() error {
+		if len(updates) == 0 {
+			return nil
+		}
+		result, err := collection.BulkWrite(ctx, updates, options.BulkWrite().SetOrdered(false))
+		if result != nil {
+			updated += result.ModifiedCount
+		}
+		updates = updates[:0]
+		return err
+	}
+	for cursor.Next(ctx) {
+		update, err := build(cursor.Current)
+		if err != nil {
+			return updated, err
+		}
+		if update == nil {
+			continue
+		}
+		updates = append(updates, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": cursor.Current.Lookup("_id")}).
+			SetUpdate(update))
+		if len(updates) == backfillBatchSize {
+			if err := flush(); err != nil {
+				return updated, err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return updated, err
+	}
+	return updated, flush()
+}
+
+// InlineTeam moves the team stored in a sub-document by the former versions to the teamId of the confirmation
+// It returns the number of confirmations updated
+func (c *Client) InlineTeam(ctx context.Context) (int64, error) {
+	query := bson.M{"team": bson.M{"$exists": true}}
+	return c.updateConfirmations(ctx, query, bson.M{"team": 1, "teamId": 1}, func(raw bson.Raw) (bson.M, error) {
+		var confirmation struct {
+			TeamID string `bson:"teamId"`
+			Team   struct {
+				TeamID string `bson:"teamId"`
+				ID     string `bson:"id"`
+			} `bson:"team"`
+		}
+		if err := bson.Unmarshal(raw, &confirmation); err != nil {
+			return nil, err
+		}
+		update := bson.M{"$unset": bson.M{"team": ""}}
+		if confirmation.TeamID == "" {
+			teamID := confirmation.Team.TeamID
+			if teamID == "" {
+				teamID = confirmation.Team.ID
+			}
+			update["$set"] = bson.M{"teamId": teamID}
+		}
+		return update, nil
+	})
+}
+
+// UnsetCreator empties the creator profiles stored by the former versions, the creator is fetched when a confirmation is returned
+// It returns the number of confirmations updated
+func (c *Client) UnsetCreator(ctx context.Context) (int64, error) {
+	query := bson.M{"creator": bson.M{"$exists": true, "$ne": bson.M{}}}
+	result, err := mgoConfirmationsCollection(c).UpdateMany(ctx, query, bson.M{"$set": bson.M{"creator": bson.M{}}})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// BackfillTemplateName sets the template name of the confirmations stored before it existed
+// It is the template sent to them, the default one of their type
+// It returns the number of confirmations updated
+func (c *Client) BackfillTemplateName(ctx context.Context) (int64, error) {
+	var updated int64
+	for confirmationType, templateName := range models.DefaultTemplateNames {
+		query := bson.M{"type": confirmationType, "templateName": bson.M{"$in": bson.A{nil, ""}}}
+		update := bson.M{"$set": bson.M{"templateName": templateName}}
+		result, err := mgoConfirmationsCollection(c).UpdateMany(ctx, query, update)
+		if err != nil {
+			return updated, err
+		}
+		updated += result.ModifiedCount
+	}
+	return updated, nil
+}
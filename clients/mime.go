@@ -0,0 +1,107 @@
+package clients
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+)
+
+// base64LineLength is the maximum length of the base64 lines of the images, as required by RFC 2045
+const base64LineLength = 76
+
+// mimeBytes returns the message in the MIME format, sent from this address
+// It is a text and html alternative, the html being related to its inline images when it has some
+func (m *Message) mimeBytes(from string) ([]byte, error) {
+	var buffer bytes.Buffer
+	alternative := multipart.NewWriter(&buffer)
+
+	fmt.Fprintf(&buffer, "From: %s\r\n", from)
+	if m.ReplyTo != "" {
+		fmt.Fprintf(&buffer, "Reply-To: %s\r\n", m.ReplyTo)
+	}
+	fmt.Fprintf(&buffer, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&buffer, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buffer, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buffer, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", alternative.Boundary())
+
+	text, err := alternative.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {`text/plain; charset="UTF-8"`},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeQuotedPrintable(text, DefaultTextMessage); err != nil {
+		return nil, err
+	}
+
+	// the html and its images are written apart, the related part is added to the alternative once complete
+	htmlWriter := alternative
+	var related *multipart.Writer
+	var relatedContent bytes.Buffer
+	if len(m.Images) > 0 {
+		related = multipart.NewWriter(&relatedContent)
+		htmlWriter = related
+	}
+
+	html, err := htmlWriter.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {`text/html; charset="UTF-8"`},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeQuotedPrintable(html, m.Body); err != nil {
+		return nil, err
+	}
+
+	if related != nil {
+		for _, image := range m.Images {
+			part, err := related.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {image.ContentType},
+				"Content-Transfer-Encoding": {"base64"},
+				"Content-ID":                {"<" + image.ContentID + ">"},
+				"Content-Disposition":       {mime.FormatMediaType("inline", map[string]string{"filename": image.Filename})},
+			})
+			if err != nil {
+				return nil, err
+			}
+			encoded := base64.StdEncoding.EncodeToString(image.Data)
+			for len(encoded) > base64LineLength {
+				fmt.Fprintf(part, "%s\r\n", encoded[:base64LineLength])
+				encoded = encoded[base64LineLength:]
+			}
+			fmt.Fprintf(part, "%s\r\n", encoded)
+		}
+		if err := related.Close(); err != nil {
+			return nil, err
+		}
+		part, err := alternative.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {fmt.Sprintf(`multipart/related; type="text/html"; boundary=%s`, related.Boundary())},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := part.Write(relatedContent.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	if err := alternative.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func writeQuotedPrintable(part io.Writer, content string) error {
+	writer := quotedprintable.NewWriter(part)
+	if _, err := writer.Write([]byte(content)); err != nil {
+		return err
+	}
+	return writer.Close()
+}
@@ -0,0 +1,90 @@
+package clients
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"testing"
+
+	"github.com/mdblp/hydrophone/utils/cid"
+)
+
+// readParts returns the parts of a multipart body keyed by their content type, the nested ones included
+func readParts(t *testing.T, contentType string, body io.Reader, parts map[string]*multipart.Part, contents map[string][]byte) {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatalf("Failed to parse the content type %s: %s", contentType, err)
+	}
+	reader := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatalf("Failed to read the part: %s", err)
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if partType == "multipart/related" {
+			readParts(t, part.Header.Get("Content-Type"), part, parts, contents)
+			continue
+		}
+		content, _ := ioutil.ReadAll(part)
+		parts[partType] = part
+		contents[partType] = content
+	}
+}
+
+func Test_MimeBytes(t *testing.T) {
+	message := &Message{
+		ReplyTo: "support@example.com",
+		To:      []string{"jane@example.com"},
+		Subject: "Invitation à rejoindre l'équipe",
+		Body:    `<html><body><img src="cid:img.logo.png@hydrophone"/>` + string(bytes.Repeat([]byte("é"), 100)) + `</body></html>`,
+		Images:  []*cid.Image{{ContentID: "img.logo.png@hydrophone", ContentType: "image/png", Filename: "logo.png", Data: bytes.Repeat([]byte{0x89, 'P', 'N', 'G'}, 50)}},
+	}
+	data, err := message.mimeBytes("YourLoops <noreply@example.com>")
+	if err != nil {
+		t.Fatalf("Failed to build the message: %s", err)
+	}
+	email, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to read the message: %s", err)
+	}
+	if subject, _ := new(mime.WordDecoder).DecodeHeader(email.Header.Get("Subject")); subject != message.Subject {
+		t.Fatalf("Wrong subject %s", subject)
+	}
+	if from := email.Header.Get("From"); from != "YourLoops <noreply@example.com>" {
+		t.Fatalf("Wrong sender %s", from)
+	}
+	if replyTo := email.Header.Get("Reply-To"); replyTo != message.ReplyTo {
+		t.Fatalf("Wrong reply-to %s", replyTo)
+	}
+
+	parts := map[string]*multipart.Part{}
+	contents := map[string][]byte{}
+	readParts(t, email.Header.Get("Content-Type"), email.Body, parts, contents)
+	if string(contents["text/plain"]) != DefaultTextMessage {
+		t.Fatalf("Wrong text part %s", contents["text/plain"])
+	}
+	if string(contents["text/html"]) != message.Body {
+		t.Fatalf("Wrong html part %s", contents["text/html"])
+	}
+	image, found := parts["image/png"]
+	if !found || image.Header.Get("Content-ID") != "<img.logo.png@hydrophone>" || image.Header.Get("Content-Disposition") != "inline; filename=logo.png" {
+		t.Fatalf("The logo should be an inline part, got %v", image)
+	}
+	for _, line := range bytes.Split(contents["image/png"], []byte("\r\n")) {
+		if len(line) > base64LineLength {
+			t.Fatalf("The image lines should be %d characters long at most, got %d", base64LineLength, len(line))
+		}
+	}
+
+	message.Images = nil
+	if data, err = message.mimeBytes("noreply@example.com"); err != nil || bytes.Contains(data, []byte("multipart/related")) {
+		t.Fatalf("A message without images should not have a related part (%v)", err)
+	}
+}
@@ -3,6 +3,8 @@ package clients
 import (
 	"fmt"
 	"log"
+
+	"github.com/mdblp/hydrophone/utils/cid"
 )
 
 type (
@@ -11,9 +13,13 @@ type (
 	}
 
 	EmailArgs struct {
-		To      []string
-		Subject string
-		Msg     string
+		From     string
+		FromName string
+		ReplyTo  string
+		To       []string
+		Subject  string
+		Msg      string
+		Images   []*cid.Image
 	}
 )
 
@@ -21,9 +27,9 @@ func NewMockNotifier() *MockNotifier {
 	return &MockNotifier{}
 }
 
-func (c *MockNotifier) Send(to []string, subject string, msg string) (int, string) {
-	details := fmt.Sprintf("Send message with subject[%s] to %v", subject, to)
-	c.lastSentEmailsArgs = &EmailArgs{To: to, Subject: subject, Msg: msg}
+func (c *MockNotifier) Send(message *Message) (int, string) {
+	details := fmt.Sprintf("Send message with subject[%s] to %v", message.Subject, message.To)
+	c.lastSentEmailsArgs = &EmailArgs{From: message.senderOf(""), FromName: message.FromName, ReplyTo: message.ReplyTo, To: message.To, Subject: message.Subject, Msg: message.Body, Images: message.Images}
 	log.Println(details)
 	return 200, details
 }
@@ -31,3 +37,8 @@ func (c *MockNotifier) Send(to []string, subject string, msg string) (int, strin
 func (c *MockNotifier) GetLastEmailSubject() string {
 	return c.lastSentEmailsArgs.Subject
 }
+
+// GetLastEmail returns the arguments of the last email sent
+func (c *MockNotifier) GetLastEmail() *EmailArgs {
+	return c.lastSentEmailsArgs
+}
@@ -3,6 +3,8 @@ package clients
 import (
 	"context"
 	"errors"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/mdblp/hydrophone/models"
@@ -13,10 +15,34 @@ type MockStoreClient struct {
 	doBad      bool
 	returnNone bool
 	now        time.Time
+	// template versions are kept in memory so the activation and rollback can be tested
+	mutex     sync.Mutex
+	versions  map[models.TemplateName][]*models.TemplateVersion
+	brandings map[string]*models.TeamBranding
+	audit     []*models.AuditEvent
+	// stored are the status and revision of the confirmations last found or changed, as if they were stored
+	stored map[string]models.Confirmation
+	// changedMeanwhile are the confirmations changed by another request after being found, as they are stored
+	changedMeanwhile map[string]models.Confirmation
 }
 
 func NewMockStoreClient(returnNone, doBad bool) *MockStoreClient {
-	return &MockStoreClient{doBad: doBad, returnNone: returnNone, now: time.Now()}
+	return &MockStoreClient{doBad: doBad, returnNone: returnNone, now: time.Now(), versions: map[models.TemplateName][]*models.TemplateVersion{}, brandings: map[string]*models.TeamBranding{},
+		stored: map[string]models.Confirmation{},
+		changedMeanwhile: map[string]models.Confirmation{
+			// the invite was accepted by another request since it was found
+			"medicalteam.invite.conflict": {Status: models.StatusCompleted, Revision: 1},
+		},
+	}
+}
+
+// store records the status and revision of the confirmations found, the ones a transition expects
+func (d *MockStoreClient) store(confirmations ...*models.Confirmation) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, c := range confirmations {
+		d.stored[c.Key] = models.Confirmation{Key: c.Key, Status: c.Status, Revision: c.Revision}
+	}
 }
 
 func (d *MockStoreClient) Close() error {
@@ -41,6 +67,10 @@ func (d *MockStoreClient) UpsertConfirmation(ctx context.Context, notification *
 	if d.doBad {
 		return errors.New("UpsertConfirmation failure")
 	}
+	// an invite created by a concurrent request
+	if notification.Email == "duplicate.invite@email.org" {
+		return ErrDuplicateInvite
+	}
 	if notification.Email == "patient@myemail.com" && notification.ShortKey == "" {
 		return errors.New("password reset for a patient should contain a short key")
 	}
@@ -107,6 +137,24 @@ func (d *MockStoreClient) FindConfirmation(ctx context.Context, notification *mo
 		notification.Team.ID = "123456"
 		notification.UserId = "UID123"
 	}
+	if notification.Key == "medicalteam.invite.conflict" {
+		notification.Status = "pending"
+		notification.Type = "medicalteam_invitation"
+		if notification.Team == nil {
+			notification.Team = &models.Team{}
+		}
+		notification.Team.ID = "123456"
+		notification.UserId = "UID123"
+	}
+	if notification.Key == "careteam.invite.history" {
+		notification.Status = "canceled"
+		notification.Type = "careteam_invitation"
+		notification.CreatorId = "UID999"
+		notification.UserId = "not.my.id"
+		notification.History = []models.StatusChange{
+			{From: models.StatusPending, To: models.StatusCanceled, Time: notification.Created, Actor: "UID999", Reason: "sent by mistake"},
+		}
+	}
 	if notification.Key == "medicalteam.invite.wrong.member" {
 		notification.Status = "pending"
 		notification.Type = "medicalteam_invitation"
@@ -153,6 +201,7 @@ func (d *MockStoreClient) FindConfirmation(ctx context.Context, notification *mo
 		notification.Type = "medicalteam_remove"
 		notification.UserId = "UID123"
 	}
+	d.store(notification)
 	return notification, nil
 }
 
@@ -169,13 +218,269 @@ func (d *MockStoreClient) FindConfirmations(ctx context.Context, confirmation *m
 	if len(statuses) == 1 {
 		confirmation.UpdateStatus(statuses[0])
 	}
+	d.store(confirmation)
 
 	return []*models.Confirmation{confirmation}, nil
 }
 
+// FindUserConfirmations returns an invite sent by the user, one sent to the first email and a pin reset of the user
+func (d *MockStoreClient) FindUserConfirmations(ctx context.Context, userID string, emails []string) ([]*models.Confirmation, error) {
+	if d.doBad {
+		return nil, errors.New("FindUserConfirmations failure")
+	}
+	if d.returnNone {
+		return nil, nil
+	}
+	sent, _ := models.NewConfirmation(models.TypeCareteamInvite, models.TemplateNameCareteamInvite, userID)
+	sent.Email = "someone@else.org"
+	results := []*models.Confirmation{sent}
+	if len(emails) > 0 {
+		received, _ := models.NewConfirmation(models.TypeMedicalTeamInvite, models.TemplateNameMedicalteamInvite, "other.user")
+		received.Email = emails[0]
+		received.Team = &models.Team{ID: "team1"}
+		results = append(results, received)
+	}
+	pinReset, _ := models.NewConfirmationWithContext(models.TypePatientPinReset, models.TemplateNamePatientPinReset, userID, map[string]interface{}{"OTP": "123456"})
+	results = append(results, pinReset)
+	d.store(results...)
+	return results, nil
+}
+
+// PseudonymizeUserConfirmations changes the confirmations returned by FindUserConfirmations
+func (d *MockStoreClient) PseudonymizeUserConfirmations(ctx context.Context, userID string, emails []string, pseudonym *models.Pseudonym) (int64, error) {
+	if d.doBad {
+		return 0, errors.New("PseudonymizeUserConfirmations failure")
+	}
+	if d.returnNone {
+		return 0, nil
+	}
+	if len(emails) > 0 {
+		return 3, nil
+	}
+	return 2, nil
+}
+
 func (d *MockStoreClient) RemoveConfirmation(ctx context.Context, notification *models.Confirmation) error {
 	if d.doBad {
 		return errors.New("RemoveConfirmation failure")
 	}
 	return nil
 }
+
+// TransitionStatus compares the status and the revision of the confirmation as stored, last found or changed, with the expected ones
+// A confirmation not found yet is looked up by FindConfirmation
+func (d *MockStoreClient) TransitionStatus(ctx context.Context, key string, revision int, change models.StatusChange, patch map[string]interface{}) (*models.Confirmation, error) {
+	if d.doBad {
+		return nil, errors.New("TransitionStatus failure")
+	}
+	d.mutex.Lock()
+	current, found := d.stored[key]
+	d.mutex.Unlock()
+	if !found {
+		if c, err := d.FindConfirmation(ctx, &models.Confirmation{Key: key}); err != nil {
+			return nil, err
+		} else if c == nil {
+			return nil, &StatusConflictError{Key: key, Expected: change.From, ExpectedRevision: revision}
+		} else {
+			current = *c
+		}
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if changed, found := d.changedMeanwhile[key]; found {
+		current.Status, current.Revision = changed.Status, changed.Revision
+	}
+	if current.Status != change.From || current.Revision != revision {
+		return nil, &StatusConflictError{Key: key, Expected: change.From, Actual: current.Status, ExpectedRevision: revision, ActualRevision: current.Revision}
+	}
+	change.Time = time.Now()
+	d.stored[key] = models.Confirmation{Key: key, Status: change.To, Revision: revision + 1}
+	return &models.Confirmation{Key: key, Status: change.To, Modified: change.Time, Revision: revision + 1, History: []models.StatusChange{change}}, nil
+}
+
+func (d *MockStoreClient) SetConfirmationTemplateVersion(ctx context.Context, key string, version int) error {
+	if d.doBad {
+		return errors.New("SetConfirmationTemplateVersion failure")
+	}
+	return nil
+}
+
+func (d *MockStoreClient) InsertTemplateVersion(ctx context.Context, version *models.TemplateVersion) error {
+	if d.doBad {
+		return errors.New("InsertTemplateVersion failure")
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	version.Version = len(d.versions[version.Template]) + 1
+	version.ID = models.TemplateVersionID(version.Template, version.Version)
+	stored := *version
+	d.versions[version.Template] = append(d.versions[version.Template], &stored)
+	return nil
+}
+
+func (d *MockStoreClient) FindTemplateVersions(ctx context.Context, name models.TemplateName) ([]*models.TemplateVersion, error) {
+	if d.doBad {
+		return nil, errors.New("FindTemplateVersions failure")
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	results := make([]*models.TemplateVersion, 0, len(d.versions[name]))
+	for _, v := range d.versions[name] {
+		found := *v
+		results = append(results, &found)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Version > results[j].Version })
+	return results, nil
+}
+
+func (d *MockStoreClient) FindTemplateVersion(ctx context.Context, name models.TemplateName, version int) (*models.TemplateVersion, error) {
+	if d.doBad {
+		return nil, errors.New("FindTemplateVersion failure")
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, v := range d.versions[name] {
+		if v.Version == version {
+			found := *v
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (d *MockStoreClient) FindActiveTemplateVersion(ctx context.Context, name models.TemplateName) (*models.TemplateVersion, error) {
+	if d.doBad {
+		return nil, errors.New("FindActiveTemplateVersion failure")
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, v := range d.versions[name] {
+		if v.Active {
+			found := *v
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (d *MockStoreClient) ActivateTemplateVersion(ctx context.Context, name models.TemplateName, version int) error {
+	if d.doBad {
+		return errors.New("ActivateTemplateVersion failure")
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.activate(name, version)
+}
+
+func (d *MockStoreClient) activate(name models.TemplateName, version int) error {
+	if version < 1 || version > len(d.versions[name]) {
+		return ErrTemplateVersionNotFound
+	}
+	for _, v := range d.versions[name] {
+		v.Active = v.Version == version
+		if v.Active {
+			v.RolledBack = false
+			v.Activated = time.Now()
+		}
+	}
+	return nil
+}
+
+func (d *MockStoreClient) RollbackTemplateVersion(ctx context.Context, name models.TemplateName) (*models.TemplateVersion, error) {
+	if d.doBad {
+		return nil, errors.New("RollbackTemplateVersion failure")
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	current, target := models.RollbackTarget(d.versions[name])
+	if current == nil {
+		return nil, ErrNoActiveTemplateVersion
+	}
+	current.Active = false
+	current.RolledBack = true
+	if target == nil {
+		return nil, nil
+	}
+	if err := d.activate(name, target.Version); err != nil {
+		return nil, err
+	}
+	activated := *target
+	return &activated, nil
+}
+
+func (d *MockStoreClient) FindTeamBranding(ctx context.Context, teamID string) (*models.TeamBranding, error) {
+	if d.doBad {
+		return nil, errors.New("FindTeamBranding failure")
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if branding, ok := d.brandings[teamID]; ok {
+		found := *branding
+		return &found, nil
+	}
+	return nil, nil
+}
+
+func (d *MockStoreClient) UpsertTeamBranding(ctx context.Context, branding *models.TeamBranding) error {
+	if d.doBad {
+		return errors.New("UpsertTeamBranding failure")
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	stored := *branding
+	d.brandings[branding.TeamID] = &stored
+	return nil
+}
+
+func (d *MockStoreClient) RemoveTeamBranding(ctx context.Context, teamID string) error {
+	if d.doBad {
+		return errors.New("RemoveTeamBranding failure")
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.brandings, teamID)
+	return nil
+}
+
+func (d *MockStoreClient) InsertAuditEvent(ctx context.Context, event *models.AuditEvent) error {
+	if d.doBad {
+		return errors.New("InsertAuditEvent failure")
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	stored := *event
+	d.audit = append(d.audit, &stored)
+	return nil
+}
+
+func (d *MockStoreClient) FindAuditEvents(ctx context.Context, filter *models.AuditFilter) ([]*models.AuditEvent, error) {
+	if d.doBad {
+		return nil, errors.New("FindAuditEvents failure")
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	results := []*models.AuditEvent{}
+	for i := len(d.audit) - 1; i >= 0 && (filter.Limit <= 0 || len(results) < filter.Limit); i-- {
+		if filter.Match(d.audit[i]) {
+			found := *d.audit[i]
+			results = append(results, &found)
+		}
+	}
+	return results, nil
+}
+
+// PseudonymizeUserAuditEvents changes the audit events recorded
+func (d *MockStoreClient) PseudonymizeUserAuditEvents(ctx context.Context, userID string, emailHashes []string, pseudonym *models.Pseudonym) (int64, error) {
+	if d.doBad {
+		return 0, errors.New("PseudonymizeUserAuditEvents failure")
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	var changed int64
+	for _, event := range d.audit {
+		if event.Pseudonymize(userID, emailHashes, pseudonym) {
+			changed++
+		}
+	}
+	return changed, nil
+}
@@ -0,0 +1,328 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mdblp/hydrophone/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// indexesTimeout bounds the creation of the indexes done at start
+	indexesTimeout = 5 * time.Minute
+	// migrationsRetryMin and migrationsRetryMax bound the delay between two checks of the migrations before creating the indexes
+	migrationsRetryMin = time.Second
+	migrationsRetryMax = time.Minute
+	// backfillBatchSize is the number of documents updated at once by the migrations
+	backfillBatchSize = 500
+)
+
+// pendingInvites are the invite types a single one of can be pending per email and inviter
+// The inviter is the field identifying who sent the invite: the caregiver sharing or the team
+var pendingInvites = []struct {
+	Type    models.Type
+	Inviter string
+}{
+	{models.TypeCareteamInvite, "creatorId"},
+	{models.TypeMedicalTeamInvite, "teamId"},
+	{models.TypeMedicalTeamPatientInvite, "teamId"},
+}
+
+// pendingInviteIndexes are the partial unique indexes enforcing a single pending invite
+// Mongo before 5.0 refuses two indexes with the same keys, even with other partial filters,
+// the type is added to the keys of an invite whose inviter is the one of a former invite
+func pendingInviteIndexes() []mongo.IndexModel {
+	indexes := make([]mongo.IndexModel, 0, len(pendingInvites))
+	inviters := make(map[string]bool, len(pendingInvites))
+	for _, invite := range pendingInvites {
+		keys := bson.D{{"emailLower", 1}, {invite.Inviter, 1}}
+		if inviters[invite.Inviter] {
+			keys = append(keys, bson.E{"type", 1})
+		}
+		inviters[invite.Inviter] = true
+		indexes = append(indexes, mongo.IndexModel{
+			Keys: keys,
+			Options: options.Index().
+				SetName("pending_" + string(invite.Type)).
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"status": models.StatusPending, "type": invite.Type}),
+		})
+	}
+	return indexes
+}
+
+// collectionIndexes are the indexes of the lookups done by the store, per collection
+// The confirmations are always sorted by creation date, it is the last key of their indexes
+var collectionIndexes = map[string][]mongo.IndexModel{
+	confirmationsCollection: append([]mongo.IndexModel{
+		{Keys: bson.D{{"emailLower", 1}, {"created", -1}}, Options: options.Index().SetName("emailLower_created")},
+		{Keys: bson.D{{"userId", 1}, {"created", -1}}, Options: options.Index().SetName("userId_created")},
+		{Keys: bson.D{{"creatorId", 1}, {"created", -1}}, Options: options.Index().SetName("creatorId_created")},
+		{Keys: bson.D{{"teamId", 1}, {"created", -1}}, Options: options.Index().SetName("teamId_created")},
+		{Keys: bson.D{{"shortKey", 1}}, Options: options.Index().SetName("shortKey")},
+		{Keys: bson.D{{"status", 1}, {"type", 1}, {"created", -1}}, Options: options.Index().SetName("status_type_created")},
+	}, pendingInviteIndexes()...),
+	templateVersionsCollection: {
+		{Keys: bson.D{{"template", 1}, {"version", -1}}, Options: options.Index().SetName("template_version").SetUnique(true)},
+	},
+}
+
+// auditTTLIndex is the name of the index expiring the audit events after the retention
+const auditTTLIndex = "time_ttl"
+
+// auditIndexes are the indexes of the audit events lookups, with the expiration of the events
+func (c *Client) auditIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.D{{"time", -1}}, Options: options.Index().SetName(auditTTLIndex).SetExpireAfterSeconds(int32(c.AuditRetention.Seconds()))},
+		{Keys: bson.D{{"actor", 1}, {"time", -1}}, Options: options.Index().SetName("actor_time")},
+		{Keys: bson.D{{"action", 1}, {"time", -1}}, Options: options.Index().SetName("action_time")},
+		{Keys: bson.D{{"targetUser", 1}, {"time", -1}}, Options: options.Index().SetName("targetUser_time")},
+		{Keys: bson.D{{"targetTeam", 1}, {"time", -1}}, Options: options.Index().SetName("targetTeam_time")},
+		{Keys: bson.D{{"targetEmailHash", 1}, {"time", -1}}, Options: options.Index().SetName("targetEmailHash_time")},
+	}
+}
+
+// errIndexesPending is returned by Ping until the indexes are created, once the migrations they rely on are applied
+var errIndexesPending = errors.New("clients: the indexes are not created yet, waiting for the migrations")
+
+// Start connects to mongo, then applies the migrations, unless skipped, and creates the indexes once they are all applied
+// The unique pending invite indexes rely on the migrations, they are created after them even when another instance runs them
+// Ping fails until the indexes are created, a failure to create them is returned by Ping until they are created by another start
+func (c *Client) Start() {
+	c.StoreClient.Start()
+	c.setIndexesError(errIndexesPending)
+	go func() {
+		c.WaitUntilStarted()
+		c.waitMigrations()
+		ctx, cancel := context.WithTimeout(context.Background(), indexesTimeout)
+		defer cancel()
+		err := c.EnsureIndexes(ctx)
+		if err != nil {
+			log.Printf("Start: failure to create the indexes [%v]", err)
+		}
+		c.setIndexesError(err)
+	}()
+}
+
+// waitMigrations returns once all the migrations are applied and none is running
+// It applies them, unless skipped, and checks again with a backoff while another instance runs them or they fail
+func (c *Client) waitMigrations() {
+	delay := migrationsRetryMin
+	for {
+		if c.SkipMigrations {
+			ctx, cancel := context.WithTimeout(context.Background(), indexesTimeout)
+			done, err := c.MigrationsDone(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("Start: failure to read the migrations [%v]", err)
+			} else if done {
+				return
+			} else {
+				log.Printf("Start: waiting for the migrations to be applied before creating the indexes")
+			}
+		} else {
+			applied, err := c.runMigrations()
+			c.logMigrations(applied, err)
+			if err == nil {
+				return
+			}
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > migrationsRetryMax {
+			delay = migrationsRetryMax
+		}
+	}
+}
+
+// Ping fails when mongo is unreachable or when the indexes could not be created:
+// without them the lookups are slow and the single pending invite is not enforced
+func (c *Client) Ping() error {
+	if err := c.StoreClient.Ping(); err != nil {
+		return err
+	}
+	c.indexesMutex.Lock()
+	defer c.indexesMutex.Unlock()
+	return c.indexesErr
+}
+
+// PingOK tells if Ping succeeds
+func (c *Client) PingOK() bool {
+	return c.Ping() == nil
+}
+
+func (c *Client) setIndexesError(err error) {
+	c.indexesMutex.Lock()
+	defer c.indexesMutex.Unlock()
+	c.indexesErr = err
+}
+
+// RunMigrations connects to mongo, applies the migrations and creates the indexes, then disconnects
+// It is run by the migration mode of the service, before starting the instances skipping them
+func (c *Client) RunMigrations() error {
+	c.StoreClient.Start()
+	defer c.Close()
+	c.WaitUntilStarted()
+	applied, err := c.runMigrations()
+	c.logMigrations(applied, err)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), indexesTimeout)
+	defer cancel()
+	return c.EnsureIndexes(ctx)
+}
+
+func (c *Client) runMigrations() ([]*AppliedMigration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), migrationsTimeout)
+	defer cancel()
+	return c.Migrate(ctx)
+}
+
+func (c *Client) logMigrations(applied []*AppliedMigration, err error) {
+	switch {
+	case errors.Is(err, ErrMigrationsLocked):
+		log.Printf("Migrate: skipped, the migrations are run by another instance")
+	case err != nil:
+		log.Printf("Migrate: failure to apply the migrations [%v]", err)
+	case len(applied) == 0:
+		log.Printf("Migrate: the documents are up to date")
+	}
+}
+
+// EnsureIndexes creates the missing indexes of the collections, then verifies they all exist
+// An index existing with the same name but other keys or options is reported as an error,
+// except the expiration of the audit events which is updated to the configured retention
+// The indexes of every collection are created even when those of another one fail, the failures are all returned
+func (c *Client) EnsureIndexes(ctx context.Context) error {
+	indexes := map[string][]mongo.IndexModel{auditEventsCollection: c.auditIndexes()}
+	for collection, specs := range collectionIndexes {
+		indexes[collection] = specs
+	}
+	var failures []string
+	for collection, specs := range indexes {
+		if err := c.ensureCollectionIndexes(ctx, collection, specs); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func (c *Client) ensureCollectionIndexes(ctx context.Context, collection string, specs []mongo.IndexModel) error {
+	_, err := c.Collection(collection).Indexes().CreateMany(ctx, specs)
+	var cmdErr mongo.CommandError
+	if collection == auditEventsCollection && errors.As(err, &cmdErr) && cmdErr.Code == indexOptionsConflictCode {
+		err = c.updateAuditRetention(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("clients: failure to create the %s indexes: %s", collection, err)
+	}
+	return c.checkIndexes(ctx, collection, specs)
+}
+
+// updateAuditRetention changes the expiration of the audit events once their index exists, then creates the other indexes
+func (c *Client) updateAuditRetention(ctx context.Context) error {
+	command := bson.D{
+		{"collMod", auditEventsCollection},
+		{"index", bson.M{"name": auditTTLIndex, "expireAfterSeconds": int32(c.AuditRetention.Seconds())}},
+	}
+	if err := mgoAuditEventsCollection(c).Database().RunCommand(ctx, command).Err(); err != nil {
+		return err
+	}
+	_, err := mgoAuditEventsCollection(c).Indexes().CreateMany(ctx, c.auditIndexes())
+	return err
+}
+
+// checkIndexes verifies the indexes exist in the collection
+func (c *Client) checkIndexes(ctx context.Context, collection string, indexes []mongo.IndexModel) error {
+	cursor, err := c.Collection(collection).Indexes().List(ctx)
+	if err != nil {
+		return fmt.Errorf("clients: failure to list the %s indexes: %s", collection, err)
+	}
+	defer cursor.Close(ctx)
+	var existing []struct {
+		Name string `bson:"name"`
+	}
+	if err := cursor.All(ctx, &existing); err != nil {
+		return fmt.Errorf("clients: failure to list the %s indexes: %s", collection, err)
+	}
+	names := make(map[string]bool, len(existing))
+	for _, index := range existing {
+		names[index.Name] = true
+	}
+	var missing []string
+	for _, index := range indexes {
+		if name := *index.Options.Name; !names[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("clients: %s indexes %s are missing", collection, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// BackfillEmailLower sets the canonical email of the confirmations stored before it existed
+// It returns the number of confirmations updated
+func (c *Client) BackfillEmailLower(ctx context.Context) (int64, error) {
+	query := bson.M{"emailLower": bson.M{"$exists": false}, "email": bson.M{"$type": "string"}}
+	return c.updateConfirmations(ctx, query, bson.M{"email": 1}, func(raw bson.Raw) (bson.M, error) {
+		email, _ := raw.Lookup("email").StringValueOK()
+		return bson.M{"$set": bson.M{"emailLower": models.CanonicalEmail(email)}}, nil
+	})
+}
+
+// CancelDuplicateInvites cancels the pending invites sent before the latest one for the same email and inviter
+// They would prevent the creation of the unique indexes, it returns the number of invites canceled
+func (c *Client) CancelDuplicateInvites(ctx context.Context) (int64, error) {
+	var canceled int64
+	for _, invite := range pendingInvites {
+		pipeline := mongo.Pipeline{
+			{{"$match", bson.M{"status": models.StatusPending, "type": invite.Type}}},
+			{{"$sort", bson.M{"created": -1}}},
+			{{"$group", bson.M{
+				"_id":   bson.M{"email": "$emailLower", "inviter": "$" + invite.Inviter},
+				"keys":  bson.M{"$push": "$_id"},
+				"count": bson.M{"$sum": 1},
+			}}},
+			{{"$match", bson.M{"count": bson.M{"$gt": 1}}}},
+		}
+		cursor, err := mgoConfirmationsCollection(c).Aggregate(ctx, pipeline)
+		if err != nil {
+			return canceled, err
+		}
+		var duplicates []struct {
+			Keys []string `bson:"keys"`
+		}
+		err = cursor.All(ctx, &duplicates)
+		cursor.Close(ctx)
+		if err != nil {
+			return canceled, err
+		}
+		for _, duplicate := range duplicates {
+			// the latest one is kept
+			query := bson.M{"_id": bson.M{"$in": duplicate.Keys[1:]}, "status": models.StatusPending}
+			update := bson.M{
+				"$set": bson.M{"status": models.StatusCanceled, "modified": time.Now()},
+				"$inc": bson.M{"revision": 1},
+			}
+			result, err := mgoConfirmationsCollection(c).UpdateMany(ctx, query, update)
+			if err != nil {
+				return canceled, err
+			}
+			canceled += result.ModifiedCount
+		}
+	}
+	return canceled, nil
+}
@@ -0,0 +1,24 @@
+package clients
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// mongo before 5.0 refuses to create two indexes with the same keys, even with other options
+func TestIndexesHaveDistinctKeys(t *testing.T) {
+	for collection, indexes := range collectionIndexes {
+		keys := make(map[string]string, len(indexes))
+		for _, index := range indexes {
+			pattern, err := bson.MarshalExtJSON(index.Keys, false, false)
+			if err != nil {
+				t.Fatalf("the keys of the index %s of %s could not be marshaled - err [%v]", *index.Options.Name, collection, err)
+			}
+			if other, exists := keys[string(pattern)]; exists {
+				t.Errorf("the indexes %s and %s of %s have the same keys %s", other, *index.Options.Name, collection, pattern)
+			}
+			keys[string(pattern)] = *index.Options.Name
+		}
+	}
+}
@@ -2,9 +2,10 @@ package clients
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"log"
-	"regexp"
+	"sync"
+	"time"
 
 	"github.com/mdblp/hydrophone/models"
 	goComMgo "github.com/tidepool-org/go-common/clients/mongo"
@@ -15,17 +16,47 @@ import (
 )
 
 const (
-	confirmationsCollection = "confirmations"
+	confirmationsCollection    = "confirmations"
+	templateVersionsCollection = "templateVersions"
+	activeTemplatesCollection  = "activeTemplates"
+	teamBrandingsCollection    = "teamBrandings"
+	auditEventsCollection      = "auditEvents"
+
+	// duplicateKeyCode is the mongo error code of a write violating a unique index
+	duplicateKeyCode = 11000
+	// indexOptionsConflictCode is the mongo error code of an index created again with other options
+	indexOptionsConflictCode = 85
+
+	// DefaultAuditRetention is how long the audit events are kept when not configured
+	DefaultAuditRetention = 365 * 24 * time.Hour
+	// templateVersionAttempts bounds the numbering of a new template version, numbered again when a concurrent upload took its number
+	templateVersionAttempts = 5
 )
 
+// activeTemplate is the version sent for a template
+// It is a single document per template so switching the active version is a single update:
+// a template never has two active versions, nor none in the middle of an activation
+type activeTemplate struct {
+	Template  models.TemplateName `bson:"_id"`
+	Version   int                 `bson:"version"`
+	Activated time.Time           `bson:"activated"`
+}
+
 // Client struct
 type Client struct {
 	*goComMgo.StoreClient
+	// AuditRetention is how long the audit events are kept, set before Start
+	AuditRetention time.Duration
+	// SkipMigrations leaves the migrations to the instance run in the migration mode, set before Start
+	SkipMigrations bool
+
+	indexesMutex sync.Mutex
+	indexesErr   error // failure to create the indexes at start, returned by Ping
 }
 
 // NewStore creates a new Client
 func NewStore(config *goComMgo.Config, logger *log.Logger) (*Client, error) {
-	client := Client{}
+	client := Client{AuditRetention: DefaultAuditRetention}
 	store, err := goComMgo.NewStoreClient(config, logger)
 	client.StoreClient = store
 	return &client, err
@@ -36,22 +67,61 @@ func mgoConfirmationsCollection(c *Client) *mongo.Collection {
 	return c.Collection(confirmationsCollection)
 }
 
-// UpsertConfirmation creates or updates a confirmation
+func mgoTemplateVersionsCollection(c *Client) *mongo.Collection {
+	return c.Collection(templateVersionsCollection)
+}
+
+func mgoActiveTemplatesCollection(c *Client) *mongo.Collection {
+	return c.Collection(activeTemplatesCollection)
+}
+
+func mgoTeamBrandingsCollection(c *Client) *mongo.Collection {
+	return c.Collection(teamBrandingsCollection)
+}
+
+func mgoAuditEventsCollection(c *Client) *mongo.Collection {
+	return c.Collection(auditEventsCollection)
+}
+
+// UpsertConfirmation creates or updates a confirmation, with the canonical form of its email
+// ErrDuplicateInvite is returned when another invite is pending for the same email and inviter
 func (c *Client) UpsertConfirmation(ctx context.Context, confirmation *models.Confirmation) error {
+	confirmation.EmailLower = models.CanonicalEmail(confirmation.Email)
+	// the revision is only incremented by the store, a stale one read before must not be saved back
+	// neither the history appended by the transitions
+	saved := *confirmation
+	saved.Revision = 0
+	saved.History = nil
 	options := options.Update().SetUpsert(true)
-	update := bson.D{{"$set", confirmation}}
+	update := bson.D{{"$set", &saved}, {"$inc", bson.M{"revision": 1}}}
 	_, err := mgoConfirmationsCollection(c).UpdateOne(ctx, bson.M{"_id": confirmation.Key}, update, options)
+	if isDuplicateKeyError(err) {
+		return ErrDuplicateInvite
+	}
 	return err
 }
 
+// isDuplicateKeyError tells if the write failed on a unique index
+func isDuplicateKeyError(err error) bool {
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) {
+		for _, e := range writeErr.WriteErrors {
+			if e.Code == duplicateKeyCode {
+				return true
+			}
+		}
+	}
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == duplicateKeyCode
+}
+
 // FindConfirmation returns latest created confirmation matching filter passed as parameter
 func (c *Client) FindConfirmation(ctx context.Context, confirmation *models.Confirmation) (result *models.Confirmation, err error) {
 
 	var query bson.M = bson.M{}
 
 	if confirmation.Email != "" {
-		regexFilter := primitive.Regex{Pattern: fmt.Sprintf("^%s$", regexp.QuoteMeta(confirmation.Email)), Options: "i"}
-		query["email"] = bson.M{"$regex": regexFilter}
+		query["emailLower"] = models.CanonicalEmail(confirmation.Email)
 	}
 	if confirmation.Key != "" {
 		query["_id"] = confirmation.Key
@@ -90,8 +160,7 @@ func (c *Client) FindConfirmations(ctx context.Context, confirmation *models.Con
 	var query bson.M = bson.M{}
 
 	if confirmation.Email != "" {
-		regexFilter := primitive.Regex{Pattern: fmt.Sprintf("^%s$", regexp.QuoteMeta(confirmation.Email)), Options: "i"}
-		query["email"] = bson.M{"$regex": regexFilter}
+		query["emailLower"] = models.CanonicalEmail(confirmation.Email)
 	}
 	if confirmation.Key != "" {
 		query["_id"] = confirmation.Key
@@ -122,15 +191,72 @@ func (c *Client) FindConfirmations(ctx context.Context, confirmation *models.Con
 	opts := options.Find()
 	opts.SetSort(bson.D{primitive.E{Key: "created", Value: -1}})
 	cursor, err := mgoConfirmationsCollection(c).Find(ctx, query, opts)
-	defer cursor.Close(ctx)
 	if err != nil {
 		log.Printf("FindConfirmation: something bad happened [%v]", err)
 		return results, err
 	}
+	defer cursor.Close(ctx)
 	err = cursor.All(ctx, &results)
 	return results, err
 }
 
+// FindUserConfirmations returns the confirmations created by the user, sent to the user or to any of the emails, the latest first
+func (c *Client) FindUserConfirmations(ctx context.Context, userID string, emails []string) (results []*models.Confirmation, err error) {
+	or := bson.A{bson.M{"creatorId": userID}, bson.M{"userId": userID}}
+	if len(emails) > 0 {
+		canonical := make([]string, 0, len(emails))
+		for _, email := range emails {
+			canonical = append(canonical, models.CanonicalEmail(email))
+		}
+		or = append(or, bson.M{"emailLower": bson.M{"$in": canonical}})
+	}
+	opts := options.Find().SetSort(bson.D{primitive.E{Key: "created", Value: -1}})
+	cursor, err := mgoConfirmationsCollection(c).Find(ctx, bson.M{"$or": or}, opts)
+	if err != nil {
+		log.Printf("FindUserConfirmations: something bad happened [%v]", err)
+		return results, err
+	}
+	defer cursor.Close(ctx)
+	err = cursor.All(ctx, &results)
+	return results, err
+}
+
+// PseudonymizeUserConfirmations replaces the identifiers of the user in the confirmations involving the user or any of the emails
+// It returns the number of confirmations changed, none once done
+func (c *Client) PseudonymizeUserConfirmations(ctx context.Context, userID string, emails []string, pseudonym *models.Pseudonym) (int64, error) {
+	canonical := make([]string, 0, len(emails))
+	for _, email := range emails {
+		canonical = append(canonical, models.CanonicalEmail(email))
+	}
+	query := bson.M{"$or": bson.A{
+		bson.M{"creatorId": userID},
+		bson.M{"userId": userID},
+		bson.M{"emailLower": bson.M{"$in": canonical}},
+		bson.M{"history.actor": userID},
+	}}
+	projection := bson.M{"creatorId": 1, "userId": 1, "email": 1, "history": 1}
+	return c.updateConfirmations(ctx, query, projection, func(raw bson.Raw) (bson.M, error) {
+		var confirmation models.Confirmation
+		if err := bson.Unmarshal(raw, &confirmation); err != nil {
+			return nil, err
+		}
+		if !confirmation.Pseudonymize(userID, emails, pseudonym) {
+			return nil, nil
+		}
+		set := bson.M{
+			"creatorId":  confirmation.CreatorId,
+			"userId":     confirmation.UserId,
+			"email":      confirmation.Email,
+			"emailLower": confirmation.EmailLower,
+			"creator":    bson.M{},
+		}
+		if len(confirmation.History) > 0 {
+			set["history"] = confirmation.History
+		}
+		return bson.M{"$set": set, "$unset": bson.M{"context": ""}, "$inc": bson.M{"revision": 1}}, nil
+	})
+}
+
 // RemoveConfirmation deletes confirmation based on key (_id)
 func (c *Client) RemoveConfirmation(ctx context.Context, confirmation *models.Confirmation) error {
 
@@ -139,3 +265,292 @@ func (c *Client) RemoveConfirmation(ctx context.Context, confirmation *models.Co
 	}
 	return nil
 }
+
+// TransitionStatus changes the status of a confirmation only if it still has the expected status and revision, with the fields of the patch
+// The revision is the one of the confirmation read, a confirmation changed since then is refused even when it is back to the same status
+// The check and the update are atomic so two concurrent transitions cannot both succeed, the second gets a StatusConflictError
+// The change is appended to the history of the confirmation, it returns the confirmation updated
+func (c *Client) TransitionStatus(ctx context.Context, key string, revision int, change models.StatusChange, patch map[string]interface{}) (*models.Confirmation, error) {
+	from, to := change.From, change.To
+	if change.Time.IsZero() {
+		change.Time = time.Now()
+	}
+	set := bson.M{}
+	for field, value := range patch {
+		set[field] = value
+	}
+	set["status"] = to
+	set["modified"] = change.Time
+	update := bson.M{"$set": set, "$inc": bson.M{"revision": 1}, "$push": bson.M{"history": change}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	// the revision is not stored until the first change
+	var expectedRevision interface{} = revision
+	if revision == 0 {
+		expectedRevision = bson.M{"$in": bson.A{0, nil}}
+	}
+	query := bson.M{"_id": key, "status": from, "revision": expectedRevision}
+
+	var result models.Confirmation
+	err := mgoConfirmationsCollection(c).FindOneAndUpdate(ctx, query, update, opts).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		conflict := &StatusConflictError{Key: key, Expected: from, ExpectedRevision: revision}
+		var current models.Confirmation
+		if err := mgoConfirmationsCollection(c).FindOne(ctx, bson.M{"_id": key}).Decode(&current); err == nil {
+			conflict.Actual, conflict.ActualRevision = current.Status, current.Revision
+		}
+		return nil, conflict
+	}
+	if err != nil {
+		log.Printf("TransitionStatus: something bad happened [%v]", err)
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SetConfirmationTemplateVersion records the template version sent for an existing confirmation
+func (c *Client) SetConfirmationTemplateVersion(ctx context.Context, key string, version int) error {
+	update := bson.D{{"$set", bson.M{"templateVersion": version}}}
+	_, err := mgoConfirmationsCollection(c).UpdateOne(ctx, bson.M{"_id": key}, update)
+	return err
+}
+
+// InsertTemplateVersion stores a new version of a template, numbered after the last one
+// The version number is part of the key and unique per template, the version is numbered again when a concurrent upload took it
+func (c *Client) InsertTemplateVersion(ctx context.Context, version *models.TemplateVersion) error {
+	for attempt := 1; ; attempt++ {
+		var last models.TemplateVersion
+		opts := options.FindOne()
+		opts.SetSort(bson.D{primitive.E{Key: "version", Value: -1}})
+		err := mgoTemplateVersionsCollection(c).FindOne(ctx, bson.M{"template": version.Template}, opts).Decode(&last)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+		version.Version = last.Version + 1
+		version.ID = models.TemplateVersionID(version.Template, version.Version)
+		version.Active = false
+		_, err = mgoTemplateVersionsCollection(c).InsertOne(ctx, version)
+		if !isDuplicateKeyError(err) || attempt == templateVersionAttempts {
+			return err
+		}
+	}
+}
+
+// activeVersion returns the active version number of a template, 0 when the disk baseline is used
+func (c *Client) activeVersion(ctx context.Context, name models.TemplateName) (int, error) {
+	var active activeTemplate
+	err := mgoActiveTemplatesCollection(c).FindOne(ctx, bson.M{"_id": name}).Decode(&active)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return active.Version, err
+}
+
+// FindTemplateVersions returns the versions of a template, the latest first
+func (c *Client) FindTemplateVersions(ctx context.Context, name models.TemplateName) (results []*models.TemplateVersion, err error) {
+	opts := options.Find()
+	opts.SetSort(bson.D{primitive.E{Key: "version", Value: -1}})
+	cursor, err := mgoTemplateVersionsCollection(c).Find(ctx, bson.M{"template": name}, opts)
+	if err != nil {
+		log.Printf("FindTemplateVersions: something bad happened [%v]", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	active, err := c.activeVersion(ctx, name)
+	for _, v := range results {
+		v.Active = v.Version == active
+	}
+	return results, err
+}
+
+// FindTemplateVersion returns a version of a template, nil when it does not exist
+func (c *Client) FindTemplateVersion(ctx context.Context, name models.TemplateName, version int) (result *models.TemplateVersion, err error) {
+	query := bson.M{"_id": models.TemplateVersionID(name, version)}
+	if err = mgoTemplateVersionsCollection(c).FindOne(ctx, query).Decode(&result); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		log.Printf("FindTemplateVersion: something bad happened [%v]", err)
+		return nil, err
+	}
+	active, err := c.activeVersion(ctx, name)
+	result.Active = result.Version == active
+	return result, err
+}
+
+// FindActiveTemplateVersion returns the active version of a template, nil when the disk baseline is used
+func (c *Client) FindActiveTemplateVersion(ctx context.Context, name models.TemplateName) (*models.TemplateVersion, error) {
+	active, err := c.activeVersion(ctx, name)
+	if err != nil {
+		log.Printf("FindActiveTemplateVersion: something bad happened [%v]", err)
+		return nil, err
+	}
+	if active == 0 {
+		return nil, nil
+	}
+	return c.FindTemplateVersion(ctx, name, active)
+}
+
+// ActivateTemplateVersion makes a version the one sent for its template, the others being inactive at once
+// The activation date of the version is recorded first, it orders the rollbacks
+func (c *Client) ActivateTemplateVersion(ctx context.Context, name models.TemplateName, version int) error {
+	now := time.Now()
+	update := bson.D{{"$set", bson.M{"rolledBack": false, "activated": now}}}
+	result, err := mgoTemplateVersionsCollection(c).UpdateOne(ctx, bson.M{"_id": models.TemplateVersionID(name, version)}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrTemplateVersionNotFound
+	}
+	update = bson.D{{"$set", bson.M{"version": version, "activated": now}}}
+	_, err = mgoActiveTemplatesCollection(c).UpdateOne(ctx, bson.M{"_id": name}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// RollbackTemplateVersion deactivates the active version of a template and activates the previous one
+// It returns the version activated, nil when the template goes back to its disk baseline
+// The active version is only switched when it is still the one rolled back, ErrTemplateVersionChanged is returned otherwise
+func (c *Client) RollbackTemplateVersion(ctx context.Context, name models.TemplateName) (*models.TemplateVersion, error) {
+	versions, err := c.FindTemplateVersions(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	current, target := models.RollbackTarget(versions)
+	if current == nil {
+		return nil, ErrNoActiveTemplateVersion
+	}
+	now := time.Now()
+	query := bson.M{"_id": name, "version": current.Version}
+	var switched int64
+	if target != nil {
+		update := bson.D{{"$set", bson.M{"version": target.Version, "activated": now}}}
+		result, err := mgoActiveTemplatesCollection(c).UpdateOne(ctx, query, update)
+		if err != nil {
+			return nil, err
+		}
+		switched = result.MatchedCount
+	} else {
+		result, err := mgoActiveTemplatesCollection(c).DeleteOne(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		switched = result.DeletedCount
+	}
+	if switched == 0 {
+		return nil, ErrTemplateVersionChanged
+	}
+
+	update := bson.D{{"$set", bson.M{"rolledBack": true}}}
+	if _, err := mgoTemplateVersionsCollection(c).UpdateOne(ctx, bson.M{"_id": current.ID}, update); err != nil {
+		return nil, err
+	}
+	if target != nil {
+		update := bson.D{{"$set", bson.M{"rolledBack": false, "activated": now}}}
+		if _, err := mgoTemplateVersionsCollection(c).UpdateOne(ctx, bson.M{"_id": target.ID}, update); err != nil {
+			return nil, err
+		}
+		target.Active, target.RolledBack, target.Activated = true, false, now
+	}
+	return target, nil
+}
+
+// FindTeamBranding returns the branding of a medical team, nil when it has none
+func (c *Client) FindTeamBranding(ctx context.Context, teamID string) (result *models.TeamBranding, err error) {
+	if err = mgoTeamBrandingsCollection(c).FindOne(ctx, bson.M{"_id": teamID}).Decode(&result); err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("FindTeamBranding: something bad happened [%v]", err)
+		return nil, err
+	}
+	return result, nil
+}
+
+// UpsertTeamBranding creates or replaces the branding of a medical team
+func (c *Client) UpsertTeamBranding(ctx context.Context, branding *models.TeamBranding) error {
+	options := options.Replace().SetUpsert(true)
+	_, err := mgoTeamBrandingsCollection(c).ReplaceOne(ctx, bson.M{"_id": branding.TeamID}, branding, options)
+	return err
+}
+
+// RemoveTeamBranding deletes the branding of a medical team
+func (c *Client) RemoveTeamBranding(ctx context.Context, teamID string) error {
+	_, err := mgoTeamBrandingsCollection(c).DeleteOne(ctx, bson.M{"_id": teamID})
+	return err
+}
+
+// InsertAuditEvent records an event in the audit log
+func (c *Client) InsertAuditEvent(ctx context.Context, event *models.AuditEvent) error {
+	_, err := mgoAuditEventsCollection(c).InsertOne(ctx, event)
+	return err
+}
+
+// PseudonymizeUserAuditEvents replaces the user ID and the hashes of the emails of the user in the audit events
+// It returns the number of events changed, none once done
+func (c *Client) PseudonymizeUserAuditEvents(ctx context.Context, userID string, emailHashes []string, pseudonym *models.Pseudonym) (int64, error) {
+	or := bson.A{bson.M{"actor": userID}, bson.M{"targetUser": userID}}
+	if len(emailHashes) > 0 {
+		or = append(or, bson.M{"targetEmailHash": bson.M{"$in": emailHashes}})
+	}
+	projection := bson.M{"actor": 1, "targetUser": 1, "targetEmailHash": 1}
+	return updateDocuments(ctx, mgoAuditEventsCollection(c), bson.M{"$or": or}, projection, func(raw bson.Raw) (bson.M, error) {
+		var event models.AuditEvent
+		if err := bson.Unmarshal(raw, &event); err != nil {
+			return nil, err
+		}
+		if !event.Pseudonymize(userID, emailHashes, pseudonym) {
+			return nil, nil
+		}
+		set := bson.M{"actor": event.Actor}
+		if event.TargetUser != "" {
+			set["targetUser"] = event.TargetUser
+		}
+		if event.TargetEmailHash != "" {
+			set["targetEmailHash"] = event.TargetEmailHash
+		}
+		return bson.M{"$set": set}, nil
+	})
+}
+
+// FindAuditEvents returns the audit events selected by the filter, the latest first
+func (c *Client) FindAuditEvents(ctx context.Context, filter *models.AuditFilter) (results []*models.AuditEvent, err error) {
+	query := bson.M{}
+	if filter.Actor != "" {
+		query["actor"] = filter.Actor
+	}
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+	if filter.TargetUser != "" {
+		query["targetUser"] = filter.TargetUser
+	}
+	if filter.TargetTeam != "" {
+		query["targetTeam"] = filter.TargetTeam
+	}
+	if filter.TargetEmailHash != "" {
+		query["targetEmailHash"] = filter.TargetEmailHash
+	}
+	period := bson.M{}
+	if !filter.From.IsZero() {
+		period["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		period["$lt"] = filter.To
+	}
+	if len(period) > 0 {
+		query["time"] = period
+	}
+	opts := options.Find().SetSort(bson.D{primitive.E{Key: "time", Value: -1}})
+	if filter.Limit > 0 {
+		opts.SetLimit(int64(filter.Limit))
+	}
+	cursor, err := mgoAuditEventsCollection(c).Find(ctx, query, opts)
+	if err != nil {
+		log.Printf("FindAuditEvents: something bad happened [%v]", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	err = cursor.All(ctx, &results)
+	return results, err
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/mdblp/hydrophone/models"
 	goComMgo "github.com/tidepool-org/go-common/clients/mongo"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 var logger = log.New(os.Stdout, "mongo-test ", log.LstdFlags|log.LUTC|log.Lshortfile)
@@ -165,3 +166,406 @@ func TestMongoStoreConfirmationOperations(t *testing.T) {
 		}
 	}
 }
+
+func TestMongoStoreTemplateVersionOperations(t *testing.T) {
+	if _, exist := os.LookupEnv("TIDEPOOL_STORE_ADDRESSES"); exist {
+		// if mongo connexion information is provided via env var
+		testingConfig.FromEnv()
+	}
+
+	mc, _ := NewStore(testingConfig, logger)
+	mc.Start()
+	mc.WaitUntilStarted()
+	mgoTemplateVersionsCollection(mc).Drop(context.TODO())
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		version := &models.TemplateVersion{Template: models.TemplateNameSignup, Subject: "SignupSubject", Created: time.Now()}
+		if err := mc.InsertTemplateVersion(ctx, version); err != nil {
+			t.Fatalf("we could not save the template version - err [%v]", err)
+		}
+		if version.Version != i+1 {
+			t.Fatalf("the template version should be numbered %d but is %d", i+1, version.Version)
+		}
+	}
+
+	if active, err := mc.FindActiveTemplateVersion(ctx, models.TemplateNameSignup); err != nil || active != nil {
+		t.Fatalf("no template version should be active [%v] - err [%v]", active, err)
+	}
+	if err := mc.ActivateTemplateVersion(ctx, models.TemplateNameSignup, 4); err != ErrTemplateVersionNotFound {
+		t.Fatalf("activating an unknown version should fail - err [%v]", err)
+	}
+	mc.ActivateTemplateVersion(ctx, models.TemplateNameSignup, 1)
+	time.Sleep(10 * time.Millisecond)
+	mc.ActivateTemplateVersion(ctx, models.TemplateNameSignup, 3)
+	if active, err := mc.FindActiveTemplateVersion(ctx, models.TemplateNameSignup); err != nil || active == nil || active.Version != 3 {
+		t.Fatalf("the version 3 should be active [%v] - err [%v]", active, err)
+	}
+
+	if target, err := mc.RollbackTemplateVersion(ctx, models.TemplateNameSignup); err != nil || target == nil || target.Version != 1 {
+		t.Fatalf("rolling back should activate the version 1 [%v] - err [%v]", target, err)
+	}
+	if target, err := mc.RollbackTemplateVersion(ctx, models.TemplateNameSignup); err != nil || target != nil {
+		t.Fatalf("rolling back should go back to the baseline [%v] - err [%v]", target, err)
+	}
+	if _, err := mc.RollbackTemplateVersion(ctx, models.TemplateNameSignup); err != ErrNoActiveTemplateVersion {
+		t.Fatalf("there should be nothing left to roll back - err [%v]", err)
+	}
+	if versions, err := mc.FindTemplateVersions(ctx, models.TemplateNameSignup); err != nil || len(versions) != 3 || versions[0].Version != 3 {
+		t.Fatalf("we should have found the 3 versions, the latest first %v - err [%v]", versions, err)
+	}
+}
+
+func TestMongoStoreTeamBrandingOperations(t *testing.T) {
+	if _, exist := os.LookupEnv("TIDEPOOL_STORE_ADDRESSES"); exist {
+		// if mongo connexion information is provided via env var
+		testingConfig.FromEnv()
+	}
+
+	mc, _ := NewStore(testingConfig, logger)
+	mc.Start()
+	mc.WaitUntilStarted()
+	mgoTeamBrandingsCollection(mc).Drop(context.TODO())
+	ctx := context.Background()
+
+	if found, err := mc.FindTeamBranding(ctx, "team.1"); err != nil || found != nil {
+		t.Fatalf("there should be no branding [%v] - err [%v]", found, err)
+	}
+	branding := &models.TeamBranding{TeamID: "team.1", Signature: "Dr. Who", Welcome: map[string]string{"en": "Welcome"}, Logo: []byte{1, 2, 3}, LogoType: "image/png"}
+	if err := mc.UpsertTeamBranding(ctx, branding); err != nil {
+		t.Fatalf("we could not save the branding - err [%v]", err)
+	}
+	branding.Signature = "Dr. Watson"
+	if err := mc.UpsertTeamBranding(ctx, branding); err != nil {
+		t.Fatalf("we could not update the branding - err [%v]", err)
+	}
+	if found, err := mc.FindTeamBranding(ctx, "team.1"); err != nil || found == nil || found.Signature != "Dr. Watson" || found.LogoType != "image/png" {
+		t.Fatalf("the updated branding should be found [%v] - err [%v]", found, err)
+	}
+	if err := mc.RemoveTeamBranding(ctx, "team.1"); err != nil {
+		t.Fatalf("we could not remove the branding - err [%v]", err)
+	}
+	if found, err := mc.FindTeamBranding(ctx, "team.1"); err != nil || found != nil {
+		t.Fatalf("the branding has been removed so we shouldn't find it [%v] - err [%v]", found, err)
+	}
+}
+
+func TestMongoStoreIndexesAndCanonicalEmail(t *testing.T) {
+	if _, exist := os.LookupEnv("TIDEPOOL_STORE_ADDRESSES"); exist {
+		// if mongo connexion information is provided via env var
+		testingConfig.FromEnv()
+	}
+
+	mc, _ := NewStore(testingConfig, logger)
+	mc.Start()
+	mc.WaitUntilStarted()
+	mgoConfirmationsCollection(mc).Drop(context.TODO())
+	ctx := context.Background()
+
+	// a confirmation stored before the canonical email existed
+	legacy := bson.M{"_id": "legacy.key", "type": models.TypeCareteamInvite, "email": "Legacy@Example.com", "status": models.StatusPending, "created": time.Now()}
+	if _, err := mgoConfirmationsCollection(mc).InsertOne(ctx, legacy); err != nil {
+		t.Fatalf("we could not save the legacy confirmation - err [%v]", err)
+	}
+	if count, err := mc.BackfillEmailLower(ctx); err != nil || count != 1 {
+		t.Fatalf("the legacy confirmation should have been backfilled, got %d - err [%v]", count, err)
+	}
+	if count, err := mc.BackfillEmailLower(ctx); err != nil || count != 0 {
+		t.Fatalf("the backfill should only update the confirmations without canonical email, got %d - err [%v]", count, err)
+	}
+	if found, err := mc.FindConfirmation(ctx, &models.Confirmation{Email: "LEGACY@example.COM"}); err != nil || found == nil || found.Key != "legacy.key" {
+		t.Fatalf("the legacy confirmation should be found by its canonical email [%v] - err [%v]", found, err)
+	}
+
+	if err := mc.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("we could not create the indexes - err [%v]", err)
+	}
+	// creating them again is a no-op
+	if err := mc.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("we could not verify the indexes - err [%v]", err)
+	}
+	mgoConfirmationsCollection(mc).Indexes().DropOne(ctx, "shortKey")
+	if err := mc.checkIndexes(ctx, confirmationsCollection, collectionIndexes[confirmationsCollection]); err == nil {
+		t.Fatalf("the missing index should have been reported")
+	}
+}
+
+func TestMongoStoreTransitionStatus(t *testing.T) {
+	if _, exist := os.LookupEnv("TIDEPOOL_STORE_ADDRESSES"); exist {
+		// if mongo connexion information is provided via env var
+		testingConfig.FromEnv()
+	}
+
+	mc, _ := NewStore(testingConfig, logger)
+	mc.Start()
+	mc.WaitUntilStarted()
+	mgoConfirmationsCollection(mc).Drop(context.TODO())
+	ctx := context.Background()
+
+	invite, _ := models.NewConfirmation(models.TypeCareteamInvite, models.TemplateNameCareteamInvite, "123.456")
+	invite.Email = "test@test.com"
+	if err := mc.UpsertConfirmation(ctx, invite); err != nil {
+		t.Fatalf("we could not save the invite - err [%v]", err)
+	}
+
+	updated, err := mc.TransitionStatus(ctx, invite.Key, 1, models.StatusChange{From: models.StatusPending, To: models.StatusCompleted, Actor: "789"}, map[string]interface{}{"userId": "789"})
+	if err != nil {
+		t.Fatalf("we could not accept the invite - err [%v]", err)
+	}
+	if updated.Status != models.StatusCompleted || updated.UserId != "789" || updated.Revision != 2 {
+		t.Fatalf("the invite should be completed at revision 2 [%v]", updated)
+	}
+	if len(updated.History) != 1 || updated.History[0].Actor != "789" || updated.History[0].Time.IsZero() {
+		t.Fatalf("the transition should be recorded in the history [%v]", updated.History)
+	}
+	// saving the invite again keeps its history
+	updated.Role = "member"
+	updated.History = nil
+	if err := mc.UpsertConfirmation(ctx, updated); err != nil {
+		t.Fatalf("we could not save the invite - err [%v]", err)
+	}
+	if found, err := mc.FindConfirmation(ctx, &models.Confirmation{Key: invite.Key}); err != nil || len(found.History) != 1 {
+		t.Fatalf("the history should be kept when saving the invite [%v] - err [%v]", found, err)
+	}
+
+	// a second request still seeing the invite pending loses
+	_, err = mc.TransitionStatus(ctx, invite.Key, 1, models.StatusChange{From: models.StatusPending, To: models.StatusDeclined, Actor: "789"}, nil)
+	conflict, ok := err.(*StatusConflictError)
+	if !ok || conflict.Actual != models.StatusCompleted {
+		t.Fatalf("the second transition should conflict with the completed status - err [%v]", err)
+	}
+	_, err = mc.TransitionStatus(ctx, "key.does.not.exist", 0, models.StatusChange{From: models.StatusPending, To: models.StatusDeclined}, nil)
+	if conflict, ok := err.(*StatusConflictError); !ok || conflict.Actual != "" {
+		t.Fatalf("the transition of a missing confirmation should conflict - err [%v]", err)
+	}
+}
+
+func TestMongoStoreUniquePendingInvite(t *testing.T) {
+	if _, exist := os.LookupEnv("TIDEPOOL_STORE_ADDRESSES"); exist {
+		// if mongo connexion information is provided via env var
+		testingConfig.FromEnv()
+	}
+
+	mc, _ := NewStore(testingConfig, logger)
+	// connect only, the duplicates are saved before the indexes are created
+	mc.StoreClient.Start()
+	mc.WaitUntilStarted()
+	mgoConfirmationsCollection(mc).Drop(context.TODO())
+	ctx := context.Background()
+
+	newInvite := func(email string, created time.Time) *models.Confirmation {
+		invite, _ := models.NewConfirmation(models.TypeCareteamInvite, models.TemplateNameCareteamInvite, "123.456")
+		invite.Email = email
+		invite.Created = created
+		return invite
+	}
+	// duplicates sent before the index existed
+	older := newInvite("dup@test.com", time.Now().AddDate(0, 0, -2))
+	latest := newInvite("Dup@test.com", time.Now())
+	for _, invite := range []*models.Confirmation{older, latest} {
+		if err := mc.UpsertConfirmation(ctx, invite); err != nil {
+			t.Fatalf("we could not save the invite - err [%v]", err)
+		}
+	}
+	if count, err := mc.CancelDuplicateInvites(ctx); err != nil || count != 1 {
+		t.Fatalf("the older invite should have been canceled, got %d - err [%v]", count, err)
+	}
+	if found, err := mc.FindConfirmation(ctx, &models.Confirmation{Key: older.Key}); err != nil || found.Status != models.StatusCanceled {
+		t.Fatalf("the older invite should be canceled [%v] - err [%v]", found, err)
+	}
+	if err := mc.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("we could not create the indexes - err [%v]", err)
+	}
+
+	if err := mc.UpsertConfirmation(ctx, newInvite("DUP@test.com", time.Now())); err != ErrDuplicateInvite {
+		t.Fatalf("a second pending invite should be refused - err [%v]", err)
+	}
+	// another inviter, or once the first one is no more pending
+	other := newInvite("dup@test.com", time.Now())
+	other.CreatorId = "789"
+	if err := mc.UpsertConfirmation(ctx, other); err != nil {
+		t.Fatalf("an invite from another inviter should be saved - err [%v]", err)
+	}
+	if _, err := mc.TransitionStatus(ctx, latest.Key, 1, models.StatusChange{From: models.StatusPending, To: models.StatusCanceled}, nil); err != nil {
+		t.Fatalf("we could not cancel the invite - err [%v]", err)
+	}
+	if err := mc.UpsertConfirmation(ctx, newInvite("dup@test.com", time.Now())); err != nil {
+		t.Fatalf("a new invite should be saved once the previous one is canceled - err [%v]", err)
+	}
+}
+
+func TestMongoStoreAuditEvents(t *testing.T) {
+	if _, exist := os.LookupEnv("TIDEPOOL_STORE_ADDRESSES"); exist {
+		// if mongo connexion information is provided via env var
+		testingConfig.FromEnv()
+	}
+
+	mc, _ := NewStore(testingConfig, logger)
+	mc.StoreClient.Start()
+	mc.WaitUntilStarted()
+	mgoAuditEventsCollection(mc).Drop(context.TODO())
+	ctx := context.Background()
+
+	now := time.Now().Truncate(time.Millisecond)
+	events := []*models.AuditEvent{
+		{Time: now.Add(-time.Hour), Actor: "123", Action: models.AuditInviteCreated, Outcome: models.AuditSuccess, TargetTeam: "team1"},
+		{Time: now, Actor: "123", Action: models.AuditInviteSent, Outcome: models.AuditFailure, TargetTeam: "team1"},
+		{Time: now, Actor: models.ActorServer, Action: models.AuditSignupSent, Outcome: models.AuditSuccess},
+	}
+	for _, event := range events {
+		if err := mc.InsertAuditEvent(ctx, event); err != nil {
+			t.Fatalf("we could not save the audit event - err [%v]", err)
+		}
+	}
+	found, err := mc.FindAuditEvents(ctx, &models.AuditFilter{Actor: "123", TargetTeam: "team1", Limit: 10})
+	if err != nil || len(found) != 2 || found[0].Action != models.AuditInviteSent {
+		t.Fatalf("the events of the team should be found, the latest first [%v] - err [%v]", found, err)
+	}
+	found, err = mc.FindAuditEvents(ctx, &models.AuditFilter{From: now.Add(-time.Minute), Limit: 1})
+	if err != nil || len(found) != 1 {
+		t.Fatalf("the events should be limited [%v] - err [%v]", found, err)
+	}
+
+	// the retention is updated when it changes
+	if err := mc.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("we could not create the indexes - err [%v]", err)
+	}
+	mc.AuditRetention = 30 * 24 * time.Hour
+	if err := mc.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("we could not update the audit retention - err [%v]", err)
+	}
+}
+
+func TestMongoStoreConformance(t *testing.T) {
+	if _, exist := os.LookupEnv("TIDEPOOL_STORE_ADDRESSES"); exist {
+		// if mongo connexion information is provided via env var
+		testingConfig.FromEnv()
+	}
+
+	testStoreConformance(t, func(t *testing.T) StoreClient {
+		mc, _ := NewStore(testingConfig, logger)
+		mc.StoreClient.Start()
+		mc.WaitUntilStarted()
+		ctx := context.Background()
+		for _, collection := range []string{confirmationsCollection, templateVersionsCollection, teamBrandingsCollection, auditEventsCollection} {
+			mc.Collection(collection).Drop(ctx)
+		}
+		if err := mc.EnsureIndexes(ctx); err != nil {
+			t.Fatalf("we could not create the indexes - err [%v]", err)
+		}
+		return mc
+	})
+}
+
+func TestMongoStoreMigrations(t *testing.T) {
+	if _, exist := os.LookupEnv("TIDEPOOL_STORE_ADDRESSES"); exist {
+		// if mongo connexion information is provided via env var
+		testingConfig.FromEnv()
+	}
+
+	mc, _ := NewStore(testingConfig, logger)
+	mc.SkipMigrations = true
+	mc.Start()
+	mc.WaitUntilStarted()
+	ctx := context.Background()
+	mgoConfirmationsCollection(mc).Drop(ctx)
+	mgoMigrationsCollection(mc).Drop(ctx)
+
+	// confirmations stored by the former versions
+	legacy := []interface{}{
+		bson.M{"_id": "team.key", "type": models.TypeMedicalTeamInvite, "email": "Team@Example.com", "status": models.StatusPending, "created": time.Now(), "team": bson.M{"teamId": "team.id", "name": "the team"}},
+		bson.M{"_id": "creator.key", "type": models.TypeCareteamInvite, "email": "creator@example.com", "emailLower": "creator@example.com", "status": models.StatusCompleted, "created": time.Now(), "creator": bson.M{"profile": bson.M{"fullName": "John Doe"}}, "templateName": ""},
+	}
+	if _, err := mgoConfirmationsCollection(mc).InsertMany(ctx, legacy); err != nil {
+		t.Fatalf("we could not save the legacy confirmations - err [%v]", err)
+	}
+
+	applied, err := mc.Migrate(ctx)
+	if err != nil || len(applied) != len(migrations) {
+		t.Fatalf("all the migrations should have been applied [%v] - err [%v]", applied, err)
+	}
+	var team bson.M
+	mgoConfirmationsCollection(mc).FindOne(ctx, bson.M{"_id": "team.key"}).Decode(&team)
+	if _, nested := team["team"]; nested || team["teamId"] != "team.id" || team["emailLower"] != "team@example.com" || team["templateName"] != string(models.TemplateNameMedicalteamInvite) {
+		t.Fatalf("the team invite should have been migrated [%v]", team)
+	}
+	var creator bson.M
+	mgoConfirmationsCollection(mc).FindOne(ctx, bson.M{"_id": "creator.key"}).Decode(&creator)
+	if len(creator["creator"].(bson.M)) != 0 || creator["templateName"] != string(models.TemplateNameCareteamInvite) {
+		t.Fatalf("the care team invite should have been migrated [%v]", creator)
+	}
+	if found, err := mc.FindConfirmation(ctx, &models.Confirmation{Team: &models.Team{ID: "team.id"}}); err != nil || found == nil || found.Key != "team.key" {
+		t.Fatalf("the migrated invite should be found by its team [%v] - err [%v]", found, err)
+	}
+
+	// applying them again is a no-op
+	if applied, err := mc.Migrate(ctx); err != nil || len(applied) != 0 {
+		t.Fatalf("the migrations should only be applied once [%v] - err [%v]", applied, err)
+	}
+	if done, err := mc.AppliedMigrations(ctx); err != nil || len(done) != len(migrations) || done[0].ID != migrations[0].ID {
+		t.Fatalf("the migrations applied should be recorded [%v] - err [%v]", done, err)
+	}
+
+	// another instance holding the lock
+	lock := bson.M{"_id": migrationsLockID, "owner": "other", "expires": time.Now().Add(time.Minute)}
+	if _, err := mgoMigrationsCollection(mc).InsertOne(ctx, lock); err != nil {
+		t.Fatalf("we could not save the lock - err [%v]", err)
+	}
+	if _, err := mc.Migrate(ctx); err != ErrMigrationsLocked {
+		t.Fatalf("the migrations should be locked - err [%v]", err)
+	}
+	// until it expires
+	mgoMigrationsCollection(mc).UpdateOne(ctx, bson.M{"_id": migrationsLockID}, bson.M{"$set": bson.M{"expires": time.Now().Add(-time.Minute)}})
+	if _, err := mc.Migrate(ctx); err != nil {
+		t.Fatalf("the expired lock should have been taken - err [%v]", err)
+	}
+	if count, _ := mgoMigrationsCollection(mc).CountDocuments(ctx, bson.M{"_id": migrationsLockID}); count != 0 {
+		t.Fatalf("the lock should have been released")
+	}
+}
+
+func TestMongoStoreIndexesWaitMigrations(t *testing.T) {
+	if _, exist := os.LookupEnv("TIDEPOOL_STORE_ADDRESSES"); exist {
+		// if mongo connexion information is provided via env var
+		testingConfig.FromEnv()
+	}
+
+	// the instance running the migrations
+	other, _ := NewStore(testingConfig, logger)
+	other.SkipMigrations = true
+	other.Start()
+	other.WaitUntilStarted()
+	ctx := context.Background()
+	mgoMigrationsCollection(other).Drop(ctx)
+	lock := bson.M{"_id": migrationsLockID, "owner": "other", "expires": time.Now().Add(time.Minute)}
+	if _, err := mgoMigrationsCollection(other).InsertOne(ctx, lock); err != nil {
+		t.Fatalf("we could not save the lock - err [%v]", err)
+	}
+
+	mc, _ := NewStore(testingConfig, logger)
+	mc.SkipMigrations = true
+	mc.Start()
+	mc.WaitUntilStarted()
+	time.Sleep(100 * time.Millisecond)
+	if err := mc.Ping(); err != errIndexesPending {
+		t.Fatalf("the indexes should wait for the migrations - err [%v]", err)
+	}
+	if done, err := mc.MigrationsDone(ctx); err != nil || done {
+		t.Fatalf("the migrations should not be done - err [%v]", err)
+	}
+
+	// the lock expires and the migrations are applied
+	mgoMigrationsCollection(other).UpdateOne(ctx, bson.M{"_id": migrationsLockID}, bson.M{"$set": bson.M{"expires": time.Now().Add(-time.Minute)}})
+	if _, err := other.Migrate(ctx); err != nil {
+		t.Fatalf("the migrations should have been applied - err [%v]", err)
+	}
+	if done, err := mc.MigrationsDone(ctx); err != nil || !done {
+		t.Fatalf("the migrations should be done - err [%v]", err)
+	}
+	for i := 0; mc.Ping() != nil; i++ {
+		if i == 100 {
+			t.Fatalf("the indexes should have been created once the migrations are done - err [%v]", mc.Ping())
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
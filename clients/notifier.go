@@ -1,5 +1,53 @@
 package clients
 
-type Notifier interface {
-	Send(addresses []string, subject, content string) (int, string)
+import (
+	"net/mail"
+
+	"github.com/mdblp/hydrophone/utils/cid"
+)
+
+type (
+	Notifier interface {
+		Send(message *Message) (int, string)
+	}
+
+	// Message is an html email to send
+	Message struct {
+		// FromName and FromAddress override the sender of the notifier configuration when they are given
+		FromName    string
+		FromAddress string
+		// ReplyTo is the address the replies are sent to, the sender one when it is empty
+		ReplyTo string
+		To      []string
+		Subject string
+		Body    string
+		// Images are the inline images referenced by the body with cid: urls
+		Images []*cid.Image
+	}
+)
+
+// FormatAddress returns the address with its display name, the name being encoded when it is not ascii
+func FormatAddress(name, address string) string {
+	if address == "" {
+		return ""
+	}
+	if name == "" {
+		return address
+	}
+	return (&mail.Address{Name: name, Address: address}).String()
+}
+
+// senderOf returns the address to send the message from, the message name and address replacing the default ones
+func (m *Message) senderOf(defaultFrom string) string {
+	name, address := "", defaultFrom
+	if parsed, err := mail.ParseAddress(defaultFrom); err == nil {
+		name, address = parsed.Name, parsed.Address
+	}
+	if m.FromName != "" {
+		name = m.FromName
+	}
+	if m.FromAddress != "" {
+		address = m.FromAddress
+	}
+	return FormatAddress(name, address)
 }
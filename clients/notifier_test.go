@@ -0,0 +1,23 @@
+package clients
+
+import "testing"
+
+func Test_SenderOf(t *testing.T) {
+	tests := []struct {
+		message  Message
+		from     string
+		expected string
+	}{
+		{Message{}, "YourLoops <noreply@example.com>", `"YourLoops" <noreply@example.com>`},
+		{Message{}, "noreply@example.com", "noreply@example.com"},
+		{Message{FromName: "Assistance YourLoops"}, "YourLoops <noreply@example.com>", `"Assistance YourLoops" <noreply@example.com>`},
+		{Message{FromName: "Équipe"}, "noreply@example.com", "=?utf-8?q?=C3=89quipe?= <noreply@example.com>"},
+		{Message{FromAddress: "support@example.com"}, "YourLoops <noreply@example.com>", `"YourLoops" <support@example.com>`},
+		{Message{FromName: "Support"}, "", ""},
+	}
+	for _, test := range tests {
+		if from := test.message.senderOf(test.from); from != test.expected {
+			t.Fatalf("Wrong sender of %+v from %s, expecting %s but got %s", test.message, test.from, test.expected, from)
+		}
+	}
+}
@@ -15,8 +15,8 @@ func NewNullNotifier() (*NullNotifier, error) {
 }
 
 // Send do nothing, return 200, "OK"
-func (c *NullNotifier) Send(to []string, subject string, msg string) (int, string) {
-	var toAddress = to[0]
-	log.Printf("Not sending mail to %s, disabled by server configuration: %s\n", toAddress, subject)
+func (c *NullNotifier) Send(message *Message) (int, string) {
+	var toAddress = message.To[0]
+	log.Printf("Not sending mail to %s, disabled by server configuration: %s\n", toAddress, message.Subject)
 	return 200, "OK"
 }
@@ -0,0 +1,310 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/mdblp/hydrophone/models"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// DefaultStoreTimeout bounds each attempt of a store operation when not configured
+	DefaultStoreTimeout = 10 * time.Second
+	// DefaultStoreRetries is the number of times a failed operation is tried again when not configured
+	DefaultStoreRetries = 2
+	// DefaultStoreBackoff is the delay before the first retry when not configured, doubled for each retry
+	DefaultStoreBackoff = 100 * time.Millisecond
+	// maxStoreBackoff bounds the delay between two attempts
+	maxStoreBackoff = 2 * time.Second
+	// serverSelectionError prefixes the error of the driver when no server could be selected for the operation,
+	// it is formatted without wrapping the cause so only its message tells
+	serverSelectionError = "server selection error"
+)
+
+var (
+	// notPrimaryCodes are the mongo error codes of an operation refused by a member which is not the primary anymore,
+	// the operation was not applied
+	notPrimaryCodes = map[int32]bool{
+		10107: true, // NotMaster
+		13435: true, // NotMasterNoSlaveOk
+		13436: true, // NotMasterOrSecondary
+		91:    true, // ShutdownInProgress
+	}
+	// transientCodes are the mongo error codes of an operation interrupted by the network or an election,
+	// a write may have been applied
+	transientCodes = map[int32]bool{
+		6:     true, // HostUnreachable
+		7:     true, // HostNotFound
+		89:    true, // NetworkTimeout
+		189:   true, // PrimarySteppedDown
+		262:   true, // ExceededTimeLimit
+		9001:  true, // SocketException
+		11600: true, // InterruptedAtShutdown
+		11602: true, // InterruptedDueToReplStateChange
+	}
+)
+
+// RetryConfig tunes the attempts of the store operations, the zero values take the defaults
+type RetryConfig struct {
+	// Timeout bounds each attempt, within the deadline of the request
+	Timeout time.Duration
+	// Retries is the number of times a transient failure is tried again, -1 to never retry
+	Retries int
+	// Backoff is the delay before the first retry, doubled for each retry with a random jitter
+	Backoff time.Duration
+}
+
+// RetryStoreClient is a StoreClient bounding each operation of the store it wraps with a timeout,
+// trying again the transient failures and classifying the errors returned in a StoreError
+// The reads are retried on any transient failure, the writes only when the store refused them before they were applied
+type RetryStoreClient struct {
+	StoreClient
+	config RetryConfig
+}
+
+// NewRetryStore wraps the store with the retries of the config
+func NewRetryStore(store StoreClient, config RetryConfig) *RetryStoreClient {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultStoreTimeout
+	}
+	if config.Retries == 0 {
+		config.Retries = DefaultStoreRetries
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = DefaultStoreBackoff
+	}
+	return &RetryStoreClient{StoreClient: store, config: config}
+}
+
+// isRejectedError tells if the store refused the operation before applying it, it can be tried again even for a write
+func isRejectedError(err error) bool {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.HasErrorLabel("RetryableWriteError") || notPrimaryCodes[cmdErr.Code]
+	}
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) {
+		return writeErr.HasErrorLabel("RetryableWriteError") ||
+			(writeErr.WriteConcernError != nil && notPrimaryCodes[int32(writeErr.WriteConcernError.Code)])
+	}
+	return strings.Contains(err.Error(), serverSelectionError)
+}
+
+// isTransientError tells if the operation may succeed when tried again: network failures, elections or the attempt timing out
+func isTransientError(err error) bool {
+	if isRejectedError(err) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.HasErrorLabel("NetworkError") || cmdErr.HasErrorLabel("TransientTransactionError") || transientCodes[cmdErr.Code]
+	}
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) {
+		return writeErr.HasErrorLabel("NetworkError") ||
+			(writeErr.WriteConcernError != nil && transientCodes[int32(writeErr.WriteConcernError.Code)])
+	}
+	return false
+}
+
+// classify wraps the error of the operation in a StoreError with its category
+// A transition of a confirmation removed meanwhile is a conflict as the one of a confirmation changed meanwhile
+func classify(op string, err error) error {
+	storeErr := &StoreError{Op: op, Err: err}
+	var conflict *StatusConflictError
+	switch {
+	case errors.As(err, &conflict), errors.Is(err, ErrDuplicateInvite), errors.Is(err, ErrTemplateVersionChanged):
+		storeErr.Kind = ErrConflict
+	case errors.Is(err, ErrTemplateVersionNotFound), errors.Is(err, ErrNoActiveTemplateVersion):
+		storeErr.Kind = ErrNotFound
+	case isTransientError(err), errors.Is(err, mongo.ErrClientDisconnected), errors.Is(err, bolt.ErrDatabaseNotOpen):
+		storeErr.Kind = ErrUnavailable
+	}
+	return storeErr
+}
+
+// backoff returns the delay before the retry, a random part of the doubled delay so the retries of concurrent requests spread
+func (c *RetryStoreClient) backoff(retry int) time.Duration {
+	delay := c.config.Backoff << uint(retry)
+	if delay <= 0 || delay > maxStoreBackoff {
+		delay = maxStoreBackoff
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// do runs the operation until it succeeds, fails for good, the retries are exhausted or the request is over
+func (c *RetryStoreClient) do(ctx context.Context, op string, write bool, call func(ctx context.Context) error) error {
+	var err error
+	for retry := 0; ; retry++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+		err = call(attemptCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		retryable := isRejectedError(err) || (!write && isTransientError(err))
+		if !retryable || retry >= c.config.Retries || ctx.Err() != nil {
+			break
+		}
+		delay := c.backoff(retry)
+		log.Printf("%s: attempt %d failed, retrying in %v [%v]", op, retry+1, delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return classify(op, err)
+		case <-timer.C:
+		}
+	}
+	return classify(op, err)
+}
+
+func (c *RetryStoreClient) UpsertConfirmation(ctx context.Context, confirmation *models.Confirmation) error {
+	return c.do(ctx, "UpsertConfirmation", true, func(ctx context.Context) error {
+		return c.StoreClient.UpsertConfirmation(ctx, confirmation)
+	})
+}
+
+func (c *RetryStoreClient) FindConfirmations(ctx context.Context, confirmation *models.Confirmation, statuses []models.Status, types []models.Type) (results []*models.Confirmation, err error) {
+	err = c.do(ctx, "FindConfirmations", false, func(ctx context.Context) (err error) {
+		results, err = c.StoreClient.FindConfirmations(ctx, confirmation, statuses, types)
+		return err
+	})
+	return results, err
+}
+
+func (c *RetryStoreClient) FindConfirmation(ctx context.Context, confirmation *models.Confirmation) (result *models.Confirmation, err error) {
+	err = c.do(ctx, "FindConfirmation", false, func(ctx context.Context) (err error) {
+		result, err = c.StoreClient.FindConfirmation(ctx, confirmation)
+		return err
+	})
+	return result, err
+}
+
+func (c *RetryStoreClient) FindUserConfirmations(ctx context.Context, userID string, emails []string) (results []*models.Confirmation, err error) {
+	err = c.do(ctx, "FindUserConfirmations", false, func(ctx context.Context) (err error) {
+		results, err = c.StoreClient.FindUserConfirmations(ctx, userID, emails)
+		return err
+	})
+	return results, err
+}
+
+func (c *RetryStoreClient) PseudonymizeUserConfirmations(ctx context.Context, userID string, emails []string, pseudonym *models.Pseudonym) (changed int64, err error) {
+	err = c.do(ctx, "PseudonymizeUserConfirmations", true, func(ctx context.Context) (err error) {
+		changed, err = c.StoreClient.PseudonymizeUserConfirmations(ctx, userID, emails, pseudonym)
+		return err
+	})
+	return changed, err
+}
+
+func (c *RetryStoreClient) RemoveConfirmation(ctx context.Context, confirmation *models.Confirmation) error {
+	return c.do(ctx, "RemoveConfirmation", true, func(ctx context.Context) error {
+		return c.StoreClient.RemoveConfirmation(ctx, confirmation)
+	})
+}
+
+func (c *RetryStoreClient) TransitionStatus(ctx context.Context, key string, revision int, change models.StatusChange, patch map[string]interface{}) (result *models.Confirmation, err error) {
+	err = c.do(ctx, "TransitionStatus", true, func(ctx context.Context) (err error) {
+		result, err = c.StoreClient.TransitionStatus(ctx, key, revision, change, patch)
+		return err
+	})
+	return result, err
+}
+
+func (c *RetryStoreClient) SetConfirmationTemplateVersion(ctx context.Context, key string, version int) error {
+	return c.do(ctx, "SetConfirmationTemplateVersion", true, func(ctx context.Context) error {
+		return c.StoreClient.SetConfirmationTemplateVersion(ctx, key, version)
+	})
+}
+
+func (c *RetryStoreClient) InsertTemplateVersion(ctx context.Context, version *models.TemplateVersion) error {
+	return c.do(ctx, "InsertTemplateVersion", true, func(ctx context.Context) error {
+		return c.StoreClient.InsertTemplateVersion(ctx, version)
+	})
+}
+
+func (c *RetryStoreClient) FindTemplateVersions(ctx context.Context, name models.TemplateName) (results []*models.TemplateVersion, err error) {
+	err = c.do(ctx, "FindTemplateVersions", false, func(ctx context.Context) (err error) {
+		results, err = c.StoreClient.FindTemplateVersions(ctx, name)
+		return err
+	})
+	return results, err
+}
+
+func (c *RetryStoreClient) FindTemplateVersion(ctx context.Context, name models.TemplateName, version int) (result *models.TemplateVersion, err error) {
+	err = c.do(ctx, "FindTemplateVersion", false, func(ctx context.Context) (err error) {
+		result, err = c.StoreClient.FindTemplateVersion(ctx, name, version)
+		return err
+	})
+	return result, err
+}
+
+func (c *RetryStoreClient) FindActiveTemplateVersion(ctx context.Context, name models.TemplateName) (result *models.TemplateVersion, err error) {
+	err = c.do(ctx, "FindActiveTemplateVersion", false, func(ctx context.Context) (err error) {
+		result, err = c.StoreClient.FindActiveTemplateVersion(ctx, name)
+		return err
+	})
+	return result, err
+}
+
+func (c *RetryStoreClient) ActivateTemplateVersion(ctx context.Context, name models.TemplateName, version int) error {
+	return c.do(ctx, "ActivateTemplateVersion", true, func(ctx context.Context) error {
+		return c.StoreClient.ActivateTemplateVersion(ctx, name, version)
+	})
+}
+
+func (c *RetryStoreClient) RollbackTemplateVersion(ctx context.Context, name models.TemplateName) (result *models.TemplateVersion, err error) {
+	err = c.do(ctx, "RollbackTemplateVersion", true, func(ctx context.Context) (err error) {
+		result, err = c.StoreClient.RollbackTemplateVersion(ctx, name)
+		return err
+	})
+	return result, err
+}
+
+func (c *RetryStoreClient) FindTeamBranding(ctx context.Context, teamID string) (result *models.TeamBranding, err error) {
+	err = c.do(ctx, "FindTeamBranding", false, func(ctx context.Context) (err error) {
+		result, err = c.StoreClient.FindTeamBranding(ctx, teamID)
+		return err
+	})
+	return result, err
+}
+
+func (c *RetryStoreClient) UpsertTeamBranding(ctx context.Context, branding *models.TeamBranding) error {
+	return c.do(ctx, "UpsertTeamBranding", true, func(ctx context.Context) error {
+		return c.StoreClient.UpsertTeamBranding(ctx, branding)
+	})
+}
+
+func (c *RetryStoreClient) RemoveTeamBranding(ctx context.Context, teamID string) error {
+	return c.do(ctx, "RemoveTeamBranding", true, func(ctx context.Context) error {
+		return c.StoreClient.RemoveTeamBranding(ctx, teamID)
+	})
+}
+
+func (c *RetryStoreClient) InsertAuditEvent(ctx context.Context, event *models.AuditEvent) error {
+	return c.do(ctx, "InsertAuditEvent", true, func(ctx context.Context) error {
+		return c.StoreClient.InsertAuditEvent(ctx, event)
+	})
+}
+
+func (c *RetryStoreClient) FindAuditEvents(ctx context.Context, filter *models.AuditFilter) (results []*models.AuditEvent, err error) {
+	err = c.do(ctx, "FindAuditEvents", false, func(ctx context.Context) (err error) {
+		results, err = c.StoreClient.FindAuditEvents(ctx, filter)
+		return err
+	})
+	return results, err
+}
+
+func (c *RetryStoreClient) PseudonymizeUserAuditEvents(ctx context.Context, userID string, emailHashes []string, pseudonym *models.Pseudonym) (changed int64, err error) {
+	err = c.do(ctx, "PseudonymizeUserAuditEvents", true, func(ctx context.Context) (err error) {
+		changed, err = c.StoreClient.PseudonymizeUserAuditEvents(ctx, userID, emailHashes, pseudonym)
+		return err
+	})
+	return changed, err
+}
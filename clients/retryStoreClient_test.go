@@ -0,0 +1,135 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mdblp/hydrophone/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// flakyStore fails its first calls with err
+type flakyStore struct {
+	*MockStoreClient
+	err      error
+	failures int
+	calls    int
+}
+
+func (s *flakyStore) fail(ctx context.Context) error {
+	s.calls++
+	if s.calls > s.failures {
+		return nil
+	}
+	if s.err == context.DeadlineExceeded {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return s.err
+}
+
+func (s *flakyStore) FindConfirmation(ctx context.Context, confirmation *models.Confirmation) (*models.Confirmation, error) {
+	if err := s.fail(ctx); err != nil {
+		return nil, err
+	}
+	return s.MockStoreClient.FindConfirmation(ctx, confirmation)
+}
+
+func (s *flakyStore) UpsertConfirmation(ctx context.Context, confirmation *models.Confirmation) error {
+	if err := s.fail(ctx); err != nil {
+		return err
+	}
+	return s.MockStoreClient.UpsertConfirmation(ctx, confirmation)
+}
+
+func TestRetryStore(t *testing.T) {
+	networkErr := mongo.CommandError{Code: 9001, Message: "connection reset", Labels: []string{"NetworkError"}}
+	notPrimaryErr := mongo.CommandError{Code: 10107, Message: "not master"}
+	tests := []struct {
+		desc     string
+		err      error
+		failures int
+		write    bool
+		calls    int
+		kind     error
+	}{
+		{desc: "a read is retried on a network error", err: networkErr, failures: 1, calls: 2},
+		{desc: "a read fails once the retries are exhausted", err: networkErr, failures: 5, calls: 3, kind: ErrUnavailable},
+		{desc: "a read is retried when the attempt times out", err: context.DeadlineExceeded, failures: 1, calls: 2},
+		{desc: "a write is not retried on a network error, it may have been applied", err: networkErr, failures: 1, write: true, calls: 1, kind: ErrUnavailable},
+		{desc: "a write refused by a secondary is retried", err: notPrimaryErr, failures: 1, write: true, calls: 2},
+		{desc: "other errors are not retried nor classified", err: errors.New("bad query"), failures: 1, calls: 1},
+	}
+
+	for idx, test := range tests {
+		flaky := &flakyStore{MockStoreClient: NewMockStoreClient(false, false), err: test.err, failures: test.failures}
+		store := NewRetryStore(flaky, RetryConfig{Timeout: 20 * time.Millisecond, Backoff: time.Millisecond})
+		var err error
+		if test.write {
+			err = store.UpsertConfirmation(context.Background(), &models.Confirmation{Key: "key", Email: "test@test.com"})
+		} else {
+			_, err = store.FindConfirmation(context.Background(), &models.Confirmation{Key: "key"})
+		}
+		if flaky.calls != test.calls {
+			t.Fatalf("TestId `%d` `%s` expected %d calls, got %d - err [%v]", idx, test.desc, test.calls, flaky.calls, err)
+		}
+		wantErr := test.calls <= test.failures
+		if wantErr != (err != nil) {
+			t.Fatalf("TestId `%d` `%s` unexpected error [%v]", idx, test.desc, err)
+		}
+		if test.kind != nil && !errors.Is(err, test.kind) {
+			t.Fatalf("TestId `%d` `%s` the error should be classified as %v [%v]", idx, test.desc, test.kind, err)
+		}
+		if err != nil && !reflect.DeepEqual(errors.Unwrap(err), test.err) {
+			t.Fatalf("TestId `%d` `%s` the error should wrap the store error [%v]", idx, test.desc, err)
+		}
+	}
+}
+
+func TestRetryStoreClassifiesErrors(t *testing.T) {
+	store := NewRetryStore(NewMockStoreClient(false, false), RetryConfig{Retries: -1})
+	ctx := context.Background()
+
+	_, err := store.TransitionStatus(ctx, "medicalteam.invite.conflict", 0, models.StatusChange{From: models.StatusPending, To: models.StatusCompleted}, nil)
+	var conflict *StatusConflictError
+	if !errors.Is(err, ErrConflict) || !errors.As(err, &conflict) || conflict.Actual != models.StatusCompleted {
+		t.Fatalf("a status conflict should be classified as a conflict [%v]", err)
+	}
+	if err := store.UpsertConfirmation(ctx, &models.Confirmation{Email: "duplicate.invite@email.org"}); !errors.Is(err, ErrConflict) || !errors.Is(err, ErrDuplicateInvite) {
+		t.Fatalf("a duplicate invite should be classified as a conflict [%v]", err)
+	}
+	if _, err := store.RollbackTemplateVersion(ctx, models.TemplateNameSignup); !errors.Is(err, ErrNotFound) || !errors.Is(err, ErrNoActiveTemplateVersion) {
+		t.Fatalf("a missing template version should be classified as not found [%v]", err)
+	}
+}
+
+func TestRetryStoreClassifiesServerSelectionErrors(t *testing.T) {
+	// nothing listens on the port, the driver fails to select a server
+	opts := options.Client().ApplyURI("mongodb://127.0.0.1:1").SetServerSelectionTimeout(50 * time.Millisecond)
+	client, err := mongo.Connect(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("we could not create the client - err [%v]", err)
+	}
+	defer client.Disconnect(context.Background())
+	selectionErr := client.Database("confirm_test").Collection(confirmationsCollection).FindOne(context.Background(), bson.M{}).Err()
+	if selectionErr == nil {
+		t.Fatalf("the server selection should have failed")
+	}
+
+	if !isRejectedError(selectionErr) {
+		t.Fatalf("a server selection error should be tried again, even for a write [%v]", selectionErr)
+	}
+	if err := classify("FindConfirmation", selectionErr); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("a server selection error should be classified as unavailable [%v]", err)
+	}
+	flaky := &flakyStore{MockStoreClient: NewMockStoreClient(false, false), err: selectionErr, failures: 1}
+	store := NewRetryStore(flaky, RetryConfig{Backoff: time.Millisecond})
+	if err := store.UpsertConfirmation(context.Background(), &models.Confirmation{Key: "key", Email: "test@test.com"}); err != nil || flaky.calls != 2 {
+		t.Fatalf("a write not sent for lack of server should be retried, got %d calls - err [%v]", flaky.calls, err)
+	}
+}
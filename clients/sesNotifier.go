@@ -1,6 +1,7 @@
 package clients
 
 import (
+	"fmt"
 	"log"
 	"net/http"
 
@@ -74,10 +75,10 @@ func NewSesNotifier(cfg *SesNotifierConfig) (*SesNotifier, error) {
 	}, nil
 }
 
-// Send a message to a list of recipients with a given subject
-func (c *SesNotifier) Send(to []string, subject string, msg string) (int, string) {
-	var toAwsAddress = make([]*string, len(to))
-	for i, x := range to {
+// Send a message to its recipients
+func (c *SesNotifier) Send(message *Message) (int, string) {
+	var toAwsAddress = make([]*string, len(message.To))
+	for i, x := range message.To {
 		toAwsAddress[i] = aws.String(x)
 	}
 
@@ -90,7 +91,7 @@ func (c *SesNotifier) Send(to []string, subject string, msg string) (int, string
 			Body: &ses.Body{
 				Html: &ses.Content{
 					Charset: aws.String(CharSet),
-					Data:    aws.String(msg),
+					Data:    aws.String(message.Body),
 				},
 				Text: &ses.Content{
 					Charset: aws.String(CharSet),
@@ -99,14 +100,24 @@ func (c *SesNotifier) Send(to []string, subject string, msg string) (int, string
 			},
 			Subject: &ses.Content{
 				Charset: aws.String(CharSet),
-				Data:    aws.String(subject),
+				Data:    aws.String(message.Subject),
 			},
 		},
-		Source: aws.String(c.Config.From),
+		Source: aws.String(message.senderOf(c.Config.From)),
+	}
+	if message.ReplyTo != "" {
+		input.ReplyToAddresses = aws.StringSlice([]string{message.ReplyTo})
 	}
 
 	// Attempt to send the email.
-	result, err := c.SES.SendEmail(input)
+	var result fmt.Stringer
+	var err error
+	if len(message.Images) > 0 {
+		// the inline images are only supported by the raw emails
+		result, err = c.sendRaw(message)
+	} else {
+		result, err = c.SES.SendEmail(input)
+	}
 
 	// Return error messages if they occur. They are traced in the caller function
 	if err != nil {
@@ -116,6 +127,18 @@ func (c *SesNotifier) Send(to []string, subject string, msg string) (int, string
 			return http.StatusInternalServerError, err.Error()
 		}
 	}
-	log.Printf("SES email sent: %s\n", subject)
+	log.Printf("SES email sent: %s\n", message.Subject)
 	return http.StatusOK, result.String()
 }
+
+// sendRaw sends the message in the MIME format built by hydrophone
+func (c *SesNotifier) sendRaw(message *Message) (*ses.SendRawEmailOutput, error) {
+	data, err := message.mimeBytes(message.senderOf(c.Config.From))
+	if err != nil {
+		return nil, err
+	}
+	return c.SES.SendRawEmail(&ses.SendRawEmailInput{
+		Destinations: aws.StringSlice(message.To),
+		RawMessage:   &ses.RawMessage{Data: data},
+	})
+}
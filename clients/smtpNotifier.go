@@ -2,6 +2,7 @@ package clients
 
 import (
 	"log"
+	"net/mail"
 	"net/smtp"
 )
 
@@ -29,24 +30,29 @@ func NewSmtpNotifier(cfg *SmtpNotifierConfig) (*SmtpNotifier, error) {
 	}, nil
 }
 
-// Send a message to a list of recipients with a given subject
-func (c *SmtpNotifier) Send(to []string, subject string, msg string) (int, string) {
+// Send a message to its recipients
+func (c *SmtpNotifier) Send(message *Message) (int, string) {
 	// Set up authentication information.
 	var auth smtp.Auth
 	// If no user is provided, then do not try to authenticate to the server (for dev only)
 	if c.Config.User != "" {
 		auth = smtp.PlainAuth("", c.Config.User, c.Config.Password, c.Config.Server)
 	}
-	mime := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
-	body := []byte("To: " + to[0] + "\r\n" +
-		"Subject: " + subject + "\r\n" +
-		mime + "\r\n" +
-		msg + "\r\n")
-	err := smtp.SendMail(c.Config.Server+":"+c.Config.Port, auth, c.Config.From, to, body)
+	from := message.senderOf(c.Config.From)
+	// the envelope sender is the bare address, the display name is only given in the header
+	sender := from
+	if address, err := mail.ParseAddress(from); err == nil {
+		sender = address.Address
+	}
+	body, err := message.mimeBytes(from)
 	if err != nil {
 		log.Println(err.Error())
 		return 400, err.Error()
 	}
-	log.Printf("SMTP email sent: %s\n", subject)
+	if err := smtp.SendMail(c.Config.Server+":"+c.Config.Port, auth, sender, message.To, body); err != nil {
+		log.Println(err.Error())
+		return 400, err.Error()
+	}
+	log.Printf("SMTP email sent: %s\n", message.Subject)
 	return 200, "OK"
 }
@@ -2,15 +2,90 @@ package clients
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"github.com/mdblp/hydrophone/models"
 	goComMgo "github.com/tidepool-org/go-common/clients/mongo"
 )
 
+var (
+	// ErrTemplateVersionNotFound is returned when activating a template version which does not exist
+	ErrTemplateVersionNotFound = errors.New("clients: template version not found")
+	// ErrNoActiveTemplateVersion is returned when rolling back a template which uses its disk baseline
+	ErrNoActiveTemplateVersion = errors.New("clients: no active template version")
+	// ErrTemplateVersionChanged is returned when rolling back a template whose active version changed meanwhile
+	ErrTemplateVersionChanged = errors.New("clients: the active template version changed meanwhile")
+	// ErrDuplicateInvite is returned when saving an invite while another one is pending for the same email and inviter
+	ErrDuplicateInvite = errors.New("clients: a pending invite already exists")
+
+	// Categories of the StoreError, matched with errors.Is
+	ErrNotFound    = errors.New("clients: not found")
+	ErrConflict    = errors.New("clients: conflict")
+	ErrUnavailable = errors.New("clients: store unavailable")
+)
+
+// StoreError is a failed store operation, classified in the category Kind so the handlers can answer accordingly
+// Kind is ErrNotFound, ErrConflict, ErrUnavailable or nil for the other errors, Err is the error of the operation
+type StoreError struct {
+	Op   string
+	Kind error
+	Err  error
+}
+
+func (e *StoreError) Error() string {
+	return fmt.Sprintf("clients: %s failed: %s", e.Op, e.Err)
+}
+
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the category of the error, errors.Is(err, ErrUnavailable) tells the store can't be reached
+func (e *StoreError) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+// StatusConflictError is returned by TransitionStatus when the confirmation does not have the expected status or revision anymore
+// Actual is the status found instead, empty when the confirmation does not exist
+type StatusConflictError struct {
+	Key              string
+	Expected         models.Status
+	Actual           models.Status
+	ExpectedRevision int
+	ActualRevision   int
+}
+
+func (e *StatusConflictError) Error() string {
+	if e.Actual == "" {
+		return fmt.Sprintf("clients: confirmation %s not found", e.Key)
+	}
+	if e.Actual == e.Expected {
+		return fmt.Sprintf("clients: confirmation %s is at revision %d instead of %d", e.Key, e.ActualRevision, e.ExpectedRevision)
+	}
+	return fmt.Sprintf("clients: confirmation %s is %s instead of %s", e.Key, e.Actual, e.Expected)
+}
+
 type StoreClient interface {
 	goComMgo.Storage
 	UpsertConfirmation(ctx context.Context, confirmation *models.Confirmation) error
 	FindConfirmations(ctx context.Context, confirmation *models.Confirmation, statuses []models.Status, types []models.Type) (results []*models.Confirmation, err error)
 	FindConfirmation(ctx context.Context, confirmation *models.Confirmation) (result *models.Confirmation, err error)
+	FindUserConfirmations(ctx context.Context, userID string, emails []string) ([]*models.Confirmation, error)
+	PseudonymizeUserConfirmations(ctx context.Context, userID string, emails []string, pseudonym *models.Pseudonym) (int64, error)
 	RemoveConfirmation(ctx context.Context, confirmation *models.Confirmation) error
+	TransitionStatus(ctx context.Context, key string, revision int, change models.StatusChange, patch map[string]interface{}) (*models.Confirmation, error)
+	SetConfirmationTemplateVersion(ctx context.Context, key string, version int) error
+	InsertTemplateVersion(ctx context.Context, version *models.TemplateVersion) error
+	FindTemplateVersions(ctx context.Context, name models.TemplateName) ([]*models.TemplateVersion, error)
+	FindTemplateVersion(ctx context.Context, name models.TemplateName, version int) (*models.TemplateVersion, error)
+	FindActiveTemplateVersion(ctx context.Context, name models.TemplateName) (*models.TemplateVersion, error)
+	ActivateTemplateVersion(ctx context.Context, name models.TemplateName, version int) error
+	RollbackTemplateVersion(ctx context.Context, name models.TemplateName) (*models.TemplateVersion, error)
+	FindTeamBranding(ctx context.Context, teamID string) (*models.TeamBranding, error)
+	UpsertTeamBranding(ctx context.Context, branding *models.TeamBranding) error
+	RemoveTeamBranding(ctx context.Context, teamID string) error
+	InsertAuditEvent(ctx context.Context, event *models.AuditEvent) error
+	FindAuditEvents(ctx context.Context, filter *models.AuditFilter) ([]*models.AuditEvent, error)
+	PseudonymizeUserAuditEvents(ctx context.Context, userID string, emailHashes []string, pseudonym *models.Pseudonym) (int64, error)
 }
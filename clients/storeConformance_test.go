@@ -0,0 +1,408 @@
+package clients
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mdblp/hydrophone/models"
+)
+
+// testStoreConformance checks a StoreClient has the lookup semantics the handlers rely on
+// newStore returns an empty store, it is called for each case
+func testStoreConformance(t *testing.T, newStore func(t *testing.T) StoreClient) {
+	ctx := context.Background()
+	newInvite := func(creator, email string, created time.Time) *models.Confirmation {
+		invite, _ := models.NewConfirmation(models.TypeCareteamInvite, models.TemplateNameCareteamInvite, creator)
+		invite.Email = email
+		invite.Created = created
+		return invite
+	}
+
+	t.Run("confirmations", func(t *testing.T) {
+		store := newStore(t)
+		now := time.Now().Truncate(time.Millisecond)
+		declined := newInvite("999.111", "Some@Email.org", now.Add(-time.Minute))
+		declined.UserId = "312.123"
+		declined.UpdateStatus(models.StatusDeclined)
+		completed := newInvite("999.111", "some@other.org", now)
+		completed.UpdateStatus(models.StatusCompleted)
+		reset, _ := models.NewConfirmation(models.TypePasswordReset, models.TemplateNamePasswordReset, "")
+		reset.Email = "some@email.org"
+		reset.Created = now.Add(time.Minute)
+		for _, confirmation := range []*models.Confirmation{declined, completed, reset} {
+			if err := store.UpsertConfirmation(ctx, confirmation); err != nil {
+				t.Fatalf("we could not save the confirmation - err [%v]", err)
+			}
+		}
+
+		if found, err := store.FindConfirmation(ctx, &models.Confirmation{Email: "SOME@email.ORG"}); err != nil || found == nil || found.Key != reset.Key {
+			t.Fatalf("the latest confirmation should be found by its canonical email [%v] - err [%v]", found, err)
+		}
+		if found, err := store.FindConfirmation(ctx, &models.Confirmation{Email: "some@email.org", Type: models.TypeCareteamInvite}); err != nil || found == nil || found.Key != declined.Key {
+			t.Fatalf("the invite should be found by its type [%v] - err [%v]", found, err)
+		}
+		if found, err := store.FindConfirmation(ctx, &models.Confirmation{Key: declined.Key, Status: models.StatusPending}); err != nil || found != nil {
+			t.Fatalf("the status of the filter should be matched [%v] - err [%v]", found, err)
+		}
+		if found, err := store.FindConfirmation(ctx, &models.Confirmation{Key: "key.does.not.exist"}); err != nil || found != nil {
+			t.Fatalf("there should have been no confirmation found [%v] - err [%v]", found, err)
+		}
+
+		statuses := []models.Status{models.StatusDeclined, models.StatusCompleted}
+		found, err := store.FindConfirmations(ctx, &models.Confirmation{CreatorId: "999.111"}, statuses, nil)
+		if err != nil || len(found) != 2 || found[0].Key != completed.Key || found[1].Key != declined.Key {
+			t.Fatalf("the 2 invites should be found, the newest first %v - err [%v]", found, err)
+		}
+		found, err = store.FindConfirmations(ctx, &models.Confirmation{UserId: "312.123"}, nil, []models.Type{models.TypeCareteamInvite, models.TypeMedicalTeamInvite})
+		if err != nil || len(found) != 1 || found[0].Key != declined.Key {
+			t.Fatalf("the invite should be found by its user and types %v - err [%v]", found, err)
+		}
+		found, err = store.FindConfirmations(ctx, &models.Confirmation{Email: "some@email.org"}, []models.Status{models.StatusPending}, nil)
+		if err != nil || len(found) != 1 || found[0].Key != reset.Key {
+			t.Fatalf("only the pending confirmation should be found %v - err [%v]", found, err)
+		}
+
+		if err := store.RemoveConfirmation(ctx, reset); err != nil {
+			t.Fatalf("we could not remove the confirmation - err [%v]", err)
+		}
+		if found, err := store.FindConfirmation(ctx, &models.Confirmation{Key: reset.Key}); err != nil || found != nil {
+			t.Fatalf("the confirmation has been removed so we shouldn't find it [%v] - err [%v]", found, err)
+		}
+	})
+
+	t.Run("teams", func(t *testing.T) {
+		store := newStore(t)
+		invite, _ := models.NewConfirmation(models.TypeMedicalTeamInvite, models.TemplateNameMedicalteamInvite, "123.456")
+		invite.Email = "member@email.org"
+		invite.Team = &models.Team{ID: "team.1"}
+		if err := store.UpsertConfirmation(ctx, invite); err != nil {
+			t.Fatalf("we could not save the invite - err [%v]", err)
+		}
+		if err := store.SetConfirmationTemplateVersion(ctx, invite.Key, 3); err != nil {
+			t.Fatalf("we could not set the template version - err [%v]", err)
+		}
+		found, err := store.FindConfirmation(ctx, &models.Confirmation{Team: &models.Team{ID: "team.1"}})
+		if err != nil || found == nil || found.Key != invite.Key || found.Team == nil || found.Team.ID != "team.1" || found.TemplateVersion != 3 {
+			t.Fatalf("the invite should be found by its team with its template version [%v] - err [%v]", found, err)
+		}
+		if found, err := store.FindConfirmation(ctx, &models.Confirmation{Team: &models.Team{ID: "team.2"}}); err != nil || found != nil {
+			t.Fatalf("the invite of another team should not be found [%v] - err [%v]", found, err)
+		}
+		// saving the invite again keeps the fields set apart
+		invite.Role = "admin"
+		if err := store.UpsertConfirmation(ctx, invite); err != nil {
+			t.Fatalf("we could not save the invite - err [%v]", err)
+		}
+		if found, err := store.FindConfirmation(ctx, &models.Confirmation{Key: invite.Key}); err != nil || found.Role != "admin" || found.TemplateVersion != 3 || found.Revision != 2 {
+			t.Fatalf("the invite should be updated at revision 2 with its template version [%v] - err [%v]", found, err)
+		}
+	})
+
+	t.Run("user confirmations", func(t *testing.T) {
+		store := newStore(t)
+		now := time.Now().Truncate(time.Millisecond)
+		sent := newInvite("123.456", "friend@email.org", now.Add(-time.Hour))
+		received := newInvite("999.111", "Me@Email.org", now)
+		accepted := newInvite("999.222", "old@email.org", now.Add(-time.Minute))
+		accepted.UserId = "123.456"
+		other := newInvite("999.111", "someone@email.org", now)
+		for _, confirmation := range []*models.Confirmation{sent, received, accepted, other} {
+			if err := store.UpsertConfirmation(ctx, confirmation); err != nil {
+				t.Fatalf("we could not save the confirmation - err [%v]", err)
+			}
+		}
+		found, err := store.FindUserConfirmations(ctx, "123.456", []string{"me@email.ORG"})
+		if err != nil || len(found) != 3 || found[0].Key != received.Key || found[1].Key != accepted.Key || found[2].Key != sent.Key {
+			t.Fatalf("the confirmations of the user should be found, the latest first [%v] - err [%v]", found, err)
+		}
+		if found, err := store.FindUserConfirmations(ctx, "123.456", nil); err != nil || len(found) != 2 {
+			t.Fatalf("the confirmations of the user ID should be found without email [%v] - err [%v]", found, err)
+		}
+	})
+
+	t.Run("pseudonymized users", func(t *testing.T) {
+		store := newStore(t)
+		now := time.Now().Truncate(time.Millisecond)
+		sent := newInvite("123.456", "friend@email.org", now.Add(-time.Hour))
+		received := newInvite("999.111", "Me@Email.org", now)
+		received.AddContext(map[string]string{"note": "hello"})
+		declined := newInvite("999.222", "other@email.org", now.Add(-time.Minute))
+		other := newInvite("999.111", "someone@email.org", now)
+		for _, confirmation := range []*models.Confirmation{sent, received, declined, other} {
+			if err := store.UpsertConfirmation(ctx, confirmation); err != nil {
+				t.Fatalf("we could not save the confirmation - err [%v]", err)
+			}
+		}
+		change := models.StatusChange{From: models.StatusPending, To: models.StatusDeclined, Actor: "123.456"}
+		if _, err := store.TransitionStatus(ctx, declined.Key, 1, change, nil); err != nil {
+			t.Fatalf("we could not decline the invite - err [%v]", err)
+		}
+
+		pseudonym := &models.Pseudonym{UserID: "erased-1", Email: "erased-1@erased.invalid"}
+		if changed, err := store.PseudonymizeUserConfirmations(ctx, "123.456", []string{"me@email.org"}, pseudonym); err != nil || changed != 3 {
+			t.Fatalf("the confirmations of the user should be pseudonymized, got %d - err [%v]", changed, err)
+		}
+		if found, err := store.FindUserConfirmations(ctx, "123.456", []string{"me@email.org"}); err != nil || len(found) != 0 {
+			t.Fatalf("the user should not be found anymore [%v] - err [%v]", found, err)
+		}
+		found, err := store.FindUserConfirmations(ctx, pseudonym.UserID, []string{pseudonym.Email})
+		if err != nil || len(found) != 2 || found[0].Key != received.Key || found[0].Context != nil || found[1].Key != sent.Key || found[1].Email != "friend@email.org" {
+			t.Fatalf("the confirmations should hold the pseudonym, the email of the friend being kept [%v] - err [%v]", found, err)
+		}
+		if found, err := store.FindConfirmation(ctx, &models.Confirmation{Key: declined.Key}); err != nil || found.History[0].Actor != pseudonym.UserID || found.Status != models.StatusDeclined {
+			t.Fatalf("the history should hold the pseudonym [%v] - err [%v]", found, err)
+		}
+		if found, err := store.FindConfirmation(ctx, &models.Confirmation{Key: other.Key}); err != nil || found.Email != "someone@email.org" || found.CreatorId != "999.111" {
+			t.Fatalf("the confirmations of the other users should be kept [%v] - err [%v]", found, err)
+		}
+		// erasing the user again is a no-op
+		if changed, err := store.PseudonymizeUserConfirmations(ctx, "123.456", []string{"me@email.org"}, pseudonym); err != nil || changed != 0 {
+			t.Fatalf("the confirmations should only be pseudonymized once, got %d - err [%v]", changed, err)
+		}
+	})
+
+	t.Run("transitions", func(t *testing.T) {
+		store := newStore(t)
+		invite := newInvite("123.456", "test@test.com", time.Now())
+		if err := store.UpsertConfirmation(ctx, invite); err != nil {
+			t.Fatalf("we could not save the invite - err [%v]", err)
+		}
+		updated, err := store.TransitionStatus(ctx, invite.Key, 1, models.StatusChange{From: models.StatusPending, To: models.StatusCompleted, Actor: "789"}, map[string]interface{}{"userId": "789"})
+		if err != nil || updated.Status != models.StatusCompleted || updated.UserId != "789" || updated.Revision != 2 {
+			t.Fatalf("the invite should be completed at revision 2 [%v] - err [%v]", updated, err)
+		}
+		if len(updated.History) != 1 || updated.History[0].Actor != "789" || updated.History[0].Time.IsZero() {
+			t.Fatalf("the transition should be recorded in the history [%v]", updated.History)
+		}
+		updated.History = nil
+		if err := store.UpsertConfirmation(ctx, updated); err != nil {
+			t.Fatalf("we could not save the invite - err [%v]", err)
+		}
+		if found, err := store.FindConfirmation(ctx, &models.Confirmation{Key: invite.Key}); err != nil || len(found.History) != 1 {
+			t.Fatalf("the history should be kept when saving the invite [%v] - err [%v]", found, err)
+		}
+
+		_, err = store.TransitionStatus(ctx, invite.Key, 1, models.StatusChange{From: models.StatusPending, To: models.StatusDeclined}, nil)
+		if conflict, ok := err.(*StatusConflictError); !ok || conflict.Actual != models.StatusCompleted {
+			t.Fatalf("the second transition should conflict with the completed status - err [%v]", err)
+		}
+		_, err = store.TransitionStatus(ctx, "key.does.not.exist", 0, models.StatusChange{From: models.StatusPending, To: models.StatusDeclined}, nil)
+		if conflict, ok := err.(*StatusConflictError); !ok || conflict.Actual != "" {
+			t.Fatalf("the transition of a missing confirmation should conflict - err [%v]", err)
+		}
+	})
+
+	t.Run("stale transitions", func(t *testing.T) {
+		store := newStore(t)
+		invite := newInvite("123.456", "stale@test.com", time.Now())
+		if err := store.UpsertConfirmation(ctx, invite); err != nil {
+			t.Fatalf("we could not save the invite - err [%v]", err)
+		}
+		stale, err := store.FindConfirmation(ctx, &models.Confirmation{Key: invite.Key})
+		if err != nil || stale == nil || stale.Revision != 1 {
+			t.Fatalf("the invite should be found at revision 1 [%v] - err [%v]", stale, err)
+		}
+		// canceled then reverted by other requests, the invite is pending again
+		canceled, err := store.TransitionStatus(ctx, invite.Key, stale.Revision, models.StatusChange{From: models.StatusPending, To: models.StatusCanceled}, nil)
+		if err != nil {
+			t.Fatalf("we could not cancel the invite - err [%v]", err)
+		}
+		if _, err := store.TransitionStatus(ctx, invite.Key, canceled.Revision, models.StatusChange{From: models.StatusCanceled, To: models.StatusPending}, nil); err != nil {
+			t.Fatalf("we could not revert the invite - err [%v]", err)
+		}
+		_, err = store.TransitionStatus(ctx, invite.Key, stale.Revision, models.StatusChange{From: stale.Status, To: models.StatusCompleted}, nil)
+		if conflict, ok := err.(*StatusConflictError); !ok || conflict.Actual != models.StatusPending || conflict.ActualRevision != 3 || conflict.ExpectedRevision != 1 {
+			t.Fatalf("the transition of the invite read before its changes should conflict although it is pending - err [%v]", err)
+		}
+		if found, err := store.FindConfirmation(ctx, &models.Confirmation{Key: invite.Key}); err != nil || found.Status != models.StatusPending || found.Revision != 3 {
+			t.Fatalf("the stale transition should not change the invite [%v] - err [%v]", found, err)
+		}
+	})
+
+	t.Run("pending invites", func(t *testing.T) {
+		store := newStore(t)
+		invite := newInvite("123.456", "dup@test.com", time.Now())
+		if err := store.UpsertConfirmation(ctx, invite); err != nil {
+			t.Fatalf("we could not save the invite - err [%v]", err)
+		}
+		if err := store.UpsertConfirmation(ctx, newInvite("123.456", "DUP@test.com", time.Now())); err != ErrDuplicateInvite {
+			t.Fatalf("a second pending invite should be refused - err [%v]", err)
+		}
+		if err := store.UpsertConfirmation(ctx, newInvite("789", "dup@test.com", time.Now())); err != nil {
+			t.Fatalf("an invite from another inviter should be saved - err [%v]", err)
+		}
+		if _, err := store.TransitionStatus(ctx, invite.Key, 1, models.StatusChange{From: models.StatusPending, To: models.StatusCanceled}, nil); err != nil {
+			t.Fatalf("we could not cancel the invite - err [%v]", err)
+		}
+		if err := store.UpsertConfirmation(ctx, newInvite("123.456", "dup@test.com", time.Now())); err != nil {
+			t.Fatalf("a new invite should be saved once the previous one is canceled - err [%v]", err)
+		}
+	})
+
+	t.Run("template versions", func(t *testing.T) {
+		store := newStore(t)
+		for i := 0; i < 3; i++ {
+			version := &models.TemplateVersion{Template: models.TemplateNameSignup, Subject: "SignupSubject", Created: time.Now()}
+			if err := store.InsertTemplateVersion(ctx, version); err != nil || version.Version != i+1 {
+				t.Fatalf("the template version should be numbered %d [%v] - err [%v]", i+1, version, err)
+			}
+		}
+		if active, err := store.FindActiveTemplateVersion(ctx, models.TemplateNameSignup); err != nil || active != nil {
+			t.Fatalf("no template version should be active [%v] - err [%v]", active, err)
+		}
+		if err := store.ActivateTemplateVersion(ctx, models.TemplateNameSignup, 4); err != ErrTemplateVersionNotFound {
+			t.Fatalf("activating an unknown version should fail - err [%v]", err)
+		}
+		store.ActivateTemplateVersion(ctx, models.TemplateNameSignup, 1)
+		time.Sleep(10 * time.Millisecond)
+		store.ActivateTemplateVersion(ctx, models.TemplateNameSignup, 3)
+		if active, err := store.FindActiveTemplateVersion(ctx, models.TemplateNameSignup); err != nil || active == nil || active.Version != 3 {
+			t.Fatalf("the version 3 should be active [%v] - err [%v]", active, err)
+		}
+		if found, err := store.FindTemplateVersion(ctx, models.TemplateNameSignup, 1); err != nil || found == nil || found.Active {
+			t.Fatalf("the version 1 should be found inactive [%v] - err [%v]", found, err)
+		}
+		if target, err := store.RollbackTemplateVersion(ctx, models.TemplateNameSignup); err != nil || target == nil || target.Version != 1 {
+			t.Fatalf("rolling back should activate the version 1 [%v] - err [%v]", target, err)
+		}
+		if target, err := store.RollbackTemplateVersion(ctx, models.TemplateNameSignup); err != nil || target != nil {
+			t.Fatalf("rolling back should go back to the baseline [%v] - err [%v]", target, err)
+		}
+		if _, err := store.RollbackTemplateVersion(ctx, models.TemplateNameSignup); err != ErrNoActiveTemplateVersion {
+			t.Fatalf("there should be nothing left to roll back - err [%v]", err)
+		}
+		if versions, err := store.FindTemplateVersions(ctx, models.TemplateNameSignup); err != nil || len(versions) != 3 || versions[0].Version != 3 || !versions[0].RolledBack {
+			t.Fatalf("we should have found the 3 versions, the latest first %v - err [%v]", versions, err)
+		}
+		if found, err := store.FindTemplateVersion(ctx, models.TemplateNamePasswordReset, 1); err != nil || found != nil {
+			t.Fatalf("the version of another template should not be found [%v] - err [%v]", found, err)
+		}
+	})
+
+	t.Run("concurrent template versions", func(t *testing.T) {
+		store := newStore(t)
+		const uploads = 8
+		numbers := make(chan int, uploads)
+		var wg sync.WaitGroup
+		for i := 0; i < uploads; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				version := &models.TemplateVersion{Template: models.TemplateNameSignup, Subject: "SignupSubject", Created: time.Now()}
+				if err := store.InsertTemplateVersion(ctx, version); err != nil {
+					t.Errorf("the concurrent upload should be stored - err [%v]", err)
+				}
+				numbers <- version.Version
+			}()
+		}
+		wg.Wait()
+		close(numbers)
+		seen := map[int]bool{}
+		for number := range numbers {
+			if seen[number] {
+				t.Fatalf("two uploads got the version %d", number)
+			}
+			seen[number] = true
+		}
+
+		for i := 1; i <= uploads; i++ {
+			wg.Add(1)
+			go func(version int) {
+				defer wg.Done()
+				store.ActivateTemplateVersion(ctx, models.TemplateNameSignup, version)
+			}(i)
+		}
+		wg.Wait()
+		versions, err := store.FindTemplateVersions(ctx, models.TemplateNameSignup)
+		active := 0
+		for _, v := range versions {
+			if v.Active {
+				active++
+			}
+		}
+		if err != nil || len(versions) != uploads || active != 1 {
+			t.Fatalf("a single version should be active after concurrent activations, got %d of %d - err [%v]", active, len(versions), err)
+		}
+	})
+
+	t.Run("team brandings", func(t *testing.T) {
+		store := newStore(t)
+		branding := &models.TeamBranding{TeamID: "team.1", Signature: "Dr. Who", Logo: []byte{1, 2, 3}, LogoType: "image/png"}
+		if err := store.UpsertTeamBranding(ctx, branding); err != nil {
+			t.Fatalf("we could not save the branding - err [%v]", err)
+		}
+		branding.Signature = "Dr. Watson"
+		if err := store.UpsertTeamBranding(ctx, branding); err != nil {
+			t.Fatalf("we could not update the branding - err [%v]", err)
+		}
+		if found, err := store.FindTeamBranding(ctx, "team.1"); err != nil || found == nil || found.Signature != "Dr. Watson" || len(found.Logo) != 3 {
+			t.Fatalf("the updated branding should be found [%v] - err [%v]", found, err)
+		}
+		if err := store.RemoveTeamBranding(ctx, "team.1"); err != nil {
+			t.Fatalf("we could not remove the branding - err [%v]", err)
+		}
+		if found, err := store.FindTeamBranding(ctx, "team.1"); err != nil || found != nil {
+			t.Fatalf("the branding has been removed so we shouldn't find it [%v] - err [%v]", found, err)
+		}
+	})
+
+	t.Run("audit events", func(t *testing.T) {
+		store := newStore(t)
+		now := time.Now().Truncate(time.Millisecond)
+		events := []*models.AuditEvent{
+			{Time: now.Add(-time.Hour), Actor: "123", Action: models.AuditInviteCreated, Outcome: models.AuditSuccess, TargetTeam: "team1"},
+			{Time: now, Actor: "123", Action: models.AuditInviteSent, Outcome: models.AuditFailure, TargetTeam: "team1"},
+			{Time: now.Add(-time.Minute), Actor: models.ActorServer, Action: models.AuditSignupSent, Outcome: models.AuditSuccess},
+		}
+		for _, event := range events {
+			if err := store.InsertAuditEvent(ctx, event); err != nil {
+				t.Fatalf("we could not save the audit event - err [%v]", err)
+			}
+		}
+		found, err := store.FindAuditEvents(ctx, &models.AuditFilter{Actor: "123", TargetTeam: "team1", Limit: 10})
+		if err != nil || len(found) != 2 || found[0].Action != models.AuditInviteSent || !found[0].Time.Equal(now) {
+			t.Fatalf("the events of the team should be found, the latest first [%v] - err [%v]", found, err)
+		}
+		found, err = store.FindAuditEvents(ctx, &models.AuditFilter{From: now.Add(-time.Hour), To: now, Limit: 1})
+		if err != nil || len(found) != 1 || found[0].Action != models.AuditSignupSent {
+			t.Fatalf("the events should be limited to the period [%v] - err [%v]", found, err)
+		}
+	})
+	t.Run("pseudonymized audit events", func(t *testing.T) {
+		store := newStore(t)
+		now := time.Now().Truncate(time.Millisecond)
+		pseudonym := &models.Pseudonym{UserID: "erased-1", Email: "erased-1@erased.invalid", EmailHash: "hash-erased"}
+		events := []*models.AuditEvent{
+			{Time: now.Add(-time.Hour), Actor: "123.456", Action: models.AuditInviteSent, Outcome: models.AuditSuccess, TargetTeam: "team1", TargetEmailHash: "hash-friend"},
+			{Time: now.Add(-time.Minute), Actor: models.ActorServer, Action: models.AuditSignupSent, Outcome: models.AuditSuccess, TargetUser: "123.456", TargetEmailHash: "hash-me"},
+			{Time: now, Actor: "999.111", Action: models.AuditInviteSent, Outcome: models.AuditSuccess, TargetEmailHash: "hash-me"},
+			{Time: now, Actor: "999.111", Action: models.AuditInviteAccepted, Outcome: models.AuditSuccess, TargetUser: "999.111"},
+		}
+		for _, event := range events {
+			if err := store.InsertAuditEvent(ctx, event); err != nil {
+				t.Fatalf("we could not save the audit event - err [%v]", err)
+			}
+		}
+		if changed, err := store.PseudonymizeUserAuditEvents(ctx, "123.456", []string{"hash-me"}, pseudonym); err != nil || changed != 3 {
+			t.Fatalf("the 3 events involving the user should be pseudonymized, got %d - err [%v]", changed, err)
+		}
+		for _, filter := range []*models.AuditFilter{{Actor: "123.456"}, {TargetUser: "123.456"}, {TargetEmailHash: "hash-me"}} {
+			if found, err := store.FindAuditEvents(ctx, filter); err != nil || len(found) != 0 {
+				t.Fatalf("no event should hold the user identifiers [%v] - err [%v]", found, err)
+			}
+		}
+		found, err := store.FindAuditEvents(ctx, &models.AuditFilter{TargetEmailHash: pseudonym.EmailHash})
+		if err != nil || len(found) != 2 || found[0].Actor != "999.111" || found[1].TargetUser != pseudonym.UserID {
+			t.Fatalf("the events should be found by the pseudonym [%v] - err [%v]", found, err)
+		}
+		found, err = store.FindAuditEvents(ctx, &models.AuditFilter{Actor: pseudonym.UserID})
+		if err != nil || len(found) != 1 || found[0].TargetEmailHash != "hash-friend" || found[0].TargetTeam != "team1" {
+			t.Fatalf("the email of the user invited and the team should be kept [%v] - err [%v]", found, err)
+		}
+		if changed, err := store.PseudonymizeUserAuditEvents(ctx, "123.456", []string{"hash-me"}, pseudonym); err != nil || changed != 0 {
+			t.Fatalf("pseudonymizing again should change nothing, got %d - err [%v]", changed, err)
+		}
+	})
+}
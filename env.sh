@@ -8,6 +8,7 @@ export SERVICE_NAME="hydrophone-local"
 export SEAGULL_HOST="http://localhost:9120"
 export SHORELINE_HOST="http://localhost:9107"
 export SERVER_SECRET="This needs to be the same secret everywhere. YaHut75NsK1f9UKUXuWqxNN0RUwHFBCy"
+export AUDIT_EMAIL_KEY="This needs to be kept secret and never changed. 3QmC9hV0sYpLw7RkZt2EfJ8uXo5NbGdA"
 export SHORELINE_TOKEN_REFRESH_INTERVAL="1h"
 export SHORELINE_TOKEN_GET_INTERVAL="5m"
 # Use this below to override local AWS credentials. Otherwise local credentials will be used so the user/profile needs to have rights for sending emails
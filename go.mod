@@ -5,6 +5,7 @@ go 1.15
 replace github.com/tidepool-org/go-common => github.com/mdblp/go-common v0.7.2-0.20210323141933-6b225f5dacf1
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/alecthomas/template v0.0.0-20190718012654-fb15b899a751
 	github.com/aws/aws-sdk-go v1.34.24
 	github.com/gorilla/mux v1.8.0
@@ -14,6 +15,7 @@ require (
 	github.com/nicksnyder/go-i18n/v2 v2.0.3
 	github.com/swaggo/swag v1.7.0
 	github.com/tidepool-org/go-common v0.0.0-00010101000000-000000000000
+	go.etcd.io/bbolt v1.3.5
 	go.mongodb.org/mongo-driver v1.4.1
 	golang.org/x/net v0.0.0-20210119194325-5f4716e94777
 	golang.org/x/text v0.3.5
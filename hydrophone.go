@@ -23,6 +23,7 @@ import (
 	"path"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/tidepool-org/go-common/clients/portal"
 
@@ -34,6 +35,7 @@ import (
 	"github.com/mdblp/hydrophone/api"
 	sc "github.com/mdblp/hydrophone/clients"
 	"github.com/mdblp/hydrophone/localize"
+	"github.com/mdblp/hydrophone/models"
 	"github.com/mdblp/hydrophone/templates"
 	"github.com/mdblp/shoreline/clients/shoreline"
 	common "github.com/tidepool-org/go-common"
@@ -53,6 +55,20 @@ type (
 		Ses          sc.SesNotifierConfig  `json:"sesEmail"`
 		Smtp         sc.SmtpNotifierConfig `json:"smtpEmail"`
 		NotifierType string                `json:"notifierType"`
+		// AuditRetentionDays is how long the audit events are kept, a year when not set
+		AuditRetentionDays int `json:"auditRetentionDays"`
+		// StoreType is mongo by default, or bolt to keep the documents in the local BoltPath file
+		StoreType string `json:"storeType"`
+		BoltPath  string `json:"boltPath"`
+		// StoreTimeoutSeconds bounds each attempt of a store operation, 10 seconds when not set
+		StoreTimeoutSeconds int `json:"storeTimeoutSeconds"`
+		// StoreRetries is the number of times a transient store failure is tried again, 2 when not set and -1 to never retry
+		StoreRetries int `json:"storeRetries"`
+		// StoreBackoffMs is the delay before the first retry, doubled for each one, 100 milliseconds when not set
+		StoreBackoffMs int `json:"storeBackoffMs"`
+		// MigrationMode is empty to migrate the mongo documents at start, skip to leave them to an instance run with only
+		// which migrates them and exits
+		MigrationMode string `json:"migrationMode"`
 	}
 )
 
@@ -82,6 +98,23 @@ func main() {
 	}
 
 	config.Mongo.FromEnv()
+	auditRetention := sc.DefaultAuditRetention
+	if config.AuditRetentionDays > 0 {
+		auditRetention = time.Duration(config.AuditRetentionDays) * 24 * time.Hour
+	}
+
+	if config.MigrationMode == "only" {
+		mongoStore, err := sc.NewStore(&config.Mongo, logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		mongoStore.AuditRetention = auditRetention
+		if err := mongoStore.RunMigrations(); err != nil {
+			logger.Fatal(err)
+		}
+		logger.Print("Migrations done")
+		return
+	}
 
 	// server secret may be passed via a separate env variable to accomodate easy secrets injection via Kubernetes
 	serverSecret, found := os.LookupEnv("SERVER_SECRET")
@@ -89,6 +122,13 @@ func main() {
 		config.ShorelineConfig.Secret = serverSecret
 		config.Api.ServerSecret = serverSecret
 	}
+	// the key of the hashes of the emails in the audit log is a secret too, passed the same way
+	if auditEmailKey, found := os.LookupEnv("AUDIT_EMAIL_KEY"); found {
+		config.Api.AuditEmailKey = auditEmailKey
+	}
+	if config.Api.AuditEmailKey == "" {
+		logger.Fatal("The auditEmailKey configuration or the AUDIT_EMAIL_KEY variable is required to hash the emails of the audit log")
+	}
 
 	protocol, found := os.LookupEnv("PROTOCOL")
 	if found {
@@ -139,11 +179,31 @@ func main() {
 	/*
 	* hydrophone setup
 	 */
-	store, err := sc.NewStore(&config.Mongo, logger)
-	/* Check that database configuration is valid. It does not check database availability */
-	if err != nil {
-		logger.Fatal(err)
+	var store sc.StoreClient
+	switch config.StoreType {
+	case "bolt":
+		boltStore, err := sc.NewBoltStore(config.BoltPath)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		boltStore.AuditRetention = auditRetention
+		store = boltStore
+		logger.Printf("Using the bolt store %s", config.BoltPath)
+	default:
+		mongoStore, err := sc.NewStore(&config.Mongo, logger)
+		/* Check that database configuration is valid. It does not check database availability */
+		if err != nil {
+			logger.Fatal(err)
+		}
+		mongoStore.AuditRetention = auditRetention
+		mongoStore.SkipMigrations = config.MigrationMode == "skip"
+		store = mongoStore
 	}
+	store = sc.NewRetryStore(store, sc.RetryConfig{
+		Timeout: time.Duration(config.StoreTimeoutSeconds) * time.Second,
+		Retries: config.StoreRetries,
+		Backoff: time.Duration(config.StoreBackoffMs) * time.Millisecond,
+	})
 	defer store.Close()
 	store.Start()
 	// Create a notifier based on configuration
@@ -181,9 +241,37 @@ func main() {
 	if err != nil {
 		logger.Fatal(err)
 	}
+	// Make sure the content sent by the handlers matches the variables declared by the templates
+	if err := api.CheckTemplatesContent(emailTemplates); err != nil {
+		logger.Fatal(err)
+	}
+
+	// Brands with their own template set have them loaded and checked as the default ones
+	if err := config.Api.CheckBrands(); err != nil {
+		logger.Fatal(err)
+	}
+	brandTemplates := make(map[string]models.Templates)
+	for _, brand := range config.Api.Brands {
+		if brand.I18nTemplatesPath == "" {
+			continue
+		}
+		brandLocalizer, err := localize.NewI18nLocalizer(path.Join(brand.I18nTemplatesPath, "/locales"))
+		if err != nil {
+			logger.Fatalf("Problem creating i18n localizer of brand %s %s", brand.Name, err)
+		}
+		if brandTemplates[brand.Name], err = templates.New(brand.I18nTemplatesPath, brandLocalizer); err != nil {
+			logger.Fatalf("Problem loading the templates of brand %s %s", brand.Name, err)
+		}
+		if err := api.CheckTemplatesContent(brandTemplates[brand.Name]); err != nil {
+			logger.Fatalf("Problem checking the templates of brand %s %s", brand.Name, err)
+		}
+	}
 
 	rtr := mux.NewRouter()
 	api := api.InitApi(config.Api, store, mail, shoreline, permsClient, seagull, portal, emailTemplates)
+	// Template versions uploaded at runtime are built with the same localizer as the disk templates
+	api.Localizer = localizer
+	api.BrandTemplates = brandTemplates
 	api.SetHandlers("", rtr)
 
 	/*
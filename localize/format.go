@@ -0,0 +1,149 @@
+package localize
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// dateFormat is the CLDR (v36, gregorian calendar) data a language writes dates and times with
+// golang.org/x/text keeps its CLDR date tables internal, the few fields used here are copied from CLDR
+type dateFormat struct {
+	months   []string // format wide month names
+	periods  []string // format abbreviated am and pm
+	date     string   // long date pattern
+	time     string   // short time pattern
+	dateTime string   // long date time pattern, {1} is the date and {0} the time
+}
+
+var dateFormats = map[language.Base]dateFormat{
+	language.MustParseBase("en"): {
+		months:   []string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+		periods:  []string{"AM", "PM"},
+		date:     "MMMM d, y",
+		time:     "h:mm a",
+		dateTime: "{1} 'at' {0}",
+	},
+	language.MustParseBase("fr"): {
+		months:   []string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		periods:  []string{"AM", "PM"},
+		date:     "d MMMM y",
+		time:     "HH:mm",
+		dateTime: "{1} 'à' {0}",
+	},
+	language.MustParseBase("de"): {
+		months:   []string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+		periods:  []string{"AM", "PM"},
+		date:     "d. MMMM y",
+		time:     "HH:mm",
+		dateTime: "{1} 'um' {0}",
+	},
+	language.MustParseBase("es"): {
+		months:   []string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		periods:  []string{"a. m.", "p. m."},
+		date:     "d 'de' MMMM 'de' y",
+		time:     "H:mm",
+		dateTime: "{1}, {0}",
+	},
+	language.MustParseBase("it"): {
+		months:   []string{"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno", "luglio", "agosto", "settembre", "ottobre", "novembre", "dicembre"},
+		periods:  []string{"AM", "PM"},
+		date:     "d MMMM y",
+		time:     "HH:mm",
+		dateTime: "{1} {0}",
+	},
+	language.MustParseBase("nl"): {
+		months:   []string{"januari", "februari", "maart", "april", "mei", "juni", "juli", "augustus", "september", "oktober", "november", "december"},
+		periods:  []string{"a.m.", "p.m."},
+		date:     "d MMMM y",
+		time:     "HH:mm",
+		dateTime: "{1} 'om' {0}",
+	},
+}
+
+// getDateFormat returns the date format of the locale language, english when it is not known
+func getDateFormat(locale string) dateFormat {
+	base, _ := language.Make(locale).Base()
+	if format, ok := dateFormats[base]; ok {
+		return format
+	}
+	return dateFormats[language.MustParseBase("en")]
+}
+
+// format writes the time with the CLDR pattern, only the fields used by the patterns above are known,
+// the quoted text is written as is
+func (f dateFormat) format(t time.Time, pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); {
+		c := pattern[i]
+		if c == '\'' {
+			end := strings.IndexByte(pattern[i+1:], '\'')
+			if end < 0 {
+				end = len(pattern) - i - 1
+			}
+			b.WriteString(pattern[i+1 : i+1+end])
+			i += end + 2
+			continue
+		}
+		n := 1
+		for i+n < len(pattern) && pattern[i+n] == c {
+			n++
+		}
+		switch c {
+		case 'y':
+			b.WriteString(strconv.Itoa(t.Year()))
+		case 'M':
+			b.WriteString(f.months[t.Month()-1])
+		case 'd':
+			b.WriteString(pad(t.Day(), n))
+		case 'H':
+			b.WriteString(pad(t.Hour(), n))
+		case 'h':
+			hour := t.Hour() % 12
+			if hour == 0 {
+				hour = 12
+			}
+			b.WriteString(pad(hour, n))
+		case 'm':
+			b.WriteString(pad(t.Minute(), n))
+		case 'a':
+			b.WriteString(f.periods[t.Hour()/12])
+		default:
+			b.WriteString(pattern[i : i+n])
+		}
+		i += n
+	}
+	return b.String()
+}
+
+// pad writes the number with at least width digits
+func pad(value int, width int) string {
+	s := strconv.Itoa(value)
+	for len(s) < width {
+		s = "0" + s
+	}
+	return s
+}
+
+// FormatDate returns the date and time written the way the locale does, in UTC with the zone shown,
+// the recipients' time zone is not known (e.g. "March 4, 2021 at 2:30 PM UTC" or "4 mars 2021 à 14:30 UTC")
+func FormatDate(t time.Time, locale string) string {
+	format := getDateFormat(locale)
+	t = t.UTC()
+	return format.format(t, strings.NewReplacer("{1}", format.date, "{0}", format.time).Replace(format.dateTime)) + " UTC"
+}
+
+// FormatTime returns the time of the day written the way the locale does, in UTC with the zone shown (e.g. "2:30 PM UTC" or "14:30 UTC")
+func FormatTime(t time.Time, locale string) string {
+	format := getDateFormat(locale)
+	return format.format(t.UTC(), format.time) + " UTC"
+}
+
+// FormatNumber returns the number with the digit grouping and decimal separator of the locale
+func FormatNumber(value interface{}, locale string) string {
+	return message.NewPrinter(language.Make(locale)).Sprint(number.Decimal(value))
+}
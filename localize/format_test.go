@@ -0,0 +1,71 @@
+package localize
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+func Test_FormatDate(t *testing.T) {
+	paris, _ := time.LoadLocation("Europe/Paris")
+	date := time.Date(2021, time.March, 4, 15, 30, 0, 0, paris)
+	tests := map[string]string{
+		"en":    "March 4, 2021 at 2:30 PM UTC",
+		"fr":    "4 mars 2021 à 14:30 UTC",
+		"fr-CA": "4 mars 2021 à 14:30 UTC",
+		"de":    "4. März 2021 um 14:30 UTC",
+		"es":    "4 de marzo de 2021, 14:30 UTC",
+		"it":    "4 marzo 2021 14:30 UTC",
+		"nl":    "4 maart 2021 om 14:30 UTC",
+		"ja":    "March 4, 2021 at 2:30 PM UTC",
+	}
+	for locale, expected := range tests {
+		if formatted := FormatDate(date, locale); formatted != expected {
+			t.Fatalf("Date formatted in %s is %q, expecting %q", locale, formatted, expected)
+		}
+	}
+}
+
+func Test_FormatTime(t *testing.T) {
+	date := time.Date(2021, time.March, 4, 0, 5, 0, 0, time.UTC)
+	tests := map[string]string{
+		"en": "12:05 AM UTC",
+		"fr": "00:05 UTC",
+		"es": "0:05 UTC",
+	}
+	for locale, expected := range tests {
+		if formatted := FormatTime(date, locale); formatted != expected {
+			t.Fatalf("Time formatted in %s is %q, expecting %q", locale, formatted, expected)
+		}
+	}
+}
+
+// The dates are written in every language the templates are translated to
+func Test_DateFormatLanguages(t *testing.T) {
+	localizer, err := NewI18nLocalizer("../templates/locales")
+	if err != nil {
+		t.Fatalf("Failed to create bundle: %s", err.Error())
+	}
+	for _, lang := range localizer.Languages() {
+		if lang == "chr" {
+			// crowdin in-context language
+			continue
+		}
+		if base, _ := language.Make(lang).Base(); dateFormats[base].date == "" {
+			t.Fatalf("No date format for %s", lang)
+		}
+	}
+}
+
+func Test_FormatNumber(t *testing.T) {
+	if formatted := FormatNumber(1234.5, "en"); formatted != "1,234.5" {
+		t.Fatalf("Number formatted in en is %q, expecting %q", formatted, "1,234.5")
+	}
+	if formatted := FormatNumber(1234.5, "de"); formatted != "1.234,5" {
+		t.Fatalf("Number formatted in de is %q, expecting %q", formatted, "1.234,5")
+	}
+	if formatted := FormatNumber(7, "fr"); formatted != "7" {
+		t.Fatalf("Number formatted in fr is %q, expecting %q", formatted, "7")
+	}
+}
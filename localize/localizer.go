@@ -8,22 +8,54 @@ import (
 	"log"
 	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/BurntSushi/toml"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 	"golang.org/x/text/language"
 	yaml "gopkg.in/yaml.v2"
 )
 
+const (
+	// PluralCountKey is the data key holding the count used to select the CLDR plural form of a message
+	PluralCountKey = "PluralCount"
+	// GenderKey is the data key holding the gender used to select the "<key>_<gender>" variant of a message
+	GenderKey = "Gender"
+	// LocaleKey is the data key holding the locale an email is rendered in, e.g. for the html lang attribute
+	LocaleKey = "Locale"
+	// testLocaleFile is the fixture of the templates tests kept in the locales folder, it is not a locale
+	testLocaleFile = "test.en.yaml"
+)
+
+// localeFormats are the extensions of the locale files, json being understood by the bundle without registration
+var localeFormats = map[string]i18n.UnmarshalFunc{
+	".yaml": yaml.Unmarshal,
+	".yml":  yaml.Unmarshal,
+	".json": nil,
+	".toml": toml.Unmarshal,
+}
+
 type Localizer interface {
 	Localize(key string, locale string, data map[string]interface{}) (string, error)
+	Match(locale string) language.Tag
 }
 
+// FallbackHook is called each time a message is missing in the matched locale and taken from the default language
+type FallbackHook func(key string, requested string, used string)
+
 type I18nLocalizer struct {
-	bundle *i18n.Bundle
+	bundle     *i18n.Bundle
+	tags       []language.Tag
+	matcher    language.Matcher
+	messages   map[language.Tag]map[string]*i18n.Message
+	onFallback FallbackHook
 }
 
 // createLocalizer initializes the internationalization objects needed by the api
 // Ensure at least en.yaml is present in the folder specified by TIDEPOOL_HYDROPHONE_SERVICE environment variable
+// The locale files are yaml, json or toml files, either in the folder or in a sub-folder per template (e.g. careteam_invitation/fr.yaml)
+// A key declared twice for the same language in different files is an error
 func NewI18nLocalizer(localesPath string) (*I18nLocalizer, error) {
 
 	// Get all the language files that exist
@@ -36,10 +68,13 @@ func NewI18nLocalizer(localesPath string) (*I18nLocalizer, error) {
 	// Bundle stores a set of messages
 	bundle := i18n.NewBundle(language.English)
 
-	// Enable bundle to understand yaml
-	bundle.RegisterUnmarshalFunc("yaml", yaml.Unmarshal)
+	// Enable bundle to understand yaml and toml
+	registerFormats(bundle)
 
 	var translations []byte
+	messages := make(map[language.Tag]map[string]*i18n.Message)
+	// origins keeps the file each message comes from to report the duplicate keys
+	origins := make(map[language.Tag]map[string]string)
 	for _, file := range langFiles {
 
 		// Read our language yaml file
@@ -49,30 +84,222 @@ func NewI18nLocalizer(localesPath string) (*I18nLocalizer, error) {
 		}
 
 		// It parses the bytes in buffer to add translations to the bundle
-		bundle.MustParseMessageFileBytes(translations, file)
+		messageFile, err := bundle.ParseMessageFileBytes(translations, file)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse translation file %s: %s", file, err)
+		}
+		if messages[messageFile.Tag] == nil {
+			messages[messageFile.Tag] = make(map[string]*i18n.Message)
+			origins[messageFile.Tag] = make(map[string]string)
+		}
+		for _, message := range messageFile.Messages {
+			if origin, found := origins[messageFile.Tag][message.ID]; found {
+				return nil, fmt.Errorf("localize: %s key %s is declared in %s and %s", messageFile.Tag, message.ID, origin, file)
+			}
+			messages[messageFile.Tag][message.ID] = message
+			origins[messageFile.Tag][message.ID] = file
+		}
 	}
 
+	if _, ok := messages[language.English]; !ok {
+		return nil, fmt.Errorf("Error initializing localization, no english locale file found in %s", localesPath)
+	}
+
+	localizer, err := newI18nLocalizer(bundle, messages)
+	if err != nil {
+		return nil, err
+	}
 	log.Printf("Localizer bundle created with default language: english")
-	return &I18nLocalizer{bundle}, nil
+	return localizer, nil
+}
+
+// WithOverrides returns a copy of the localizer which messages are replaced or completed by the given locale files
+// The files are keyed by locale and use the yaml format of the locale files, the pseudo locale is generated again
+func (l *I18nLocalizer) WithOverrides(files map[string]string) (*I18nLocalizer, error) {
+	bundle := i18n.NewBundle(language.English)
+	registerFormats(bundle)
+
+	pseudoTag := language.Make(PseudoLocale)
+	messages := make(map[language.Tag]map[string]*i18n.Message, len(l.messages))
+	for tag, tagMessages := range l.messages {
+		if tag == pseudoTag {
+			continue
+		}
+		messages[tag] = make(map[string]*i18n.Message, len(tagMessages))
+		for id, message := range tagMessages {
+			messages[tag][id] = message
+			if err := bundle.AddMessages(tag, message); err != nil {
+				return nil, fmt.Errorf("localize: unable to copy %s message %s: %s", tag, id, err)
+			}
+		}
+	}
+
+	for locale, content := range files {
+		tag, err := language.Parse(locale)
+		if err != nil || tag == pseudoTag {
+			return nil, fmt.Errorf("localize: invalid override locale %s", strconv.Quote(locale))
+		}
+		messageFile, err := bundle.ParseMessageFileBytes([]byte(content), tag.String()+".yaml")
+		if err != nil {
+			return nil, fmt.Errorf("localize: unable to parse %s overrides: %s", tag, err)
+		}
+		if messages[messageFile.Tag] == nil {
+			messages[messageFile.Tag] = make(map[string]*i18n.Message)
+		}
+		for _, message := range messageFile.Messages {
+			messages[messageFile.Tag][message.ID] = message
+		}
+	}
+
+	localizer, err := newI18nLocalizer(bundle, messages)
+	if err != nil {
+		return nil, err
+	}
+	localizer.onFallback = l.onFallback
+	return localizer, nil
+}
+
+// registerFormats enables the bundle to parse the locale file formats it does not understand natively
+func registerFormats(bundle *i18n.Bundle) {
+	for extension, unmarshal := range localeFormats {
+		if unmarshal != nil {
+			bundle.RegisterUnmarshalFunc(strings.TrimPrefix(extension, "."), unmarshal)
+		}
+	}
 }
 
-// getLocalizedContentPart returns translated content part based on key and locale
+// newI18nLocalizer generates the pseudo locale into the bundle and builds the matcher of the loaded locales
+func newI18nLocalizer(bundle *i18n.Bundle, messages map[language.Tag]map[string]*i18n.Message) (*I18nLocalizer, error) {
+	// The pseudo locale is generated from english
+	pseudoTag := language.Make(PseudoLocale)
+	messages[pseudoTag] = make(map[string]*i18n.Message, len(messages[language.English]))
+	for id, message := range messages[language.English] {
+		messages[pseudoTag][id] = pseudoLocalize(message)
+	}
+	for _, message := range messages[pseudoTag] {
+		if err := bundle.AddMessages(pseudoTag, message); err != nil {
+			return nil, fmt.Errorf("Unable to create the %s pseudo locale: %s", PseudoLocale, err)
+		}
+	}
+
+	// The default language comes first so it is the one matched when nothing else fits
+	tags := []language.Tag{language.English}
+	for _, tag := range bundle.LanguageTags() {
+		if tag != language.English && tag != language.Und && tag != pseudoTag {
+			tags = append(tags, tag)
+		}
+	}
+	// The pseudo locale is only used when explicitly requested, en-GB must not match en-XA
+	matcher := language.NewMatcher(tags)
+	tags = append(tags, pseudoTag)
+
+	return &I18nLocalizer{
+		bundle:     bundle,
+		tags:       tags,
+		matcher:    matcher,
+		messages:   messages,
+		onFallback: logFallback,
+	}, nil
+}
+
+// Languages returns the loaded locales, the default language first
+func (l *I18nLocalizer) Languages() []string {
+	languages := make([]string, len(l.tags))
+	for i, tag := range l.tags {
+		languages[i] = tag.String()
+	}
+	return languages
+}
+
+// Messages returns the messages loaded for the locale, without any matching nor fallback
+func (l *I18nLocalizer) Messages(locale string) map[string]*i18n.Message {
+	return l.messages[language.Make(locale)]
+}
+
+// SetFallbackHook replaces the hook reporting the messages taken from the default language
+func (l *I18nLocalizer) SetFallbackHook(hook FallbackHook) {
+	l.onFallback = hook
+}
+
+// Match returns the loaded locale that best fits the requested one
+// e.g. fr-CA gives fr when there is no fr-CA file, an unknown or invalid locale gives the default language
+// The pseudo locale is only matched when requested as is
+func (l *I18nLocalizer) Match(locale string) language.Tag {
+	requested, err := language.Parse(locale)
+	if err != nil {
+		return l.tags[0]
+	}
+	if requested == language.Make(PseudoLocale) {
+		return requested
+	}
+	_, index, confidence := l.matcher.Match(requested)
+	if confidence == language.No {
+		return l.tags[0]
+	}
+	return l.tags[index]
+}
+
+// Localize returns translated content part based on key and locale
+// The locale is matched against the loaded ones, then each missing key falls back to the default language
+// When the data holds a PluralCountKey, it selects the plural form of the message
+// When the data holds a GenderKey, the "<key>_<gender>" message is preferred to the neutral one
 func (l *I18nLocalizer) Localize(key string, locale string, data map[string]interface{}) (string, error) {
-	localizer := i18n.NewLocalizer(l.bundle, locale)
-	msg, err := localizer.Localize(
-		&i18n.LocalizeConfig{
-			MessageID:    key,
-			TemplateData: data,
-		},
-	)
-	if msg == "" {
-		msg = "<< Cannot find translation for item " + key + " >>"
+	keys := []string{key}
+	if gender, ok := data[GenderKey].(string); ok && gender != "" {
+		keys = []string{key + "_" + gender, key}
+	}
+
+	matched := l.Match(locale)
+	for _, tag := range l.fallbackChain(matched) {
+		for _, id := range keys {
+			message, ok := l.messages[tag][id]
+			if !ok {
+				continue
+			}
+			if tag != matched && l.onFallback != nil {
+				l.onFallback(id, matched.String(), tag.String())
+			}
+			config := &i18n.LocalizeConfig{
+				MessageID:    id,
+				TemplateData: data,
+			}
+			if isPlural(message) {
+				config.PluralCount = data[PluralCountKey]
+			}
+			localizer := i18n.NewLocalizer(l.bundle, tag.String())
+			return localizer.Localize(config)
+		}
+	}
+	return "", fmt.Errorf("localize: no translation found for %s", key)
+}
+
+// isPlural reports whether the message has plural forms
+func isPlural(message *i18n.Message) bool {
+	return message.Zero != "" || message.One != "" || message.Two != "" || message.Few != "" || message.Many != ""
+}
+
+// fallbackChain returns the locales to look into for a message: the matched one, its parents then the default language
+func (l *I18nLocalizer) fallbackChain(tag language.Tag) []language.Tag {
+	chain := []language.Tag{tag}
+	for parent := tag.Parent(); parent != language.Und; parent = parent.Parent() {
+		if _, ok := l.messages[parent]; ok {
+			chain = append(chain, parent)
+		}
+	}
+	if chain[len(chain)-1] != l.tags[0] {
+		chain = append(chain, l.tags[0])
 	}
-	return msg, err
+	return chain
+}
+
+// logFallback is the default fallback hook
+func logFallback(key string, requested string, used string) {
+	log.Printf("localize: %s is missing in %s, using %s", key, requested, used)
 }
 
 // getAllLocalizationFiles returns all the filenames within the folder specified by the TIDEPOOL_HYDROPHONE_SERVICE environment variable
-// Add yaml file to this folder to get a language added
+// and within its sub-folders, one per template
+// Add a yaml, json or toml file to this folder to get a language added
 // At least en.yaml should be present
 func getAllLocalizationFiles(dir string) ([]string, error) {
 
@@ -86,13 +313,31 @@ func getAllLocalizationFiles(dir string) ([]string, error) {
 
 	for _, file := range files {
 		filePath, _ := filepath.Abs(path.Join(dir, file.Name()))
-		if !file.IsDir() && file.Name() != "test.en.yaml" && (filepath.Ext(filePath) == ".yaml" || filepath.Ext(filePath) == ".yml") {
+		if file.IsDir() {
+			templateFiles, err := ioutil.ReadDir(filePath)
+			if err != nil {
+				log.Printf("Can't read directory %s", filePath)
+				return nil, err
+			}
+			for _, templateFile := range templateFiles {
+				if templateFilePath := path.Join(filePath, templateFile.Name()); !templateFile.IsDir() && isLocaleFile(templateFilePath) {
+					log.Printf("Found localization file %s", templateFilePath)
+					retFiles = append(retFiles, templateFilePath)
+				}
+			}
+		} else if file.Name() != testLocaleFile && isLocaleFile(filePath) {
 			log.Printf("Found localization file %s", filePath)
 			retFiles = append(retFiles, filePath)
 		}
 	}
 	if len(retFiles) < 1 {
-		return nil, fmt.Errorf("No locale files (yml or yaml extension) found in %s", dir)
+		return nil, fmt.Errorf("No locale files (yaml, yml, json or toml extension) found in %s", dir)
 	}
 	return retFiles, nil
 }
+
+// isLocaleFile tells whether the file has the extension of a supported locale format
+func isLocaleFile(file string) bool {
+	_, supported := localeFormats[filepath.Ext(file)]
+	return supported
+}
@@ -1,6 +1,8 @@
 package localize
 
 import (
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -23,9 +25,9 @@ func Test_CreateLocalizerBundle(t *testing.T) {
 		t.Fatalf("Creation of the localizer should have failed when called with a wrong path")
 	}
 
-	localizer, err = NewI18nLocalizer(".")
+	localizer, err = NewI18nLocalizer("../utils")
 	if err == nil || localizer != nil {
-		t.Fatalf("Creation of the localizer should have failed when called with a wrong path")
+		t.Fatalf("Creation of the localizer should have failed when called with a path without locale files")
 	}
 
 	localizer, err = NewI18nLocalizer("./test_fixture/")
@@ -65,3 +67,216 @@ func Test_GetLocalizedPart(t *testing.T) {
 		t.Fatalf("Localization should have failed when called with a wrong key")
 	}
 }
+
+func Test_LocaleFormats(t *testing.T) {
+	localizer, err := NewI18nLocalizer("./test_fixture_formats/")
+	if err != nil {
+		t.Fatalf("Failed to create bundle: %s", err)
+	}
+	tests := []struct {
+		key      string
+		locale   string
+		data     map[string]interface{}
+		expected string
+	}{
+		{"TestTemplateSubject", "fr", nil, "Cet email est là pour les tests."},
+		{"TestExpiry", "fr", map[string]interface{}{PluralCountKey: 2}, "Valable 2 jours."},
+		{"TestTemplateSubject", "de", nil, "Diese E-Mail dient zu Testzwecken."},
+		{"TestExpiry", "de", map[string]interface{}{PluralCountKey: 1}, "1 Tag gültig."},
+		{"TestContentInjection", "en", map[string]interface{}{"TestCreatorName": TestCreatorName}, expectedLocalizedContent},
+		{"TestContentInjection", "fr", map[string]interface{}{"TestCreatorName": TestCreatorName}, "Ceci est un contenu de test créé par " + TestCreatorName + "."},
+	}
+	for _, test := range tests {
+		if localized, err := localizer.Localize(test.key, test.locale, test.data); err != nil || localized != test.expected {
+			t.Fatalf("Wrong %s content of %s, expecting %s but found %s (%v)", test.locale, test.key, test.expected, localized, err)
+		}
+	}
+}
+
+func Test_DuplicateKeys(t *testing.T) {
+	localizer, err := NewI18nLocalizer("./test_fixture_duplicate/")
+	if err == nil || localizer != nil {
+		t.Fatalf("Creation of the localizer should have failed when a key is declared twice")
+	}
+	if !strings.Contains(err.Error(), "en key TestTemplateSubject is declared in") || !strings.Contains(err.Error(), filepath.Join("careteam", "en.yaml")) {
+		t.Fatalf("The duplicate key should be reported with its files, got %s", err)
+	}
+}
+
+func Test_MatchLocale(t *testing.T) {
+	localizer, err := NewI18nLocalizer("./test_fixture/")
+	if err != nil {
+		t.Fatalf("Failed to create bundle: %s", err.Error())
+	}
+
+	tests := map[string]string{
+		"fr":        "fr",
+		"fr-CA":     "fr",
+		"FR-fr":     "fr",
+		"en-US":     "en",
+		"en-GB":     "en",
+		"en-XA":     "en-XA",
+		"ja":        "en",
+		"":          "en",
+		"not a tag": "en",
+	}
+	for requested, expected := range tests {
+		if matched := localizer.Match(requested).String(); matched != expected {
+			t.Fatalf("Locale %q matched %q, expecting %q", requested, matched, expected)
+		}
+	}
+}
+
+func Test_LocalizeFallback(t *testing.T) {
+	localizer, err := NewI18nLocalizer("./test_fixture/")
+	if err != nil {
+		t.Fatalf("Failed to create bundle: %s", err.Error())
+	}
+	var fallbacks []string
+	localizer.SetFallbackHook(func(key string, requested string, used string) {
+		fallbacks = append(fallbacks, key+":"+requested+"->"+used)
+	})
+
+	localizedContent, err := localizer.Localize("TestTemplateSubject", "fr-CA", nil)
+	if err != nil || localizedContent != "Cet email est là pour les tests." {
+		t.Fatalf("Wrong localized content, expecting the french subject but found %s (%v)", localizedContent, err)
+	}
+	if len(fallbacks) != 0 {
+		t.Fatalf("No fallback should have been reported, got %v", fallbacks)
+	}
+
+	content := map[string]interface{}{"TestCreatorName": TestCreatorName}
+	localizedContent, err = localizer.Localize("TestContentInjection", "fr-CA", content)
+	if err != nil || localizedContent != expectedLocalizedContent {
+		t.Fatalf("Wrong localized content, expecting %s but found %s (%v)", expectedLocalizedContent, localizedContent, err)
+	}
+	if len(fallbacks) != 1 || fallbacks[0] != "TestContentInjection:fr->en" {
+		t.Fatalf("The fallback to english should have been reported, got %v", fallbacks)
+	}
+
+	localizedContent, err = localizer.Localize("wrongKey", "fr", nil)
+	if err == nil || localizedContent != "" {
+		t.Fatalf("Localization of a wrong key should fail with an empty content, found %q", localizedContent)
+	}
+}
+
+func Test_LocalizePlural(t *testing.T) {
+	localizer, err := NewI18nLocalizer("./test_fixture/")
+	if err != nil {
+		t.Fatalf("Failed to create bundle: %s", err.Error())
+	}
+	tests := []struct {
+		locale   string
+		count    int
+		expected string
+	}{
+		{"en", 1, "Valid for 1 day."},
+		{"en", 7, "Valid for 7 days."},
+		{"fr", 1, "Valable 1 jour."},
+		{"fr", 7, "Valable 7 jours."},
+	}
+	for _, test := range tests {
+		localizedContent, err := localizer.Localize("TestExpiry", test.locale, map[string]interface{}{PluralCountKey: test.count})
+		if err != nil || localizedContent != test.expected {
+			t.Fatalf("Wrong localized content in %s for %d, expecting %q but found %q (%v)", test.locale, test.count, test.expected, localizedContent, err)
+		}
+	}
+}
+
+func Test_LocalizeGender(t *testing.T) {
+	localizer, err := NewI18nLocalizer("./test_fixture/")
+	if err != nil {
+		t.Fatalf("Failed to create bundle: %s", err.Error())
+	}
+	tests := map[string]string{
+		"female": "Dear madam,",
+		"male":   "Dear user,",
+		"":       "Dear user,",
+	}
+	for gender, expected := range tests {
+		localizedContent, err := localizer.Localize("TestGreeting", "en", map[string]interface{}{GenderKey: gender})
+		if err != nil || localizedContent != expected {
+			t.Fatalf("Wrong localized content for gender %q, expecting %q but found %q (%v)", gender, expected, localizedContent, err)
+		}
+	}
+}
+
+func Test_WithOverrides(t *testing.T) {
+	localizer, err := NewI18nLocalizer("./test_fixture/")
+	if err != nil {
+		t.Fatalf("Failed to create bundle: %s", err.Error())
+	}
+	overridden, err := localizer.WithOverrides(map[string]string{
+		"en": "TestTemplateSubject: \"An overridden subject.\"",
+		"de": "TestTemplateSubject: \"Ein neuer Betreff.\"",
+	})
+	if err != nil {
+		t.Fatalf("Failed to override the messages: %s", err)
+	}
+	tests := []struct {
+		key      string
+		locale   string
+		expected string
+	}{
+		{"TestTemplateSubject", "en", "An overridden subject."},
+		{"TestTemplateSubject", "fr", "Cet email est là pour les tests."},
+		{"TestTemplateSubject", "de-AT", "Ein neuer Betreff."},
+		{"TestTemplateSubject", PseudoLocale, "[Åñ öṽéŕŕîððéñ šûƀĵéçţ. ~~~~~~~~~]"},
+		{"TestGreeting", "de", "Dear user,"},
+	}
+	for _, test := range tests {
+		localizedContent, err := overridden.Localize(test.key, test.locale, nil)
+		if err != nil || localizedContent != test.expected {
+			t.Fatalf("Wrong overridden content for %s in %s, expecting %q but found %q (%v)", test.key, test.locale, test.expected, localizedContent, err)
+		}
+	}
+	if localizedContent, _ := localizer.Localize("TestTemplateSubject", "en", nil); localizedContent != "This email is here for testing purposes." {
+		t.Fatalf("The original localizer should not be modified, found %q", localizedContent)
+	}
+
+	if _, err := localizer.WithOverrides(map[string]string{"not a locale": "Key: value"}); err == nil {
+		t.Fatalf("An invalid locale should be rejected")
+	}
+	if _, err := localizer.WithOverrides(map[string]string{"fr": "Key: [unclosed"}); err == nil {
+		t.Fatalf("An invalid locale file should be rejected")
+	}
+}
+
+func Test_PseudoLocale(t *testing.T) {
+	localizer, err := NewI18nLocalizer("./test_fixture/")
+	if err != nil {
+		t.Fatalf("Failed to create bundle: %s", err.Error())
+	}
+	var fallbacks []string
+	localizer.SetFallbackHook(func(key string, requested string, used string) {
+		fallbacks = append(fallbacks, key+":"+requested+"->"+used)
+	})
+
+	content := map[string]interface{}{"TestCreatorName": TestCreatorName}
+	localizedContent, err := localizer.Localize("TestContentInjection", PseudoLocale, content)
+	expected := "[Ţĥîš îš å ţéšţ çöñţéñţ çŕéåţéð ƀý " + TestCreatorName + ". ~~~~~~~~~~~~~~]"
+	if err != nil || localizedContent != expected {
+		t.Fatalf("Wrong pseudo localized content, expecting %q but found %q (%v)", expected, localizedContent, err)
+	}
+
+	localizedContent, err = localizer.Localize("TestExpiry", PseudoLocale, map[string]interface{}{PluralCountKey: 7})
+	if err != nil || localizedContent != "[Ṽåļîð ƒöŕ 7 ðåýš. ~~~~~~]" {
+		t.Fatalf("Wrong pseudo localized plural, found %q (%v)", localizedContent, err)
+	}
+	if len(fallbacks) != 0 {
+		t.Fatalf("No fallback should have been reported, got %v", fallbacks)
+	}
+}
+
+func Test_PseudoText(t *testing.T) {
+	tests := map[string]string{
+		"":                                  "",
+		"Hello":                             "[Ĥéļļö ~~]",
+		"<b>Hi</b> {{ .Name }}&nbsp;there!": "[<b>Ĥî</b> {{ .Name }}&nbsp;ţĥéŕé! ~~~~]",
+	}
+	for text, expected := range tests {
+		if pseudo := pseudoText(text); pseudo != expected {
+			t.Fatalf("Wrong pseudo text for %q, expecting %q but found %q", text, expected, pseudo)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package localize
 
-import "fmt"
+import (
+	"fmt"
+
+	"golang.org/x/text/language"
+)
 
 type MockLocalizer struct {
 	translations map[string]string
@@ -20,3 +24,11 @@ func (l *MockLocalizer) Localize(key string, locale string, data map[string]inte
 		return msg, nil
 	}
 }
+
+// Match returns the requested locale, english when it is invalid
+func (l *MockLocalizer) Match(locale string) language.Tag {
+	if tag, err := language.Parse(locale); err == nil {
+		return tag
+	}
+	return language.English
+}
@@ -0,0 +1,60 @@
+package localize
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// PseudoLocale is the locale generated from english to review the emails layout before translation
+// Its messages are longer, accented and bracketed, so truncated, hard-coded or concatenated texts are easy to spot
+const PseudoLocale = "en-XA"
+
+// pseudoExpansion is the ratio of padding added to the messages, translations are often 30 to 40% longer than english
+const pseudoExpansion = 0.4
+
+var (
+	// template actions, html tags and entities are kept as they are
+	pseudoKeptRegexp = regexp.MustCompile(`{{.*?}}|<[^>]*>|&[#a-zA-Z0-9]+;`)
+	pseudoAccents    = strings.NewReplacer(
+		"a", "å", "b", "ƀ", "c", "ç", "d", "ð", "e", "é", "f", "ƒ", "g", "ĝ", "h", "ĥ", "i", "î", "j", "ĵ", "k", "ķ", "l", "ļ", "m", "ɱ",
+		"n", "ñ", "o", "ö", "p", "þ", "q", "ǫ", "r", "ŕ", "s", "š", "t", "ţ", "u", "û", "v", "ṽ", "w", "ŵ", "x", "ẋ", "y", "ý", "z", "ž",
+		"A", "Å", "B", "Ɓ", "C", "Ç", "D", "Ð", "E", "É", "F", "Ƒ", "G", "Ĝ", "H", "Ĥ", "I", "Î", "J", "Ĵ", "K", "Ķ", "L", "Ļ", "M", "Ṁ",
+		"N", "Ñ", "O", "Ö", "P", "Þ", "Q", "Ǫ", "R", "Ŕ", "S", "Š", "T", "Ţ", "U", "Û", "V", "Ṽ", "W", "Ŵ", "X", "Ẋ", "Y", "Ý", "Z", "Ž",
+	)
+)
+
+// pseudoLocalize returns the pseudo localized copy of a message
+func pseudoLocalize(message *i18n.Message) *i18n.Message {
+	pseudo := *message
+	pseudo.Hash = ""
+	pseudo.Zero = pseudoText(message.Zero)
+	pseudo.One = pseudoText(message.One)
+	pseudo.Two = pseudoText(message.Two)
+	pseudo.Few = pseudoText(message.Few)
+	pseudo.Many = pseudoText(message.Many)
+	pseudo.Other = pseudoText(message.Other)
+	return &pseudo
+}
+
+// pseudoText accents the letters of the text, pads it by about 40% and puts it in brackets
+func pseudoText(text string) string {
+	if text == "" {
+		return ""
+	}
+	var builder strings.Builder
+	length := 0
+	last := 0
+	for _, kept := range pseudoKeptRegexp.FindAllStringIndex(text, -1) {
+		length += len([]rune(text[last:kept[0]]))
+		builder.WriteString(pseudoAccents.Replace(text[last:kept[0]]))
+		builder.WriteString(text[kept[0]:kept[1]])
+		last = kept[1]
+	}
+	length += len([]rune(text[last:]))
+	builder.WriteString(pseudoAccents.Replace(text[last:]))
+
+	padding := int(float64(length)*pseudoExpansion + 0.5)
+	return "[" + builder.String() + " " + strings.Repeat("~", padding) + "]"
+}
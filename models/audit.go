@@ -0,0 +1,123 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+type (
+	// AuditAction is what a request did, recorded in the audit log
+	AuditAction string
+	// AuditOutcome tells if the action succeeded
+	AuditOutcome string
+
+	// AuditEvent is a record of the audit log
+	// The target email is only stored hashed with a secret key, the events can be looked up by email without keeping it
+	AuditEvent struct {
+		Time            time.Time    `json:"time" bson:"time"`
+		Actor           string       `json:"actor" bson:"actor"` // user ID, or ActorServer
+		Action          AuditAction  `json:"action" bson:"action"`
+		Outcome         AuditOutcome `json:"outcome" bson:"outcome"`
+		TargetUser      string       `json:"targetUser,omitempty" bson:"targetUser,omitempty"`
+		TargetTeam      string       `json:"targetTeam,omitempty" bson:"targetTeam,omitempty"`
+		TargetEmailHash string       `json:"targetEmailHash,omitempty" bson:"targetEmailHash,omitempty"`
+		TargetEmail     string       `json:"-" bson:"-"`                                 // hashed into TargetEmailHash when the event is recorded, never stored
+		Details         string       `json:"details,omitempty" bson:"details,omitempty"` // e.g. the template version or the confirmation key
+		TraceSession    string       `json:"traceSession,omitempty" bson:"traceSession,omitempty"`
+		RemoteAddr      string       `json:"remoteAddr,omitempty" bson:"remoteAddr,omitempty"`
+	}
+
+	// AuditFilter selects audit events, the empty fields match any event
+	AuditFilter struct {
+		Actor           string
+		Action          AuditAction
+		TargetUser      string
+		TargetTeam      string
+		TargetEmailHash string
+		From            time.Time // inclusive
+		To              time.Time // exclusive
+		Limit           int
+	}
+)
+
+const (
+	//Available audit outcomes
+	AuditSuccess AuditOutcome = "success"
+	AuditFailure AuditOutcome = "failure"
+	//Available audit actions
+	AuditInvitesRead              AuditAction = "invites_read"
+	AuditInviteCreated            AuditAction = "invite_created"
+	AuditInviteSent               AuditAction = "invite_sent"
+	AuditInviteAccepted           AuditAction = "invite_accepted"
+	AuditInviteDeclined           AuditAction = "invite_declined"
+	AuditInviteCanceled           AuditAction = "invite_canceled"
+	AuditPasswordResetCreated     AuditAction = "password_reset_created"
+	AuditPasswordResetSent        AuditAction = "password_reset_sent"
+	AuditPasswordReset            AuditAction = "password_reset"
+	AuditPinResetSent             AuditAction = "pin_reset_sent"
+	AuditSignupsRead              AuditAction = "signups_read"
+	AuditSignupCreated            AuditAction = "signup_created"
+	AuditSignupSent               AuditAction = "signup_sent"
+	AuditSignupAccepted           AuditAction = "signup_accepted"
+	AuditSignupDeclined           AuditAction = "signup_declined"
+	AuditSignupCanceled           AuditAction = "signup_canceled"
+	AuditSignupInformationSent    AuditAction = "signup_information_sent"
+	AuditTeamBrandingUpdated      AuditAction = "team_branding_updated"
+	AuditTeamBrandingDeleted      AuditAction = "team_branding_deleted"
+	AuditTeamLogoUploaded         AuditAction = "team_logo_uploaded"
+	AuditTemplateVersionUploaded  AuditAction = "template_version_uploaded"
+	AuditTemplateVersionActivated AuditAction = "template_version_activated"
+	AuditTemplateRolledBack       AuditAction = "template_rolled_back"
+	AuditUserDataExported         AuditAction = "user_data_exported"
+	AuditUserDataErased           AuditAction = "user_data_erased"
+)
+
+// ConfirmationEvent returns the audit event of an action on a confirmation, targeting its user, team and email
+// The key of the confirmation is left out, it grants the action to whoever holds it
+func ConfirmationEvent(action AuditAction, outcome AuditOutcome, c *Confirmation) AuditEvent {
+	event := AuditEvent{
+		Action:      action,
+		Outcome:     outcome,
+		TargetUser:  c.UserId,
+		TargetEmail: c.Email,
+		Details:     string(c.Type),
+	}
+	if c.Team != nil {
+		event.TargetTeam = c.Team.ID
+	}
+	return event
+}
+
+// HashEmail returns the HMAC-SHA256 of the canonical email with the secret key, stored in the audit events, empty for an empty email
+// Without the key, the hash of a known email can't be computed to find its events
+func HashEmail(key string, email string) string {
+	if email == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(CanonicalEmail(email)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Match tells if the event is selected by the filter
+func (f *AuditFilter) Match(event *AuditEvent) bool {
+	switch {
+	case f.Actor != "" && f.Actor != event.Actor:
+		return false
+	case f.Action != "" && f.Action != event.Action:
+		return false
+	case f.TargetUser != "" && f.TargetUser != event.TargetUser:
+		return false
+	case f.TargetTeam != "" && f.TargetTeam != event.TargetTeam:
+		return false
+	case f.TargetEmailHash != "" && f.TargetEmailHash != event.TargetEmailHash:
+		return false
+	case !f.From.IsZero() && event.Time.Before(f.From):
+		return false
+	case !f.To.IsZero() && !event.Time.Before(f.To):
+		return false
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+const testAuditKey = "audit key"
+
+func Test_HashEmail(t *testing.T) {
+	if HashEmail(testAuditKey, "") != "" {
+		t.Fatalf("An empty email should have an empty hash")
+	}
+	hash := HashEmail(testAuditKey, "Invitee@Example.com")
+	if len(hash) != 64 || hash != HashEmail(testAuditKey, "invitee@example.COM") {
+		t.Fatalf("The hash should be the hex HMAC-SHA256 of the canonical email, got %s", hash)
+	}
+	if hash == HashEmail(testAuditKey, "other@example.com") {
+		t.Fatalf("Two emails should not have the same hash")
+	}
+	if hash == HashEmail("other key", "invitee@example.com") {
+		t.Fatalf("The hash should depend on the key")
+	}
+	if sum := sha256.Sum256([]byte("invitee@example.com")); hash == hex.EncodeToString(sum[:]) {
+		t.Fatalf("The hash should not be the plain sha256 of the email")
+	}
+}
+
+func Test_ConfirmationEvent(t *testing.T) {
+	invite := &Confirmation{Key: "secret.key", Type: TypeMedicalTeamInvite, Email: "invitee@example.com", UserId: "123", Team: &Team{ID: "team1"}}
+	event := ConfirmationEvent(AuditInviteAccepted, AuditSuccess, invite)
+	expected := AuditEvent{
+		Action:      AuditInviteAccepted,
+		Outcome:     AuditSuccess,
+		TargetUser:  "123",
+		TargetTeam:  "team1",
+		TargetEmail: "invitee@example.com",
+		Details:     string(TypeMedicalTeamInvite),
+	}
+	if event != expected {
+		t.Fatalf("Wrong event, expecting %v but got %v", expected, event)
+	}
+	if event := ConfirmationEvent(AuditSignupSent, AuditFailure, &Confirmation{Type: TypeSignUp}); event.TargetTeam != "" {
+		t.Fatalf("A confirmation without team should not target one, got %v", event)
+	}
+}
+
+func Test_AuditFilter_Match(t *testing.T) {
+	now := time.Now()
+	event := &AuditEvent{Time: now, Actor: "123", Action: AuditInviteSent, TargetTeam: "team1", TargetEmailHash: HashEmail(testAuditKey, "invitee@example.com")}
+	tests := []struct {
+		filter AuditFilter
+		match  bool
+	}{
+		{AuditFilter{}, true},
+		{AuditFilter{Actor: "123", Action: AuditInviteSent, TargetTeam: "team1"}, true},
+		{AuditFilter{Actor: "456"}, false},
+		{AuditFilter{Action: AuditInviteAccepted}, false},
+		{AuditFilter{TargetUser: "123"}, false},
+		{AuditFilter{TargetEmailHash: HashEmail(testAuditKey, "INVITEE@example.com")}, true},
+		{AuditFilter{From: now, To: now.Add(time.Second)}, true},
+		{AuditFilter{From: now.Add(time.Second)}, false},
+		{AuditFilter{To: now}, false},
+	}
+	for idx, test := range tests {
+		if match := test.filter.Match(event); match != test.match {
+			t.Fatalf("TestId `%d` the filter %v should match %t, got %t", idx, test.filter, test.match, match)
+		}
+	}
+}
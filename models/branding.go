@@ -0,0 +1,166 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"image"
+	_ "image/gif" // registers the gif logos
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/language"
+)
+
+const (
+	// MaxBrandingTextLength is the maximum number of characters of the signature, the contact block and each welcome paragraph
+	MaxBrandingTextLength = 500
+	// MaxBrandingLocales is the maximum number of languages of the welcome paragraph
+	MaxBrandingLocales = 10
+	// MaxLogoSize is the maximum size in bytes of a team logo, it is kept small as it is served with every invite
+	MaxLogoSize = 100 * 1024
+	// MaxLogoDimension is the maximum width and height in pixels of a team logo
+	MaxLogoDimension = 1000
+)
+
+// TeamBranding is the branding of the emails sent on behalf of a medical team
+// The texts are plain text, they are escaped when added to the emails
+type TeamBranding struct {
+	TeamID     string            `json:"teamId" bson:"_id"`
+	Signature  string            `json:"signature" bson:"signature"`
+	Contact    string            `json:"contact" bson:"contact"`
+	Welcome    map[string]string `json:"welcome" bson:"welcome"`
+	Logo       []byte            `json:"-" bson:"logo,omitempty"`
+	LogoType   string            `json:"-" bson:"logoType,omitempty"`
+	LogoURL    string            `json:"logoUrl,omitempty" bson:"-"`
+	Modified   time.Time         `json:"modified" bson:"modified"`
+	ModifiedBy string            `json:"modifiedBy" bson:"modifiedBy"`
+}
+
+// Validate checks the texts of the branding against the size limits, it returns every problem found
+func (b *TeamBranding) Validate() []string {
+	var problems []string
+	checkText := func(name, text string) {
+		if length := utf8.RuneCountInString(text); length > MaxBrandingTextLength {
+			problems = append(problems, fmt.Sprintf("%s is %d characters long, the maximum is %d", name, length, MaxBrandingTextLength))
+		}
+		if strings.IndexFunc(text, isForbiddenRune) >= 0 {
+			problems = append(problems, fmt.Sprintf("%s contains control characters", name))
+		}
+	}
+	checkText("signature", b.Signature)
+	checkText("contact", b.Contact)
+	if len(b.Welcome) > MaxBrandingLocales {
+		problems = append(problems, fmt.Sprintf("welcome has %d languages, the maximum is %d", len(b.Welcome), MaxBrandingLocales))
+	}
+	locales := make([]string, 0, len(b.Welcome))
+	for locale := range b.Welcome {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	for _, locale := range locales {
+		if _, err := language.Parse(locale); err != nil {
+			problems = append(problems, fmt.Sprintf("welcome language %s is invalid", locale))
+			continue
+		}
+		checkText("welcome "+locale, b.Welcome[locale])
+	}
+	return problems
+}
+
+// SetLogo checks the uploaded image is a png, jpeg or gif logo within the size limits and keeps it with its content type
+func (b *TeamBranding) SetLogo(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("models: the logo is empty")
+	}
+	if len(data) > MaxLogoSize {
+		return fmt.Errorf("models: the logo is %d bytes, the maximum is %d", len(data), MaxLogoSize)
+	}
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case "image/png", "image/jpeg", "image/gif":
+	default:
+		return fmt.Errorf("models: the logo type %s is not supported, use png, jpeg or gif", contentType)
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("models: the logo cannot be decoded: %s", err)
+	}
+	if config.Width > MaxLogoDimension || config.Height > MaxLogoDimension {
+		return fmt.Errorf("models: the logo is %dx%d pixels, the maximum is %dx%d", config.Width, config.Height, MaxLogoDimension, MaxLogoDimension)
+	}
+	b.Logo = data
+	b.LogoType = contentType
+	return nil
+}
+
+// WelcomeText returns the welcome paragraph which language best fits the requested one
+// English, then any language, is used when none fits
+func (b *TeamBranding) WelcomeText(lang string) string {
+	if len(b.Welcome) == 0 {
+		return ""
+	}
+	locales := make([]string, 0, len(b.Welcome))
+	for locale := range b.Welcome {
+		locales = append(locales, locale)
+	}
+	// English first so it is matched when nothing else fits, the other languages sorted to be deterministic
+	sort.Slice(locales, func(i, j int) bool {
+		if (locales[i] == "en") != (locales[j] == "en") {
+			return locales[i] == "en"
+		}
+		return locales[i] < locales[j]
+	})
+	tags := make([]language.Tag, len(locales))
+	for i, locale := range locales {
+		tags[i] = language.Make(locale)
+	}
+	_, index, _ := language.NewMatcher(tags).Match(language.Make(lang))
+	return b.Welcome[locales[index]]
+}
+
+// Content returns the branding variables of the medical team invites, in the language of the email
+// The texts are escaped and their new lines become line breaks, the empty ones are left out so the templates skip them
+func (b *TeamBranding) Content(lang string, logoURL string) map[string]interface{} {
+	content := map[string]interface{}{}
+	if logoURL != "" && len(b.Logo) > 0 {
+		content["TeamLogoURL"] = logoURL
+	}
+	for name, text := range map[string]string{
+		"TeamWelcome":   b.WelcomeText(lang),
+		"TeamSignature": b.Signature,
+		"TeamContact":   b.Contact,
+	} {
+		if text = sanitizeBrandingText(text); text != "" {
+			content[name] = text
+		}
+	}
+	return content
+}
+
+// sanitizeBrandingText escapes a plain text for the html emails, keeping its lines
+func sanitizeBrandingText(text string) string {
+	text = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if isForbiddenRune(r) {
+			return -1
+		}
+		return r
+	}, text))
+	// braces are escaped as well so the text never looks like a template action
+	escaped := strings.NewReplacer("{", "&#123;", "}", "&#125;").Replace(html.EscapeString(text))
+	lines := strings.Split(strings.ReplaceAll(escaped, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.Join(lines, "<br/>")
+}
+
+func isForbiddenRune(r rune) bool {
+	return unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t'
+}
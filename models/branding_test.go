@@ -0,0 +1,103 @@
+package models
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testLogo(t *testing.T, width, height int) []byte {
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("Failed to encode the logo: %s", err)
+	}
+	return buffer.Bytes()
+}
+
+func Test_TeamBranding_Validate(t *testing.T) {
+	branding := &TeamBranding{
+		Signature: "Dr. Who\nTardis clinic",
+		Contact:   "+33 1 23 45 67 89",
+		Welcome:   map[string]string{"en": "Welcome", "fr": "Bienvenue"},
+	}
+	if problems := branding.Validate(); len(problems) != 0 {
+		t.Fatalf("The branding should be valid, got %v", problems)
+	}
+
+	branding.Signature = strings.Repeat("a", MaxBrandingTextLength+1)
+	branding.Contact = "bell\a"
+	branding.Welcome["not a locale"] = "Hello"
+	expected := []string{
+		"signature is 501 characters long, the maximum is 500",
+		"contact contains control characters",
+		"welcome language not a locale is invalid",
+	}
+	if problems := branding.Validate(); !reflect.DeepEqual(problems, expected) {
+		t.Fatalf("Wrong problems, expecting %v but got %v", expected, problems)
+	}
+}
+
+func Test_TeamBranding_SetLogo(t *testing.T) {
+	branding := &TeamBranding{}
+	if err := branding.SetLogo(testLogo(t, 200, 100)); err != nil || branding.LogoType != "image/png" {
+		t.Fatalf("The png logo should be accepted, got %s (%v)", branding.LogoType, err)
+	}
+	tests := map[string][]byte{
+		"empty":    {},
+		"too big":  make([]byte, MaxLogoSize+1),
+		"svg":      []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`),
+		"too wide": testLogo(t, MaxLogoDimension+1, 10),
+	}
+	for name, logo := range tests {
+		if err := (&TeamBranding{}).SetLogo(logo); err == nil {
+			t.Fatalf("The %s logo should be rejected", name)
+		}
+	}
+}
+
+func Test_TeamBranding_WelcomeText(t *testing.T) {
+	branding := &TeamBranding{Welcome: map[string]string{"de": "Willkommen", "en": "Welcome", "fr": "Bienvenue"}}
+	tests := map[string]string{
+		"fr":    "Bienvenue",
+		"fr-CA": "Bienvenue",
+		"de-AT": "Willkommen",
+		"it":    "Welcome",
+		"":      "Welcome",
+	}
+	for lang, expected := range tests {
+		if text := branding.WelcomeText(lang); text != expected {
+			t.Fatalf("Wrong welcome text in %q, expecting %q but got %q", lang, expected, text)
+		}
+	}
+	if text := (&TeamBranding{Welcome: map[string]string{"nl": "Welkom"}}).WelcomeText("fr"); text != "Welkom" {
+		t.Fatalf("The only welcome text should be used, got %q", text)
+	}
+}
+
+func Test_TeamBranding_Content(t *testing.T) {
+	branding := &TeamBranding{
+		Signature: "  Dr. <b>Who</b>\r\n  Tardis & co  ",
+		Contact:   "{{ .WebURL }}",
+		Welcome:   map[string]string{"en": "Welcome"},
+	}
+	content := branding.Content("en", "https://api.example.com/confirm/branding/123/logo")
+	expected := map[string]interface{}{
+		"TeamSignature": "Dr. &lt;b&gt;Who&lt;/b&gt;<br/>Tardis &amp; co",
+		"TeamContact":   "&#123;&#123; .WebURL &#125;&#125;",
+		"TeamWelcome":   "Welcome",
+	}
+	if !reflect.DeepEqual(content, expected) {
+		t.Fatalf("Wrong branding content without logo, expecting %v but got %v", expected, content)
+	}
+
+	branding.Logo = testLogo(t, 10, 10)
+	if content = branding.Content("en", "https://api.example.com/confirm/branding/123/logo"); content["TeamLogoURL"] != "https://api.example.com/confirm/branding/123/logo" {
+		t.Fatalf("The logo url should be given, got %v", content)
+	}
+	if content = (&TeamBranding{}).Content("en", ""); len(content) != 0 {
+		t.Fatalf("An empty branding should not give any content, got %v", content)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 )
 
@@ -19,13 +20,27 @@ type (
 		Context   json.RawMessage `json:"context" bson:"context,omitempty" swaggertype:"string" format:"base64"`
 		Created   time.Time       `json:"created" bson:"created"`
 
-		TemplateName TemplateName `json:"-" bson:"templateName"`
-		UserId       string       `json:"userId" bson:"userId"`
-		Team         *Team        `json:"target" bson:",inline"`
-		Role         string       `json:"role" bson:"role"`
-		Status       Status       `json:"status" bson:"status"`
-		Modified     time.Time    `json:"-" bson:"modified"`
-		ShortKey     string       `json:"shortKey" bson:"shortKey"`
+		TemplateName    TemplateName   `json:"-" bson:"templateName"`
+		TemplateVersion int            `json:"-" bson:"templateVersion,omitempty"` // version of the template sent, 0 for the disk one
+		UserId          string         `json:"userId" bson:"userId"`
+		Team            *Team          `json:"target" bson:",inline"`
+		Role            string         `json:"role" bson:"role"`
+		Status          Status         `json:"status" bson:"status"`
+		Modified        time.Time      `json:"-" bson:"modified"`
+		ShortKey        string         `json:"shortKey" bson:"shortKey"`
+		EmailLower      string         `json:"-" bson:"emailLower"`         // canonical email, the one looked up
+		Revision        int            `json:"-" bson:"revision,omitempty"` // incremented by the store on each change
+		History         []StatusChange `json:"-" bson:"history,omitempty"`  // appended by the store on each transition
+	}
+
+	// StatusChange is a transition of the status of a confirmation, recorded in its history
+	StatusChange struct {
+		From         Status    `json:"from" bson:"from"`
+		To           Status    `json:"to" bson:"to"`
+		Time         time.Time `json:"time" bson:"time"`
+		Actor        string    `json:"actor" bson:"actor"` // user ID, or ActorServer
+		TraceSession string    `json:"traceSession,omitempty" bson:"traceSession,omitempty"`
+		Reason       string    `json:"reason,omitempty" bson:"reason,omitempty"`
 	}
 
 	Team struct {
@@ -69,6 +84,8 @@ const (
 	StatusCompleted Status = "completed"
 	StatusCanceled  Status = "canceled"
 	StatusDeclined  Status = "declined"
+	// ActorServer is the actor of the status changes made by the servers
+	ActorServer = "server"
 	//Available Type's
 	TypePasswordReset            Type = "password_reset"
 	TypePatientPasswordReset     Type = "patient_password_reset"
@@ -95,6 +112,21 @@ var (
 		TypeMedicalTeamInvite:        7 * 24 * time.Hour,
 		TypeMedicalTeamPatientInvite: 7 * 24 * time.Hour,
 	}
+	// DefaultTemplateNames are the templates sent for the confirmations stored without template name
+	// The sign up confirmations of the clinicians have their own template, the default one is the patients one
+	DefaultTemplateNames = map[Type]TemplateName{
+		TypePasswordReset:            TemplateNamePasswordReset,
+		TypePatientPasswordReset:     TemplateNamePatientPasswordReset,
+		TypePatientPasswordInfo:      TemplateNamePatientPasswordInfo,
+		TypeCareteamInvite:           TemplateNameCareteamInvite,
+		TypeMedicalTeamInvite:        TemplateNameMedicalteamInvite,
+		TypeMedicalTeamPatientInvite: TemplateNameMedicalteamPatientInvite,
+		TypeMedicalTeamDoAdmin:       TemplateNameMedicalteamDoAdmin,
+		TypeMedicalTeamRemove:        TemplateNameMedicalteamRemove,
+		TypeSignUp:                   TemplateNameSignup,
+		TypeNoAccount:                TemplateNameNoAccount,
+		TypeInformation:              TemplateNamePatientInformation,
+	}
 )
 
 //New confirmation with just the basics
@@ -163,6 +195,11 @@ func (c *Confirmation) DecodeContext(data interface{}) error {
 	return nil
 }
 
+// CanonicalEmail returns the form of an email the confirmations are looked up with, the emails being case insensitive
+func CanonicalEmail(email string) string {
+	return strings.ToLower(email)
+}
+
 //Set a new status and update the modified time
 func (c *Confirmation) UpdateStatus(newStatus Status) {
 	c.Status = newStatus
@@ -244,6 +281,15 @@ func (c *Confirmation) IsExpired() bool {
 	return time.Now().After(c.Created.Add(timeout))
 }
 
+// ExpiresAt returns the time after which the confirmation is expired, false when its type never expires
+func (c *Confirmation) ExpiresAt() (time.Time, bool) {
+	timeout, ok := Timeouts[c.Type]
+	if !ok {
+		return time.Time{}, false
+	}
+	return c.Created.Add(timeout), true
+}
+
 func (c *Confirmation) ResetKey() error {
 	key, err := generateKey()
 	if err != nil {
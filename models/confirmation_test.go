@@ -396,3 +396,9 @@ func TestConfirmationTeam(t *testing.T) {
 	}
 
 }
+
+func TestCanonicalEmail(t *testing.T) {
+	if email := CanonicalEmail("Jane.Doe@Example.COM"); email != "jane.doe@example.com" {
+		t.Fatalf("Wrong canonical email %s", email)
+	}
+}
@@ -0,0 +1,88 @@
+package models
+
+import "encoding/hex"
+
+// erasedDomain is the domain of the pseudonymous emails, reserved so it never receives an email
+const erasedDomain = "erased.invalid"
+
+// Pseudonym replaces the identifiers of an erased user in the confirmations and in the audit events
+// The same pseudonym is used for all the confirmations and events of the user so the statistics per user are kept,
+// it is random so it can't be traced back to the user
+type Pseudonym struct {
+	UserID    string
+	Email     string
+	EmailHash string // hash of the email in the audit events
+}
+
+// NewPseudonym returns random identifiers for a user being erased, the email being hashed with the key of the audit events
+func NewPseudonym(auditEmailKey string) (*Pseudonym, error) {
+	rb, err := GenerateRandomBytes(12)
+	if err != nil {
+		return nil, err
+	}
+	id := "erased-" + hex.EncodeToString(rb)
+	email := id + "@" + erasedDomain
+	return &Pseudonym{UserID: id, Email: email, EmailHash: HashEmail(auditEmailKey, email)}, nil
+}
+
+// Pseudonymize replaces the user ID and the emails of the user in the confirmation and in its history
+// and removes its context, the type, status, team and dates are kept
+// It tells if the confirmation involved the user, the email of another user invited by the user is kept
+func (c *Confirmation) Pseudonymize(userID string, emails []string, pseudonym *Pseudonym) bool {
+	if userID == "" {
+		return false
+	}
+	changed := false
+	if c.CreatorId == userID {
+		c.CreatorId = pseudonym.UserID
+		changed = true
+	}
+	if c.UserId == userID {
+		c.UserId = pseudonym.UserID
+		changed = true
+	}
+	for _, email := range emails {
+		if c.Email != "" && CanonicalEmail(email) == CanonicalEmail(c.Email) {
+			c.Email = pseudonym.Email
+			changed = true
+			break
+		}
+	}
+	for i := range c.History {
+		if c.History[i].Actor == userID {
+			c.History[i].Actor = pseudonym.UserID
+			changed = true
+		}
+	}
+	if changed {
+		c.EmailLower = CanonicalEmail(c.Email)
+		c.Context = nil
+		c.Creator = Creator{}
+	}
+	return changed
+}
+
+// Pseudonymize replaces the user ID and the hashes of the emails of the user in the audit event
+// It tells if the event involved the user
+func (e *AuditEvent) Pseudonymize(userID string, emailHashes []string, pseudonym *Pseudonym) bool {
+	if userID == "" {
+		return false
+	}
+	changed := false
+	if e.Actor == userID {
+		e.Actor = pseudonym.UserID
+		changed = true
+	}
+	if e.TargetUser == userID {
+		e.TargetUser = pseudonym.UserID
+		changed = true
+	}
+	for _, hash := range emailHashes {
+		if e.TargetEmailHash != "" && e.TargetEmailHash == hash {
+			e.TargetEmailHash = pseudonym.EmailHash
+			changed = true
+			break
+		}
+	}
+	return changed
+}
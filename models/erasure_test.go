@@ -0,0 +1,77 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_NewPseudonym(t *testing.T) {
+	pseudonym, err := NewPseudonym(testAuditKey)
+	if err != nil || !strings.HasPrefix(pseudonym.UserID, "erased-") || pseudonym.Email != pseudonym.UserID+"@"+erasedDomain ||
+		pseudonym.EmailHash != HashEmail(testAuditKey, pseudonym.Email) {
+		t.Fatalf("Wrong pseudonym %v - err [%v]", pseudonym, err)
+	}
+	if other, _ := NewPseudonym(testAuditKey); other.UserID == pseudonym.UserID {
+		t.Fatalf("Two pseudonyms should differ, got %v twice", other)
+	}
+}
+
+func Test_Confirmation_Pseudonymize(t *testing.T) {
+	pseudonym := &Pseudonym{UserID: "erased-1", Email: "erased-1@erased.invalid"}
+	sent := &Confirmation{Type: TypeCareteamInvite, CreatorId: "123", Email: "friend@example.com", Context: []byte(`{"note":"hello"}`), Status: StatusCompleted}
+	if !sent.Pseudonymize("123", []string{"me@example.com"}, pseudonym) {
+		t.Fatalf("The invite sent by the user should be pseudonymized")
+	}
+	if sent.CreatorId != pseudonym.UserID || sent.Email != "friend@example.com" || sent.Context != nil || sent.Status != StatusCompleted || sent.Type != TypeCareteamInvite {
+		t.Fatalf("Only the identifiers of the user and the context should change, got %v", sent)
+	}
+
+	received := &Confirmation{Type: TypeMedicalTeamInvite, CreatorId: "456", Email: "Me@Example.com", Team: &Team{ID: "team1"},
+		History: []StatusChange{{From: StatusPending, To: StatusDeclined, Actor: "123"}}}
+	if !received.Pseudonymize("123", []string{"me@example.com"}, pseudonym) {
+		t.Fatalf("The invite received by the user should be pseudonymized")
+	}
+	if received.Email != pseudonym.Email || received.EmailLower != pseudonym.Email || received.CreatorId != "456" || received.History[0].Actor != pseudonym.UserID || received.Team.ID != "team1" {
+		t.Fatalf("The email and the actor of the user should be replaced, got %v", received)
+	}
+
+	reset := &Confirmation{Type: TypePasswordReset, Email: "other@example.com"}
+	if reset.Pseudonymize("123", []string{"me@example.com"}, pseudonym) || reset.Pseudonymize("", []string{""}, pseudonym) {
+		t.Fatalf("A confirmation not involving the user should not change, got %v", reset)
+	}
+}
+
+func Test_AuditEvent_Pseudonymize(t *testing.T) {
+	pseudonym := &Pseudonym{UserID: "erased-1", Email: "erased-1@erased.invalid", EmailHash: HashEmail(testAuditKey, "erased-1@erased.invalid")}
+	hashes := []string{HashEmail(testAuditKey, "me@example.com")}
+	tests := []struct {
+		event    AuditEvent
+		changed  bool
+		expected AuditEvent
+	}{
+		{
+			event:    AuditEvent{Actor: "123", Action: AuditInviteSent, TargetTeam: "team1", TargetEmailHash: HashEmail(testAuditKey, "friend@example.com")},
+			changed:  true,
+			expected: AuditEvent{Actor: "erased-1", Action: AuditInviteSent, TargetTeam: "team1", TargetEmailHash: HashEmail(testAuditKey, "friend@example.com")},
+		},
+		{
+			event:    AuditEvent{Actor: ActorServer, Action: AuditInviteSent, TargetUser: "123", TargetEmailHash: HashEmail(testAuditKey, "Me@Example.com")},
+			changed:  true,
+			expected: AuditEvent{Actor: ActorServer, Action: AuditInviteSent, TargetUser: "erased-1", TargetEmailHash: pseudonym.EmailHash},
+		},
+		{
+			event:    AuditEvent{Actor: "456", Action: AuditInviteAccepted, TargetUser: "456"},
+			changed:  false,
+			expected: AuditEvent{Actor: "456", Action: AuditInviteAccepted, TargetUser: "456"},
+		},
+	}
+	for idx, test := range tests {
+		event := test.event
+		if changed := event.Pseudonymize("123", hashes, pseudonym); changed != test.changed || event != test.expected {
+			t.Fatalf("TestId `%d` expected %t %v actual %t %v", idx, test.changed, test.expected, changed, event)
+		}
+	}
+	if event := (AuditEvent{Actor: ""}); event.Pseudonymize("", hashes, pseudonym) {
+		t.Fatalf("An empty user ID should not match, got %v", event)
+	}
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type (
+	// ExportRelation tells how an exported confirmation relates to the user
+	ExportRelation string
+
+	// UserExport is the data held about a user: every confirmation the user created, was invited by
+	// or is the subject of, the latest first
+	UserExport struct {
+		UserID        string                 `json:"userId"`
+		Emails        []string               `json:"emails"`
+		Exported      time.Time              `json:"exported"`
+		Confirmations []ExportedConfirmation `json:"confirmations"`
+	}
+
+	// ExportedConfirmation is a confirmation as stored, with its context decoded
+	// The key is left out, it still grants the confirmation to whoever holds it
+	ExportedConfirmation struct {
+		Relations       []ExportRelation `json:"relations"`
+		Type            Type             `json:"type"`
+		Status          Status           `json:"status"`
+		Email           string           `json:"email,omitempty"`
+		CreatorID       string           `json:"creatorId,omitempty"`
+		UserID          string           `json:"userId,omitempty"`
+		TeamID          string           `json:"teamId,omitempty"`
+		Role            string           `json:"role,omitempty"`
+		TemplateName    TemplateName     `json:"templateName,omitempty"`
+		TemplateVersion int              `json:"templateVersion,omitempty"`
+		Context         interface{}      `json:"context,omitempty"`
+		Created         time.Time        `json:"created"`
+		Modified        time.Time        `json:"modified,omitempty"`
+		History         []StatusChange   `json:"history,omitempty"`
+	}
+)
+
+const (
+	//Available export relations
+	ExportRelationCreator ExportRelation = "creator" // the user sent the invite
+	ExportRelationInvitee ExportRelation = "invitee" // the user received the invite, by user ID or email
+	ExportRelationSubject ExportRelation = "subject" // the reset, sign up or information is about the user
+)
+
+// isSentToOther tells if the confirmations of the type are sent by their creator to another user: the invites and the team notifications
+func isSentToOther(confirmationType Type) bool {
+	switch confirmationType {
+	case TypeCareteamInvite, TypeMedicalTeamInvite, TypeMedicalTeamPatientInvite, TypeMedicalTeamDoAdmin, TypeMedicalTeamRemove:
+		return true
+	}
+	return false
+}
+
+// NewUserExport returns the export of the confirmations of the user known by these emails
+// The context which is not json is exported as a string
+func NewUserExport(userID string, emails []string, confirmations []*Confirmation) *UserExport {
+	export := &UserExport{UserID: userID, Emails: emails, Exported: time.Now(), Confirmations: []ExportedConfirmation{}}
+	if export.Emails == nil {
+		export.Emails = []string{}
+	}
+	canonical := make(map[string]bool, len(emails))
+	for _, email := range emails {
+		canonical[CanonicalEmail(email)] = true
+	}
+	for _, c := range confirmations {
+		exported := ExportedConfirmation{
+			Type:            c.Type,
+			Status:          c.Status,
+			Email:           c.Email,
+			CreatorID:       c.CreatorId,
+			UserID:          c.UserId,
+			Role:            c.Role,
+			TemplateName:    c.TemplateName,
+			TemplateVersion: c.TemplateVersion,
+			Created:         c.Created,
+			Modified:        c.Modified,
+			History:         c.History,
+		}
+		if c.Team != nil {
+			exported.TeamID = c.Team.ID
+		}
+		if len(c.Context) > 0 {
+			if err := json.Unmarshal(c.Context, &exported.Context); err != nil {
+				exported.Context = string(c.Context)
+			}
+		}
+		isRecipient := c.UserId == userID || (c.Email != "" && canonical[CanonicalEmail(c.Email)])
+		if !isSentToOther(c.Type) {
+			// the resets and pin resets are created by the user they are about
+			if isRecipient || c.CreatorId == userID {
+				exported.Relations = append(exported.Relations, ExportRelationSubject)
+			}
+		} else {
+			if c.CreatorId == userID {
+				exported.Relations = append(exported.Relations, ExportRelationCreator)
+			}
+			if isRecipient {
+				exported.Relations = append(exported.Relations, ExportRelationInvitee)
+			}
+		}
+		if len(exported.Relations) > 0 {
+			export.Confirmations = append(export.Confirmations, exported)
+		}
+	}
+	return export
+}
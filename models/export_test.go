@@ -0,0 +1,51 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_NewUserExport(t *testing.T) {
+	sent := &Confirmation{Key: "sent.key", Type: TypeCareteamInvite, CreatorId: "123", Email: "friend@example.com"}
+	received := &Confirmation{Key: "received.key", Type: TypeMedicalTeamInvite, CreatorId: "456", Email: "Me@Example.com", Team: &Team{ID: "team1"}}
+	accepted := &Confirmation{Key: "accepted.key", Type: TypeCareteamInvite, CreatorId: "456", Email: "old@example.com", UserId: "123"}
+	pinReset := &Confirmation{Key: "pin.key", Type: TypePatientPinReset, CreatorId: "123", Context: []byte(`{"OTP":"123456"}`)}
+	reset := &Confirmation{Key: "reset.key", Type: TypePasswordReset, Email: "me@example.com", Context: []byte(`not json`)}
+	unrelated := &Confirmation{Key: "other.key", Type: TypeCareteamInvite, CreatorId: "456", Email: "someone@example.com"}
+
+	export := NewUserExport("123", []string{"me@example.com"}, []*Confirmation{sent, received, accepted, pinReset, reset, unrelated})
+	if export.UserID != "123" || export.Exported.IsZero() || len(export.Confirmations) != 5 {
+		t.Fatalf("The confirmations of the user should be exported, got %v", export)
+	}
+	expected := [][]ExportRelation{
+		{ExportRelationCreator},
+		{ExportRelationInvitee},
+		{ExportRelationInvitee},
+		{ExportRelationSubject},
+		{ExportRelationSubject},
+	}
+	for i, relations := range expected {
+		if !reflect.DeepEqual(export.Confirmations[i].Relations, relations) {
+			t.Fatalf("Wrong relations of %v, expecting %v", export.Confirmations[i], relations)
+		}
+	}
+	if export.Confirmations[1].TeamID != "team1" {
+		t.Fatalf("The team of the invite should be exported, got %v", export.Confirmations[1])
+	}
+	if otp, ok := export.Confirmations[3].Context.(map[string]interface{}); !ok || otp["OTP"] != "123456" {
+		t.Fatalf("The context should be decoded, got %v", export.Confirmations[3].Context)
+	}
+	if export.Confirmations[4].Context != "not json" {
+		t.Fatalf("The context which is not json should be exported as a string, got %v", export.Confirmations[4].Context)
+	}
+	encoded, _ := json.Marshal(export)
+	if strings.Contains(string(encoded), ".key") {
+		t.Fatalf("The confirmation keys should not be exported, got %s", encoded)
+	}
+
+	if empty := NewUserExport("123", nil, nil); empty.Emails == nil || empty.Confirmations == nil {
+		t.Fatalf("An empty export should have empty lists, got %v", empty)
+	}
+}
@@ -4,8 +4,15 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"math"
+	"net/mail"
+	"net/url"
+	"sort"
 	"strconv"
+	"strings"
 	"text/template"
+	"text/template/parse"
+	"time"
 
 	"github.com/mdblp/hydrophone/localize"
 )
@@ -36,12 +43,69 @@ const (
 	TemplateNameUndefined                TemplateName = ""
 )
 
+// VariableType is the type of the value expected for a template variable
+type VariableType string
+
+const (
+	VariableTypeString VariableType = "string"
+	VariableTypeURL    VariableType = "url"
+	VariableTypeNumber VariableType = "number"
+	VariableTypeDate   VariableType = "date"
+	VariableTypeGender VariableType = "gender"
+	VariableTypeAny    VariableType = "any"
+)
+
+// TemplateVariable is a variable declared by a template
+// Required variables must be part of the content given to Execute, optional ones may be omitted
+// Dates and numbers are formatted in the language of the email
+// The number variable flagged as plural selects the plural form of the messages, the gender variable selects their gendered variant
+type TemplateVariable struct {
+	Name     string       `json:"name"`
+	Type     VariableType `json:"type"`
+	Required bool         `json:"required"`
+	Plural   bool         `json:"plural,omitempty"`
+}
+
+// TemplateSender is the optional sender identity of a template
+// Name is the key of the sender display name in the locale files, it may use the escape parts (e.g. "{{ .CreatorName }} via YourLoops")
+// Address overrides the sender address of the brand, ReplyTo is the string variable holding the address the replies are sent to
+type TemplateSender struct {
+	Name    string `json:"name,omitempty" bson:"name,omitempty"`
+	Address string `json:"address,omitempty" bson:"address,omitempty"`
+	ReplyTo string `json:"replyTo,omitempty" bson:"replyTo,omitempty"`
+}
+
+// ContentError is returned by Execute when the content does not match the declared variables
+type ContentError struct {
+	Template TemplateName
+	Missing  []string
+	Unknown  []string
+	Invalid  []string
+}
+
+func (e *ContentError) Error() string {
+	var problems []string
+	if len(e.Missing) > 0 {
+		problems = append(problems, "missing "+strings.Join(e.Missing, ", "))
+	}
+	if len(e.Unknown) > 0 {
+		problems = append(problems, "unknown "+strings.Join(e.Unknown, ", "))
+	}
+	if len(e.Invalid) > 0 {
+		problems = append(problems, "invalid "+strings.Join(e.Invalid, ", "))
+	}
+	return fmt.Sprintf("models: invalid content for template %s: %s", strconv.Quote(e.Template.String()), strings.Join(problems, "; "))
+}
+
 type Template interface {
 	Name() TemplateName
 	Execute(content interface{}, lang string) (string, string, error)
 	ContentParts() []string
 	EscapeParts() []string
+	Variables() []TemplateVariable
 	Subject() string
+	Sender() TemplateSender
+	ExecuteSender(content map[string]interface{}, lang string) (string, string, error)
 }
 
 type Templates map[TemplateName]Template
@@ -53,11 +117,13 @@ type PrecompiledTemplate struct {
 	contentParts       []string
 	subject            string
 	escapeParts        []string
+	variables          []TemplateVariable
+	sender             TemplateSender
 	localizer          localize.Localizer
 }
 
 // NewPrecompiledTemplate creates a new pre-compiled template
-func NewPrecompiledTemplate(name TemplateName, subjectTemplate string, bodyTemplate string, contentParts []string, escapeParts []string, localizer localize.Localizer) (*PrecompiledTemplate, error) {
+func NewPrecompiledTemplate(name TemplateName, subjectTemplate string, bodyTemplate string, contentParts []string, escapeParts []string, variables []TemplateVariable, localizer localize.Localizer) (*PrecompiledTemplate, error) {
 	if name == TemplateNameUndefined {
 		return nil, errors.New("models: name is missing")
 	}
@@ -80,6 +146,14 @@ func NewPrecompiledTemplate(name TemplateName, subjectTemplate string, bodyTempl
 		return nil, errors.New("escapeParts is missing or null")
 	}
 
+	if variables == nil {
+		return nil, errors.New("variables is missing or null")
+	}
+
+	if err := checkVariables(variables, contentParts, escapeParts); err != nil {
+		return nil, err
+	}
+
 	precompiledSubject, err := template.New(name.String()).Parse(subjectTemplate)
 	if err != nil {
 		return nil, fmt.Errorf("models: failure to precompile subject template: %s", err)
@@ -90,6 +164,10 @@ func NewPrecompiledTemplate(name TemplateName, subjectTemplate string, bodyTempl
 		return nil, fmt.Errorf("models: failure to precompile body template: %s", err)
 	}
 
+	if err := checkReferences(precompiledBody, variables, contentParts); err != nil {
+		return nil, err
+	}
+
 	return &PrecompiledTemplate{
 		name:               name,
 		precompiledSubject: precompiledSubject,
@@ -97,6 +175,7 @@ func NewPrecompiledTemplate(name TemplateName, subjectTemplate string, bodyTempl
 		subject:            subjectTemplate,
 		contentParts:       contentParts,
 		escapeParts:        escapeParts,
+		variables:          variables,
 		localizer:          localizer,
 	}, nil
 }
@@ -123,13 +202,75 @@ func (p *PrecompiledTemplate) EscapeParts() []string {
 	return p.escapeParts
 }
 
+// Variables returns the variables declared by the template
+// The content given to Execute must provide the required ones and cannot hold undeclared ones
+func (p *PrecompiledTemplate) Variables() []TemplateVariable {
+	return p.variables
+}
+
+// Sender returns the sender identity declared by the template, empty when the brand one is used
+func (p *PrecompiledTemplate) Sender() TemplateSender {
+	return p.sender
+}
+
+// SetSender declares the sender identity of the template, the reply-to variable must be a declared string
+func (p *PrecompiledTemplate) SetSender(sender TemplateSender) error {
+	if sender.Address != "" {
+		if _, err := mail.ParseAddress(sender.Address); err != nil {
+			return fmt.Errorf("models: sender address %s is invalid", strconv.Quote(sender.Address))
+		}
+	}
+	if sender.ReplyTo != "" {
+		declared := false
+		for _, v := range p.variables {
+			if v.Name == sender.ReplyTo {
+				declared = v.Type == VariableTypeString
+			}
+		}
+		if !declared {
+			return fmt.Errorf("models: reply-to %s is not a declared string variable", strconv.Quote(sender.ReplyTo))
+		}
+	}
+	p.sender = sender
+	return nil
+}
+
+// ExecuteSender returns the sender display name localized in the language and the reply-to address found in the content
+// They are empty when the template does not declare them or when the content has no reply-to address
+func (p *PrecompiledTemplate) ExecuteSender(content map[string]interface{}, lang string) (string, string, error) {
+	var name, replyTo string
+	if err := p.ValidateContent(content); err != nil {
+		return "", "", err
+	}
+	if p.sender.Name != "" {
+		var err error
+		if name, err = p.localizer.Localize(p.sender.Name, lang, p.fillEscapedParts(p.formatContent(content, lang))); err != nil {
+			return "", "", fmt.Errorf("models: failure to localize the sender of %s: %s", strconv.Quote(p.name.String()), err)
+		}
+	}
+	if p.sender.ReplyTo != "" {
+		replyTo, _ = content[p.sender.ReplyTo].(string)
+	}
+	return name, replyTo, nil
+}
+
 // Execute compiles the pre-compiled template with provided content
 func (p *PrecompiledTemplate) Execute(content interface{}, lang string) (string, string, error) {
 
 	var bodyBuffer bytes.Buffer
 	var subject string
 	var err error
-	p.fillAndLocalize(lang, content.(map[string]interface{}))
+
+	// The content is validated then copied so the caller map is not filled with the localized parts
+	values := content.(map[string]interface{})
+	if err = p.ValidateContent(values); err != nil {
+		return "", "", err
+	}
+	content = p.formatContent(values, lang)
+
+	if err = p.fillAndLocalize(lang, content.(map[string]interface{})); err != nil {
+		return "", "", fmt.Errorf("models: failure to localize template %s: %s", strconv.Quote(p.name.String()), err)
+	}
 
 	if subject, err = p.fillAndLocalizeSubject(lang, content.(map[string]interface{})); err != nil {
 		return "", "", fmt.Errorf("models: failure to generate subject %s", strconv.Quote(p.name.String()))
@@ -142,17 +283,95 @@ func (p *PrecompiledTemplate) Execute(content interface{}, lang string) (string,
 	return subject, bodyBuffer.String(), nil
 }
 
+// ValidateContent checks the content against the declared variables
+// It returns a *ContentError listing the missing, unknown and badly typed variables
+func (p *PrecompiledTemplate) ValidateContent(content map[string]interface{}) error {
+	contentErr := &ContentError{Template: p.name}
+	declared := make(map[string]bool, len(p.variables))
+	for _, v := range p.variables {
+		declared[v.Name] = true
+		value, ok := content[v.Name]
+		if !ok {
+			if v.Required {
+				contentErr.Missing = append(contentErr.Missing, v.Name)
+			}
+			continue
+		}
+		if !v.Type.accepts(value) {
+			contentErr.Invalid = append(contentErr.Invalid, v.Name)
+		}
+	}
+	for k := range content {
+		if !declared[k] {
+			contentErr.Unknown = append(contentErr.Unknown, k)
+		}
+	}
+	if len(contentErr.Missing)+len(contentErr.Unknown)+len(contentErr.Invalid) == 0 {
+		return nil
+	}
+	sort.Strings(contentErr.Unknown)
+	return contentErr
+}
+
+// formatContent returns a copy of the content with dates and numbers formatted for the language
+// The plural count and the gender are kept raw under the localize reserved keys, with the locale the email is rendered in
+func (p *PrecompiledTemplate) formatContent(content map[string]interface{}, lang string) map[string]interface{} {
+	values := make(map[string]interface{}, len(content)+len(p.contentParts))
+	for k, v := range content {
+		values[k] = v
+	}
+	values[localize.LocaleKey] = p.localizer.Match(lang).String()
+	for _, v := range p.variables {
+		value, ok := content[v.Name]
+		if !ok {
+			continue
+		}
+		switch {
+		case v.Plural:
+			values[localize.PluralCountKey] = pluralCount(value)
+		case v.Type == VariableTypeGender:
+			values[localize.GenderKey] = value
+		}
+		switch v.Type {
+		case VariableTypeDate:
+			values[v.Name] = localize.FormatDate(value.(time.Time), lang)
+		case VariableTypeNumber:
+			values[v.Name] = localize.FormatNumber(value, lang)
+		}
+	}
+	return values
+}
+
+// pluralCount returns the count used to select a plural form
+// Whole floats (e.g. numbers decoded from json) are given as integers, the localizer only accepts formatted floats
+func pluralCount(value interface{}) interface{} {
+	switch n := value.(type) {
+	case float64:
+		if n == math.Trunc(n) {
+			return int64(n)
+		}
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	case float32:
+		return pluralCount(float64(n))
+	}
+	return value
+}
+
 // fillAndLocalize fills the template content parts based on language bundle and locale
 // A template content/body is made of HTML tags and content that can be localized
 // Each template references its parts that can be filled in a collection called ContentParts
-func (p *PrecompiledTemplate) fillAndLocalize(locale string, content map[string]interface{}) {
+func (p *PrecompiledTemplate) fillAndLocalize(locale string, content map[string]interface{}) error {
 	contextParts := p.fillEscapedParts(content)
 	// Get content parts from the template
 	for _, v := range p.ContentParts() {
 		// Each part is translated in the requested locale and added to the Content collection
-		contentItem, _ := p.localizer.Localize(v, locale, contextParts)
+		contentItem, err := p.localizer.Localize(v, locale, contextParts)
+		if err != nil {
+			return err
+		}
 		content[v] = contentItem
 	}
+	return nil
 }
 
 func (p *PrecompiledTemplate) fillAndLocalizeSubject(locale string, content map[string]interface{}) (string, error) {
@@ -171,6 +390,173 @@ func (p *PrecompiledTemplate) fillEscapedParts(content map[string]interface{}) m
 			escape[v] = content[v]
 		}
 	}
+	// The plural count and gender select the message form
+	for _, k := range []string{localize.PluralCountKey, localize.GenderKey} {
+		if value, ok := content[k]; ok {
+			escape[k] = value
+		}
+	}
 
 	return escape
 }
+
+// accepts reports whether the value can be used for a variable of this type
+func (t VariableType) accepts(value interface{}) bool {
+	switch t {
+	case VariableTypeAny:
+		return true
+	case VariableTypeNumber:
+		switch value.(type) {
+		case int, int32, int64, uint, uint32, uint64, float32, float64:
+			return true
+		}
+		return false
+	case VariableTypeDate:
+		_, ok := value.(time.Time)
+		return ok
+	case VariableTypeGender:
+		switch value {
+		case "female", "male", "other":
+			return true
+		}
+		return false
+	}
+	str, ok := value.(string)
+	if !ok {
+		return false
+	}
+	switch t {
+	case VariableTypeURL:
+		u, err := url.Parse(str)
+		return err == nil && u.Scheme != ""
+	}
+	return t == VariableTypeString
+}
+
+// JSONValue converts a value decoded from json to the type expected by a variable of this type
+// Dates are given as RFC 3339 strings or as YYYY-MM-DD, other values are kept as decoded
+func (t VariableType) JSONValue(value interface{}) interface{} {
+	str, ok := value.(string)
+	if !ok || t != VariableTypeDate {
+		return value
+	}
+	if date, err := time.Parse(time.RFC3339, str); err == nil {
+		return date
+	}
+	if date, err := time.Parse("2006-01-02", str); err == nil {
+		return date
+	}
+	return value
+}
+
+// checkVariables validates the variables declaration against the template parts
+func checkVariables(variables []TemplateVariable, contentParts []string, escapeParts []string) error {
+	declared := make(map[string]bool, len(variables))
+	plurals, genders := 0, 0
+	for _, v := range variables {
+		if v.Name == "" {
+			return errors.New("models: variable name is missing")
+		}
+		switch v.Type {
+		case VariableTypeString, VariableTypeURL, VariableTypeNumber, VariableTypeDate, VariableTypeAny:
+		case VariableTypeGender:
+			if genders++; genders > 1 {
+				return errors.New("models: only one gender variable can be declared")
+			}
+		default:
+			return fmt.Errorf("models: variable %s has unknown type %s", strconv.Quote(v.Name), strconv.Quote(string(v.Type)))
+		}
+		if v.Plural {
+			if v.Type != VariableTypeNumber {
+				return fmt.Errorf("models: plural variable %s is not a number", strconv.Quote(v.Name))
+			}
+			if plurals++; plurals > 1 {
+				return errors.New("models: only one plural variable can be declared")
+			}
+		}
+		if v.Name == localize.PluralCountKey || v.Name == localize.GenderKey || v.Name == localize.LocaleKey {
+			return fmt.Errorf("models: variable name %s is reserved", strconv.Quote(v.Name))
+		}
+		if declared[v.Name] {
+			return fmt.Errorf("models: variable %s is declared twice", strconv.Quote(v.Name))
+		}
+		declared[v.Name] = true
+	}
+	for _, part := range contentParts {
+		if declared[part] {
+			return fmt.Errorf("models: variable %s is also a content part", strconv.Quote(part))
+		}
+	}
+	for _, part := range escapeParts {
+		if !declared[part] {
+			return fmt.Errorf("models: escape part %s is not a declared variable", strconv.Quote(part))
+		}
+	}
+	return nil
+}
+
+// checkReferences ensures the body only references declared variables, content parts and the locale
+func checkReferences(body *template.Template, variables []TemplateVariable, contentParts []string) error {
+	known := map[string]bool{localize.LocaleKey: true}
+	for _, v := range variables {
+		known[v.Name] = true
+	}
+	for _, part := range contentParts {
+		known[part] = true
+	}
+	var unknown []string
+	walkFields(body.Tree.Root, func(field string) {
+		if !known[field] {
+			known[field] = true
+			unknown = append(unknown, field)
+		}
+	})
+	if len(unknown) > 0 {
+		return fmt.Errorf("models: body template references undeclared variables %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// walkFields calls fn with the first identifier of every field (e.g. .Email) used in the node
+func walkFields(node parse.Node, fn func(string)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walkFields(child, fn)
+		}
+	case *parse.ActionNode:
+		walkFields(n.Pipe, fn)
+	case *parse.IfNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.RangeNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.WithNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.TemplateNode:
+		walkFields(n.Pipe, fn)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			walkFields(cmd, fn)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			walkFields(arg, fn)
+		}
+	case *parse.ChainNode:
+		walkFields(n.Node, fn)
+	case *parse.FieldNode:
+		fn(n.Ident[0])
+	}
+}
+
+func walkBranch(n *parse.BranchNode, fn func(string)) {
+	walkFields(n.Pipe, fn)
+	walkFields(n.List, fn)
+	walkFields(n.ElseList, fn)
+}
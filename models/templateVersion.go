@@ -0,0 +1,55 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// TemplateVersion is a version of an email template uploaded at runtime
+// It holds the same meta as the template files, its html body and the locale files overriding the loaded messages
+// Once activated, it is sent instead of the template loaded from disk, which remains the baseline (version 0)
+// The mongo store holds the active version of each template apart, Active is then set when the versions are read
+type TemplateVersion struct {
+	ID                 string             `json:"id" bson:"_id"`
+	Template           TemplateName       `json:"template" bson:"template"`
+	Version            int                `json:"version" bson:"version"`
+	Description        string             `json:"description" bson:"description"`
+	Subject            string             `json:"subject" bson:"subject"`
+	HTML               string             `json:"html" bson:"html"`
+	ContentParts       []string           `json:"contentParts" bson:"contentParts"`
+	EscapeContentParts []string           `json:"escapeContentParts" bson:"escapeContentParts"`
+	Variables          []TemplateVariable `json:"variables" bson:"variables"`
+	Sender             TemplateSender     `json:"sender" bson:"sender"`
+	Locales            map[string]string  `json:"locales,omitempty" bson:"locales,omitempty"`
+	Active             bool               `json:"active" bson:"active,omitempty"`
+	RolledBack         bool               `json:"rolledBack" bson:"rolledBack"`
+	Created            time.Time          `json:"created" bson:"created"`
+	Activated          time.Time          `json:"activated,omitempty" bson:"activated,omitempty"`
+}
+
+// TemplateVersionID returns the key of a template version
+func TemplateVersionID(name TemplateName, version int) string {
+	return fmt.Sprintf("%s:%d", name, version)
+}
+
+// RollbackTarget returns the active version and the version to activate when rolling it back
+// The target is the last activated version which has not been rolled back itself, nil meaning the disk baseline
+func RollbackTarget(versions []*TemplateVersion) (current *TemplateVersion, target *TemplateVersion) {
+	for _, v := range versions {
+		if v.Active {
+			current = v
+		}
+	}
+	if current == nil {
+		return nil, nil
+	}
+	for _, v := range versions {
+		if v == current || v.RolledBack || v.Activated.IsZero() {
+			continue
+		}
+		if target == nil || v.Activated.After(target.Activated) {
+			target = v
+		}
+	}
+	return current, target
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_RollbackTarget(t *testing.T) {
+	now := time.Now()
+	v1 := &TemplateVersion{Version: 1, Activated: now.Add(-3 * time.Hour)}
+	v2 := &TemplateVersion{Version: 2, Activated: now.Add(-2 * time.Hour), RolledBack: true}
+	v3 := &TemplateVersion{Version: 3, Activated: now.Add(-1 * time.Hour), Active: true}
+	v4 := &TemplateVersion{Version: 4}
+
+	current, target := RollbackTarget([]*TemplateVersion{v4, v3, v2, v1})
+	if current != v3 || target != v1 {
+		t.Fatalf("Rolling back version 3 should activate version 1, got %v and %v", current, target)
+	}
+
+	v1.RolledBack = true
+	if current, target = RollbackTarget([]*TemplateVersion{v4, v3, v2, v1}); current != v3 || target != nil {
+		t.Fatalf("Rolling back version 3 should go back to the baseline, got %v and %v", current, target)
+	}
+
+	v3.Active = false
+	if current, target = RollbackTarget([]*TemplateVersion{v4, v3, v2, v1}); current != nil || target != nil {
+		t.Fatalf("There should be nothing to roll back without an active version, got %v and %v", current, target)
+	}
+}
+
+func Test_TemplateVersionID(t *testing.T) {
+	if id := TemplateVersionID(TemplateNameSignup, 3); id != "signup_confirmation:3" {
+		t.Fatalf("Wrong template version id %s", id)
+	}
+}
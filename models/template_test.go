@@ -2,6 +2,7 @@ package models
 
 import (
 	"testing"
+	"time"
 
 	"github.com/mdblp/hydrophone/localize"
 )
@@ -31,6 +32,7 @@ var (
 
 	contentPart = []string{"Key"}
 	espacePart  = []string{"Username"}
+	variables   = []TemplateVariable{{Name: "Username", Type: VariableTypeString, Required: true}}
 )
 
 const (
@@ -50,53 +52,53 @@ func assertFailure(t *testing.T, template *PrecompiledTemplate, err error, expec
 
 func Test_NewPrecompiledTemplate_NameMissing(t *testing.T) {
 	expectedError := "models: name is missing"
-	tmpl, err := NewPrecompiledTemplate("", subjectSuccessTemplate, bodySuccessTemplate, contentPart, espacePart, localizer)
+	tmpl, err := NewPrecompiledTemplate("", subjectSuccessTemplate, bodySuccessTemplate, contentPart, espacePart, variables, localizer)
 	assertFailure(t, tmpl, err, expectedError)
 }
 
 func Test_NewPrecompiledTemplate_SubjectTemplateMissing(t *testing.T) {
 	expectedError := "models: subject template is missing"
-	tmpl, err := NewPrecompiledTemplate(name, "", bodySuccessTemplate, contentPart, espacePart, localizer)
+	tmpl, err := NewPrecompiledTemplate(name, "", bodySuccessTemplate, contentPart, espacePart, variables, localizer)
 	assertFailure(t, tmpl, err, expectedError)
 }
 
 func Test_NewPrecompiledTemplate_BodyTemplateMissing(t *testing.T) {
 	expectedError := "models: body template is missing"
-	tmpl, err := NewPrecompiledTemplate(name, subjectSuccessTemplate, "", contentPart, espacePart, localizer)
+	tmpl, err := NewPrecompiledTemplate(name, subjectSuccessTemplate, "", contentPart, espacePart, variables, localizer)
 	assertFailure(t, tmpl, err, expectedError)
 }
 
 func Test_NewPrecompiledTemplate_LocalizerMissing(t *testing.T) {
 	expectedError := "localizer is missing or null"
-	tmpl, err := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, contentPart, espacePart, nil)
+	tmpl, err := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, contentPart, espacePart, variables, nil)
 	assertFailure(t, tmpl, err, expectedError)
 }
 
 func Test_NewPrecompiledTemplate_ContentPartsMissing(t *testing.T) {
 	expectedError := "contentParts is missing or null"
-	tmpl, err := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, nil, espacePart, localizer)
+	tmpl, err := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, nil, espacePart, variables, localizer)
 	assertFailure(t, tmpl, err, expectedError)
 }
 
 func Test_NewPrecompiledTemplate_TokensMissing(t *testing.T) {
 	expectedError := "escapeParts is missing or null"
-	tmpl, err := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, contentPart, nil, localizer)
+	tmpl, err := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, contentPart, nil, variables, localizer)
 	assertFailure(t, tmpl, err, expectedError)
 }
 func Test_NewPrecompiledTemplate_SubjectTemplateNotPrecompiled(t *testing.T) {
 	expectedError := "models: failure to precompile subject template: template: test:1: unexpected EOF"
-	tmpl, err := NewPrecompiledTemplate(name, subjectFailureTemplate, bodySuccessTemplate, contentPart, espacePart, localizer)
+	tmpl, err := NewPrecompiledTemplate(name, subjectFailureTemplate, bodySuccessTemplate, contentPart, espacePart, variables, localizer)
 	assertFailure(t, tmpl, err, expectedError)
 }
 
 func Test_NewPrecompiledTemplate_BodyTemplateNotPrecompiled(t *testing.T) {
 	expectedError := "models: failure to precompile body template: template: test:1: unexpected EOF"
-	tmpl, err := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodyFailureTemplate, contentPart, espacePart, localizer)
+	tmpl, err := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodyFailureTemplate, contentPart, espacePart, variables, localizer)
 	assertFailure(t, tmpl, err, expectedError)
 }
 
 func Test_NewPrecompiledTemplate_Success(t *testing.T) {
-	tmpl, err := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, contentPart, espacePart, localizer)
+	tmpl, err := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, contentPart, espacePart, variables, localizer)
 	if err != nil {
 		t.Fatalf(`Error is "%s", but should be nil`, err)
 	}
@@ -106,7 +108,7 @@ func Test_NewPrecompiledTemplate_Success(t *testing.T) {
 }
 
 func Test_NewPrecompiledTemplate_Name(t *testing.T) {
-	tmpl, _ := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, contentPart, espacePart, localizer)
+	tmpl, _ := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, contentPart, espacePart, variables, localizer)
 	if tmpl.Name() != name {
 		t.Fatalf(`Name is "%s", but should be "%s"`, tmpl.Name(), name)
 	}
@@ -117,7 +119,7 @@ func Test_NewPrecompiledTemplate_ExecuteSuccess(t *testing.T) {
 	content["Username"] = "Test User"
 	expectedSubject := `Username is 'Test User'`
 	expectedBody := `Key is '123.blah.456.blah'`
-	tmpl, _ := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, contentPart, espacePart, localizer)
+	tmpl, _ := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, contentPart, espacePart, variables, localizer)
 	subject, body, err := tmpl.Execute(content, "en")
 	if err != nil {
 		t.Fatalf(`Error is "%s", but should be nil`, err)
@@ -134,9 +136,217 @@ func Test_NewPrecompiledTemplate_ExecuteFailure(t *testing.T) {
 	content := make(map[string]interface{})
 	content["Username2"] = "Test User"
 	// Should fail if the subject cannot be localized
-	tmpl, _ := NewPrecompiledTemplate(name, "subject2", bodySuccessTemplate, contentPart, espacePart, localizer)
+	tmpl, _ := NewPrecompiledTemplate(name, "subject2", bodySuccessTemplate, contentPart, espacePart, variables, localizer)
 	_, _, err := tmpl.Execute(content, "en")
 	if err == nil {
 		t.Fatalf(`Error should be "%s", but is nil`, "models: failure to generate subject \"test\"")
 	}
 }
+
+func Test_NewPrecompiledTemplate_VariablesMissing(t *testing.T) {
+	expectedError := "variables is missing or null"
+	tmpl, err := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, contentPart, espacePart, nil, localizer)
+	assertFailure(t, tmpl, err, expectedError)
+}
+
+func Test_NewPrecompiledTemplate_EscapePartNotDeclared(t *testing.T) {
+	expectedError := `models: escape part "Username" is not a declared variable`
+	tmpl, err := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, contentPart, espacePart, []TemplateVariable{}, localizer)
+	assertFailure(t, tmpl, err, expectedError)
+}
+
+func Test_NewPrecompiledTemplate_UnknownVariableType(t *testing.T) {
+	expectedError := `models: variable "Username" has unknown type "timestamp"`
+	vars := []TemplateVariable{{Name: "Username", Type: "timestamp"}}
+	tmpl, err := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, contentPart, espacePart, vars, localizer)
+	assertFailure(t, tmpl, err, expectedError)
+}
+
+func Test_NewPrecompiledTemplate_BodyReferencesUndeclared(t *testing.T) {
+	expectedError := "models: body template references undeclared variables WebURL"
+	body := `{{ if .Username }}<a href="{{ .WebURL }}">{{ .Key }}</a>{{ end }}`
+	tmpl, err := NewPrecompiledTemplate(name, subjectSuccessTemplate, body, contentPart, espacePart, variables, localizer)
+	assertFailure(t, tmpl, err, expectedError)
+}
+
+func Test_NewPrecompiledTemplate_ExecuteMissingVariable(t *testing.T) {
+	tmpl, _ := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, contentPart, espacePart, variables, localizer)
+	_, _, err := tmpl.Execute(map[string]interface{}{}, "en")
+	contentErr, ok := err.(*ContentError)
+	if !ok {
+		t.Fatalf(`Error is "%v", but should be a content error`, err)
+	}
+	if len(contentErr.Missing) != 1 || contentErr.Missing[0] != "Username" {
+		t.Fatalf(`Missing variables are %v, but should be [Username]`, contentErr.Missing)
+	}
+}
+
+func Test_NewPrecompiledTemplate_ExecuteUnknownVariable(t *testing.T) {
+	expectedError := `models: invalid content for template "test": unknown Nickname`
+	content := map[string]interface{}{"Username": "Test User", "Nickname": "Tester"}
+	tmpl, _ := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, contentPart, espacePart, variables, localizer)
+	_, _, err := tmpl.Execute(content, "en")
+	if err == nil || err.Error() != expectedError {
+		t.Fatalf(`Error is "%v", but should be "%s"`, err, expectedError)
+	}
+}
+
+func Test_NewPrecompiledTemplate_ExecuteInvalidVariable(t *testing.T) {
+	vars := []TemplateVariable{
+		{Name: "Username", Type: VariableTypeString, Required: true},
+		{Name: "WebURL", Type: VariableTypeURL},
+		{Name: "Count", Type: VariableTypeNumber},
+	}
+	content := map[string]interface{}{"Username": 42, "WebURL": "example.com/path", "Count": 3}
+	tmpl, _ := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, contentPart, espacePart, vars, localizer)
+	_, _, err := tmpl.Execute(content, "en")
+	contentErr, ok := err.(*ContentError)
+	if !ok {
+		t.Fatalf(`Error is "%v", but should be a content error`, err)
+	}
+	if len(contentErr.Invalid) != 2 || contentErr.Invalid[0] != "Username" || contentErr.Invalid[1] != "WebURL" {
+		t.Fatalf(`Invalid variables are %v, but should be [Username WebURL]`, contentErr.Invalid)
+	}
+}
+
+func Test_NewPrecompiledTemplate_ExecuteKeepsContent(t *testing.T) {
+	content := map[string]interface{}{"Username": "Test User"}
+	tmpl, _ := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, contentPart, espacePart, variables, localizer)
+	if _, _, err := tmpl.Execute(content, "en"); err != nil {
+		t.Fatalf(`Error is "%s", but should be nil`, err)
+	}
+	if _, _, err := tmpl.Execute(content, "en"); err != nil {
+		t.Fatalf(`Second execution failed with "%s"`, err)
+	}
+	if len(content) != 1 {
+		t.Fatalf(`Content has been modified: %v`, content)
+	}
+}
+
+func Test_NewPrecompiledTemplate_ExecuteMissingTranslation(t *testing.T) {
+	content := map[string]interface{}{"Username": "Test User"}
+	tmpl, _ := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, []string{"Key", "Unknown"}, espacePart, variables, localizer)
+	_, body, err := tmpl.Execute(content, "en")
+	if err == nil {
+		t.Fatalf(`Execute should fail when a content part cannot be localized, body is "%s"`, body)
+	}
+}
+
+func Test_NewPrecompiledTemplate_ExecuteFormatsValues(t *testing.T) {
+	vars := []TemplateVariable{
+		{Name: "Username", Type: VariableTypeString, Required: true},
+		{Name: "ExpiryDate", Type: VariableTypeDate, Required: true},
+		{Name: "ExpiryDays", Type: VariableTypeNumber, Required: true, Plural: true},
+	}
+	body := `{{ .ExpiryDate }} ({{ .ExpiryDays }})`
+	content := map[string]interface{}{
+		"Username":   "Test User",
+		"ExpiryDate": time.Date(2021, time.March, 4, 14, 30, 0, 0, time.UTC),
+		"ExpiryDays": 1500,
+	}
+	tmpl, err := NewPrecompiledTemplate(name, subjectSuccessTemplate, body, []string{}, espacePart, vars, localizer)
+	if err != nil {
+		t.Fatalf(`Error is "%s", but should be nil`, err)
+	}
+	_, result, err := tmpl.Execute(content, "de")
+	if err != nil {
+		t.Fatalf(`Error is "%s", but should be nil`, err)
+	}
+	if result != "4. März 2021 um 14:30 UTC (1.500)" {
+		t.Fatalf(`Body is "%s", but should be "%s"`, result, "4. März 2021 um 14:30 UTC (1.500)")
+	}
+}
+
+func Test_NewPrecompiledTemplate_ExecuteLocale(t *testing.T) {
+	body := `<html lang="{{ .Locale }}">{{ .Username }}</html>`
+	content := map[string]interface{}{"Username": "Test User"}
+	tmpl, err := NewPrecompiledTemplate(name, subjectSuccessTemplate, body, []string{}, espacePart, variables, localizer)
+	if err != nil {
+		t.Fatalf(`Error is "%s", but should be nil`, err)
+	}
+	_, result, err := tmpl.Execute(content, "fr-CA")
+	if err != nil || result != `<html lang="fr-CA">Test User</html>` {
+		t.Fatalf(`Body is "%s" (%v), but should hold the locale`, result, err)
+	}
+}
+
+func Test_NewPrecompiledTemplate_LocaleReserved(t *testing.T) {
+	expectedError := `models: variable name "Locale" is reserved`
+	vars := []TemplateVariable{{Name: "Locale", Type: VariableTypeString}}
+	tmpl, err := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, contentPart, espacePart, vars, localizer)
+	assertFailure(t, tmpl, err, expectedError)
+}
+
+func Test_NewPrecompiledTemplate_PluralNotNumber(t *testing.T) {
+	expectedError := `models: plural variable "Username" is not a number`
+	vars := []TemplateVariable{{Name: "Username", Type: VariableTypeString, Plural: true}}
+	tmpl, err := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, contentPart, espacePart, vars, localizer)
+	assertFailure(t, tmpl, err, expectedError)
+}
+
+func Test_PluralCount(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected interface{}
+	}{
+		{7, 7},
+		{float64(7), int64(7)},
+		{1.5, "1.5"},
+		{float32(2), int64(2)},
+	}
+	for _, test := range tests {
+		if count := pluralCount(test.value); count != test.expected {
+			t.Fatalf("Wrong plural count for %v, expecting %#v but found %#v", test.value, test.expected, count)
+		}
+	}
+}
+
+func Test_NewPrecompiledTemplate_SenderInvalid(t *testing.T) {
+	tmpl, _ := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, contentPart, espacePart, variables, localizer)
+	expectedErrors := map[string]TemplateSender{
+		`models: sender address "noreply" is invalid`:                       {Address: "noreply"},
+		`models: reply-to "ReplyAddress" is not a declared string variable`: {ReplyTo: "ReplyAddress"},
+	}
+	for expectedError, sender := range expectedErrors {
+		if err := tmpl.SetSender(sender); err == nil || err.Error() != expectedError {
+			t.Fatalf(`Error is "%v", but should be "%s"`, err, expectedError)
+		}
+	}
+}
+
+func Test_NewPrecompiledTemplate_ExecuteSender(t *testing.T) {
+	vars := append([]TemplateVariable{{Name: "ReplyAddress", Type: VariableTypeString}}, variables...)
+	tmpl, _ := NewPrecompiledTemplate(name, subjectSuccessTemplate, bodySuccessTemplate, contentPart, espacePart, vars, localizer)
+	content := map[string]interface{}{"Username": "Test User"}
+	if senderName, replyTo, err := tmpl.ExecuteSender(content, "en"); err != nil || senderName != "" || replyTo != "" {
+		t.Fatalf(`A template without sender should use the default one, got "%s" "%s" (%v)`, senderName, replyTo, err)
+	}
+
+	if err := tmpl.SetSender(TemplateSender{Name: "Key", Address: "support@example.com", ReplyTo: "ReplyAddress"}); err != nil {
+		t.Fatalf(`Error is "%s", but should be nil`, err)
+	}
+	if senderName, replyTo, err := tmpl.ExecuteSender(content, "en"); err != nil || senderName != "123.blah.456.blah" || replyTo != "" {
+		t.Fatalf(`The sender name should be localized without reply-to, got "%s" "%s" (%v)`, senderName, replyTo, err)
+	}
+	content["ReplyAddress"] = "jane@example.com"
+	if _, replyTo, _ := tmpl.ExecuteSender(content, "en"); replyTo != "jane@example.com" {
+		t.Fatalf(`Reply-to is "%s", but should be "jane@example.com"`, replyTo)
+	}
+	if tmpl.Sender().Address != "support@example.com" {
+		t.Fatalf(`Sender address is "%s", but should be "support@example.com"`, tmpl.Sender().Address)
+	}
+}
+
+func Test_VariableType_JSONValue(t *testing.T) {
+	if date, ok := VariableTypeDate.JSONValue("2021-06-01T10:00:00Z").(time.Time); !ok || !date.Equal(time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf(`The RFC 3339 date should be parsed, got "%v"`, date)
+	}
+	if date, ok := VariableTypeDate.JSONValue("2021-06-01").(time.Time); !ok || date.Day() != 1 {
+		t.Fatalf(`The day should be parsed, got "%v"`, date)
+	}
+	for variableType, value := range map[VariableType]interface{}{VariableTypeDate: "tomorrow", VariableTypeString: "2021-06-01", VariableTypeNumber: 7.0} {
+		if converted := variableType.JSONValue(value); converted != value {
+			t.Fatalf(`The %s value "%v" should be kept, got "%v"`, variableType, value, converted)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// emaillint checks rendered emails: size, links, images alt text, lang attribute, preheader and unrendered variables
+// It takes html files or folders (e.g. the golden files or emails saved from the preview) and exits with a non-zero status on issues
+//
+// Usage: go run ./templates/emaillint [file or folder...]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mdblp/hydrophone/templates"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [file or folder...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var files []string
+	for _, arg := range flag.Args() {
+		err := filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && strings.HasSuffix(path, ".html") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	failed := false
+	for _, file := range files {
+		body, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		for _, issue := range templates.LintEmail(string(body)) {
+			fmt.Printf("%s: %s\n", file, issue)
+			failed = true
+		}
+	}
+	fmt.Printf("%d emails checked\n", len(files))
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,158 @@
+package templates
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdblp/hydrophone/localize"
+	"github.com/mdblp/hydrophone/models"
+)
+
+// Regenerate the golden files with: go test ./templates -run Test_Golden -update
+var update = flag.Bool("update", false, "update the golden files of the templates")
+
+const goldenPath = "./testdata/golden"
+
+// Test_Golden renders every template in every loaded locale, lints the result and compares it with its golden file
+func Test_Golden(t *testing.T) {
+	localizer, err := localize.NewI18nLocalizer(path.Join(templatesPath, "locales"))
+	if err != nil {
+		t.Fatalf("Failed to create the localizer: %s", err)
+	}
+	localizer.SetFallbackHook(func(key string, requested string, used string) {})
+	emailTemplates, err := New(templatesPath, localizer)
+	if err != nil {
+		t.Fatalf("Failed to load the templates: %s", err)
+	}
+	fixture := loadGoldenContent(t)
+
+	names := make([]string, 0, len(emailTemplates))
+	for name := range emailTemplates {
+		names = append(names, name.String())
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		template := emailTemplates[models.TemplateName(name)]
+		for _, lang := range localizer.Languages() {
+			t.Run(name+"/"+lang, func(t *testing.T) {
+				content := goldenContent(t, template, fixture, lang)
+				subject, body, err := template.Execute(content, lang)
+				if err != nil {
+					t.Fatalf("Failed to render: %s", err)
+				}
+				for _, issue := range LintEmail(body) {
+					t.Errorf("Lint: %s", issue)
+				}
+				rendered := fmt.Sprintf("Subject: %s\n\n%s", subject, body)
+				goldenFile := path.Join(goldenPath, name, lang+".html")
+				if *update {
+					if err := os.MkdirAll(path.Dir(goldenFile), 0755); err != nil {
+						t.Fatal(err)
+					}
+					if err := ioutil.WriteFile(goldenFile, []byte(rendered), 0644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				expected, err := ioutil.ReadFile(goldenFile)
+				if err != nil {
+					t.Fatalf("Failed to read the golden file, run the test with -update to create it: %s", err)
+				}
+				if diff := lineDiff(string(expected), rendered); diff != "" {
+					t.Fatalf("Rendering differs from %s (run the test with -update if the change is expected):\n%s", goldenFile, diff)
+				}
+			})
+		}
+	}
+}
+
+func loadGoldenContent(t *testing.T) map[string]interface{} {
+	data, err := ioutil.ReadFile(path.Join(goldenPath, "content.json"))
+	if err != nil {
+		t.Fatalf("Failed to read the golden content: %s", err)
+	}
+	var fixture map[string]interface{}
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		t.Fatalf("Failed to parse the golden content: %s", err)
+	}
+	return fixture
+}
+
+// goldenContent picks the fixture values declared by the template, in the types they are declared with
+func goldenContent(t *testing.T, template models.Template, fixture map[string]interface{}, lang string) map[string]interface{} {
+	content := make(map[string]interface{})
+	for _, v := range template.Variables() {
+		if v.Name == "Language" {
+			content[v.Name] = lang
+			continue
+		}
+		value, ok := fixture[v.Name]
+		if !ok {
+			t.Fatalf("The golden content has no value for %s", v.Name)
+		}
+		switch v.Type {
+		case models.VariableTypeDate:
+			date, err := time.Parse(time.RFC3339, value.(string))
+			if err != nil {
+				t.Fatalf("The golden content %s is not a date: %s", v.Name, err)
+			}
+			value = date
+		case models.VariableTypeNumber:
+			value = int(value.(float64))
+		}
+		content[v.Name] = value
+	}
+	return content
+}
+
+// lineDiff returns the lines removed (-) and added (+) between the expected and actual texts, with their line numbers
+func lineDiff(expected, actual string) string {
+	if expected == actual {
+		return ""
+	}
+	a := strings.Split(expected, "\n")
+	b := strings.Split(actual, "\n")
+
+	// longest common subsequence of the lines
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&diff, "-%4d: %s\n", i+1, a[i])
+			i++
+		default:
+			fmt.Fprintf(&diff, "+%4d: %s\n", j+1, b[j])
+			j++
+		}
+	}
+	return diff.String()
+}
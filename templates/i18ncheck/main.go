@@ -0,0 +1,53 @@
+// i18ncheck reports the translation completeness of the email templates
+// It exits with a non-zero status when a language misses keys or has placeholders not matching the english ones
+//
+// Usage: go run ./templates/i18ncheck [-templates ./templates] [-skip chr] [-strict]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mdblp/hydrophone/templates"
+)
+
+func main() {
+	templatesPath := flag.String("templates", "./templates", "folder holding the meta and locales folders")
+	skip := flag.String("skip", "chr", "comma separated languages not to check (chr is the crowdin in-context language)")
+	strict := flag.Bool("strict", false, "also fail on unused and untranslated keys")
+	flag.Parse()
+
+	var skipped []string
+	if *skip != "" {
+		skipped = strings.Split(*skip, ",")
+	}
+	reports, err := templates.CheckTranslations(*templatesPath, skipped)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, report := range reports {
+		fmt.Printf("%s: %d missing, %d placeholder mismatches, %d unused, %d untranslated\n",
+			report.Language, len(report.Missing), len(report.Placeholders), len(report.Unused), len(report.Untranslated))
+		printKeys("missing", report.Missing)
+		printKeys("placeholder mismatch", report.Placeholders)
+		printKeys("unused", report.Unused)
+		printKeys("untranslated", report.Untranslated)
+		if report.HasErrors() || (*strict && report.HasWarnings()) {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func printKeys(kind string, keys []string) {
+	for _, key := range keys {
+		fmt.Printf("  %s: %s\n", kind, key)
+	}
+}
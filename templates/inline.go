@@ -0,0 +1,398 @@
+package templates
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+type (
+	// cssRule is a style rule which selector can be matched against the elements
+	cssRule struct {
+		selector     []selectorPart
+		specificity  [3]int
+		order        int
+		declarations []cssDeclaration
+	}
+	// selectorPart is a compound selector and the combinator linking it to the previous part (" " or ">")
+	selectorPart struct {
+		combinator string
+		tag        string
+		id         string
+		classes    []string
+	}
+	cssDeclaration struct {
+		property  string
+		value     string
+		important bool
+	}
+	// element is an opened element of the html being inlined
+	element struct {
+		tag     string
+		id      string
+		classes []string
+	}
+	// styleSheet holds the rules to inline and the css kept in a style block (media queries, pseudo classes...)
+	styleSheet struct {
+		rules []cssRule
+		kept  []string
+	}
+)
+
+var (
+	cssCommentRegexp    = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	styleAttributeRegex = regexp.MustCompile(`(?i)(\sstyle\s*=\s*)("[^"]*"|'[^']*')`)
+	tagEndRegexp        = regexp.MustCompile(`\s*/?>$`)
+	compoundRegexp      = regexp.MustCompile(`[.#]?[^.#]+`)
+	stylesheetRegexp    = regexp.MustCompile(`(?i)<link\s[^>]*rel=["']?stylesheet["']?[^>]*>`)
+	hrefRegexp          = regexp.MustCompile(`(?i)\shref\s*=\s*["']([^"']*)["']`)
+	// selectors which cannot be matched on the markup alone are kept in the style block
+	unsupportedSelector = regexp.MustCompile(`[\[:*+~]`)
+)
+
+// SourceAssetsURL is the location of the images referenced by the source templates
+const SourceAssetsURL = "https://s3-eu-west-1.amazonaws.com/com.diabeloop.public-assets"
+
+// voidElements have no end tag
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// CompileSource inlines the local stylesheets linked by a source template
+// The link to the stylesheet is replaced with a style block holding the css which cannot be inlined,
+// remote stylesheets (e.g. web fonts) are left as they are.
+// The assets url of the source, if any, is replaced with the AssetURL variable of the templates.
+func CompileSource(sourceFile string, assetsURL string) (string, error) {
+	data, err := ioutil.ReadFile(sourceFile)
+	if err != nil {
+		return "", fmt.Errorf("templates: failure to read %s: %s", sourceFile, err)
+	}
+	source := string(data)
+
+	const placeholder = "<!--hydrophone-inlined-css-->"
+	var css []string
+	var failure error
+	source = stylesheetRegexp.ReplaceAllStringFunc(source, func(link string) string {
+		href := hrefRegexp.FindStringSubmatch(link)
+		if href == nil || strings.Contains(href[1], "://") || strings.HasPrefix(href[1], "//") {
+			return link
+		}
+		content, err := ioutil.ReadFile(filepath.Join(filepath.Dir(sourceFile), href[1]))
+		if err != nil {
+			failure = fmt.Errorf("templates: failure to read the stylesheet of %s: %s", sourceFile, err)
+		}
+		css = append(css, string(content))
+		if len(css) > 1 {
+			return ""
+		}
+		return placeholder
+	})
+	if failure != nil {
+		return "", failure
+	}
+
+	inlined, kept, err := InlineCSS(source, strings.Join(css, "\n"))
+	if err != nil {
+		return "", fmt.Errorf("templates: failure to inline %s: %s", sourceFile, err)
+	}
+	style := ""
+	if kept != "" {
+		style = "<style type=\"text/css\">\n" + kept + "\n</style>"
+	}
+	inlined = strings.Replace(inlined, placeholder, style, 1)
+	if assetsURL != "" {
+		inlined = strings.Replace(inlined, strings.TrimSuffix(assetsURL, "/"), "{{ .AssetURL }}", -1)
+	}
+	return inlined, nil
+}
+
+// InlineCSS applies the css rules to the style attributes of the source html elements
+// The markup is kept as written, template actions included, only the style attributes change.
+// It returns the inlined html and the css which cannot be inlined (media queries, pseudo classes,
+// attribute selectors...) to be kept in a style block.
+func InlineCSS(source string, css string) (string, string, error) {
+	sheet, err := parseCSS(css)
+	if err != nil {
+		return "", "", err
+	}
+
+	var out strings.Builder
+	var stack []element
+	tokenizer := html.NewTokenizer(strings.NewReader(source))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+		raw := string(tokenizer.Raw())
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			current := element{tag: token.Data, id: attribute(token, "id"), classes: strings.Fields(attribute(token, "class"))}
+			if style := sheet.styleFor(current, stack, attribute(token, "style")); style != "" {
+				raw = setStyle(raw, style)
+			}
+			if tokenType == html.StartTagToken && !voidElements[token.Data] {
+				stack = append(stack, current)
+			}
+		case html.EndTagToken:
+			token := tokenizer.Token()
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i].tag == token.Data {
+					stack = stack[:i]
+					break
+				}
+			}
+		}
+		out.WriteString(raw)
+	}
+
+	inlined := out.String()
+	if strings.Count(inlined, "{{") != strings.Count(source, "{{") || strings.Count(inlined, "}}") != strings.Count(source, "}}") {
+		return "", "", fmt.Errorf("templates: template actions altered by the css inlining")
+	}
+	return inlined, strings.Join(sheet.kept, "\n"), nil
+}
+
+// styleFor returns the style attribute of an element: the matching rules by specificity and order, then its own style
+func (s *styleSheet) styleFor(current element, ancestors []element, own string) string {
+	var matching []*cssRule
+	for i := range s.rules {
+		if matches(s.rules[i].selector, current, ancestors) {
+			matching = append(matching, &s.rules[i])
+		}
+	}
+	if len(matching) == 0 {
+		return ""
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		if matching[i].specificity != matching[j].specificity {
+			return lessSpecific(matching[i].specificity, matching[j].specificity)
+		}
+		return matching[i].order < matching[j].order
+	})
+
+	var properties []string
+	values := make(map[string]cssDeclaration)
+	apply := func(declaration cssDeclaration) {
+		previous, ok := values[declaration.property]
+		if !ok {
+			properties = append(properties, declaration.property)
+		} else if previous.important && !declaration.important {
+			return
+		}
+		values[declaration.property] = declaration
+	}
+	for _, rule := range matching {
+		for _, declaration := range rule.declarations {
+			apply(declaration)
+		}
+	}
+	for _, declaration := range parseDeclarations(own) {
+		apply(declaration)
+	}
+
+	var style strings.Builder
+	for _, property := range properties {
+		declaration := values[property]
+		style.WriteString(property + ":" + declaration.value)
+		if declaration.important {
+			style.WriteString(" !important")
+		}
+		style.WriteString(";")
+	}
+	return style.String()
+}
+
+// setStyle replaces or adds the style attribute of a raw start tag
+func setStyle(raw string, style string) string {
+	if styleAttributeRegex.MatchString(raw) {
+		return styleAttributeRegex.ReplaceAllLiteralString(raw, ` style="`+style+`"`)
+	}
+	end := tagEndRegexp.FindString(raw)
+	return raw[:len(raw)-len(end)] + ` style="` + style + `"` + end
+}
+
+// matches tells whether the selector matches the element, parts are matched from the last one to the first one
+func matches(selector []selectorPart, current element, ancestors []element) bool {
+	last := len(selector) - 1
+	if !selector[last].matches(current) {
+		return false
+	}
+	return matchesAncestors(selector[:last], selector[last].combinator, ancestors)
+}
+
+func matchesAncestors(selector []selectorPart, combinator string, ancestors []element) bool {
+	if len(selector) == 0 {
+		return true
+	}
+	last := len(selector) - 1
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		if selector[last].matches(ancestors[i]) && matchesAncestors(selector[:last], selector[last].combinator, ancestors[:i]) {
+			return true
+		}
+		if combinator == ">" {
+			return false
+		}
+	}
+	return false
+}
+
+func (p selectorPart) matches(e element) bool {
+	if p.tag != "" && p.tag != e.tag {
+		return false
+	}
+	if p.id != "" && p.id != e.id {
+		return false
+	}
+	for _, class := range p.classes {
+		found := false
+		for _, c := range e.classes {
+			if c == class {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func lessSpecific(a, b [3]int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+// parseCSS splits the stylesheet into the rules to inline and the css to keep
+func parseCSS(css string) (*styleSheet, error) {
+	css = cssCommentRegexp.ReplaceAllString(css, "")
+	sheet := &styleSheet{}
+	order := 0
+	for len(strings.TrimSpace(css)) > 0 {
+		open := strings.Index(css, "{")
+		if open < 0 {
+			return nil, fmt.Errorf("templates: invalid css near %q", strings.TrimSpace(css))
+		}
+		prelude := strings.TrimSpace(css[:open])
+		end, err := blockEnd(css, open)
+		if err != nil {
+			return nil, err
+		}
+		block := css[open+1 : end]
+		css = css[end+1:]
+
+		if strings.HasPrefix(prelude, "@") {
+			sheet.kept = append(sheet.kept, prelude+" {"+block+"}")
+			continue
+		}
+		declarations := parseDeclarations(block)
+		var kept []string
+		for _, selector := range strings.Split(prelude, ",") {
+			selector = strings.TrimSpace(selector)
+			if unsupportedSelector.MatchString(selector) {
+				kept = append(kept, selector)
+				continue
+			}
+			parts, specificity, err := parseSelector(selector)
+			if err != nil {
+				return nil, err
+			}
+			sheet.rules = append(sheet.rules, cssRule{selector: parts, specificity: specificity, order: order, declarations: declarations})
+			order++
+		}
+		if len(kept) > 0 {
+			sheet.kept = append(sheet.kept, strings.Join(kept, ", ")+" {"+block+"}")
+		}
+	}
+	return sheet, nil
+}
+
+// blockEnd returns the index of the brace closing the block opened at the given index
+func blockEnd(css string, open int) (int, error) {
+	depth := 0
+	for i := open; i < len(css); i++ {
+		switch css[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("templates: unclosed css block near %q", strings.TrimSpace(css[:open]))
+}
+
+func parseDeclarations(block string) []cssDeclaration {
+	var declarations []cssDeclaration
+	for _, declaration := range strings.Split(block, ";") {
+		colon := strings.Index(declaration, ":")
+		if colon < 0 {
+			continue
+		}
+		property := strings.ToLower(strings.TrimSpace(declaration[:colon]))
+		value := strings.TrimSpace(declaration[colon+1:])
+		important := false
+		if i := strings.Index(strings.ToLower(value), "!important"); i >= 0 {
+			important = true
+			value = strings.TrimSpace(value[:i])
+		}
+		if property != "" && value != "" {
+			declarations = append(declarations, cssDeclaration{property: property, value: value, important: important})
+		}
+	}
+	return declarations
+}
+
+// parseSelector parses the type, class and id selectors combined with the descendant and child combinators
+func parseSelector(selector string) ([]selectorPart, [3]int, error) {
+	var parts []selectorPart
+	var specificity [3]int
+	combinator := ""
+	for _, field := range strings.Fields(strings.Replace(selector, ">", " > ", -1)) {
+		if field == ">" {
+			if len(parts) == 0 || combinator == ">" {
+				return nil, specificity, fmt.Errorf("templates: invalid css selector %q", selector)
+			}
+			combinator = ">"
+			continue
+		}
+		part := selectorPart{combinator: combinator}
+		if len(parts) > 0 && combinator == "" {
+			part.combinator = " "
+		}
+		combinator = ""
+		for i, name := range compoundRegexp.FindAllString(field, -1) {
+			switch {
+			case strings.HasPrefix(name, "."):
+				part.classes = append(part.classes, name[1:])
+				specificity[1]++
+			case strings.HasPrefix(name, "#"):
+				part.id = name[1:]
+				specificity[0]++
+			case i == 0:
+				part.tag = strings.ToLower(name)
+				specificity[2]++
+			default:
+				return nil, specificity, fmt.Errorf("templates: invalid css selector %q", selector)
+			}
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 || combinator != "" {
+		return nil, specificity, fmt.Errorf("templates: invalid css selector %q", selector)
+	}
+	return parts, specificity, nil
+}
@@ -0,0 +1,66 @@
+package templates
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_InlineCSS(t *testing.T) {
+	css := `
+/* comment */
+p { color: red; margin: 0; }
+td p { color: blue; }
+.outer > p { font-weight: bold; }
+p.h1 { font-size: 18px; }
+a { color: green !important; }
+a:hover { color: black; }
+@media screen and (max-width: 360px) { p { font-size: 10px; } }`
+	source := `<table class="outer"><tr><td><p class="h1">{{ .Headline }}</p></td></tr></table>
+<div class="outer"><p style="margin: 4px">Text</p></div>
+<a href="{{ .WebURL }}/x?a=1&b=2" style="color: red"><img src="logo.png"/></a>`
+	expected := `<table class="outer"><tr><td><p class="h1" style="color:blue;margin:0;font-size:18px;">{{ .Headline }}</p></td></tr></table>
+<div class="outer"><p style="color:red;margin:4px;font-weight:bold;">Text</p></div>
+<a href="{{ .WebURL }}/x?a=1&b=2" style="color:green !important;"><img src="logo.png"/></a>`
+
+	inlined, kept, err := InlineCSS(source, css)
+	if err != nil {
+		t.Fatalf("InlineCSS failed with error %s", err)
+	}
+	if inlined != expected {
+		t.Fatalf("Wrong inlined html, expecting\n%s\nbut found\n%s", expected, inlined)
+	}
+	if !strings.Contains(kept, "a:hover {") || !strings.Contains(kept, "@media screen and (max-width: 360px) {") {
+		t.Fatalf("Pseudo classes and media queries should be kept, found %q", kept)
+	}
+}
+
+func Test_InlineCSS_InvalidCSS(t *testing.T) {
+	for _, css := range []string{"p { color: red;", "p color: red;", "> p { color: red; }"} {
+		if _, _, err := InlineCSS("<p></p>", css); err == nil {
+			t.Fatalf("InlineCSS should fail with css %q", css)
+		}
+	}
+}
+
+// The html templates must be compiled from their source with the inliner command
+func Test_CompiledTemplatesUpToDate(t *testing.T) {
+	files, err := filepath.Glob("./source/*.html")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("No source template found: %v", err)
+	}
+	for _, file := range files {
+		if filepath.Base(file) == "index.html" {
+			continue
+		}
+		compiled, err := CompileSource(file, SourceAssetsURL)
+		if err != nil {
+			t.Fatalf("Failed to compile %s: %s", file, err)
+		}
+		current, err := ioutil.ReadFile(filepath.Join("./html", filepath.Base(file)))
+		if err != nil || string(current) != compiled {
+			t.Errorf("%s is not up to date with its source, run: go run ./templates/inliner", filepath.Base(file))
+		}
+	}
+}
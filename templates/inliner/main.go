@@ -0,0 +1,60 @@
+// inliner compiles the source templates into the html templates by inlining their css
+// With -check, it only reports the html templates which are not up to date with their source and exits with a non-zero status
+//
+// Usage: go run ./templates/inliner [-source ./templates/source] [-html ./templates/html] [-assets https://...] [-check]
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mdblp/hydrophone/templates"
+)
+
+func main() {
+	sourcePath := flag.String("source", "./templates/source", "folder holding the source templates and their css")
+	htmlPath := flag.String("html", "./templates/html", "folder where the html templates are written")
+	assetsURL := flag.String("assets", templates.SourceAssetsURL, "assets url of the source templates, replaced with the AssetURL variable")
+	check := flag.Bool("check", false, "only check the html templates are up to date")
+	flag.Parse()
+
+	files, err := filepath.Glob(filepath.Join(*sourcePath, "*.html"))
+	if err != nil || len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "no source template found in %s\n", *sourcePath)
+		os.Exit(2)
+	}
+
+	outdated := false
+	for _, file := range files {
+		// the index only links the source templates for their development
+		if filepath.Base(file) == "index.html" {
+			continue
+		}
+		compiled, err := templates.CompileSource(file, *assetsURL)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		target := filepath.Join(*htmlPath, filepath.Base(file))
+		if *check {
+			current, err := ioutil.ReadFile(target)
+			if err != nil || !bytes.Equal(current, []byte(compiled)) {
+				fmt.Printf("%s is not up to date with %s\n", target, file)
+				outdated = true
+			}
+			continue
+		}
+		if err := ioutil.WriteFile(target, []byte(compiled), 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		fmt.Printf("%s compiled into %s\n", file, target)
+	}
+	if outdated {
+		os.Exit(1)
+	}
+}
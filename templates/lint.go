@@ -0,0 +1,126 @@
+package templates
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// MaxEmailSize is the html size above which gmail clips the messages
+const MaxEmailSize = 102 * 1024
+
+// The rules checked by LintEmail
+const (
+	LintRuleSize       = "size"
+	LintRuleLink       = "link"
+	LintRuleAlt        = "img-alt"
+	LintRuleLang       = "lang"
+	LintRulePreheader  = "preheader"
+	LintRuleUnrendered = "unrendered"
+)
+
+// LintIssue is a problem found in a rendered email
+type LintIssue struct {
+	Rule   string `json:"rule"`
+	Detail string `json:"detail"`
+}
+
+func (i LintIssue) String() string {
+	return i.Rule + ": " + i.Detail
+}
+
+// LintEmail checks a rendered email html body:
+// its size, absolute https links (mailto and tel are allowed), an alt attribute on every image,
+// a lang attribute on the html element, a non empty preheader and no template action or value left unrendered
+func LintEmail(body string) []LintIssue {
+	var issues []LintIssue
+	add := func(rule string, format string, args ...interface{}) {
+		issues = append(issues, LintIssue{Rule: rule, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	if len(body) > MaxEmailSize {
+		add(LintRuleSize, "%d bytes, gmail clips emails over %d bytes", len(body), MaxEmailSize)
+	}
+	for _, left := range []string{"{{", "}}", "<no value>"} {
+		if strings.Contains(body, left) {
+			add(LintRuleUnrendered, "%q found in the email", left)
+		}
+	}
+
+	lang := false
+	preheader := false
+	var preheaderTag atom.Atom
+	var preheaderText strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+		token := tokenizer.Token()
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			switch token.DataAtom {
+			case atom.Html:
+				lang = strings.TrimSpace(attribute(token, "lang")) != ""
+			case atom.A:
+				checkLink(token, "href", true, add)
+			case atom.Link:
+				checkLink(token, "href", false, add)
+			case atom.Img:
+				checkLink(token, "src", false, add)
+				if _, ok := attributeValue(token, "alt"); !ok {
+					add(LintRuleAlt, "image %s has no alt attribute", attribute(token, "src"))
+				}
+			}
+			if tokenType == html.StartTagToken && hasClass(token, "preheader") {
+				preheader = true
+				preheaderTag = token.DataAtom
+				preheaderText.Reset()
+			}
+		case html.EndTagToken:
+			if preheaderTag != 0 && token.DataAtom == preheaderTag {
+				preheaderTag = 0
+			}
+		case html.TextToken:
+			if preheaderTag != 0 {
+				preheaderText.WriteString(token.Data)
+			}
+		}
+	}
+
+	if !lang {
+		add(LintRuleLang, "the html element has no lang attribute")
+	}
+	if !preheader {
+		add(LintRulePreheader, "no preheader element found")
+	} else if strings.TrimSpace(preheaderText.String()) == "" {
+		add(LintRulePreheader, "the preheader is empty")
+	}
+	return issues
+}
+
+// checkLink reports the urls which are not absolute https ones, contact links being allowed for the anchors
+func checkLink(token html.Token, name string, contact bool, add func(string, string, ...interface{})) {
+	value := strings.TrimSpace(attribute(token, name))
+	link, err := url.Parse(value)
+	switch {
+	case value == "" || err != nil:
+		add(LintRuleLink, "%s %s %q is not a valid url", token.Data, name, value)
+	case contact && (link.Scheme == "mailto" || link.Scheme == "tel"):
+	case link.Scheme != "https" || link.Host == "":
+		add(LintRuleLink, "%s %s %q is not an absolute https url", token.Data, name, value)
+	}
+}
+
+func hasClass(token html.Token, class string) bool {
+	for _, c := range strings.Fields(attribute(token, "class")) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package templates
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_LintEmail(t *testing.T) {
+	valid := `<html lang="fr"><body><div class="preheader">Welcome</div>
+<a href="https://example.com"><img src="https://example.com/logo.png" alt=""></a>
+<a href="mailto:support@example.com">support</a></body></html>`
+	if issues := LintEmail(valid); len(issues) != 0 {
+		t.Fatalf("A valid email should have no issue, got %v", issues)
+	}
+
+	invalid := `<html><body><div class="preheader"> </div>
+<a href="/login"><img src="http://example.com/logo.png"></a>
+<a href="">{{ .Name }}</a> <no value></body></html>`
+	var rules []string
+	for _, issue := range LintEmail(invalid) {
+		rules = append(rules, issue.Rule)
+	}
+	expected := []string{LintRuleUnrendered, LintRuleUnrendered, LintRuleUnrendered, LintRuleLink, LintRuleLink, LintRuleAlt, LintRuleLink, LintRuleLang, LintRulePreheader}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("Wrong lint issues, expecting %v but found %v", expected, rules)
+	}
+
+	large := `<html lang="en"><body><p class="preheader">Hello</p>` + strings.Repeat("a", MaxEmailSize) + `</body></html>`
+	if issues := LintEmail(large); len(issues) != 1 || issues[0].Rule != LintRuleSize {
+		t.Fatalf("A large email should be reported, got %v", issues)
+	}
+}
@@ -3,12 +3,15 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"path"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 
@@ -26,8 +29,31 @@ type (
 	}
 	// this just makes it easier to bind a handler for the Handle function
 	varsHandler func(http.ResponseWriter, *http.Request, map[string]string)
+
+	// previewRequest is the content and language to render a template with
+	previewRequest struct {
+		Lang    string                 `json:"lang"`
+		Content map[string]interface{} `json:"content"`
+	}
+	// previewResponse is the rendered email, the warnings tell which sample values were used and which content was ignored
+	previewResponse struct {
+		Subject  string   `json:"subject"`
+		HTML     string   `json:"html"`
+		Text     string   `json:"text"`
+		Warnings []string `json:"warnings"`
+	}
+	// templateDescription describes a template and its declared variables
+	templateDescription struct {
+		Name         string                    `json:"name"`
+		Subject      string                    `json:"subject"`
+		ContentParts []string                  `json:"contentParts"`
+		Variables    []models.TemplateVariable `json:"variables"`
+	}
 )
 
+// maxPreviewRequestSize limits the size of the content posted for a preview
+const maxPreviewRequestSize = 1 << 20
+
 // Init the preview api with configuration
 func InitApi(
 	cfg Config,
@@ -42,7 +68,9 @@ func InitApi(
 }
 
 func (a *Api) SetHandlers(prefix string, rtr *mux.Router) {
+	rtr.Handle("/templates", varsHandler(a.listTemplates)).Methods("GET")
 	rtr.Handle("/preview/{template}", varsHandler(a.preview)).Methods("GET")
+	rtr.Handle("/preview/{template}", varsHandler(a.renderPreview)).Methods("POST")
 	rtr.Handle("/refreshlocal", varsHandler(a.refreshLocal)).Methods("POST")
 	rtr.HandleFunc("/", a.serveStatic).Methods("GET")
 	rtr.HandleFunc("/mail_preview", a.serveStatic).Methods("GET")
@@ -113,43 +141,12 @@ func (a *Api) preview(res http.ResponseWriter, req *http.Request, vars map[strin
 // Compile a template with test content and return the html result
 func (a *Api) buildPreview(res http.ResponseWriter, req *http.Request, vars map[string]string) {
 	//Determine the email template:
-	var templateName models.TemplateName
+	templateName := models.TemplateName(vars["template"])
 	lang := "en"
-	switch vars["template"] {
-	case "careteam_invitation":
-		templateName = models.TemplateNameCareteamInvite
-	case "medicalteam_patient_invitation":
-		templateName = models.TemplateNameMedicalteamPatientInvite
-	case "medicalteam_invitation":
-		templateName = models.TemplateNameMedicalteamInvite
-	case "medicalteam_do_admin":
-		templateName = models.TemplateNameMedicalteamDoAdmin
-	case "medicalteam_remove":
-		templateName = models.TemplateNameMedicalteamRemove
-	case "no_account":
-		templateName = models.TemplateNameNoAccount
-	case "password_reset":
-		templateName = models.TemplateNamePasswordReset
-	case "patient_password_reset":
-		templateName = models.TemplateNamePatientPasswordReset
-	case "patient_password_info":
-		templateName = models.TemplateNamePatientPasswordInfo
-	case "patient_information":
-		templateName = models.TemplateNamePatientInformation
-	case "patient_pin_reset":
-		templateName = models.TemplateNamePatientPinReset
-	case "signup_confirmation":
-		templateName = models.TemplateNameSignup
-	case "signup_clinic_confirmation":
-		templateName = models.TemplateNameSignupClinic
-	case "signup_custodial_confirmation":
-		templateName = models.TemplateNameSignupCustodial
-	case "signup_custodial_clinic_confirmation":
-		templateName = models.TemplateNameSignupCustodialClinic
-	default:
+	if _, ok := a.templateByName(templateName); !ok {
 		log.Printf("Unknown template %s", vars["template"])
-		s := status.NewApiStatus(400, "Incorrect template name")
-		a.sendModelAsResWithStatus(res, s, http.StatusInternalServerError)
+		s := status.NewApiStatus(http.StatusBadRequest, "Incorrect template name")
+		a.sendModelAsResWithStatus(res, s, http.StatusBadRequest)
 		return
 	}
 
@@ -176,8 +173,137 @@ func (a *Api) generateEmail(templateName models.TemplateName, lang string) (stri
 
 	log.Printf("trying preview with template '%s' with language '%s'", templateName, lang)
 
-	supportEmail := fmt.Sprintf("<a href=%s>%s</a>", a.Config.SupportURL, strings.Replace(a.Config.SupportURL, "mailto:", "", 1))
+	content := a.sampleContent()
+
+	// Retrieve the template from all the preloaded templates
+	template, ok := a.templateByName(templateName)
+	if !ok {
+		return "", fmt.Errorf("Unknown template type %s", templateName)
+	}
+
+	// Only the variables declared by the template are given to it
+	templateContent := make(map[string]interface{})
+	for _, v := range template.Variables() {
+		if value, ok := content[v.Name]; ok {
+			templateContent[v.Name] = value
+		}
+	}
+
+	// Get localized subject of email
+	subject, body, err := template.Execute(templateContent, lang)
+	if err != nil {
+		return "", fmt.Errorf("Error executing email template '%s'", err)
+	}
+	result := fmt.Sprintf("<div align=\"center\" id=\"subject\">Subject: %s \n</div><div id=\"body\">%s</div>", subject, body)
+	return result, nil
+}
+
+// Render a template with the posted content and language
+// Missing required variables get a sample value and unknown ones are ignored, both are reported as warnings
+func (a *Api) renderPreview(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	template, ok := a.templateByName(models.TemplateName(vars["template"]))
+	if !ok {
+		log.Printf("Unknown template %s", vars["template"])
+		s := status.NewApiStatus(http.StatusNotFound, "Unknown template name")
+		a.sendModelAsResWithStatus(res, s, http.StatusNotFound)
+		return
+	}
+
+	var preview previewRequest
+	if err := json.NewDecoder(io.LimitReader(req.Body, maxPreviewRequestSize)).Decode(&preview); err != nil {
+		s := status.NewApiStatus(http.StatusBadRequest, fmt.Sprintf("Invalid preview request: %s", err))
+		a.sendModelAsResWithStatus(res, s, http.StatusBadRequest)
+		return
+	}
+	if preview.Lang == "" {
+		preview.Lang = "en"
+	}
+
+	samples := a.sampleContent()
+	configured := a.configContent()
+	content := make(map[string]interface{})
+	warnings := []string{}
+	declared := make(map[string]bool)
+	for _, v := range template.Variables() {
+		declared[v.Name] = true
+		if value, ok := preview.Content[v.Name]; ok {
+			content[v.Name] = v.Type.JSONValue(value)
+			continue
+		}
+		if value, ok := configured[v.Name]; ok {
+			content[v.Name] = value
+			continue
+		}
+		if value, ok := samples[v.Name]; ok && v.Required {
+			content[v.Name] = value
+			warnings = append(warnings, fmt.Sprintf("%s is missing, the sample value is used", v.Name))
+		}
+	}
+	for name := range preview.Content {
+		if !declared[name] {
+			warnings = append(warnings, fmt.Sprintf("%s is not a variable of the template, it is ignored", name))
+		}
+	}
+	sort.Strings(warnings)
+
+	subject, body, err := template.Execute(content, preview.Lang)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if _, ok := err.(*models.ContentError); ok {
+			statusCode = http.StatusBadRequest
+		}
+		s := status.NewApiStatus(statusCode, err.Error())
+		a.sendModelAsResWithStatus(res, s, statusCode)
+		return
+	}
+	a.sendModelAsResWithStatus(res, previewResponse{
+		Subject:  subject,
+		HTML:     body,
+		Text:     templates.HTMLToText(body),
+		Warnings: warnings,
+	}, http.StatusOK)
+}
+
+// List the templates with their declared variables
+func (a *Api) listTemplates(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	descriptions := []templateDescription{}
+	for name, template := range a.templates {
+		if name == models.TemplateNameTest {
+			continue
+		}
+		descriptions = append(descriptions, templateDescription{
+			Name:         name.String(),
+			Subject:      template.Subject(),
+			ContentParts: template.ContentParts(),
+			Variables:    template.Variables(),
+		})
+	}
+	sort.Slice(descriptions, func(i, j int) bool { return descriptions[i].Name < descriptions[j].Name })
+	a.sendModelAsResWithStatus(res, descriptions, http.StatusOK)
+}
+
+// templateByName returns the template which can be previewed, the test template is excluded
+func (a *Api) templateByName(name models.TemplateName) (models.Template, bool) {
+	if name == models.TemplateNameTest {
+		return nil, false
+	}
+	template, ok := a.templates[name]
+	return template, ok
+}
 
+// configContent returns the content coming from the service configuration
+func (a *Api) configContent() map[string]interface{} {
+	return map[string]interface{}{
+		"WebURL":                  a.Config.WebURL,
+		"SupportURL":              a.Config.SupportURL,
+		"AssetURL":                a.Config.AssetURL,
+		"PatientPasswordResetURL": a.Config.PatientPasswordResetURL,
+		"SupportEmail":            fmt.Sprintf("<a href=%s>%s</a>", a.Config.SupportURL, strings.Replace(a.Config.SupportURL, "mailto:", "", 1)),
+	}
+}
+
+// sampleContent returns a value for every variable used by the templates
+func (a *Api) sampleContent() map[string]interface{} {
 	content := map[string]interface{}{
 		"Key":                      "123456789123456789123456789123456789",
 		"Email":                    "john@diabeloop.com",
@@ -187,36 +313,19 @@ func (a *Api) generateEmail(templateName models.TemplateName, lang string) (stri
 		"WebPath":                  "login",
 		"ShortKey":                 "12345678",
 		"OTP":                      "165236984",
-		"SupportEmail":             supportEmail,
 		"MedicalteamName":          "Team CHU",
 		"MedicalteamAddress":       "Bd de la chantourne, 38000 Grenoble",
 		"MedicalteamPhone":         "33 4 760 101",
 		"MedicalteamIentification": "123-456-789",
 		"CreatorName":              "John Doe",
 		"Language":                 "en",
+		"ExpiryDate":               time.Now().Add(7 * 24 * time.Hour),
+		"ExpiryDays":               7,
 	}
-	// Content collection is here to replace placeholders in template body/content
-	content["CreatorName"] = "John Doe"
-	content["WebURL"] = a.Config.WebURL
-	content["SupportURL"] = a.Config.SupportURL
-	content["AssetURL"] = a.Config.AssetURL
-	content["PatientPasswordResetURL"] = a.Config.PatientPasswordResetURL
-
-	// Retrieve the template from all the preloaded templates
-	var template models.Template
-	var ok bool
-	template, ok = a.templates[templateName]
-	if !ok {
-		return "", fmt.Errorf("Unknown template type %s", templateName)
-	}
-
-	// Get localized subject of email
-	subject, body, err := template.Execute(content, lang)
-	if err != nil {
-		return "", fmt.Errorf("Error executing email template '%s'", err)
+	for name, value := range a.configContent() {
+		content[name] = value
 	}
-	result := fmt.Sprintf("<div align=\"center\" id=\"subject\">Subject: %s \n</div><div id=\"body\">%s</div>", subject, body)
-	return result, nil
+	return content
 }
 
 func (a *Api) sendModelAsResWithStatus(res http.ResponseWriter, model interface{}, statusCode int) {
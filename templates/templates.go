@@ -15,12 +15,14 @@ import (
 )
 
 type TemplateMeta struct {
-	Name               string   `json:"name"`
-	Description        string   `json:"description"`
-	TemplateFilename   string   `json:"templateFilename"`
-	ContentParts       []string `json:"contentParts"`
-	Subject            string   `json:"subject"`
-	EscapeContentParts []string `json:"escapeContentParts"`
+	Name               string                    `json:"name"`
+	Description        string                    `json:"description"`
+	TemplateFilename   string                    `json:"templateFilename"`
+	ContentParts       []string                  `json:"contentParts"`
+	Subject            string                    `json:"subject"`
+	EscapeContentParts []string                  `json:"escapeContentParts"`
+	Variables          []models.TemplateVariable `json:"variables"`
+	Sender             models.TemplateSender     `json:"sender"`
 }
 
 func New(templatesPath string, localizer localize.Localizer) (models.Templates, error) {
@@ -126,7 +128,44 @@ func newTemplate(templatesPath string, templateName models.TemplateName, localiz
 	var templateMeta = getTemplateMeta(templatesPath + "/meta/" + string(templateName) + ".json")
 	var templateFileName = templatesPath + "/html/" + templateMeta.TemplateFilename
 
-	return models.NewPrecompiledTemplate(templateName, templateMeta.Subject, getBodySkeleton(templateFileName), templateMeta.ContentParts, templateMeta.EscapeContentParts, localizer)
+	template, err := models.NewPrecompiledTemplate(templateName, templateMeta.Subject, getBodySkeleton(templateFileName), templateMeta.ContentParts, templateMeta.EscapeContentParts, templateMeta.Variables, localizer)
+	if err != nil {
+		return nil, err
+	}
+	if err := template.SetSender(templateMeta.Sender); err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+// NewFromVersion returns the template of a version uploaded at runtime
+// The locale files of the version override the messages of the localizer for this template only
+func NewFromVersion(version *models.TemplateVersion, localizer *localize.I18nLocalizer) (models.Template, error) {
+	if len(version.Locales) > 0 {
+		overridden, err := localizer.WithOverrides(version.Locales)
+		if err != nil {
+			return nil, fmt.Errorf("templates: failure to load the locales of %s version %d: %s", version.Template, version.Version, err)
+		}
+		localizer = overridden
+	}
+	contentParts, escapeParts, variables := version.ContentParts, version.EscapeContentParts, version.Variables
+	if contentParts == nil {
+		contentParts = []string{}
+	}
+	if escapeParts == nil {
+		escapeParts = []string{}
+	}
+	if variables == nil {
+		variables = []models.TemplateVariable{}
+	}
+	template, err := models.NewPrecompiledTemplate(version.Template, version.Subject, version.HTML, contentParts, escapeParts, variables, localizer)
+	if err != nil {
+		return nil, err
+	}
+	if err := template.SetSender(version.Sender); err != nil {
+		return nil, err
+	}
+	return template, nil
 }
 
 // getTemplateMeta returns the template metadata
@@ -62,3 +62,41 @@ func Test_NewTemplates(t *testing.T) {
 		}
 	}
 }
+
+func Test_NewFromVersion(t *testing.T) {
+	localizer, err := localize.NewI18nLocalizer("../localize/test_fixture")
+	if err != nil {
+		t.Fatalf("Failed to create the localizer: %s", err)
+	}
+	version := &models.TemplateVersion{
+		Template:           models.TemplateNameTest,
+		Version:            2,
+		Subject:            "TestTemplateSubject",
+		HTML:               `<html lang="{{ .Locale }}"><body><p>{{ .TestContentInjection }}</p></body></html>`,
+		ContentParts:       []string{"TestContentInjection"},
+		EscapeContentParts: []string{"TestCreatorName"},
+		Variables:          []models.TemplateVariable{{Name: "TestCreatorName", Type: models.VariableTypeString, Required: true}},
+		Locales:            map[string]string{"fr": `TestContentInjection: "Contenu de {{ .TestCreatorName }}."`},
+	}
+	template, err := NewFromVersion(version, localizer)
+	if err != nil {
+		t.Fatalf("Failed to create the template of the version: %s", err)
+	}
+	subject, body, err := template.Execute(map[string]interface{}{"TestCreatorName": "Chuck"}, "fr")
+	if err != nil {
+		t.Fatalf("Failed to execute the template of the version: %s", err)
+	}
+	if subject != "Cet email est là pour les tests." || body != `<html lang="fr"><body><p>Contenu de Chuck.</p></body></html>` {
+		t.Fatalf("The version should use its locale overrides, got %q and %q", subject, body)
+	}
+
+	version.Locales = map[string]string{"fr": "TestContentInjection: [unclosed"}
+	if _, err := NewFromVersion(version, localizer); err == nil {
+		t.Fatalf("A version with an invalid locale file should be rejected")
+	}
+	version.Locales = nil
+	version.HTML = "<p>{{ .Unknown }}</p>"
+	if _, err := NewFromVersion(version, localizer); err == nil {
+		t.Fatalf("A version with an undeclared placeholder should be rejected")
+	}
+}
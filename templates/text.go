@@ -0,0 +1,122 @@
+package templates
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	spacesRegexp     = regexp.MustCompile(`[ \t\r\n\f]+`)
+	blankLinesRegexp = regexp.MustCompile(`\n{3,}`)
+)
+
+// HTMLToText converts an email html body into its plain text alternative
+// Block elements give new lines, links are followed by their url, links without any text or image alternative text
+// are dropped as are the head, styles and scripts
+func HTMLToText(body string) string {
+	var text strings.Builder
+	var href string
+	var linkText strings.Builder
+	skip := 0
+	inLink := false
+
+	write := func(s string) {
+		if inLink {
+			linkText.WriteString(s)
+		} else {
+			text.WriteString(s)
+		}
+	}
+
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+		token := tokenizer.Token()
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			switch token.DataAtom {
+			case atom.Head, atom.Style, atom.Script, atom.Title:
+				if tokenType == html.StartTagToken {
+					skip++
+				}
+			case atom.Br:
+				write("\n")
+			case atom.Img:
+				// an image link is named by the image alternative text
+				if inLink {
+					write(attribute(token, "alt"))
+				}
+			case atom.A:
+				href = attribute(token, "href")
+				inLink = true
+				linkText.Reset()
+			default:
+				if isBlock(token.DataAtom) {
+					write("\n")
+				}
+			}
+		case html.EndTagToken:
+			switch token.DataAtom {
+			case atom.Head, atom.Style, atom.Script, atom.Title:
+				if skip > 0 {
+					skip--
+				}
+			case atom.A:
+				if inLink {
+					inLink = false
+					label := strings.TrimSpace(spacesRegexp.ReplaceAllString(linkText.String(), " "))
+					if label == "" {
+						break
+					}
+					text.WriteString(label)
+					if target := strings.TrimPrefix(href, "mailto:"); href != "" && target != label {
+						text.WriteString(" (" + href + ")")
+					}
+				}
+			default:
+				if isBlock(token.DataAtom) {
+					write("\n")
+				}
+			}
+		case html.TextToken:
+			if skip == 0 {
+				write(spacesRegexp.ReplaceAllString(token.Data, " "))
+			}
+		}
+	}
+
+	lines := strings.Split(text.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	result := blankLinesRegexp.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(result) + "\n"
+}
+
+func attribute(token html.Token, name string) string {
+	value, _ := attributeValue(token, name)
+	return value
+}
+
+func attributeValue(token html.Token, name string) (string, bool) {
+	for _, attr := range token.Attr {
+		if attr.Key == name {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+func isBlock(a atom.Atom) bool {
+	switch a {
+	case atom.P, atom.Div, atom.Table, atom.Tr, atom.Li, atom.Ul, atom.Ol, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Hr, atom.Center, atom.Blockquote:
+		return true
+	}
+	return false
+}
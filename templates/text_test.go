@@ -0,0 +1,16 @@
+package templates
+
+import (
+	"testing"
+)
+
+func Test_HTMLToText(t *testing.T) {
+	body := `<html><head><title>Title</title><style>p { color: red; }</style></head>
+<body><a href="https://example.com"><img src="logo.png"></a><table><tr><td><h1>Hello   John</h1></td></tr>
+<tr><td><p>Please <a href="https://example.com/confirm?key=1">confirm</a> your account.<br>Thanks</p>
+<p><a href="https://example.com/help"><img src="help.png" alt="Help"></a> Contact <a href="mailto:support@example.com">support@example.com</a></p></td></tr></table></body></html>`
+	expected := "Hello John\n\nPlease confirm (https://example.com/confirm?key=1) your account.\nThanks\n\nHelp (https://example.com/help) Contact support@example.com\n"
+	if text := HTMLToText(body); text != expected {
+		t.Fatalf("Wrong text conversion, expecting %q but found %q", expected, text)
+	}
+}
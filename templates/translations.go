@@ -0,0 +1,164 @@
+package templates
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+
+	"github.com/mdblp/hydrophone/localize"
+	"github.com/mdblp/hydrophone/models"
+)
+
+// TranslationReport lists the translation problems found for one language
+// Missing keys and placeholder mismatches are errors, unused and untranslated keys are warnings
+type TranslationReport struct {
+	Language     string   `json:"language"`
+	Missing      []string `json:"missing"`
+	Placeholders []string `json:"placeholders"`
+	Unused       []string `json:"unused"`
+	Untranslated []string `json:"untranslated"`
+}
+
+// HasErrors tells whether the language misses keys or has placeholders not matching the english ones
+func (r *TranslationReport) HasErrors() bool {
+	return len(r.Missing) > 0 || len(r.Placeholders) > 0
+}
+
+// HasWarnings tells whether the language has unused or untranslated keys
+func (r *TranslationReport) HasWarnings() bool {
+	return len(r.Unused) > 0 || len(r.Untranslated) > 0
+}
+
+var (
+	actionRegexp      = regexp.MustCompile(`{{(.*?)}}`)
+	placeholderRegexp = regexp.MustCompile(`\.([A-Za-z_][A-Za-z0-9_]*)`)
+)
+
+// LoadMetas returns the metadata of every template found in the meta folder
+func LoadMetas(templatesPath string) ([]TemplateMeta, error) {
+	files, err := filepath.Glob(path.Join(templatesPath, "meta", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("templates: no meta file found in %s", path.Join(templatesPath, "meta"))
+	}
+	metas := make([]TemplateMeta, 0, len(files))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("templates: failure to read %s: %s", file, err)
+		}
+		var meta TemplateMeta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return nil, fmt.Errorf("templates: failure to parse %s: %s", file, err)
+		}
+		metas = append(metas, meta)
+	}
+	return metas, nil
+}
+
+// CheckTranslations compares every loaded language with the keys used by the templates and with english
+// The languages to skip (e.g. the in-context translation pseudo language) are not reported,
+// nor is the generated pseudo locale
+func CheckTranslations(templatesPath string, skip []string) ([]TranslationReport, error) {
+	metas, err := LoadMetas(templatesPath)
+	if err != nil {
+		return nil, err
+	}
+	localizer, err := localize.NewI18nLocalizer(path.Join(templatesPath, "locales"))
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[string]bool)
+	for _, meta := range metas {
+		if meta.Name == models.TemplateNameTest.String() {
+			continue
+		}
+		used[meta.Subject] = true
+		if meta.Sender.Name != "" {
+			used[meta.Sender.Name] = true
+		}
+		for _, part := range meta.ContentParts {
+			used[part] = true
+		}
+	}
+
+	skipped := map[string]bool{localize.PseudoLocale: true}
+	for _, lang := range skip {
+		skipped[lang] = true
+	}
+
+	languages := localizer.Languages()
+	english := localizer.Messages(languages[0])
+	var reports []TranslationReport
+	for _, lang := range languages {
+		if skipped[lang] {
+			continue
+		}
+		messages := localizer.Messages(lang)
+		report := TranslationReport{Language: lang}
+		for key := range used {
+			if _, ok := messages[key]; !ok {
+				report.Missing = append(report.Missing, key)
+			}
+		}
+		for key, message := range messages {
+			if !used[key] {
+				report.Unused = append(report.Unused, key)
+			}
+			reference, ok := english[key]
+			if !ok || lang == languages[0] {
+				continue
+			}
+			if messageText(message) == messageText(reference) {
+				report.Untranslated = append(report.Untranslated, key)
+			}
+			if !samePlaceholders(message, reference) {
+				report.Placeholders = append(report.Placeholders, key)
+			}
+		}
+		sort.Strings(report.Missing)
+		sort.Strings(report.Placeholders)
+		sort.Strings(report.Unused)
+		sort.Strings(report.Untranslated)
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// messageText returns all the forms of a message, to compare them at once
+func messageText(message *i18n.Message) string {
+	return message.Zero + "|" + message.One + "|" + message.Two + "|" + message.Few + "|" + message.Many + "|" + message.Other
+}
+
+// placeholders returns the variables used by the message, in any of its forms
+func placeholders(message *i18n.Message) map[string]bool {
+	names := make(map[string]bool)
+	for _, action := range actionRegexp.FindAllStringSubmatch(messageText(message), -1) {
+		for _, name := range placeholderRegexp.FindAllStringSubmatch(action[1], -1) {
+			names[name[1]] = true
+		}
+	}
+	return names
+}
+
+func samePlaceholders(message *i18n.Message, reference *i18n.Message) bool {
+	got, expected := placeholders(message), placeholders(reference)
+	if len(got) != len(expected) {
+		return false
+	}
+	for name := range expected {
+		if !got[name] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+package templates
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_CheckTranslations(t *testing.T) {
+	reports, err := CheckTranslations("./testdata/translations", nil)
+	if err != nil {
+		t.Fatalf("CheckTranslations failed with error %s", err)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("CheckTranslations returned %d reports, expecting 2", len(reports))
+	}
+
+	english := reports[0]
+	if english.Language != "en" || english.HasErrors() {
+		t.Fatalf("English should come first without errors: %+v", english)
+	}
+	if !reflect.DeepEqual(english.Unused, []string{"SampleLegacy"}) {
+		t.Fatalf("Unused english keys are %v, expecting [SampleLegacy]", english.Unused)
+	}
+
+	french := reports[1]
+	expected := TranslationReport{
+		Language:     "fr",
+		Missing:      []string{"SampleFooter"},
+		Placeholders: []string{"SampleBody"},
+		Untranslated: []string{"SampleSubject"},
+	}
+	if !reflect.DeepEqual(french, expected) {
+		t.Fatalf("French report is %+v, expecting %+v", french, expected)
+	}
+}
+
+func Test_CheckTranslations_Templates(t *testing.T) {
+	reports, err := CheckTranslations(".", []string{"chr"})
+	if err != nil {
+		t.Fatalf("CheckTranslations failed with error %s", err)
+	}
+	for _, report := range reports {
+		if report.HasErrors() {
+			t.Fatalf("Language %s misses keys %v or has wrong placeholders %v", report.Language, report.Missing, report.Placeholders)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Package cid embeds the images of the emails as inline MIME parts referenced by their Content-ID
+// Many mail clients block the remote images, the inline ones are always displayed
+package cid
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// contentIDDomain makes the Content-IDs globally unique as required by RFC 2392
+const contentIDDomain = "hydrophone"
+
+// contentIDForbidden matches the characters of the asset paths which are not kept in the Content-IDs
+var contentIDForbidden = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+type (
+	// Image is an image embedded in an email
+	Image struct {
+		ContentID   string
+		ContentType string
+		Filename    string
+		Data        []byte
+	}
+
+	// Inliner replaces the images of the emails linked to the asset URL by inline ones read from the local asset directory
+	// The images are read once and kept in memory
+	Inliner struct {
+		mutex  sync.RWMutex
+		images map[string]*Image
+	}
+)
+
+// NewInliner creates an inliner with an empty cache
+func NewInliner() *Inliner {
+	return &Inliner{images: map[string]*Image{}}
+}
+
+// Inline replaces the src attributes linking to an image under the asset URL with a cid: reference
+// It returns the updated html with the images to attach, each image once
+// The images which cannot be read from the asset directory are left remote
+func (i *Inliner) Inline(html string, assetURL string, assetDir string) (string, []*Image) {
+	if assetURL == "" || assetDir == "" {
+		return html, nil
+	}
+	pattern := regexp.MustCompile(`(\ssrc\s*=\s*["'])` + regexp.QuoteMeta(strings.TrimSuffix(assetURL, "/")) + `/([^"'?#]+)(["'])`)
+	var images []*Image
+	attached := map[string]bool{}
+	inlined := pattern.ReplaceAllStringFunc(html, func(match string) string {
+		parts := pattern.FindStringSubmatch(match)
+		image, err := i.image(assetDir, parts[2])
+		if err != nil {
+			log.Printf("cid: keeping %s remote [%v]", parts[2], err)
+			return match
+		}
+		if !attached[image.ContentID] {
+			attached[image.ContentID] = true
+			images = append(images, image)
+		}
+		return parts[1] + "cid:" + image.ContentID + parts[3]
+	})
+	return inlined, images
+}
+
+// image returns the image found at this path of the asset directory, reading it the first time
+func (i *Inliner) image(assetDir string, assetPath string) (*Image, error) {
+	cleaned := path.Clean("/" + assetPath)[1:]
+	if cleaned == "" || cleaned != assetPath {
+		return nil, fmt.Errorf("invalid asset path %s", assetPath)
+	}
+	file := filepath.Join(assetDir, filepath.FromSlash(cleaned))
+
+	i.mutex.RLock()
+	image, found := i.images[file]
+	i.mutex.RUnlock()
+	if found {
+		return image, nil
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(cleaned))
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("%s is not an image", assetPath)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	// the extension is trusted only when it matches the content
+	if detected := http.DetectContentType(data); detected != contentType && strings.HasPrefix(detected, "image/") {
+		contentType = detected
+	}
+	image = &Image{
+		ContentID:   contentIDForbidden.ReplaceAllString(strings.ReplaceAll(cleaned, "/", "."), "-") + "@" + contentIDDomain,
+		ContentType: contentType,
+		Filename:    path.Base(cleaned),
+		Data:        data,
+	}
+	i.mutex.Lock()
+	i.images[file] = image
+	i.mutex.Unlock()
+	return image, nil
+}
@@ -0,0 +1,75 @@
+package cid
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testAssets(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "assets")
+	if err != nil {
+		t.Fatalf("Failed to create the asset directory: %s", err)
+	}
+	var logo bytes.Buffer
+	png.Encode(&logo, image.NewRGBA(image.Rect(0, 0, 10, 10)))
+	os.MkdirAll(filepath.Join(dir, "img"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "img", "logo.png"), logo.Bytes(), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "privacy.pdf"), []byte("%PDF-1.4"), 0644)
+	return dir
+}
+
+func Test_Inline(t *testing.T) {
+	dir := testAssets(t)
+	defer os.RemoveAll(dir)
+	inliner := NewInliner()
+
+	html := `<img class="logo" src="https://assets.example.com/img/logo.png" alt="logo"/>` +
+		`<img src='https://assets.example.com/img/logo.png'/>` +
+		`<img src="https://assets.example.com/img/missing.png"/>` +
+		`<a href="https://assets.example.com/privacy.pdf">privacy</a>` +
+		`<img src="https://other.example.com/img/logo.png"/>`
+	expected := `<img class="logo" src="cid:img.logo.png@hydrophone" alt="logo"/>` +
+		`<img src='cid:img.logo.png@hydrophone'/>` +
+		`<img src="https://assets.example.com/img/missing.png"/>` +
+		`<a href="https://assets.example.com/privacy.pdf">privacy</a>` +
+		`<img src="https://other.example.com/img/logo.png"/>`
+
+	inlined, images := inliner.Inline(html, "https://assets.example.com/", dir)
+	if inlined != expected {
+		t.Fatalf("Wrong inlined html, expecting\n%s\nbut got\n%s", expected, inlined)
+	}
+	if len(images) != 1 || images[0].ContentType != "image/png" || images[0].Filename != "logo.png" || len(images[0].Data) == 0 {
+		t.Fatalf("The logo should be attached once, got %+v", images)
+	}
+
+	// the images are kept in memory
+	os.RemoveAll(dir)
+	if _, images = inliner.Inline(html, "https://assets.example.com", dir); len(images) != 1 {
+		t.Fatalf("The logo should be read from the cache, got %+v", images)
+	}
+}
+
+func Test_Inline_Paths(t *testing.T) {
+	dir := testAssets(t)
+	defer os.RemoveAll(dir)
+	inliner := NewInliner()
+
+	for _, html := range []string{
+		`<img src="https://assets.example.com/../img/logo.png"/>`,
+		`<img src="https://assets.example.com/img/../../etc/passwd.png"/>`,
+		`<img src="https://assets.example.com/privacy.pdf"/>`,
+	} {
+		if inlined, images := inliner.Inline(html, "https://assets.example.com", dir); inlined != html || len(images) != 0 {
+			t.Fatalf("The image should be left remote, got %s", inlined)
+		}
+	}
+	html := `<img src="https://assets.example.com/img/logo.png"/>`
+	if inlined, _ := inliner.Inline(html, "https://assets.example.com", ""); inlined != html {
+		t.Fatalf("Nothing should be inlined without an asset directory, got %s", inlined)
+	}
+}